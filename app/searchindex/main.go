@@ -0,0 +1,75 @@
+package main
+
+// searchindex 把数据库中的对象回填到搜索索引
+// 第一次启用搜索、修改分词器重建索引或者索引遗漏对象后执行
+// -semantic 只为语义检索向量化对象，第一次启用或者修改向量模型后执行，避免api服务启动时大量请求向量平台
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/service"
+)
+
+var (
+	configPath = flag.String("config", "config.json", "config file")
+	kinds      = flag.String("kinds", strings.Join(search.Kinds, ","), "回填的对象类型，逗号分隔")
+	batchSize  = flag.Int("batch", 200, "每批写入的文档数")
+	semantic   = flag.Bool("semantic", false, "只为语义检索向量化对象，不需要搜索服务")
+)
+
+func main() {
+	flag.Parse()
+	err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("read config failed : ", err)
+	}
+	err = config.ValiedConfig(config.GlobalConfig)
+	if err != nil {
+		log.Fatal("Valied config failed : ", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = service.InitBackend(ctx, config.GlobalConfig)
+	if err != nil {
+		log.Fatal("init backend failed : ", err)
+	}
+	go func() {
+		sc := make(chan os.Signal, 1)
+		signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
+		s := <-sc
+		log.Info("signal : ", s.String())
+		cancel()
+	}()
+	if *semantic {
+		n, err := search.Rebuild(ctx)
+		if err != nil {
+			log.Fatalf("embed semantic items failed after %d items : %v", n, err)
+		}
+		log.Infof("embed semantic items done, %d items", n)
+		return
+	}
+	if !search.Enabled() {
+		log.Fatal("elastic search not enabled, check elastic config")
+	}
+	for _, kind := range strings.Split(*kinds, ",") {
+		kind = strings.TrimSpace(kind)
+		if !slices.Contains(search.Kinds, kind) {
+			log.Fatalf("unknown kind %s, should be one of %v", kind, search.Kinds)
+		}
+		n, err := search.Backfill(ctx, kind, *batchSize)
+		if err != nil {
+			log.Fatalf("backfill %s failed after %d docs : %v", kind, n, err)
+		}
+		log.Infof("backfill %s done, %d docs", kind, n)
+	}
+}
@@ -1,14 +1,18 @@
-package syncworker
+package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/pkg/story"
 	"github.com/grapery/grapery/service"
 	"github.com/grapery/grapery/version"
 )
@@ -30,20 +34,35 @@ func main() {
 	if err != nil {
 		log.Fatal("Valied config failed : ", err)
 	}
-	srv := service.NewTeamsService()
-	err = service.Run(srv, config.GlobalConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	err = service.InitBackend(ctx, config.GlobalConfig)
 	if err != nil {
-		log.Fatal("start service failed")
+		log.Fatal("init backend failed : ", err)
 	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		log.Info("start render worker")
+		story.NewRenderWorker(config.GlobalConfig.Render).Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		var interval time.Duration
+		if config.GlobalConfig.Render != nil {
+			interval = time.Duration(config.GlobalConfig.Render.SyncInterval) * time.Second
+		}
+		log.Info("start media sync worker")
+		story.NewMediaSyncWorker(interval).Run(ctx)
+	}()
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT,
 	)
-	select {
-	case s := <-sc:
-		log.Info("signal : ", s.String())
-	}
-	return
+	s := <-sc
+	log.Info("signal : ", s.String())
+	cancel()
+	wg.Wait()
 }
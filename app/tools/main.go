@@ -2,22 +2,39 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	api "github.com/grapery/common-protoc/gen"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
-const Address string = "127.0.0.1:12307"
+var (
+	address = flag.String("addr", "127.0.0.1:12307", "聊天服务地址")
+	token   = flag.String("token", "", "登录后获得的token")
+	roleId  = flag.Int64("role", 2, "聊天的角色ID")
+	count   = flag.Int("n", 5, "发送的消息数")
+)
 
 var streamClient api.StreamMessageServiceClient
 
+// lastMessageId 收到的最后一条消息ID，断线重连时带上以补发错过的回复
+var lastMessageId int64
+
 func main() {
+	flag.Parse()
+	if *token == "" {
+		log.Fatal("token is required")
+	}
 	// 连接服务器
-	conn, err := grpc.Dial(Address, grpc.WithInsecure())
+	conn, err := grpc.NewClient(*address, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		log.Fatalf("net.Connect err: %v", err)
 	}
@@ -25,57 +42,90 @@ func main() {
 
 	// 建立gRPC连接
 	streamClient = api.NewStreamMessageServiceClient(conn)
-	conversations()
+	for sent := 0; sent < *count; {
+		n, err := conversations(*count - sent)
+		sent += n
+		if err == nil {
+			break
+		}
+		log.Printf("stream broken after %d messages, reconnect: %v", sent, err)
+		time.Sleep(time.Second * 2)
+	}
 }
 
-func conversations() {
-	stream, err := streamClient.StreamChatMessage(context.Background())
+// conversations 建立一次连接并发送消息，返回发送成功的消息数
+func conversations(n int) (int, error) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "bearer "+*token,
+		"role-id", strconv.FormatInt(*roleId, 10),
+		"last-message-id", strconv.FormatInt(lastMessageId, 10),
+	)
+	stream, err := streamClient.StreamChatMessage(ctx)
 	if err != nil {
-		log.Fatalf("get conversations stream err: %v", err)
+		return 0, err
 	}
 
+	done := make(chan error, 1)
+	replies := make(chan struct{}, n)
 	go func() {
 		for {
 			res, err := stream.Recv()
 			if err == io.EOF {
-				break
+				done <- nil
+				return
 			}
 			if err != nil {
-				log.Fatalf("Conversations get stream err: %v", err)
+				done <- err
+				return
+			}
+			for _, reply := range res.ReplyMessages {
+				for _, message := range reply.Messages {
+					if message.Id > lastMessageId {
+						lastMessageId = message.Id
+					}
+					log.Printf("reply %d: %s", message.Id, message.Message)
+				}
+			}
+			if res.Message != "" {
+				log.Println("res: ", res.Message)
+			}
+			// 回复或失败响应表示这条消息处理完成
+			if res.RequestId != "" && (len(res.ReplyMessages) > 0 || res.Code != 0) {
+				replies <- struct{}{}
 			}
-			// 打印返回值
-			log.Println("res: ", res.Message)
 		}
 	}()
-	for n := 0; n < 5; n++ {
+	for i := 0; i < n; i++ {
 		err := stream.Send(&api.StreamChatMessageRequest{
 			Message: &api.StreamChatMessage{
-				RoleId: 2,
-				UserId: 1,
+				RoleId: *roleId,
 				Messages: []*api.ChatMessage{
 					{
-						RoleId:  2,
-						UserId:  1,
-						Sender:  1,
+						RoleId:  *roleId,
 						Message: "hello grapery",
-						ChatId:  1,
+						Uuid:    uuid.New().String(),
 					},
 				},
 			},
 			Timestamp: time.Now().Unix(),
-			RequestId: fmt.Sprintf("%d", time.Now().Unix()),
-			Token:     "1234567890",
+			RequestId: fmt.Sprintf("%d", time.Now().UnixNano()),
 		})
 		if err != nil {
-			log.Fatalf("stream request err: %v", err)
+			return i, err
+		}
+		// 等待回复后再发送下一条
+		select {
+		case <-replies:
+		case err := <-done:
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return i, err
 		}
-
-		time.Sleep(time.Second * 1)
 	}
-	time.Sleep(time.Hour * 10)
 	//最后关闭流
-	err = stream.CloseSend()
-	if err != nil {
-		log.Fatalf("Conversations close stream err: %v", err)
+	if err := stream.CloseSend(); err != nil {
+		log.Printf("Conversations close stream err: %v", err)
 	}
+	return n, <-done
 }
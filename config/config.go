@@ -23,26 +23,124 @@ type RedisConfig struct {
 	PingInterval int    `json:"ping_interval,omitempty"`
 }
 
+// ElasticConfig 搜索服务配置，没有配置地址时搜索使用数据库查询
 type ElasticConfig struct {
-	Address []string
+	Address []string `json:"address,omitempty"`
+	// 索引使用的分词器，默认为内置的 cjk，安装 ik 插件后可以使用 ik_max_word
+	Analyzer       string `json:"analyzer,omitempty"`
+	SearchAnalyzer string `json:"search_analyzer,omitempty"` // 搜索使用的分词器，默认同 Analyzer
+	Prefix         string `json:"prefix,omitempty"`          // 索引名前缀，多个环境共用集群时区分
 }
 
 // Config define common config struct
 type Config struct {
-	SqlDB      *DBConfig      `json:"sql_db,omitempty"`
-	Redis      *RedisConfig   `json:"redis,omitempty"`
-	Elastic    *ElasticConfig `json:"elastic,omitempty"`
-	LogLevel   string         `json:"log_level,omitempty"`
-	RpcPort    string         `json:"rpc_port,omitempty"`
-	HttpPort   string         `json:"http_port,omitempty"`
-	S3Store    *S3Store       `json:"s3store,omitempty"`
-	Ali        *AIPlatform    `json:"ali,omitempty"`
-	Tencent    *AIPlatform    `json:"tencent,omitempty"`
-	Midjourney *AIPlatform    `json:"midjourney,omitempty"`
-	SelfHost   *AIPlatform    `json:"selfhost,omitempty"`
-	MiniMax    *AIPlatform    `json:"minimax,omitempty"`
+	SqlDB      *DBConfig         `json:"sql_db,omitempty"`
+	Redis      *RedisConfig      `json:"redis,omitempty"`
+	Elastic    *ElasticConfig    `json:"elastic,omitempty"`
+	LogLevel   string            `json:"log_level,omitempty"`
+	RpcPort    string            `json:"rpc_port,omitempty"`
+	HttpPort   string            `json:"http_port,omitempty"`
+	S3Store    *S3Store          `json:"s3store,omitempty"`
+	Storage    *StorageConfig    `json:"storage,omitempty"`
+	Ali        *AIPlatform       `json:"ali,omitempty"`
+	Tencent    *AIPlatform       `json:"tencent,omitempty"`
+	Midjourney *AIPlatform       `json:"midjourney,omitempty"`
+	SelfHost   *AIPlatform       `json:"selfhost,omitempty"`
+	MiniMax    *AIPlatform       `json:"minimax,omitempty"`
+	LLM        *LLMConfig        `json:"llm,omitempty"`
+	Render     *RenderConfig     `json:"render,omitempty"`
+	Memory     *MemoryConfig     `json:"memory,omitempty"`
+	Semantic   *SemanticConfig   `json:"semantic,omitempty"`
+	Prompt     *PromptConfig     `json:"prompt,omitempty"`
+	Moderation *ModerationConfig `json:"moderation,omitempty"`
 }
 
+// ModerationConfig 内容审核配置，本地规则先于远程审核执行
+// 命中 reject 的内容直接拒绝，命中 review 的内容进入人工审核队列，审核通过前不公开
+type ModerationConfig struct {
+	Disable bool   `json:"disable,omitempty"`
+	Address string `json:"address,omitempty"` // 远程审核服务地址，为空时只使用本地规则
+	Secret  string `json:"secret,omitempty"`
+	// 远程审核请求失败时内容是否直接通过，默认进入审核队列
+	PassOnError bool                        `json:"pass_on_error,omitempty"`
+	Rules       []*ModerationRule           `json:"rules,omitempty"`
+	Groups      map[string]*ModerationGroup `json:"groups,omitempty"`    // key 为小组ID
+	Reviewers   []int64                     `json:"reviewers,omitempty"` // 可以处理审核队列的用户
+	// 举报次数达到阈值后对象在审核前隐藏，为0时举报不隐藏对象
+	ReportHideThreshold int `json:"report_hide_threshold,omitempty"`
+}
+
+// ModerationRule 本地审核规则，关键词不区分大小写
+type ModerationRule struct {
+	Name     string   `json:"name,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	Patterns []string `json:"patterns,omitempty"` // 正则表达式
+	Action   string   `json:"action,omitempty"`   // reject/review，默认 review
+}
+
+// ModerationGroup 小组对全局规则的覆盖
+type ModerationGroup struct {
+	Rules  []*ModerationRule `json:"rules,omitempty"`  // 小组额外的规则
+	Allow  []string          `json:"allow,omitempty"`  // 在小组内不生效的全局规则名
+	Strict bool              `json:"strict,omitempty"` // 需要审核的内容直接拒绝
+}
+
+// PromptConfig 提示词的token预算，实际预算不超过模型上下文长度减去回复预留
+type PromptConfig struct {
+	ChatMaxTokens  int `json:"chat_max_tokens,omitempty"`  // 角色聊天提示词的最大token数
+	StoryMaxTokens int `json:"story_max_tokens,omitempty"` // 故事续写提示词的最大token数
+	ReplyTokens    int `json:"reply_tokens,omitempty"`     // 为回复预留的token数
+}
+
+// MemoryConfig 角色聊天的长期记忆配置，未配置的项使用默认值
+type MemoryConfig struct {
+	Disable        bool    `json:"disable,omitempty"`         // 关闭长期记忆，只使用最近的消息
+	EmbeddingModel string  `json:"embedding_model,omitempty"` // 向量模型，为空时使用平台默认模型
+	TopK           int     `json:"top_k,omitempty"`           // 每轮召回的记忆数
+	MinScore       float64 `json:"min_score,omitempty"`       // 召回的最低相似度
+	RecentMessages int     `json:"recent_messages,omitempty"` // 每轮带上的最近消息数
+	SummaryEvery   int     `json:"summary_every,omitempty"`   // 每隔多少条消息总结一次用户档案
+}
+
+// SemanticConfig 语义检索和相似推荐配置，未配置的项使用默认值
+type SemanticConfig struct {
+	Disable         bool    `json:"disable,omitempty"`          // 关闭语义检索
+	EmbeddingModel  string  `json:"embedding_model,omitempty"`  // 向量模型，为空时使用平台默认模型，修改后需要重新向量化
+	MinScore        float64 `json:"min_score,omitempty"`        // 结果的最低相似度
+	RefreshInterval int     `json:"refresh_interval,omitempty"` // 从数据库重建内存索引的间隔，分钟
+}
+
+// RenderConfig 异步渲染任务配置
+// async 为true时渲染接口只提交任务，由 syncworker 执行
+type RenderConfig struct {
+	Async        bool `json:"async,omitempty"`
+	Workers      int  `json:"workers,omitempty"`       // 并发执行的任务数
+	MaxAttempts  int  `json:"max_attempts,omitempty"`  // 最大执行次数
+	Interval     int  `json:"interval,omitempty"`      // 轮询间隔，秒
+	Timeout      int  `json:"timeout,omitempty"`       // 单个任务超时时间，秒
+	SyncInterval int  `json:"sync_interval,omitempty"` // 图片/视频任务结果同步间隔，秒
+	// 场景渲染时生成的候选图片数
+	ImageCandidates int `json:"image_candidates,omitempty"`
+}
+
+// StorageConfig 对象存储配置，type: oss/cos/s3/local
+// 未配置时依次使用 s3store 和环境变量中的阿里云OSS
+type StorageConfig struct {
+	Type      string `json:"type,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"` // oss/s3 的服务地址，cos 的存储桶地址
+	Region    string `json:"region,omitempty"`   // s3 签名使用的区域
+	Bucket    string `json:"bucket,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+	// 对象的公开访问地址前缀，为空时按 endpoint 和 bucket 拼接；local 时为文件服务的地址，例如 http://127.0.0.1:12305/files
+	BaseURL   string `json:"base_url,omitempty"`
+	PathStyle bool   `json:"path_style,omitempty"` // s3 使用 endpoint/bucket/key 形式的地址
+	Dir       string `json:"dir,omitempty"`        // local 存储目录，目录下的文件都可以公开访问
+	// 同一存储的其他访问地址前缀，例如CDN，这些地址上的文件视为存储中的对象
+	AliasURLs []string `json:"alias_urls,omitempty"`
+}
+
+// S3Store 兼容S3的对象存储，建议使用 storage
 type S3Store struct {
 	Token   string
 	Secret  string
@@ -59,6 +157,28 @@ type AIPlatform struct {
 	Limit   int    `json:"limit,omitempty"`
 }
 
+// LLMConfig 大模型平台选择
+// scenes: 业务场景(story/storyboard/role/chat/image/image_ref/image_edit/vision/embedding) -> 平台名称(zhipu/aliyun/doubao/coze/azure/google)
+// failover: 业务场景 -> 按顺序尝试的平台，例如 ["coze","doubao","zhipu"]
+type LLMConfig struct {
+	Default      string              `json:"default,omitempty"`
+	Scenes       map[string]string   `json:"scenes,omitempty"`
+	Failover     map[string][]string `json:"failover,omitempty"`
+	Breaker      *BreakerConfig      `json:"breaker,omitempty"`
+	ParseRetries int                 `json:"parse_retries,omitempty"` // 输出校验失败后重新生成的次数
+	Azure        *AIPlatform         `json:"azure,omitempty"`
+	Google       *AIPlatform         `json:"google,omitempty"`
+}
+
+// BreakerConfig 平台熔断配置，时间单位为秒
+type BreakerConfig struct {
+	Window      int     `json:"window,omitempty"`       // 错误率统计窗口
+	MinRequests int     `json:"min_requests,omitempty"` // 窗口内最少请求数
+	ErrorRate   float64 `json:"error_rate,omitempty"`   // 触发熔断的错误率
+	Cooldown    int     `json:"cooldown,omitempty"`     // 熔断后的冷却时间
+	Timeout     int     `json:"timeout,omitempty"`      // 单个平台的超时时间
+}
+
 func ValiedConfig(cfg *Config) error {
 	if cfg.RpcPort == "" {
 		return fmt.Errorf("server rpc port not set")
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// ChatMemory.Kind 记忆类型
+const (
+	ChatMemoryKindTurn    = 1 // 一轮对话
+	ChatMemoryKindSummary = 2 // 多轮对话的摘要
+)
+
+// ChatMemory 聊天的长期记忆，每条记录保存一段内容及其向量
+// status: 1-有效, -1-删除
+type ChatMemory struct {
+	IDBase
+	ChatContextID int64  `gorm:"column:chat_context_id;index" json:"chat_context_id,omitempty"` // 会话ID
+	UserID        int64  `gorm:"column:user_id" json:"user_id,omitempty"`                       // 用户ID
+	RoleID        int64  `gorm:"column:role_id" json:"role_id,omitempty"`                       // 角色ID
+	MessageID     int64  `gorm:"column:message_id" json:"message_id,omitempty"`                 // 对应的最后一条消息ID
+	Kind          int    `gorm:"column:kind" json:"kind,omitempty"`                             // 记忆类型
+	Content       string `gorm:"column:content;type:text" json:"content,omitempty"`             // 记忆内容
+	Model         string `gorm:"column:model" json:"model,omitempty"`                           // 向量模型
+	Vector        []byte `gorm:"column:vector;type:blob" json:"-"`                              // 向量，小端序float32
+	Status        int    `gorm:"column:status" json:"status,omitempty"`                         // 状态
+}
+
+func (m ChatMemory) TableName() string {
+	return "chat_memory"
+}
+
+func CreateChatMemory(ctx context.Context, memory *ChatMemory) (int64, error) {
+	memory.Status = 1
+	if err := DataBase().Model(memory).
+		WithContext(ctx).
+		Create(memory).Error; err != nil {
+		return 0, err
+	}
+	return int64(memory.ID), nil
+}
+
+// GetChatMemories 获取会话最近的记忆，只返回指定向量模型生成的记录
+func GetChatMemories(ctx context.Context, chatContextID int64, model string, limit int) ([]*ChatMemory, error) {
+	memories := make([]*ChatMemory, 0)
+	err := DataBase().Model(&ChatMemory{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("model = ?", model).
+		Where("status = ?", 1).
+		Order("id desc").
+		Limit(limit).
+		Find(&memories).Error
+	if err != nil {
+		return nil, err
+	}
+	return memories, nil
+}
+
+// DeleteChatMemories 删除会话的所有记忆
+func DeleteChatMemories(ctx context.Context, chatContextID int64) error {
+	return DataBase().Model(&ChatMemory{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Update("status", -1).Error
+}
+
+// DeleteChatMemoriesAfter 删除会话中对应消息ID大于 messageID 的记忆，用于重新生成或编辑消息后
+func DeleteChatMemoriesAfter(ctx context.Context, chatContextID, messageID int64) error {
+	return DataBase().Model(&ChatMemory{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("message_id > ?", messageID).
+		Update("status", -1).Error
+}
+
+// GetChatMemoriesUpTo 获取会话中对应消息ID不大于 messageID 的记忆，按时间从旧到新
+func GetChatMemoriesUpTo(ctx context.Context, chatContextID, messageID int64) ([]*ChatMemory, error) {
+	memories := make([]*ChatMemory, 0)
+	err := DataBase().Model(&ChatMemory{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("message_id <= ?", messageID).
+		Where("status = ?", 1).
+		Order("id asc").
+		Find(&memories).Error
+	if err != nil {
+		return nil, err
+	}
+	return memories, nil
+}
+
+// ChatMemoryProfile 角色对用户的了解，由旧的对话定期总结得到，每个会话一条
+type ChatMemoryProfile struct {
+	IDBase
+	ChatContextID int64  `gorm:"column:chat_context_id;uniqueIndex" json:"chat_context_id,omitempty"` // 会话ID
+	UserID        int64  `gorm:"column:user_id" json:"user_id,omitempty"`                             // 用户ID
+	RoleID        int64  `gorm:"column:role_id" json:"role_id,omitempty"`                             // 角色ID
+	Profile       string `gorm:"column:profile;type:text" json:"profile,omitempty"`                   // 用户档案
+	LastMessageID int64  `gorm:"column:last_message_id" json:"last_message_id,omitempty"`             // 已总结的最后一条消息ID
+	SummaryNum    int64  `gorm:"column:summary_num" json:"summary_num,omitempty"`                     // 总结次数
+}
+
+func (p ChatMemoryProfile) TableName() string {
+	return "chat_memory_profile"
+}
+
+// GetChatMemoryProfile 获取会话的用户档案，不存在时返回nil
+func GetChatMemoryProfile(ctx context.Context, chatContextID int64) (*ChatMemoryProfile, error) {
+	profile := &ChatMemoryProfile{}
+	err := DataBase().Model(profile).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		First(profile).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return profile, nil
+}
+
+// SaveChatMemoryProfile 新建或更新用户档案
+func SaveChatMemoryProfile(ctx context.Context, profile *ChatMemoryProfile) error {
+	if profile.ID == 0 {
+		return DataBase().Model(profile).WithContext(ctx).Create(profile).Error
+	}
+	return DataBase().Model(&ChatMemoryProfile{}).
+		WithContext(ctx).
+		Where("id = ?", profile.ID).
+		Updates(map[string]interface{}{
+			"profile":         profile.Profile,
+			"last_message_id": profile.LastMessageID,
+			"summary_num":     profile.SummaryNum,
+		}).Error
+}
+
+// GetRecentChatMessages 获取会话最近的消息，按时间从旧到新
+func GetRecentChatMessages(ctx context.Context, chatContextID int64, limit int) ([]*ChatMessage, error) {
+	messages := make([]*ChatMessage, 0)
+	err := DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", 1).
+		Order("id desc").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// GetChatMessagesAfter 获取会话中 id 在 (afterID, beforeID] 之间的消息，按时间从旧到新
+func GetChatMessagesAfter(ctx context.Context, chatContextID int64, afterID, beforeID int64, limit int) ([]*ChatMessage, error) {
+	messages := make([]*ChatMessage, 0)
+	err := DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", 1).
+		Where("id > ? and id <= ?", afterID, beforeID).
+		Order("id asc").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// CountChatMessagesAfter 统计会话中 id 大于 afterID 的消息数
+func CountChatMessagesAfter(ctx context.Context, chatContextID int64, afterID int64) (int64, error) {
+	var total int64
+	err := DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", 1).
+		Where("id > ?", afterID).
+		Count(&total).Error
+	return total, err
+}
@@ -8,17 +8,29 @@ import (
 	"gorm.io/gorm"
 )
 
+// ChatContext.ChatType 会话类型
+const (
+	ChatTypeSingle = 0 // 用户和一个角色
+	ChatTypeGroup  = 1 // 用户和多个角色，角色列表见 ChatContextRole
+)
+
 // ChatContext 聊天上下文/会话
 // status: 1-open, 2-close, 3-delete
 type ChatContext struct {
 	IDBase
-	UserID      int64  `gorm:"column:user_id" json:"user_id,omitempty"`           // 用户ID
-	RoleID      int64  `gorm:"column:role_id" json:"role_id,omitempty"`           // 角色ID
-	Title       string `gorm:"column:title" json:"title,omitempty"`               // 会话标题
-	Content     string `gorm:"column:content" json:"content,omitempty"`           // 会话内容
-	Status      int64  `gorm:"column:status" json:"status,omitempty"`             // 会话状态
-	UseAgent    int64  `gorm:"column:use_agent" json:"use_agent,omitempty"`       // 是否使用Agent
-	AgentPrompt string `gorm:"column:agent_prompt" json:"agent_prompt,omitempty"` // Agent提示词
+	UserID        int64  `gorm:"column:user_id" json:"user_id,omitempty"`                 // 用户ID
+	RoleID        int64  `gorm:"column:role_id" json:"role_id,omitempty"`                 // 角色ID，群聊为0
+	Title         string `gorm:"column:title" json:"title,omitempty"`                     // 会话标题
+	Content       string `gorm:"column:content" json:"content,omitempty"`                 // 会话内容
+	Status        int64  `gorm:"column:status" json:"status,omitempty"`                   // 会话状态
+	UseAgent      int64  `gorm:"column:use_agent" json:"use_agent,omitempty"`             // 是否使用Agent
+	AgentPrompt   string `gorm:"column:agent_prompt" json:"agent_prompt,omitempty"`       // Agent提示词
+	ChatType      int    `gorm:"column:chat_type" json:"chat_type,omitempty"`             // 会话类型
+	StoryID       int64  `gorm:"column:story_id" json:"story_id,omitempty"`               // 群聊角色所属的故事
+	TurnPolicy    string `gorm:"column:turn_policy" json:"turn_policy,omitempty"`         // 群聊的发言策略
+	TokenNum      int64  `gorm:"column:token_num" json:"token_num,omitempty"`             // 会话累计消耗的token数，用于计费
+	ForkFromID    int64  `gorm:"column:fork_from_id" json:"fork_from_id,omitempty"`       // 分支来源的会话ID
+	ForkMessageID int64  `gorm:"column:fork_message_id" json:"fork_message_id,omitempty"` // 分支来源会话中的分叉消息ID
 }
 
 func (c ChatContext) TableName() string {
@@ -43,6 +55,7 @@ func GetChatContextByUserID(ctx context.Context, userID int64, page, size int) (
 	var chatContexts []*ChatContext
 	err := DataBase().Model(&ChatContext{}).WithContext(ctx).
 		Where("user_id = ?", userID).
+		Where("chat_type = ?", ChatTypeSingle).
 		Where("status = ?", 1).
 		Order("update_at DESC").
 		Offset((page) * size).
@@ -55,6 +68,7 @@ func GetChatContextByUserID(ctx context.Context, userID int64, page, size int) (
 	var total int64
 	err = DataBase().Model(&ChatContext{}).
 		Where("user_id = ?", userID).
+		Where("chat_type = ?", ChatTypeSingle).
 		Where("status = ?", 1).
 		Count(&total).Error
 	if err != nil {
@@ -90,12 +104,144 @@ func UpdateChatContext(ctx context.Context, id int64, updates map[string]interfa
 		Updates(updates).Error
 }
 
+// AddChatContextTokens 累加会话消耗的token数
+func AddChatContextTokens(ctx context.Context, id int64, tokenNum int) error {
+	return DataBase().Model(&ChatContext{}).
+		Where("id = ?", id).
+		WithContext(ctx).
+		Update("token_num", gorm.Expr("token_num + ?", tokenNum)).Error
+}
+
 func DeleteChatContext(ctx context.Context, id int64) error {
 	return DataBase().Model(&ChatContext{}).Update("status", -1).
 		Where("id = ?", id).
 		WithContext(ctx).Error
 }
 
+// GetGroupChatContexts 获取用户的群聊会话，按更新时间倒序
+func GetGroupChatContexts(ctx context.Context, userID int64, offset, limit int) ([]*ChatContext, int64, error) {
+	var total int64
+	err := DataBase().Model(&ChatContext{}).
+		WithContext(ctx).
+		Where("user_id = ?", userID).
+		Where("chat_type = ?", ChatTypeGroup).
+		Where("status = ?", 1).
+		Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	chatContexts := make([]*ChatContext, 0)
+	err = DataBase().Model(&ChatContext{}).
+		WithContext(ctx).
+		Where("user_id = ?", userID).
+		Where("chat_type = ?", ChatTypeGroup).
+		Where("status = ?", 1).
+		Order("update_at DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&chatContexts).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return chatContexts, total, nil
+}
+
+// ChatContextRole 群聊中的角色
+// status: 1-有效, -1-已移出
+type ChatContextRole struct {
+	IDBase
+	ChatContextID int64 `gorm:"column:chat_context_id;index" json:"chat_context_id,omitempty"` // 会话ID
+	RoleID        int64 `gorm:"column:role_id" json:"role_id,omitempty"`                       // 角色ID
+	Seq           int   `gorm:"column:seq" json:"seq,omitempty"`                               // 轮流发言的顺序
+	Status        int   `gorm:"column:status" json:"status,omitempty"`                         // 状态
+}
+
+func (c ChatContextRole) TableName() string {
+	return "chat_context_role"
+}
+
+// GetChatContextRoles 获取群聊中的角色，按发言顺序
+func GetChatContextRoles(ctx context.Context, chatContextID int64) ([]*ChatContextRole, error) {
+	roles := make([]*ChatContextRole, 0)
+	err := DataBase().Model(&ChatContextRole{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", 1).
+		Order("seq asc").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetChatContextRoles 替换群聊的角色列表，roleIDs 的顺序即发言顺序
+func SetChatContextRoles(ctx context.Context, chatContextID int64, roleIDs []int64) error {
+	return DataBase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ChatContextRole{}).
+			Where("chat_context_id = ?", chatContextID).
+			Where("status = ?", 1).
+			Update("status", -1).Error; err != nil {
+			return err
+		}
+		for i, roleID := range roleIDs {
+			role := &ChatContextRole{
+				ChatContextID: chatContextID,
+				RoleID:        roleID,
+				Seq:           i,
+				Status:        1,
+			}
+			if err := tx.Create(role).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetChatMessagesBefore 获取会话中 id 小于 beforeID 的消息，beforeID 为0时从最新的消息开始，按时间从新到旧
+func GetChatMessagesBefore(ctx context.Context, chatContextID int64, beforeID int64, limit int) ([]*ChatMessage, error) {
+	messages := make([]*ChatMessage, 0)
+	query := DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", 1)
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id desc").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ChatMessage.Status 消息状态，只有正常的消息会出现在聊天记录和提示词中
+const (
+	ChatMessageStatusNormal      = 1 // 正常
+	ChatMessageStatusAlternative = 2 // 重新生成后保留的其他回复
+	ChatMessageStatusReplaced    = 3 // 用户编辑消息后被替换的消息
+)
+
+// ChatMessage.MessageType 消息类型，工具相关的消息只在Agent模式的会话中出现
+const (
+	ChatMessageTypeText       = 0 // 文本
+	ChatMessageTypeToolCall   = 1 // 角色调用工具，Content 为json参数
+	ChatMessageTypeToolResult = 2 // 工具的执行结果，ToolData 为json结果
+	ChatMessageTypeProposal   = 3 // 角色提出的故事板续写，用户可以采纳，ToolData 见续写建议
+	ChatMessageTypeImage      = 4 // 用户发送的图片，AfterRender 为逗号分隔的图片URL，Content 为附带的文字
+)
+
+// ChatMessage.NeedRender 角色回复的插图状态，Prompt 为画面描述，AfterRender 为生成的图片URL
+const (
+	ChatRenderNone    = 0 // 没有插图
+	ChatRenderPending = 1 // 生成中
+	ChatRenderDone    = 2 // 已生成
+	ChatRenderFailed  = 3 // 生成失败
+)
+
 // ChatMessage 聊天消息
 type ChatMessage struct {
 	IDBase
@@ -113,6 +259,12 @@ type ChatMessage struct {
 	SendTime      int64  `gorm:"column:send_time" json:"send_time,omitempty"`             // 发送时间
 	ReceiveTime   int64  `gorm:"column:receive_time" json:"receive_time,omitempty"`       // 接收时间
 	MessageID     string `gorm:"column:message_id" json:"message_id,omitempty"`           // 消息ID
+	PromptTokens  int    `gorm:"column:prompt_tokens" json:"prompt_tokens,omitempty"`     // 生成回复时提示词的token数
+	TokenNum      int    `gorm:"column:token_num" json:"token_num,omitempty"`             // 生成回复消耗的token数，包含提示词
+	ReplyToID     int64  `gorm:"column:reply_to_id;index" json:"reply_to_id,omitempty"`   // 角色回复对应的用户消息ID
+	OriginID      int64  `gorm:"column:origin_id" json:"origin_id,omitempty"`             // 编辑后的消息对应的原消息ID
+	ToolName      string `gorm:"column:tool_name" json:"tool_name,omitempty"`             // 调用的工具名
+	ToolData      string `gorm:"column:tool_data;type:text" json:"tool_data,omitempty"`   // 工具的执行结果
 }
 
 func (c ChatMessage) TableName() string {
@@ -123,9 +275,50 @@ func CreateChatMessage(ctx context.Context, chatMessage *ChatMessage) error {
 	return DataBase().Create(chatMessage).WithContext(ctx).Error
 }
 
+// GetChatMessageByUUID 获取会话中 sender 发送的指定uuid的消息，不存在时返回nil
+func GetChatMessageByUUID(ctx context.Context, chatContextID, sender int64, uuid string) (*ChatMessage, error) {
+	message := &ChatMessage{}
+	err := DataBase().Model(message).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("sender = ?", sender).
+		Where("uuid = ?", uuid).
+		Where("status = ?", 1).
+		Order("id desc").
+		First(message).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// GetChatRepliesAfter 获取会话中 id 大于 afterID 的角色回复，按时间从旧到新
+func GetChatRepliesAfter(ctx context.Context, chatContextID, afterID int64, limit int) ([]*ChatMessage, error) {
+	messages := make([]*ChatMessage, 0)
+	err := DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", 1).
+		Where("id > ?", afterID).
+		Where("role_id <> 0 and sender = role_id").
+		Where("message_type = ?", ChatMessageTypeText).
+		Order("id asc").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
 func GetChatMessageByChatContextID(ctx context.Context, chatContextID int64, page, size int) ([]*ChatMessage, int, error) {
 	var chatMessages []*ChatMessage
 	err := DataBase().Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", ChatMessageStatusNormal).
+		Where("message_type not in ?", []int{ChatMessageTypeToolCall, ChatMessageTypeToolResult}).
 		WithContext(ctx).
 		Offset((page - 1) * size).
 		Limit(size).
@@ -136,6 +329,8 @@ func GetChatMessageByChatContextID(ctx context.Context, chatContextID int64, pag
 	var total int64
 	err = DataBase().Model(&ChatMessage{}).
 		Where("chat_context_id = ?", chatContextID).
+		Where("status = ?", ChatMessageStatusNormal).
+		Where("message_type not in ?", []int{ChatMessageTypeToolCall, ChatMessageTypeToolResult}).
 		Count(&total).Error
 	if err != nil {
 		return nil, 0, err
@@ -143,6 +338,75 @@ func GetChatMessageByChatContextID(ctx context.Context, chatContextID int64, pag
 	return chatMessages, int(total), nil
 }
 
+// GetChatMessageByID 获取会话中的一条消息，不区分状态
+func GetChatMessageByID(ctx context.Context, chatContextID, id int64) (*ChatMessage, error) {
+	message := &ChatMessage{}
+	err := DataBase().Model(message).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("id = ?", id).
+		First(message).Error
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// GetChatReplies 获取用户消息的所有回复，包括重新生成前的回复，按时间从旧到新
+func GetChatReplies(ctx context.Context, chatContextID, replyToID int64) ([]*ChatMessage, error) {
+	messages := make([]*ChatMessage, 0)
+	err := DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("reply_to_id = ?", replyToID).
+		Where("message_type = ?", ChatMessageTypeText).
+		Where("status in ?", []int{ChatMessageStatusNormal, ChatMessageStatusAlternative}).
+		Order("id asc").
+		Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// UpdateChatMessage 更新消息的字段
+func UpdateChatMessage(ctx context.Context, id int64, updates map[string]interface{}) error {
+	return DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("id = ?", id).
+		Updates(updates).Error
+}
+
+// SwitchChatReply 把当前回复 fromID 改为候选，候选回复 toID 改为正常
+func SwitchChatReply(ctx context.Context, fromID, toID int64) error {
+	return DataBase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ChatMessage{}).Where("id = ?", fromID).
+			Update("status", ChatMessageStatusAlternative).Error; err != nil {
+			return err
+		}
+		return tx.Model(&ChatMessage{}).Where("id = ?", toID).
+			Update("status", ChatMessageStatusNormal).Error
+	})
+}
+
+// ReplaceChatMessagesFrom 将会话中 id 不小于 fromID 的消息标记为已替换
+func ReplaceChatMessagesFrom(ctx context.Context, chatContextID, fromID int64) error {
+	return DataBase().Model(&ChatMessage{}).
+		WithContext(ctx).
+		Where("chat_context_id = ?", chatContextID).
+		Where("id >= ?", fromID).
+		Where("status in ?", []int{ChatMessageStatusNormal, ChatMessageStatusAlternative}).
+		Update("status", ChatMessageStatusReplaced).Error
+}
+
+// CreateChatMessages 批量创建消息，按顺序回填ID
+func CreateChatMessages(ctx context.Context, messages []*ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	return DataBase().WithContext(ctx).CreateInBatches(messages, 100).Error
+}
+
 func GetChatContextLastMessage(ctx context.Context, chatContextID int64) (*ChatMessage, error) {
 	var chatMessage ChatMessage
 	err := DataBase().Where("chat_context_id = ?", chatContextID).
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+
+	"gorm.io/gorm/clause"
+)
+
+// ContentEmbedding 故事、故事板和角色内容的向量，语义检索的内存索引从这里重建
+// ContentHash 为向量模型和内容的摘要，内容没有变化时不重新向量化
+type ContentEmbedding struct {
+	IDBase
+	EntityType  string `gorm:"column:entity_type;size:32;uniqueIndex:idx_content_embedding" json:"entity_type,omitempty"` // 对象类型，同搜索的 kind
+	EntityId    int64  `gorm:"column:entity_id;uniqueIndex:idx_content_embedding" json:"entity_id,omitempty"`             // 对象ID
+	StoryId     int64  `gorm:"column:story_id" json:"story_id,omitempty"`                                                 // 所属故事ID
+	Model       string `gorm:"column:model" json:"model,omitempty"`                                                       // 向量模型
+	ContentHash string `gorm:"column:content_hash;size:64" json:"content_hash,omitempty"`                                 // 向量化内容的摘要
+	Vector      []byte `gorm:"column:vector;type:blob" json:"-"`                                                          // 向量，小端序float32
+}
+
+func (e ContentEmbedding) TableName() string {
+	return "content_embedding"
+}
+
+// SaveContentEmbedding 按对象类型和ID写入，已存在时覆盖
+func SaveContentEmbedding(ctx context.Context, embedding *ContentEmbedding) error {
+	return DataBase().WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "entity_type"}, {Name: "entity_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"story_id", "model", "content_hash", "vector", "update_at"}),
+		}).
+		Create(embedding).Error
+}
+
+func DeleteContentEmbedding(ctx context.Context, entityType string, entityId int64) error {
+	return DataBase().WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Delete(&ContentEmbedding{}).Error
+}
+
+// GetContentEmbeddings 获取一批对象的向量，返回对象ID到向量的映射
+func GetContentEmbeddings(ctx context.Context, entityType string, entityIds []int64) (map[int64]*ContentEmbedding, error) {
+	found := make(map[int64]*ContentEmbedding, len(entityIds))
+	if len(entityIds) == 0 {
+		return found, nil
+	}
+	embeddings := make([]*ContentEmbedding, 0, len(entityIds))
+	err := DataBase().Model(&ContentEmbedding{}).
+		WithContext(ctx).
+		Where("entity_type = ?", entityType).
+		Where("entity_id in (?)", entityIds).
+		Find(&embeddings).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, embedding := range embeddings {
+		found[embedding.EntityId] = embedding
+	}
+	return found, nil
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+)
+
+// ContentReport.Reason 举报原因
+const (
+	ReportReasonSpam       = 1 // 垃圾广告
+	ReportReasonPorn       = 2 // 色情低俗
+	ReportReasonViolence   = 3 // 暴力血腥
+	ReportReasonHarassment = 4 // 骚扰辱骂
+	ReportReasonCopyright  = 5 // 侵权
+	ReportReasonOther      = 6 // 其他
+)
+
+// ReportReasonNames 举报原因写入审核记录的标签
+var ReportReasonNames = map[int]string{
+	ReportReasonSpam:       "spam",
+	ReportReasonPorn:       "porn",
+	ReportReasonViolence:   "violence",
+	ReportReasonHarassment: "harassment",
+	ReportReasonCopyright:  "copyright",
+	ReportReasonOther:      "other",
+}
+
+// ContentReport 用户的举报记录，同一对象的举报合并为一条审核记录
+// EntityType 同 ModerationItem.EntityType
+type ContentReport struct {
+	IDBase
+	ReporterId       int64  `gorm:"column:reporter_id;uniqueIndex:idx_content_report" json:"reporter_id,omitempty"` // 举报人
+	EntityType       int    `gorm:"column:entity_type;uniqueIndex:idx_content_report" json:"entity_type,omitempty"` // 被举报的对象类型
+	EntityId         int64  `gorm:"column:entity_id;uniqueIndex:idx_content_report" json:"entity_id,omitempty"`     // 被举报的对象ID
+	Reason           int    `gorm:"column:reason" json:"reason,omitempty"`                                          // 举报原因
+	Detail           string `gorm:"column:detail;type:text" json:"detail,omitempty"`                                // 补充说明
+	ModerationItemId int64  `gorm:"column:moderation_item_id;index" json:"moderation_item_id,omitempty"`            // 对应的审核记录
+}
+
+func (r ContentReport) TableName() string {
+	return "content_report"
+}
+
+func CreateContentReport(ctx context.Context, report *ContentReport) (int64, error) {
+	if err := DataBase().WithContext(ctx).Create(report).Error; err != nil {
+		return 0, err
+	}
+	return int64(report.ID), nil
+}
+
+// HasContentReport 用户是否已经举报过对象
+func HasContentReport(ctx context.Context, reporterId int64, entityType int, entityId int64) (bool, error) {
+	var total int64
+	err := DataBase().Model(&ContentReport{}).
+		WithContext(ctx).
+		Where("reporter_id = ? and entity_type = ? and entity_id = ?", reporterId, entityType, entityId).
+		Count(&total).Error
+	return total > 0, err
+}
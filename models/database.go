@@ -88,7 +88,18 @@ func Init(uname, pwd, dbname string) error {
 	database.AutoMigrate(&StoryRole{})
 	database.AutoMigrate(&ChatContext{})
 	database.AutoMigrate(&ChatMessage{})
+	database.AutoMigrate(&ChatContextRole{})
+	database.AutoMigrate(&ChatMemory{})
+	database.AutoMigrate(&ChatMemoryProfile{})
 	database.AutoMigrate(&StoryBoardRole{})
+	database.AutoMigrate(&ImageGen{})
+	database.AutoMigrate(&VideoGen{})
+	database.AutoMigrate(&Revision{})
+	database.AutoMigrate(&ModerationItem{})
+	database.AutoMigrate(&ContentReport{})
+	database.AutoMigrate(&UserBlock{})
+	database.AutoMigrate(&MutedKeyword{})
+	database.AutoMigrate(&ContentEmbedding{})
 
 	database.AutoMigrate(&Comment{})
 	database.AutoMigrate(&CommentLike{})
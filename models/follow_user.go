@@ -125,3 +125,39 @@ func GetFollowUserByIDs(ctx context.Context, userID, followedID int64) (*FollowU
 	}
 	return follow, nil
 }
+
+// ListFollowing 分页返回用户关注的人，excludeIds 中的用户不返回，最近关注的在前
+func ListFollowing(ctx context.Context, userId int64, excludeIds []int64, offset, limit int) ([]*FollowUser, int64, error) {
+	return listFollows(ctx, "user_id", "followed_id", userId, excludeIds, offset, limit)
+}
+
+// ListFollowers 分页返回关注用户的人，excludeIds 中的用户不返回，最近关注的在前
+func ListFollowers(ctx context.Context, userId int64, excludeIds []int64, offset, limit int) ([]*FollowUser, int64, error) {
+	return listFollows(ctx, "followed_id", "user_id", userId, excludeIds, offset, limit)
+}
+
+func listFollows(ctx context.Context, column, otherColumn string, userId int64, excludeIds []int64,
+	offset, limit int) ([]*FollowUser, int64, error) {
+	query := func() *gorm.DB {
+		db := DataBase().Model(&FollowUser{}).
+			WithContext(ctx).
+			Where(column+" = ?", userId)
+		if len(excludeIds) > 0 {
+			db = db.Where(otherColumn+" not in ?", excludeIds)
+		}
+		return db
+	}
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	follows := make([]*FollowUser, 0)
+	err := query().Order("id desc").
+		Offset(offset).
+		Limit(limit).
+		Find(&follows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return follows, total, nil
+}
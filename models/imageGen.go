@@ -6,20 +6,35 @@ import (
 	"gorm.io/gorm"
 )
 
+// ImageGen/VideoGen 的状态，异步任务提交到平台后为 MediaGenStatusPending，由 syncworker 查询结果
+const (
+	MediaGenStatusPending = 1
+	MediaGenStatusSucceed = 2
+	MediaGenStatusFailed  = 3
+)
+
 // ImageGen 图片生成任务记录
 type ImageGen struct {
 	IDBase
-	OriginID int64  `gorm:"column:origin_id" json:"origin_id,omitempty"` // 源故事ID
-	BoardID  int64  `gorm:"column:board_id" json:"board_id,omitempty"`   // 故事板ID
-	RoleID   int64  `gorm:"column:role_id" json:"role_id,omitempty"`     // 角色ID
-	TaskID   string `gorm:"column:task_id" json:"task_id,omitempty"`     // 任务ID
-	Uuid     string `gorm:"column:uuid" json:"uuid,omitempty"`           // 唯一标识
-	Status   int    `gorm:"column:status" json:"status,omitempty"`       // 状态
-	Prompt   string `gorm:"column:prompt" json:"prompt,omitempty"`       // 提示词
-	ImageUrl string `gorm:"column:image_url" json:"image_url,omitempty"` // 图片URL
-	Code     string `gorm:"column:code" json:"code,omitempty"`           // 错误码
-	Message  string `gorm:"column:message" json:"message,omitempty"`     // 错误信息
-	Deleted  int    `gorm:"column:deleted" json:"deleted,omitempty"`     // 是否删除
+	OriginID  int64  `gorm:"column:origin_id" json:"origin_id,omitempty"`   // 源故事ID
+	BoardID   int64  `gorm:"column:board_id" json:"board_id,omitempty"`     // 故事板ID
+	RoleID    int64  `gorm:"column:role_id" json:"role_id,omitempty"`       // 角色ID
+	SceneID   int64  `gorm:"column:scene_id" json:"scene_id,omitempty"`     // 场景ID
+	MessageID int64  `gorm:"column:message_id" json:"message_id,omitempty"` // 角色聊天中插图所属的消息ID
+	Batch     string `gorm:"column:batch;index" json:"batch,omitempty"`     // 场景的一次渲染，等于渲染时场景的 TaskId，同一批次的记录为场景的候选图片
+	Seed      int64  `gorm:"column:seed" json:"seed,omitempty"`             // 随机种子
+	Starred   int    `gorm:"column:starred" json:"starred,omitempty"`       // 用户是否收藏了该候选图片
+	ParentID  int64  `gorm:"column:parent_id" json:"parent_id,omitempty"`   // 编辑生成的图片，来源图片的 ImageGen ID
+	EditMode  string `gorm:"column:edit_mode" json:"edit_mode,omitempty"`   // 编辑方式，见 client.EditMode*
+	Platform  string `gorm:"column:platform" json:"platform,omitempty"`     // 生成平台
+	TaskID    string `gorm:"column:task_id" json:"task_id,omitempty"`       // 任务ID
+	Uuid      string `gorm:"column:uuid" json:"uuid,omitempty"`             // 唯一标识
+	Status    int    `gorm:"column:status" json:"status,omitempty"`         // 状态
+	Prompt    string `gorm:"column:prompt" json:"prompt,omitempty"`         // 提示词
+	ImageUrl  string `gorm:"column:image_url" json:"image_url,omitempty"`   // 图片URL，多张时逗号分隔
+	Code      string `gorm:"column:code" json:"code,omitempty"`             // 错误码
+	Message   string `gorm:"column:message" json:"message,omitempty"`       // 错误信息
+	Deleted   int    `gorm:"column:deleted" json:"deleted,omitempty"`       // 是否删除
 }
 
 func (i ImageGen) TableName() string {
@@ -53,6 +68,45 @@ func DeleteImageGen(ctx context.Context, id int64) error {
 		Update("deleted", 1).Error
 }
 
+// UpdateImageGenColumns 更新指定字段，零值也会更新
+func UpdateImageGenColumns(ctx context.Context, id int64, columns map[string]interface{}) error {
+	return DataBase().Table(ImageGen{}.TableName()).
+		WithContext(ctx).
+		Where("id = ?", id).
+		Updates(columns).Error
+}
+
+// GetSceneImageGens 获取场景未删除的生成记录，按时间从新到旧
+func GetSceneImageGens(ctx context.Context, sceneID int64, limit int) ([]*ImageGen, error) {
+	var imageGenList []*ImageGen
+	err := DataBase().Table(ImageGen{}.TableName()).
+		WithContext(ctx).
+		Where("scene_id = ?", sceneID).
+		Where("deleted = ?", 0).
+		Order("id desc").
+		Limit(limit).
+		Find(&imageGenList).Error
+	if err != nil {
+		return nil, err
+	}
+	return imageGenList, nil
+}
+
+// GetImageGensByBatch 获取场景一次渲染的所有候选图片，按生成顺序
+func GetImageGensByBatch(ctx context.Context, sceneID int64, batch string) ([]*ImageGen, error) {
+	var imageGenList []*ImageGen
+	err := DataBase().Table(ImageGen{}.TableName()).
+		WithContext(ctx).
+		Where("scene_id = ?", sceneID).
+		Where("batch = ?", batch).
+		Order("id asc").
+		Find(&imageGenList).Error
+	if err != nil {
+		return nil, err
+	}
+	return imageGenList, nil
+}
+
 func GetImageGenList(ctx context.Context, page, pageSize int) ([]*ImageGen, error) {
 	var imageGenList []*ImageGen
 	err := DataBase().Table(ImageGen{}.TableName()).
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ModerationItem.EntityType 被审核的对象类型
+const (
+	ModerationEntityStory       = 1
+	ModerationEntityStoryboard  = 2
+	ModerationEntityScene       = 3
+	ModerationEntityRole        = 4
+	ModerationEntityComment     = 5
+	ModerationEntityChatMessage = 6
+	ModerationEntityImage       = 7 // 生成的图片（image_gen）
+	ModerationEntityUser        = 8 // 被举报的用户，不会被隐藏
+)
+
+// ModerationSourceReport 用户举报进入审核队列的记录
+const ModerationSourceReport = "report"
+
+// ModerationHiddenDeleted 被审核隐藏的对象的 deleted 值，与用户删除（1）区分，
+// 按 deleted = 0 过滤的查询同样不会返回这些对象
+const ModerationHiddenDeleted = 2
+
+// ModerationItem.Status 审核状态
+const (
+	ModerationStatusPending  = 0 // 等待人工审核
+	ModerationStatusApproved = 1
+	ModerationStatusRejected = 2
+)
+
+// ModerationItem 审核队列，需要人工审核的对象在审核通过前被隐藏（deleted = ModerationHiddenDeleted）
+type ModerationItem struct {
+	IDBase
+	EntityType int    `gorm:"column:entity_type;index:idx_moderation_entity" json:"entity_type,omitempty"` // 对象类型
+	EntityId   int64  `gorm:"column:entity_id;index:idx_moderation_entity" json:"entity_id,omitempty"`     // 对象ID
+	Field      string `gorm:"column:field" json:"field,omitempty"`                                         // 被审核的字段，图片为 image
+	GroupId    int64  `gorm:"column:group_id" json:"group_id,omitempty"`                                   // 所属小组ID
+	StoryId    int64  `gorm:"column:story_id" json:"story_id,omitempty"`                                   // 所属故事ID
+	AuthorId   int64  `gorm:"column:author_id" json:"author_id,omitempty"`                                 // 发布者ID，生成内容为发起者
+	Content    string `gorm:"column:content;type:text" json:"content,omitempty"`                           // 被审核的文本或图片URL
+	Verdict    int    `gorm:"column:verdict" json:"verdict,omitempty"`                                     // 自动审核的结论
+	Labels     string `gorm:"column:labels" json:"labels,omitempty"`                                       // 命中的规则或标签，逗号分隔
+	Reason     string `gorm:"column:reason" json:"reason,omitempty"`                                       // 自动审核的原因
+	Source     string `gorm:"column:source" json:"source,omitempty"`                                       // 来源 rules/remote/report
+	Visible    bool   `gorm:"column:visible" json:"visible,omitempty"`                                     // 等待审核时对象仍然显示，用于举报
+	Reports    int    `gorm:"column:reports" json:"reports,omitempty"`                                     // 举报次数
+	Status     int    `gorm:"column:status;index" json:"status,omitempty"`                                 // 审核状态
+	ReviewerId int64  `gorm:"column:reviewer_id" json:"reviewer_id,omitempty"`                             // 审核人
+	ReviewNote string `gorm:"column:review_note" json:"review_note,omitempty"`                             // 审核备注
+	ReviewAt   int64  `gorm:"column:review_at" json:"review_at,omitempty"`                                 // 审核时间
+}
+
+func (m ModerationItem) TableName() string {
+	return "moderation_item"
+}
+
+// moderationTables 审核对象对应的表
+var moderationTables = map[int]string{
+	ModerationEntityStory:       (&Story{}).TableName(),
+	ModerationEntityStoryboard:  StoryBoard{}.TableName(),
+	ModerationEntityScene:       StoryBoardScene{}.TableName(),
+	ModerationEntityRole:        StoryRole{}.TableName(),
+	ModerationEntityComment:     Comment{}.TableName(),
+	ModerationEntityChatMessage: ChatMessage{}.TableName(),
+	ModerationEntityImage:       ImageGen{}.TableName(),
+}
+
+func CreateModerationItem(ctx context.Context, item *ModerationItem) (int64, error) {
+	if err := DataBase().WithContext(ctx).Create(item).Error; err != nil {
+		return 0, err
+	}
+	return int64(item.ID), nil
+}
+
+// GetModerationItem 不存在时返回nil
+func GetModerationItem(ctx context.Context, id int64) (*ModerationItem, error) {
+	item := &ModerationItem{}
+	err := DataBase().Model(item).
+		WithContext(ctx).
+		Where("id = ?", id).
+		First(item).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+// ListModerationItems 按状态分页返回，entityType 为0时返回所有类型，先进入队列的在前
+func ListModerationItems(ctx context.Context, status int, entityType int, offset, limit int) ([]*ModerationItem, int64, error) {
+	query := func() *gorm.DB {
+		db := DataBase().Model(&ModerationItem{}).
+			WithContext(ctx).
+			Where("status = ?", status)
+		if entityType != 0 {
+			db = db.Where("entity_type = ?", entityType)
+		}
+		return db
+	}
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	items := make([]*ModerationItem, 0)
+	err := query().Order("id asc").
+		Offset(offset).
+		Limit(limit).
+		Find(&items).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// CountUnapprovedModeration 对象被隐藏等待审核或被拒绝的记录数
+func CountUnapprovedModeration(ctx context.Context, entityType int, entityId int64) (int64, error) {
+	var total int64
+	err := DataBase().Model(&ModerationItem{}).
+		WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Where("(status = ? and visible = ?) or status = ?",
+			ModerationStatusPending, false, ModerationStatusRejected).
+		Count(&total).Error
+	return total, err
+}
+
+// GetPendingReportItem 对象等待处理的举报记录，不存在时返回nil
+func GetPendingReportItem(ctx context.Context, entityType int, entityId int64) (*ModerationItem, error) {
+	item := &ModerationItem{}
+	err := DataBase().Model(item).
+		WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Where("source = ? and status = ?", ModerationSourceReport, ModerationStatusPending).
+		First(item).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+// AddModerationReport 举报次数加一并追加举报原因
+func AddModerationReport(ctx context.Context, id int64, labels string) error {
+	return DataBase().Model(&ModerationItem{}).
+		WithContext(ctx).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"reports": gorm.Expr("reports + 1"),
+			"labels":  labels,
+		}).Error
+}
+
+// SetModerationItemVisible 设置等待审核时对象是否显示
+func SetModerationItemVisible(ctx context.Context, id int64, visible bool) error {
+	return DataBase().Model(&ModerationItem{}).
+		WithContext(ctx).
+		Where("id = ?", id).
+		Update("visible", visible).Error
+}
+
+// HasModerationItem 对象是否进入过审核队列
+func HasModerationItem(ctx context.Context, entityType int, entityId int64) (bool, error) {
+	var total int64
+	err := DataBase().Model(&ModerationItem{}).
+		WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Count(&total).Error
+	return total > 0, err
+}
+
+// ReviewModerationItem 更新审核状态，fromStatus 用于避免并发的审核覆盖彼此的结果
+func ReviewModerationItem(ctx context.Context, id int64, fromStatus, status int, reviewerId int64, note string) error {
+	ret := DataBase().Model(&ModerationItem{}).
+		WithContext(ctx).
+		Where("id = ? and status = ?", id, fromStatus).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewer_id": reviewerId,
+			"review_note": note,
+			"review_at":   time.Now().Unix(),
+		})
+	if ret.Error != nil {
+		return ret.Error
+	}
+	if ret.RowsAffected == 0 {
+		return fmt.Errorf("moderation item %d already reviewed", id)
+	}
+	return nil
+}
+
+// ModerationHideable 对象类型是否可以被隐藏
+func ModerationHideable(entityType int) bool {
+	_, ok := moderationTables[entityType]
+	return ok
+}
+
+// SetModerationHidden 隐藏或恢复被审核的对象，只修改正常显示或被审核隐藏的记录，
+// 不会覆盖用户的删除状态；查询和更新都会过滤已删除的记录，所以直接执行 sql
+func SetModerationHidden(ctx context.Context, entityType int, entityId int64, hidden bool) error {
+	table, ok := moderationTables[entityType]
+	if !ok {
+		return fmt.Errorf("unknown moderation entity type %d", entityType)
+	}
+	from, to := 0, ModerationHiddenDeleted
+	if !hidden {
+		from, to = ModerationHiddenDeleted, 0
+	}
+	return DataBase().WithContext(ctx).
+		Exec(fmt.Sprintf("UPDATE `%s` SET deleted = ? WHERE id = ? AND deleted = ?", table), to, entityId, from).Error
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+// Revision.EntityType 修改的对象类型
+const (
+	RevisionEntityStoryboard = 1
+	RevisionEntityScene      = 2
+	RevisionEntityRole       = 3
+)
+
+// Revision.Source 修改来源
+const (
+	RevisionSourceOriginal = 0 // 第一次修改前的内容
+	RevisionSourceManual   = 1 // 用户手动编辑
+	RevisionSourceGenerate = 2 // 生成任务写入
+	RevisionSourceRollback = 3 // 回滚到历史版本
+)
+
+// Revision 故事板、场景、角色的历史版本，只追加不修改
+// 每条记录保存修改后的完整快照，version 在同一对象内从1开始递增
+type Revision struct {
+	IDBase
+	EntityType  int    `gorm:"column:entity_type;uniqueIndex:idx_revision_entity_version" json:"entity_type,omitempty"` // 对象类型
+	EntityId    int64  `gorm:"column:entity_id;uniqueIndex:idx_revision_entity_version" json:"entity_id,omitempty"`     // 对象ID
+	Version     int64  `gorm:"column:version;uniqueIndex:idx_revision_entity_version" json:"version,omitempty"`         // 版本号
+	StoryId     int64  `gorm:"column:story_id;index" json:"story_id,omitempty"`                                         // 故事ID
+	AuthorId    int64  `gorm:"column:author_id" json:"author_id,omitempty"`                                             // 修改者ID，生成任务为发起者
+	Source      int    `gorm:"column:source" json:"source,omitempty"`                                                   // 修改来源
+	StoryGenId  int64  `gorm:"column:story_gen_id" json:"story_gen_id,omitempty"`                                       // 生成任务ID（story_gen）
+	TaskId      string `gorm:"column:task_id" json:"task_id,omitempty"`                                                 // 生成平台的任务ID
+	BaseVersion int64  `gorm:"column:base_version" json:"base_version,omitempty"`                                       // 回滚时为目标版本
+	Snapshot    string `gorm:"column:snapshot;type:text" json:"snapshot,omitempty"`                                     // 修改后的内容快照（json）
+}
+
+func (r Revision) TableName() string {
+	return "story_revision"
+}
+
+// revisionConflictRetries 并发修改同一对象时版本号冲突的重试次数
+const revisionConflictRetries = 3
+
+// RevisionUpdate 与版本记录在同一个事务中执行的对象修改
+type RevisionUpdate func(tx *gorm.DB) error
+
+// StoryboardColumnsUpdate 更新故事板的列
+func StoryboardColumnsUpdate(id int64, columns map[string]interface{}) RevisionUpdate {
+	return func(tx *gorm.DB) error {
+		if len(columns) == 0 {
+			return nil
+		}
+		return tx.Model(&StoryBoard{}).Where("id = ?", id).Updates(columns).Error
+	}
+}
+
+// SceneUpdate 保存场景的非零字段
+func SceneUpdate(scene *StoryBoardScene) RevisionUpdate {
+	return func(tx *gorm.DB) error {
+		return tx.Model(scene).
+			Where("id = ?", scene.IDBase.ID).
+			Where("status >= 0").
+			Updates(scene).Error
+	}
+}
+
+// SceneColumnsUpdate 更新场景的列
+func SceneColumnsUpdate(id int64, columns map[string]interface{}) RevisionUpdate {
+	return func(tx *gorm.DB) error {
+		return tx.Model(&StoryBoardScene{}).
+			Where("id = ?", id).
+			Where("status >= 0").
+			Updates(columns).Error
+	}
+}
+
+// RoleColumnsUpdate 更新角色的列
+func RoleColumnsUpdate(id int64, columns map[string]interface{}) RevisionUpdate {
+	return func(tx *gorm.DB) error {
+		if len(columns) == 0 {
+			return nil
+		}
+		columns["update_at"] = time.Now()
+		return tx.Model(&StoryRole{}).Where("id = ?", id).Updates(columns).Error
+	}
+}
+
+// UpdateWithRevision 在一个事务中修改对象并追加版本，版本号为当前最大版本加1
+// origin 只在对象还没有版本时写入，作为修改前的原始版本；rev 为nil时只修改对象
+// 并发修改同一对象导致版本号冲突时重试整个事务
+func UpdateWithRevision(ctx context.Context, update RevisionUpdate, origin, rev *Revision) error {
+	var err error
+	for i := 0; i <= revisionConflictRetries; i++ {
+		err = DataBase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := update(tx); err != nil {
+				return err
+			}
+			if rev == nil {
+				return nil
+			}
+			var maxVersion int64
+			if err := tx.Model(&Revision{}).
+				Where("entity_type = ? and entity_id = ?", rev.EntityType, rev.EntityId).
+				Select("COALESCE(MAX(version), 0)").
+				Scan(&maxVersion).Error; err != nil {
+				return err
+			}
+			if maxVersion == 0 && origin != nil {
+				origin.ID = 0
+				origin.Version = 1
+				if err := tx.Create(origin).Error; err != nil {
+					return err
+				}
+				maxVersion = origin.Version
+			}
+			rev.ID = 0
+			rev.Version = maxVersion + 1
+			return tx.Create(rev).Error
+		})
+		if !isDuplicateKey(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isDuplicateKey 是否为唯一索引冲突
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// GetRevision 获取指定版本，不存在时返回nil
+func GetRevision(ctx context.Context, entityType int, entityId int64, version int64) (*Revision, error) {
+	rev := &Revision{}
+	err := DataBase().Model(rev).
+		WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Where("version = ?", version).
+		First(rev).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return rev, nil
+}
+
+// CountRevisions 对象的版本数
+func CountRevisions(ctx context.Context, entityType int, entityId int64) (int64, error) {
+	var total int64
+	err := DataBase().Model(&Revision{}).
+		WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Count(&total).Error
+	return total, err
+}
+
+// ListRevisions 按版本从新到旧分页返回
+func ListRevisions(ctx context.Context, entityType int, entityId int64, offset, limit int) ([]*Revision, int64, error) {
+	total, err := CountRevisions(ctx, entityType, entityId)
+	if err != nil {
+		return nil, 0, err
+	}
+	revs := make([]*Revision, 0)
+	err = DataBase().Model(&Revision{}).
+		WithContext(ctx).
+		Where("entity_type = ? and entity_id = ?", entityType, entityId).
+		Order("version desc").
+		Offset(offset).
+		Limit(limit).
+		Find(&revs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return revs, total, nil
+}
@@ -157,6 +157,46 @@ func CreateStory(ctx context.Context, s *Story) (int64, error) {
 	return int64(s.ID), nil
 }
 
+// CreateStoryWithBoards 在一个事务中创建故事、故事板链和场景，boards 按阅读顺序串成链，
+// scenes[i] 为第i个故事板的场景，创建后回填各对象的ID
+func CreateStoryWithBoards(ctx context.Context, s *Story, boards []*StoryBoard, scenes [][]*StoryBoardScene) error {
+	if s.Avatar == "" {
+		s.Avatar = "https://grapery-dev.oss-cn-shanghai.aliyuncs.com/default.png"
+	}
+	return DataBase().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		prevId := int64(-1)
+		for i, board := range boards {
+			board.StoryID = int64(s.ID)
+			board.PrevId = prevId
+			if err := tx.Create(board).Error; err != nil {
+				return err
+			}
+			prevId = int64(board.ID)
+			if i >= len(scenes) {
+				continue
+			}
+			for _, scene := range scenes[i] {
+				scene.StoryId = int64(s.ID)
+				scene.BoardId = int64(board.ID)
+				scene.Status = 1
+				if err := tx.Create(scene).Error; err != nil {
+					return err
+				}
+			}
+		}
+		columns := map[string]interface{}{
+			"total_boards": len(boards),
+		}
+		if len(boards) > 0 {
+			columns["root_board_id"] = boards[0].ID
+		}
+		return tx.Model(&Story{}).Where("id = ?", s.ID).Updates(columns).Error
+	})
+}
+
 func UpdateStory(ctx context.Context, s *Story) error {
 	err := DataBase().Model(s).WithContext(ctx).Updates(s).Error
 	return err
@@ -148,6 +148,19 @@ func CreateStoryBoard(ctx context.Context, board *StoryBoard) (int64, error) {
 	return int64(board.IDBase.ID), nil
 }
 
+// GetStoryboardsByIDs 根据故事板id列表获取故事板，不返回已删除的故事板
+func GetStoryboardsByIDs(ctx context.Context, ids []int64) ([]*StoryBoard, error) {
+	boards := make([]*StoryBoard, 0)
+	err := DataBase().Model(&StoryBoard{}).
+		WithContext(ctx).
+		Where("id in (?) and status >= 0", ids).
+		Find(&boards).Error
+	if err != nil {
+		return nil, err
+	}
+	return boards, nil
+}
+
 func GetStoryboard(ctx context.Context, id int64) (*StoryBoard, error) {
 	board := &StoryBoard{}
 	err := DataBase().Model(board).
@@ -330,6 +343,12 @@ type StoryBoardScene struct {
 	GenResult    string `gorm:"column:gen_result" json:"gen_result,omitempty"`       // 生成结果
 	Status       int    `gorm:"column:status" json:"status,omitempty"`               // 记录状态
 	TaskId       string `gorm:"column:task_id" json:"task_id,omitempty"`             // 任务ID
+	// 用户选定的候选图片，GenResult 为它的图片
+	SelectedImageID int64  `gorm:"column:selected_image_id" json:"selected_image_id,omitempty"`
+	SelectedSeed    int64  `gorm:"column:selected_seed" json:"selected_seed,omitempty"`
+	SelectedPrompt  string `gorm:"column:selected_prompt" json:"selected_prompt,omitempty"`
+	// 最近一次生成完成的场景视频
+	VideoUrl string `gorm:"column:video_url" json:"video_url,omitempty"`
 }
 
 func (board StoryBoardScene) TableName() string {
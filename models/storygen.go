@@ -3,6 +3,7 @@ package models
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -24,6 +25,14 @@ const (
 	StoryGenStatusError
 )
 
+// StoryGen.TaskType 任务类型
+const (
+	StoryGenTaskStory      = 1
+	StoryGenTaskStoryboard = 2
+	StoryGenTaskRole       = 3
+	StoryGenTaskScenes     = 4
+)
+
 // just for gen record
 // StoryGen 记录一次生成任务的详细信息
 // status: 0-无效 1-有效
@@ -51,6 +60,9 @@ type StoryGen struct {
 	GenType        int            `gorm:"column:gen_type" json:"gen_type,omitempty"`               // 生成类型
 	TaskType       int            `gorm:"column:task_type" json:"task_type,omitempty"`             // 任务类型（1:故事,2:故事板,3:角色）
 	TaskId         string         `gorm:"column:task_id" json:"task_id,omitempty"`                 // 任务ID
+	Attempts       int            `gorm:"column:attempts" json:"attempts,omitempty"`               // 异步任务已执行次数
+	NextRunAt      int64          `gorm:"column:next_run_at" json:"next_run_at,omitempty"`         // 异步任务下次执行时间，0表示同步任务
+	ErrMsg         string         `gorm:"column:err_msg" json:"err_msg,omitempty"`                 // 最近一次失败原因
 }
 
 func (s StoryGen) TableName() string {
@@ -186,3 +198,72 @@ func GetStoryGenByTaskId(ctx context.Context, taskId string) (*StoryGen, error)
 	}
 	return gen, nil
 }
+
+// ClaimStoryGenJob 领取一个待执行的异步任务，按优先级从高到低，没有任务时返回nil
+// 通过条件更新保证多个worker不会领取到同一个任务
+func ClaimStoryGenJob(ctx context.Context, taskTypes []int) (*StoryGen, error) {
+	for i := 0; i < 3; i++ {
+		now := time.Now().Unix()
+		gen := &StoryGen{}
+		err := DataBase().Model(gen).
+			WithContext(ctx).
+			Where("gen_status = ? and next_run_at > 0 and next_run_at <= ?", StoryGenStatusInit, now).
+			Where("task_type in ? and deleted = ?", taskTypes, 0).
+			Order("priority desc, id asc").
+			First(gen).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret := DataBase().Model(&StoryGen{}).
+			WithContext(ctx).
+			Where("id = ? and gen_status = ?", gen.ID, StoryGenStatusInit).
+			Updates(map[string]interface{}{
+				"gen_status": StoryGenStatusRunning,
+				"start_time": now,
+				"attempts":   gen.Attempts + 1,
+			})
+		if ret.Error != nil {
+			return nil, ret.Error
+		}
+		if ret.RowsAffected == 1 {
+			gen.GenStatus = StoryGenStatusRunning
+			gen.StartTime = now
+			gen.Attempts++
+			return gen, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetLatestStoryGenJob 获取故事板上最近一次的异步任务，没有时返回nil
+func GetLatestStoryGenJob(ctx context.Context, boardId int64, taskType int) (*StoryGen, error) {
+	gen := &StoryGen{}
+	err := DataBase().Model(gen).
+		WithContext(ctx).
+		Where("board_id = ? and task_type = ? and next_run_at > 0", boardId, taskType).
+		Order("id desc").
+		First(gen).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gen, nil
+}
+
+// RequeueStaleStoryGenJobs 将执行超时的任务重新放回队列，用于worker异常退出后的恢复
+func RequeueStaleStoryGenJobs(ctx context.Context, timeout time.Duration) (int64, error) {
+	now := time.Now()
+	ret := DataBase().Model(&StoryGen{}).
+		WithContext(ctx).
+		Where("gen_status = ? and next_run_at > 0 and start_time < ?", StoryGenStatusRunning, now.Add(-timeout).Unix()).
+		Updates(map[string]interface{}{
+			"gen_status":  StoryGenStatusInit,
+			"next_run_at": now.Unix(),
+		})
+	return ret.RowsAffected, ret.Error
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserBlock 用户屏蔽关系，被屏蔽用户的评论、故事板和动态对屏蔽者不可见
+type UserBlock struct {
+	IDBase
+	UserId    int64 `gorm:"column:user_id;uniqueIndex:idx_user_block" json:"user_id,omitempty"`       // 屏蔽者
+	BlockedId int64 `gorm:"column:blocked_id;uniqueIndex:idx_user_block" json:"blocked_id,omitempty"` // 被屏蔽的用户
+}
+
+func (b UserBlock) TableName() string {
+	return "user_block"
+}
+
+// CreateUserBlock 已经屏蔽时不报错
+func CreateUserBlock(ctx context.Context, userId, blockedId int64) error {
+	return DataBase().WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&UserBlock{UserId: userId, BlockedId: blockedId}).Error
+}
+
+func DeleteUserBlock(ctx context.Context, userId, blockedId int64) error {
+	return DataBase().WithContext(ctx).
+		Where("user_id = ? and blocked_id = ?", userId, blockedId).
+		Delete(&UserBlock{}).Error
+}
+
+// GetBlockedUserIds 用户屏蔽的所有用户
+func GetBlockedUserIds(ctx context.Context, userId int64) ([]int64, error) {
+	ids := make([]int64, 0)
+	err := DataBase().Model(&UserBlock{}).
+		WithContext(ctx).
+		Where("user_id = ?", userId).
+		Pluck("blocked_id", &ids).Error
+	return ids, err
+}
+
+// GetBlockedBetweenIds 用户屏蔽的和屏蔽了该用户的所有用户
+func GetBlockedBetweenIds(ctx context.Context, userId int64) ([]int64, error) {
+	ids, err := GetBlockedUserIds(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	blockers := make([]int64, 0)
+	err = DataBase().Model(&UserBlock{}).
+		WithContext(ctx).
+		Where("blocked_id = ?", userId).
+		Pluck("user_id", &blockers).Error
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, blockers...), nil
+}
+
+// IsBlockedBetween 两个用户中是否有一方屏蔽了另一方
+func IsBlockedBetween(ctx context.Context, userId, otherId int64) (bool, error) {
+	var total int64
+	err := DataBase().Model(&UserBlock{}).
+		WithContext(ctx).
+		Where("(user_id = ? and blocked_id = ?) or (user_id = ? and blocked_id = ?)",
+			userId, otherId, otherId, userId).
+		Count(&total).Error
+	return total > 0, err
+}
+
+// ListUserBlocks 分页返回用户的屏蔽列表，最近屏蔽的在前
+func ListUserBlocks(ctx context.Context, userId int64, offset, limit int) ([]*UserBlock, int64, error) {
+	query := func() *gorm.DB {
+		return DataBase().Model(&UserBlock{}).
+			WithContext(ctx).
+			Where("user_id = ?", userId)
+	}
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	blocks := make([]*UserBlock, 0)
+	err := query().Order("id desc").
+		Offset(offset).
+		Limit(limit).
+		Find(&blocks).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return blocks, total, nil
+}
+
+// DeleteFollowBetween 删除两个用户之间双向的关注关系
+func DeleteFollowBetween(ctx context.Context, userId, otherId int64) error {
+	return DataBase().WithContext(ctx).
+		Where("(user_id = ? and followed_id = ?) or (user_id = ? and followed_id = ?)",
+			userId, otherId, otherId, userId).
+		Delete(&FollowUser{}).Error
+}
+
+// MutedKeyword 用户设置的屏蔽词，包含屏蔽词的评论、动态和搜索结果对该用户不可见
+type MutedKeyword struct {
+	IDBase
+	UserId  int64  `gorm:"column:user_id;uniqueIndex:idx_user_muted_keyword" json:"user_id,omitempty"`
+	Keyword string `gorm:"column:keyword;size:64;uniqueIndex:idx_user_muted_keyword" json:"keyword,omitempty"`
+}
+
+func (m MutedKeyword) TableName() string {
+	return "user_muted_keyword"
+}
+
+// CreateMutedKeyword 已经存在时不报错
+func CreateMutedKeyword(ctx context.Context, userId int64, keyword string) error {
+	return DataBase().WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&MutedKeyword{UserId: userId, Keyword: keyword}).Error
+}
+
+func DeleteMutedKeyword(ctx context.Context, userId int64, keyword string) error {
+	return DataBase().WithContext(ctx).
+		Where("user_id = ? and keyword = ?", userId, keyword).
+		Delete(&MutedKeyword{}).Error
+}
+
+// GetMutedKeywords 用户的所有屏蔽词，先添加的在前
+func GetMutedKeywords(ctx context.Context, userId int64) ([]*MutedKeyword, error) {
+	keywords := make([]*MutedKeyword, 0)
+	err := DataBase().Model(&MutedKeyword{}).
+		WithContext(ctx).
+		Where("user_id = ?", userId).
+		Order("id asc").
+		Find(&keywords).Error
+	return keywords, err
+}
+
+func CountMutedKeywords(ctx context.Context, userId int64) (int64, error) {
+	var total int64
+	err := DataBase().Model(&MutedKeyword{}).
+		WithContext(ctx).
+		Where("user_id = ?", userId).
+		Count(&total).Error
+	return total, err
+}
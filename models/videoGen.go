@@ -12,6 +12,8 @@ type VideoGen struct {
 	OriginID   int64  `gorm:"column:origin_id" json:"origin_id,omitempty"`     // 源故事ID
 	BoardID    int64  `gorm:"column:board_id" json:"board_id,omitempty"`       // 故事板ID
 	RoleID     int64  `gorm:"column:role_id" json:"role_id,omitempty"`         // 角色ID
+	SceneID    int64  `gorm:"column:scene_id" json:"scene_id,omitempty"`       // 场景ID
+	Platform   string `gorm:"column:platform" json:"platform,omitempty"`       // 生成平台
 	TaskID     string `gorm:"column:task_id" json:"task_id,omitempty"`         // 任务ID
 	Uuid       string `gorm:"column:uuid" json:"uuid,omitempty"`               // 唯一标识
 	Status     int    `gorm:"column:status" json:"status,omitempty"`           // 状态
@@ -104,3 +106,20 @@ func GetVideoGenByTaskID(ctx context.Context, taskID string) (*VideoGen, error)
 	}
 	return video, nil
 }
+
+// GetLatestSceneVideoGen 场景最近一次的视频生成记录，没有时返回nil
+func GetLatestSceneVideoGen(ctx context.Context, sceneId int64) (*VideoGen, error) {
+	video := &VideoGen{}
+	err := DataBase().Model(video).
+		WithContext(ctx).
+		Where("scene_id = ?", sceneId).
+		Order("id desc").
+		First(video).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return video, nil
+}
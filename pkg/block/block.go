@@ -0,0 +1,245 @@
+package block
+
+// 用户屏蔽和屏蔽词：被屏蔽用户的评论、故事板、动态和搜索结果对屏蔽者不可见
+// 屏蔽时同时取消双方的关注，之后任意一方都不能再关注对方；包含屏蔽词的内容同样不可见
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxKeywordLen   = 64
+	maxKeywords     = 200
+)
+
+var (
+	ErrInvalidBlock   = errors.New("invalid block user")
+	ErrInvalidFollow  = errors.New("invalid follow user")
+	ErrFollowBlocked  = errors.New("can not follow a blocked user")
+	ErrInvalidKeyword = errors.New("invalid muted keyword")
+	ErrTooManyKeyword = errors.New("too many muted keywords")
+)
+
+// Block 屏蔽用户并取消双方的关注
+func Block(ctx context.Context, blockedId int64) error {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if blockedId <= 0 || blockedId == userId {
+		return ErrInvalidBlock
+	}
+	if err := models.CreateUserBlock(ctx, userId, blockedId); err != nil {
+		log.Log().Error("create user block failed", zap.Int64("user_id", userId),
+			zap.Int64("blocked_id", blockedId), zap.Error(err))
+		return err
+	}
+	if err := models.DeleteFollowBetween(ctx, userId, blockedId); err != nil {
+		log.Log().Error("delete follow between blocked users failed", zap.Int64("user_id", userId),
+			zap.Int64("blocked_id", blockedId), zap.Error(err))
+	}
+	return nil
+}
+
+// Unblock 取消屏蔽，不会恢复之前的关注
+func Unblock(ctx context.Context, blockedId int64) error {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return models.DeleteUserBlock(ctx, userId, blockedId)
+}
+
+// BlockedUser 屏蔽列表中的用户
+type BlockedUser struct {
+	UserId    int64  `json:"user_id"`
+	Name      string `json:"name"`
+	Avatar    string `json:"avatar"`
+	BlockedAt int64  `json:"blocked_at"`
+}
+
+type BlockedUserList struct {
+	Total int64          `json:"total"`
+	List  []*BlockedUser `json:"list"`
+}
+
+// ListBlocked 当前用户的屏蔽列表
+func ListBlocked(ctx context.Context, offset, pageSize int) (*BlockedUserList, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	blocks, total, err := models.ListUserBlocks(ctx, userId, offset, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(blocks))
+	for _, b := range blocks {
+		ids = append(ids, b.BlockedId)
+	}
+	users, err := models.GetUsersByIdsMap(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*BlockedUser, 0, len(blocks))
+	for _, b := range blocks {
+		item := &BlockedUser{UserId: b.BlockedId, BlockedAt: b.CreateAt.Unix()}
+		if u, ok := users[int(b.BlockedId)]; ok && u != nil {
+			item.Name, item.Avatar = u.Name, u.Avatar
+		}
+		list = append(list, item)
+	}
+	return &BlockedUserList{Total: total, List: list}, nil
+}
+
+// Follow 关注用户，任意一方屏蔽了对方时返回 ErrFollowBlocked
+func Follow(ctx context.Context, followId int64) error {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if followId <= 0 || followId == userId {
+		return ErrInvalidFollow
+	}
+	blocked, err := models.IsBlockedBetween(ctx, userId, followId)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrFollowBlocked
+	}
+	if _, err := models.GetFollowUserByIDs(ctx, userId, followId); err == nil {
+		return nil
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return models.NewFollowUser(userId, followId)
+}
+
+// FollowUser 关注列表中的用户
+type FollowUser struct {
+	UserId     int64  `json:"user_id"`
+	Name       string `json:"name"`
+	Avatar     string `json:"avatar"`
+	FollowedAt int64  `json:"followed_at"`
+}
+
+type FollowUserList struct {
+	Total int64         `json:"total"`
+	List  []*FollowUser `json:"list"`
+}
+
+// ListFollowing 用户关注的人，不包含与当前用户之间有屏蔽关系的用户
+func ListFollowing(ctx context.Context, userId int64, offset, pageSize int) (*FollowUserList, error) {
+	return listFollows(ctx, userId, offset, pageSize, models.ListFollowing,
+		func(f *models.FollowUser) int64 { return f.FollowedID })
+}
+
+// ListFollowers 关注用户的人，不包含与当前用户之间有屏蔽关系的用户
+func ListFollowers(ctx context.Context, userId int64, offset, pageSize int) (*FollowUserList, error) {
+	return listFollows(ctx, userId, offset, pageSize, models.ListFollowers,
+		func(f *models.FollowUser) int64 { return f.UserID })
+}
+
+func listFollows(ctx context.Context, userId int64, offset, pageSize int,
+	list func(ctx context.Context, userId int64, excludeIds []int64, offset, limit int) ([]*models.FollowUser, int64, error),
+	other func(f *models.FollowUser) int64) (*FollowUserList, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	var excludeIds []int64
+	if viewerId, _ := utils.GetUserIDFromContext(ctx); viewerId > 0 {
+		ids, err := models.GetBlockedBetweenIds(ctx, viewerId)
+		if err != nil {
+			log.Log().Error("get blocked between ids failed", zap.Int64("user_id", viewerId), zap.Error(err))
+			return nil, err
+		}
+		excludeIds = ids
+	}
+	follows, total, err := list(ctx, userId, excludeIds, offset, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(follows))
+	for _, f := range follows {
+		ids = append(ids, other(f))
+	}
+	users, err := models.GetUsersByIdsMap(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	ret := &FollowUserList{Total: total, List: make([]*FollowUser, 0, len(follows))}
+	for _, f := range follows {
+		item := &FollowUser{UserId: other(f), FollowedAt: f.CreateAt.Unix()}
+		if u, ok := users[int(item.UserId)]; ok && u != nil {
+			item.Name, item.Avatar = u.Name, u.Avatar
+		}
+		ret.List = append(ret.List, item)
+	}
+	return ret, nil
+}
+
+// AddMutedKeyword 添加屏蔽词，不区分大小写
+func AddMutedKeyword(ctx context.Context, keyword string) error {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	if keyword == "" || len([]rune(keyword)) > maxKeywordLen {
+		return ErrInvalidKeyword
+	}
+	total, err := models.CountMutedKeywords(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if total >= maxKeywords {
+		return ErrTooManyKeyword
+	}
+	return models.CreateMutedKeyword(ctx, userId, keyword)
+}
+
+func RemoveMutedKeyword(ctx context.Context, keyword string) error {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return models.DeleteMutedKeyword(ctx, userId, strings.ToLower(strings.TrimSpace(keyword)))
+}
+
+// ListMutedKeywords 当前用户的屏蔽词
+func ListMutedKeywords(ctx context.Context) ([]string, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keywords, err := models.GetMutedKeywords(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(keywords))
+	for _, k := range keywords {
+		list = append(list, k.Keyword)
+	}
+	return list, nil
+}
@@ -0,0 +1,17 @@
+package block
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/utils"
+)
+
+func TestFollowInvalid(t *testing.T) {
+	ctx := context.WithValue(context.Background(), utils.UserIdKey, int64(1))
+	assert.ErrorIs(t, Follow(ctx, 0), ErrInvalidFollow)
+	assert.ErrorIs(t, Follow(ctx, 1), ErrInvalidFollow)
+	assert.Error(t, Follow(context.Background(), 2))
+}
@@ -0,0 +1,93 @@
+package block
+
+import (
+	"context"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// Filter 用户看到的内容的过滤条件，零值不过滤任何内容
+type Filter struct {
+	blocked  map[int64]struct{}
+	keywords []string
+}
+
+// NewFilter blocked 为屏蔽的用户，keywords 为屏蔽词
+func NewFilter(blocked []int64, keywords []string) *Filter {
+	f := &Filter{blocked: make(map[int64]struct{}, len(blocked))}
+	for _, id := range blocked {
+		f.blocked[id] = struct{}{}
+	}
+	for _, k := range keywords {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			f.keywords = append(f.keywords, k)
+		}
+	}
+	return f
+}
+
+// LoadFilter 加载用户的过滤条件，未登录或者查询失败时不过滤
+func LoadFilter(ctx context.Context, userId int64) *Filter {
+	if userId <= 0 {
+		return &Filter{}
+	}
+	blocked, err := models.GetBlockedUserIds(ctx, userId)
+	if err != nil {
+		log.Log().Error("get blocked user ids failed", zap.Int64("user_id", userId), zap.Error(err))
+	}
+	mutes, err := models.GetMutedKeywords(ctx, userId)
+	if err != nil {
+		log.Log().Error("get muted keywords failed", zap.Int64("user_id", userId), zap.Error(err))
+	}
+	keywords := make([]string, 0, len(mutes))
+	for _, m := range mutes {
+		keywords = append(keywords, m.Keyword)
+	}
+	return NewFilter(blocked, keywords)
+}
+
+// Empty 没有需要过滤的内容
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.blocked) == 0 && len(f.keywords) == 0)
+}
+
+// Blocked 用户是否被屏蔽
+func (f *Filter) Blocked(userId int64) bool {
+	if f == nil {
+		return false
+	}
+	_, ok := f.blocked[userId]
+	return ok
+}
+
+// Muted 文本是否包含屏蔽词
+func (f *Filter) Muted(texts ...string) bool {
+	if f == nil || len(f.keywords) == 0 {
+		return false
+	}
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, k := range f.keywords {
+			if strings.Contains(text, k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Hidden 作者被屏蔽或者内容包含屏蔽词
+func (f *Filter) Hidden(authorId int64, texts ...string) bool {
+	return f.Blocked(authorId) || f.Muted(texts...)
+}
+
+// ViewerFilter 加载当前登录用户的过滤条件
+func ViewerFilter(ctx context.Context) *Filter {
+	userId, _ := utils.GetUserIDFromContext(ctx)
+	return LoadFilter(ctx, userId)
+}
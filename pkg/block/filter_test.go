@@ -0,0 +1,28 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	f := NewFilter([]int64{2, 3}, []string{" Spoiler ", "", "剧透"})
+	assert.False(t, f.Empty())
+	assert.True(t, f.Blocked(2))
+	assert.False(t, f.Blocked(1))
+	assert.True(t, f.Muted("big SPOILER ahead"))
+	assert.True(t, f.Muted("title", "这里有剧透"))
+	assert.False(t, f.Muted("nothing here"))
+	assert.True(t, f.Hidden(3, "hello"))
+	assert.True(t, f.Hidden(1, "spoiler"))
+	assert.False(t, f.Hidden(1, "hello"))
+}
+
+func TestFilterEmpty(t *testing.T) {
+	var f *Filter
+	assert.True(t, f.Empty())
+	assert.False(t, f.Hidden(1, "anything"))
+	assert.True(t, NewFilter(nil, []string{" "}).Empty())
+	assert.False(t, (&Filter{}).Muted("text"))
+}
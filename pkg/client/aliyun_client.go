@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -414,6 +415,7 @@ type DashScopeImageInputParams struct {
 	Watermark    bool    `json:"watermark,omitempty"`     // 可选字段，用于添加水印
 	IsSketch     bool    `json:"is_sketch,omitempty"`     // 可选字段，用于指定是否为草图
 	Temperature  float64 `json:"temperature,omitempty"`   // 可选字段，用于控制生成图像的温度
+	Seed         int64   `json:"seed,omitempty"`          // 可选字段，随机种子
 }
 
 func (d DashScopeImageInputParams) String() string {
@@ -459,6 +461,7 @@ func (c *AliyunStoryClient) GenStoryBoardImages(ctx context.Context, params *Gen
 			N:            1,
 			PromptExtend: true, // 默认开启提示词扩展
 			Watermark:    true, // 默认添加水印
+			Seed:         params.Seed,
 		},
 	}
 	jsonData, err := json.Marshal(requestBody)
@@ -605,6 +608,7 @@ func (c *AliyunStoryClient) RepaintingStoryBoardImages(ctx context.Context, para
 			N:            1,
 			PromptExtend: true, // 默认开启提示词扩展
 			Watermark:    true, // 默认添加水印
+			Seed:         params.Seed,
 		},
 	}
 	jsonData, err := json.Marshal(requestBody)
@@ -668,6 +672,7 @@ func (c *AliyunStoryClient) SketchStoryBoardImages(ctx context.Context, params *
 			PromptExtend: true, // 默认开启提示词扩展
 			Watermark:    true, // 默认添加水印
 			IsSketch:     true, // 指定为草图模式
+			Seed:         params.Seed,
 		},
 	}
 	jsonData, err := json.Marshal(requestBody)
@@ -1066,3 +1071,198 @@ func (c *AliyunStoryClient) GetVideoGenerationTaskStatus(ctx context.Context, ta
 	}
 	return ret, nil
 }
+
+func (c *AliyunStoryClient) Name() string {
+	return PlatformNameAliyun
+}
+
+// textGeneration 调用百炼文本生成接口，返回内容和token数
+func (c *AliyunStoryClient) textGeneration(ctx context.Context, model string, messages []DashScopeTextMessage) (string, int, error) {
+	if model == "" {
+		model = "qwen-plus"
+	}
+	requestBody := DashScopeTextRequestBody{
+		Model: model,
+		Input: DashScopeInput{
+			Messages: messages,
+		},
+		Parameters: DashScopeTextParameters{
+			ResultFormat: "message",
+		},
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.DashScopeAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	bodyText, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("http status: %d, body: %s", resp.StatusCode, string(bodyText))
+	}
+	ret := &DashScopeTextResponse{}
+	err = json.Unmarshal(bodyText, ret)
+	if err != nil {
+		return "", 0, err
+	}
+	content := ret.Output.Text
+	if len(ret.Output.Choices) > 0 {
+		content = ret.Output.Choices[0].Message.Content
+	}
+	return content, ret.Usage.TotalTokens, nil
+}
+
+func (c *AliyunStoryClient) GenerateText(ctx context.Context, params *TextParams) (*TextResult, error) {
+	messages := make([]DashScopeTextMessage, 0, 2)
+	if params.System != "" {
+		messages = append(messages, DashScopeTextMessage{Role: "system", Content: params.System})
+	}
+	messages = append(messages, DashScopeTextMessage{Role: "user", Content: params.Prompt})
+	content, tokenNum, err := c.textGeneration(ctx, params.Model, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &TextResult{
+		Content:  content,
+		TokenNum: tokenNum,
+	}, nil
+}
+
+func (c *AliyunStoryClient) Chat(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	messages := make([]DashScopeTextMessage, 0, len(params.Messages)+1)
+	if params.System != "" {
+		messages = append(messages, DashScopeTextMessage{Role: "system", Content: params.System})
+	}
+	for _, m := range params.Messages {
+		messages = append(messages, DashScopeTextMessage{Role: m.Role, Content: m.Content})
+	}
+	content, tokenNum, err := c.textGeneration(ctx, params.Model, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResult{
+		Content:  content,
+		TokenNum: tokenNum,
+	}, nil
+}
+
+// GenerateImage 百炼图片生成为异步任务，结果需要通过 GetImageGenerationTaskStatus 查询
+func (c *AliyunStoryClient) GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	ret, err := c.GenStoryBoardImages(ctx, &GenStoryImagesParams{
+		Content:        params.Prompt,
+		NegativePrompt: params.NegativePrompt,
+		RefImage:       params.RefImage,
+		UserId:         params.UserId,
+		RequestId:      params.RequestId,
+		Seed:           params.Seed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ImageResult{
+		TaskId: ret.Output.TaskID,
+	}, nil
+}
+
+// GenerateImageWithRef 参考图用万相图像编辑保持人物一致，草图和局部重绘用文生图的对应功能，都是异步任务
+func (c *AliyunStoryClient) GenerateImageWithRef(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	if params.RefImage == "" {
+		return c.GenerateImage(ctx, params)
+	}
+	imageParams := &GenStoryImagesParams{
+		Prompt:         params.Prompt,
+		NegativePrompt: params.NegativePrompt,
+		RefImage:       params.RefImage,
+		MaskImageUrl:   params.MaskImage,
+		UserId:         params.UserId,
+		RequestId:      params.RequestId,
+		Seed:           params.Seed,
+	}
+	switch params.RefMode {
+	case RefModeSketch:
+		ret, err := c.SketchStoryBoardImages(ctx, imageParams)
+		if err != nil {
+			return nil, err
+		}
+		return &ImageResult{TaskId: ret.Output.TaskID}, nil
+	case RefModeRepaint:
+		if params.MaskImage == "" {
+			return nil, errors.New("repaint needs a mask image")
+		}
+		ret, err := c.RepaintingStoryBoardImages(ctx, imageParams)
+		if err != nil {
+			return nil, err
+		}
+		return &ImageResult{TaskId: ret.Output.TaskID}, nil
+	case "", RefModeReference:
+		// 万相图像编辑只接受一张参考图，只能保持一个人物的形象，多个角色的场景不应使用参考图
+		var editParams *aliyun.T2IParams
+		if params.Seed > 0 {
+			seed := int(params.Seed)
+			editParams = &aliyun.T2IParams{Seed: &seed}
+		}
+		ret, err := aliyun.NewWanxiangClient(c.DashScopeAPIKey).EditImage(ctx, params.RefImage, params.Prompt, editParams)
+		if err != nil {
+			return nil, err
+		}
+		return &ImageResult{TaskId: ret.Output.TaskID}, nil
+	}
+	return nil, fmt.Errorf("%w: aliyun ref mode %s", ErrNotSupported, params.RefMode)
+}
+
+// EditImage 用万相的图像编辑能力修改已有图片，都是异步任务
+func (c *AliyunStoryClient) EditImage(ctx context.Context, params *ImageEditParams) (*ImageResult, error) {
+	if params.Image == "" {
+		return nil, errors.New("edit needs a base image")
+	}
+	wanxiang := aliyun.NewWanxiangClient(c.DashScopeAPIKey)
+	editParams := &aliyun.T2IParams{N: aliyun.IntPtr(1)}
+	if params.Seed > 0 {
+		seed := int(params.Seed)
+		editParams.Seed = &seed
+	}
+	var (
+		ret *aliyun.TaskResponse
+		err error
+	)
+	switch params.Mode {
+	case EditModeInpaint:
+		if params.MaskImage == "" {
+			return nil, errors.New("inpaint needs a mask image")
+		}
+		ret, err = wanxiang.LocalEditImage(ctx, params.Image, params.Prompt, editParams, params.MaskImage)
+	case EditModeInstruction:
+		ret, err = wanxiang.EditImage(ctx, params.Image, params.Prompt, editParams)
+	case EditModeRestyle:
+		// PortraitStyleTransfer 用 Seed 传预置风格，用 prompt 传风格参考图
+		style := params.Style
+		ret, err = wanxiang.PortraitStyleTransfer(ctx, params.Image, params.StyleRef, &aliyun.T2IParams{Seed: &style})
+	case EditModeExpand:
+		if params.Expand == nil {
+			return nil, errors.New("expand needs scales")
+		}
+		scales := [4]float64{params.Expand.Top, params.Expand.Bottom, params.Expand.Left, params.Expand.Right}
+		ret, err = wanxiang.ExpandImage(ctx, params.Image, params.Prompt, editParams, scales)
+	default:
+		return nil, fmt.Errorf("%w: aliyun edit mode %s", ErrNotSupported, params.Mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret.Output.TaskID == "" {
+		return nil, fmt.Errorf("edit image failed: %s %s", ret.Code, ret.Message)
+	}
+	return &ImageResult{TaskId: ret.Output.TaskID}, nil
+}
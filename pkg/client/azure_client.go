@@ -1,3 +1,74 @@
 package client
 
 // azure and openai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grapery/grapery/pkg/cloud/azure"
+)
+
+// AzureClient 将 Azure OpenAI 接入统一的 Provider 接口
+type AzureClient struct {
+	Client *azure.AzureOpenAIClient
+}
+
+func NewAzureClient(endpoint, apiKey, deploymentName string) (*AzureClient, error) {
+	client, err := azure.NewAzureOpenAIClient(endpoint, apiKey, deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureClient{
+		Client: client,
+	}, nil
+}
+
+func (c *AzureClient) Name() string {
+	return PlatformNameAzure
+}
+
+func (c *AzureClient) GenerateText(ctx context.Context, params *TextParams) (*TextResult, error) {
+	messages := make([]azure.ChatMessage, 0, 2)
+	if params.System != "" {
+		messages = append(messages, azure.ChatMessage{Role: "system", Content: params.System})
+	}
+	messages = append(messages, azure.ChatMessage{Role: "user", Content: params.Prompt})
+	content, err := c.Client.Chat(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &TextResult{
+		Content: content,
+	}, nil
+}
+
+func (c *AzureClient) Chat(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	messages := make([]azure.ChatMessage, 0, len(params.Messages)+1)
+	if params.System != "" {
+		messages = append(messages, azure.ChatMessage{Role: "system", Content: params.System})
+	}
+	for _, m := range params.Messages {
+		messages = append(messages, azure.ChatMessage{Role: m.Role, Content: m.Content})
+	}
+	content, err := c.Client.Chat(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResult{
+		Content: content,
+	}, nil
+}
+
+func (c *AzureClient) GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	url, err := c.Client.GenerateImage(ctx, params.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		return nil, fmt.Errorf("azure return empty image url")
+	}
+	return &ImageResult{
+		ImageUrls: []string{url},
+	}, nil
+}
@@ -48,6 +48,7 @@ type DoubaoGenImageParams struct {
 	Size           string `json:"size"`
 	GuidanceScale  int    `json:"guidance_scale"`
 	Watermark      bool   `json:"watermark"`
+	Seed           int64  `json:"seed,omitempty"`
 }
 
 func (d DoubaoGenImageParams) String() string {
@@ -89,6 +90,7 @@ func (c *DoubaoClient) GenStoryBoardImage(ctx context.Context, params *GenStoryI
 	realParams.Size = "1024x1024"
 	realParams.GuidanceScale = 3
 	realParams.ResponseFormat = "url"
+	realParams.Seed = params.Seed
 	// 1. 序列化请求参数为 JSON
 	body, err := json.Marshal(realParams)
 	if err != nil {
@@ -441,7 +443,8 @@ func (c *DoubaoClient) GenStoryboardVideo(ctx context.Context, params *GenStoryb
 		return nil, err
 	}
 
-	url := "https://ark.cn-beijing.volces.com/api/v3/images/generations"
+	// 视频生成是异步任务，提交到内容生成任务接口
+	url := "https://ark.cn-beijing.volces.com/api/v3/contents/generations/tasks"
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
@@ -622,3 +625,110 @@ func (d DoubaoClient) ChatWithRole(ctx context.Context, params *ChatWithRolePara
 	}
 	return ret, nil
 }
+
+func (c *DoubaoClient) Name() string {
+	return PlatformNameDoubao
+}
+
+// chatCompletion 调用方舟对话接口，返回内容和token数
+func (c *DoubaoClient) chatCompletion(ctx context.Context, model string, messages []DoubaoChatCompletionMessage) (string, int, error) {
+	if model == "" {
+		model = "doubao-seed-1-6-flash-250615"
+	}
+	body, err := json.Marshal(&DoubaoGenStoryInfoParams{
+		Model:    model,
+		Messages: messages,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	url := "https://ark.cn-beijing.volces.com/api/v3/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.DoubaoAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("http status: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	var result DoubaoGenStoryInfoResult
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(result.Choices) == 0 {
+		return "", 0, fmt.Errorf("doubao return empty choices")
+	}
+	return result.Choices[0].Message.Content, result.Usage.TotalTokens, nil
+}
+
+func doubaoTextMessage(role, text string) DoubaoChatCompletionMessage {
+	return DoubaoChatCompletionMessage{
+		Role: role,
+		Content: []DoubaoChatCompletionMessageContent{
+			{
+				Type: "text",
+				Text: text,
+			},
+		},
+	}
+}
+
+func (c *DoubaoClient) GenerateText(ctx context.Context, params *TextParams) (*TextResult, error) {
+	messages := make([]DoubaoChatCompletionMessage, 0, 2)
+	if params.System != "" {
+		messages = append(messages, doubaoTextMessage("system", params.System))
+	}
+	messages = append(messages, doubaoTextMessage("user", params.Prompt))
+	content, tokenNum, err := c.chatCompletion(ctx, params.Model, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &TextResult{
+		Content:  content,
+		TokenNum: tokenNum,
+	}, nil
+}
+
+func (c *DoubaoClient) Chat(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	messages := make([]DoubaoChatCompletionMessage, 0, len(params.Messages)+1)
+	if params.System != "" {
+		messages = append(messages, doubaoTextMessage("system", params.System))
+	}
+	for _, m := range params.Messages {
+		messages = append(messages, doubaoTextMessage(m.Role, m.Content))
+	}
+	content, tokenNum, err := c.chatCompletion(ctx, params.Model, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResult{
+		Content:  content,
+		TokenNum: tokenNum,
+	}, nil
+}
+
+func (c *DoubaoClient) GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	ret, err := c.GenStoryBoardImage(ctx, &GenStoryImagesParams{
+		Content:   params.Prompt,
+		UserId:    params.UserId,
+		RequestId: params.RequestId,
+		Seed:      params.Seed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ImageResult{
+		ImageUrls: ret.ImageUrls,
+	}, nil
+}
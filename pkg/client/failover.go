@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen         = errors.New("provider circuit breaker is open")
+	ErrAllProvidersFailed  = errors.New("all llm providers failed")
+	DefaultBreakerOptions  = BreakerOptions{Window: time.Minute, MinRequests: 5, ErrorRate: 0.5, Cooldown: 30 * time.Second}
+	DefaultAttemptTimeout  = 90 * time.Second
+	breakerMaxWindowEvents = 256
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions 熔断参数
+// 窗口内请求数达到 MinRequests 且错误率超过 ErrorRate 时熔断，Cooldown 后放行一次探测请求
+type BreakerOptions struct {
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64
+	Cooldown    time.Duration
+}
+
+type breakerEvent struct {
+	at     time.Time
+	failed bool
+}
+
+// CircuitBreaker 单个平台的熔断器
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	opts     BreakerOptions
+	state    BreakerState
+	openedAt time.Time
+	probing  bool
+	events   []breakerEvent
+	now      func() time.Time
+}
+
+func NewCircuitBreaker(opts BreakerOptions) *CircuitBreaker {
+	return &CircuitBreaker{
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+// Allow 判断是否允许请求通过，半开状态下同一时间只放行一个探测请求
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.opts.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerClosed
+		b.probing = false
+		b.events = b.events[:0]
+		return
+	}
+	b.record(false)
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.trip()
+		return
+	}
+	b.record(true)
+	total, failed := b.count()
+	if total >= b.opts.MinRequests && float64(failed)/float64(total) >= b.opts.ErrorRate {
+		b.trip()
+	}
+}
+
+// Cancel 请求被调用方取消或者平台不支持，结果不计入错误率，半开状态下释放探测名额
+func (b *CircuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// ErrorRate 当前窗口内的错误率
+func (b *CircuitBreaker) ErrorRate() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total, failed := b.count()
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
+
+func (b *CircuitBreaker) trip() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.probing = false
+	b.events = b.events[:0]
+}
+
+func (b *CircuitBreaker) record(failed bool) {
+	b.events = append(b.events, breakerEvent{at: b.now(), failed: failed})
+	if len(b.events) > breakerMaxWindowEvents {
+		b.events = b.events[len(b.events)-breakerMaxWindowEvents:]
+	}
+}
+
+// count 统计窗口内的请求数和失败数，同时清理过期记录
+func (b *CircuitBreaker) count() (int, int) {
+	deadline := b.now().Add(-b.opts.Window)
+	idx := 0
+	for idx < len(b.events) && b.events[idx].at.Before(deadline) {
+		idx++
+	}
+	b.events = b.events[idx:]
+	failed := 0
+	for _, e := range b.events {
+		if e.failed {
+			failed++
+		}
+	}
+	return len(b.events), failed
+}
+
+// breaker 获取平台的熔断器，不存在时按当前参数创建
+func (r *Registry) breaker(name string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[name]
+	if !ok {
+		b = NewCircuitBreaker(r.breakerOpts)
+		r.breakers[name] = b
+	}
+	return b
+}
+
+// SetBreakerOptions 设置熔断参数，只对之后创建的熔断器生效
+func (r *Registry) SetBreakerOptions(opts BreakerOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakerOpts = opts
+}
+
+// SetAttemptTimeout 设置故障转移时单个平台的超时时间
+func (r *Registry) SetAttemptTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attemptTimeout = timeout
+}
+
+// SetChain 设置业务场景的故障转移顺序
+func (r *Registry) SetChain(scene string, names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chain := make([]string, 0, len(names))
+	for _, name := range names {
+		chain = append(chain, normalizeName(name))
+	}
+	r.chains[scene] = chain
+}
+
+// BreakerState 获取平台熔断器的状态
+func (r *Registry) BreakerState(name string) BreakerState {
+	return r.breaker(normalizeName(name)).State()
+}
+
+// FailoverChain 按顺序尝试多个平台
+type FailoverChain struct {
+	registry *Registry
+	names    []string
+	timeout  time.Duration
+}
+
+// Chain 获取业务场景的故障转移链，未配置时只包含场景绑定的平台
+func (r *Registry) Chain(scene string) *FailoverChain {
+	r.mu.RLock()
+	names, ok := r.chains[scene]
+	if !ok || len(names) == 0 {
+		name := r.scenes[scene]
+		if name == "" {
+			name = r.defaultName
+		}
+		names = []string{name}
+	}
+	timeout := r.attemptTimeout
+	r.mu.RUnlock()
+	return &FailoverChain{
+		registry: r,
+		names:    names,
+		timeout:  timeout,
+	}
+}
+
+// Do 依次在链上的平台执行fn，直到成功为止，返回实际提供服务的平台
+// 熔断中的平台会被跳过，ErrNotSupported 不计入错误率
+func (c *FailoverChain) Do(ctx context.Context, fn func(ctx context.Context, p Provider) error) (Provider, error) {
+	var errs []error
+	for _, name := range c.names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p, err := c.registry.Get(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		b := c.registry.breaker(normalizeName(p.Name()))
+		if !b.Allow() {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), ErrCircuitOpen))
+			continue
+		}
+		err = c.attempt(ctx, p, fn)
+		if err == nil {
+			b.Success()
+			return p, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+		if errors.Is(err, ErrNotSupported) {
+			// 平台不支持不代表平台可用，熔断器按平台共享，不能因此结束半开状态
+			b.Cancel()
+			continue
+		}
+		if ctx.Err() != nil {
+			// 调用方取消，不计入平台的错误率
+			b.Cancel()
+			return nil, errors.Join(errs...)
+		}
+		b.Failure()
+	}
+	return nil, fmt.Errorf("%w: %w", ErrAllProvidersFailed, errors.Join(errs...))
+}
+
+func (c *FailoverChain) attempt(ctx context.Context, p Provider, fn func(ctx context.Context, p Provider) error) error {
+	if c.timeout <= 0 {
+		return fn(ctx, p)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return fn(attemptCtx, p)
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(BreakerOptions{Window: time.Minute, MinRequests: 4, ErrorRate: 0.5, Cooldown: 10 * time.Second})
+	b.now = func() time.Time { return now }
+
+	b.Success()
+	b.Success()
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State())
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Allow())
+
+	// 冷却结束后只放行一个探测请求
+	now = now.Add(11 * time.Second)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+
+	now = now.Add(11 * time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State())
+	assert.Equal(t, float64(0), b.ErrorRate())
+
+	// 探测请求被取消时释放探测名额
+	b.Failure()
+	b.Failure()
+	b.Failure()
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+	now = now.Add(11 * time.Second)
+	assert.True(t, b.Allow())
+	b.Cancel()
+	assert.True(t, b.Allow())
+}
+
+func TestFailoverChainCancelProbe(t *testing.T) {
+	now := time.Now()
+	r := NewRegistry()
+	r.SetBreakerOptions(BreakerOptions{Window: time.Minute, MinRequests: 1, ErrorRate: 0.5, Cooldown: 10 * time.Second})
+	r.Register(&mockProvider{name: "coze"})
+	r.SetChain("storyboard", "coze")
+	b := r.breaker("coze")
+	b.now = func() time.Time { return now }
+
+	_, err := r.Chain("storyboard").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return errors.New("down")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, BreakerOpen, r.BreakerState("coze"))
+
+	now = now.Add(11 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = r.Chain("storyboard").Do(ctx, func(ctx context.Context, p Provider) error {
+		cancel()
+		return ctx.Err()
+	})
+	assert.Error(t, err)
+
+	// 取消的探测不影响下一次探测
+	p, err := r.Chain("storyboard").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "coze", p.Name())
+	assert.Equal(t, BreakerClosed, r.BreakerState("coze"))
+
+	_, err = r.Chain("storyboard").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return errors.New("down")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, BreakerOpen, r.BreakerState("coze"))
+
+	// 不支持的能力不会结束半开状态，也不占用探测名额
+	now = now.Add(11 * time.Second)
+	_, err = r.Chain("storyboard").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return ErrNotSupported
+	})
+	assert.Error(t, err)
+	assert.Equal(t, BreakerHalfOpen, r.BreakerState("coze"))
+	_, err = r.Chain("storyboard").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return errors.New("down")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, BreakerOpen, r.BreakerState("coze"))
+}
+
+func TestFailoverChain(t *testing.T) {
+	r := NewRegistry()
+	r.SetBreakerOptions(BreakerOptions{Window: time.Minute, MinRequests: 1, ErrorRate: 0.5, Cooldown: time.Minute})
+	r.Register(&mockProvider{name: "coze"})
+	r.Register(&mockProvider{name: "doubao"})
+	r.Register(&mockProvider{name: "zhipu"})
+	r.SetChain("storyboard", "coze", "doubao", "zhipu")
+
+	calls := make([]string, 0)
+	fn := func(ctx context.Context, p Provider) error {
+		calls = append(calls, p.Name())
+		switch p.Name() {
+		case "coze":
+			return errors.New("workflow timeout")
+		case "doubao":
+			return ErrNotSupported
+		}
+		return nil
+	}
+	p, err := r.Chain("storyboard").Do(context.Background(), fn)
+	assert.NoError(t, err)
+	assert.Equal(t, "zhipu", p.Name())
+	assert.Equal(t, []string{"coze", "doubao", "zhipu"}, calls)
+	assert.Equal(t, BreakerOpen, r.BreakerState("coze"))
+	assert.Equal(t, BreakerClosed, r.BreakerState("doubao"))
+
+	// 熔断中的平台直接跳过
+	calls = calls[:0]
+	p, err = r.Chain("storyboard").Do(context.Background(), fn)
+	assert.NoError(t, err)
+	assert.Equal(t, "zhipu", p.Name())
+	assert.Equal(t, []string{"doubao", "zhipu"}, calls)
+
+	_, err = r.Chain("storyboard").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return errors.New("down")
+	})
+	assert.True(t, errors.Is(err, ErrAllProvidersFailed))
+	// 不支持的调用不稀释错误率，doubao 的第一次失败即熔断
+	assert.Equal(t, BreakerOpen, r.BreakerState("doubao"))
+
+	// 未配置链时使用场景绑定的平台
+	r.Bind("chat", "zhipu")
+	p, err = r.Chain("chat").Do(context.Background(), func(ctx context.Context, p Provider) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "zhipu", p.Name())
+}
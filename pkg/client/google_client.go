@@ -1 +1,104 @@
 package client
+
+import (
+	"context"
+
+	"github.com/grapery/grapery/pkg/cloud/google"
+	"github.com/grapery/grapery/pkg/storage"
+)
+
+// GoogleClient 将 Gemini 接入统一的 Provider 接口
+type GoogleClient struct {
+	Client *google.GeminiClient
+}
+
+func NewGoogleClient(ctx context.Context, apiKey string) (*GoogleClient, error) {
+	client, err := google.NewGeminiClient(ctx, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &GoogleClient{
+		Client: client,
+	}, nil
+}
+
+func (c *GoogleClient) Name() string {
+	return PlatformNameGoogle
+}
+
+func (c *GoogleClient) GenerateText(ctx context.Context, params *TextParams) (*TextResult, error) {
+	prompt := params.Prompt
+	if params.System != "" {
+		prompt = params.System + "\n" + params.Prompt
+	}
+	content, err := c.Client.GenerateText(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return &TextResult{
+		Content: content,
+	}, nil
+}
+
+// Chat gemini 的会话由 SDK 维护，这里把历史消息拼接成一次请求
+func (c *GoogleClient) Chat(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	prompt := chatMessagesToPrompt(params.Messages)
+	if params.System != "" {
+		prompt = params.System + "\n" + prompt
+	}
+	content, err := c.Client.GenerateText(ctx, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResult{
+		Content: content,
+	}, nil
+}
+
+// ChatWithImages gemini 需要图片数据，先下载消息中的图片
+func (c *GoogleClient) ChatWithImages(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	model := params.Model
+	if model == "" {
+		model = VisionModel(PlatformNameGoogle)
+	}
+	prompt := chatMessagesToPrompt(params.Messages)
+	if params.System != "" {
+		prompt = params.System + "\n" + prompt
+	}
+	images := make([]google.ImagePart, 0)
+	for _, m := range params.Messages {
+		for _, url := range m.Images {
+			data, format, err := fetchImage(ctx, url)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, google.ImagePart{Format: format, Data: data})
+		}
+	}
+	content, err := c.Client.GenerateTextWithImages(ctx, model, prompt, images)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatResult{
+		Content: content,
+	}, nil
+}
+
+// GenerateImage gemini 返回图片数据，上传到对象存储后返回地址
+func (c *GoogleClient) GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	data, err := c.Client.GenerateImage(ctx, params.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+	url, _, err := storage.PutBytes(ctx, store, storage.DirImages, data, ".jpg")
+	if err != nil {
+		return nil, err
+	}
+	return &ImageResult{
+		ImageUrls: []string{url},
+	}, nil
+}
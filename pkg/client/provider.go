@@ -0,0 +1,373 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grapery/grapery/pkg/storage"
+)
+
+// 平台名称，用于配置和 StoryGen.LLmPlatform 记录
+const (
+	PlatformNameZhipu  = "zhipu"
+	PlatformNameAliyun = "aliyun"
+	PlatformNameDoubao = "doubao"
+	PlatformNameCoze   = "coze"
+	PlatformNameAzure  = "azure"
+	PlatformNameGoogle = "google"
+)
+
+var (
+	ErrNotSupported     = errors.New("operation not supported by provider")
+	ErrProviderNotFound = errors.New("llm provider not found")
+)
+
+// Message 对话中的一条消息
+type Message struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // 图片地址，只有 VisionChatter 会发给模型
+}
+
+// TextParams 文本生成参数
+type TextParams struct {
+	Model     string `json:"model"`      // 为空时使用平台默认模型
+	System    string `json:"system"`     // 系统提示词
+	Prompt    string `json:"prompt"`     // 用户输入
+	JsonMode  bool   `json:"json_mode"`  // 是否要求返回json
+	UserId    string `json:"user_id"`    // 用户ID
+	RequestId string `json:"request_id"` // 请求ID
+}
+
+// TextResult 文本生成结果
+type TextResult struct {
+	Content  string `json:"content"`
+	TokenNum int    `json:"token_num"`
+}
+
+// ChatParams 多轮对话参数
+type ChatParams struct {
+	Model     string    `json:"model"`
+	System    string    `json:"system"`
+	Messages  []Message `json:"messages"`
+	UserId    string    `json:"user_id"`
+	RequestId string    `json:"request_id"`
+}
+
+// ChatResult 多轮对话结果
+type ChatResult struct {
+	Content  string `json:"content"`
+	TokenNum int    `json:"token_num"`
+}
+
+// ImageParams 图片生成参数
+type ImageParams struct {
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt"`
+	RefImage       string `json:"ref_image"`
+	RefMode        string `json:"ref_mode"`   // RefImage 的用法，见 RefMode*，为空时作为参考图
+	MaskImage      string `json:"mask_image"` // 局部重绘的掩码图片
+	Seed           int64  `json:"seed"`       // 随机种子，为0时由平台决定，不支持的平台忽略
+	Size           string `json:"size"`
+	Num            int    `json:"num"`
+	UserId         string `json:"user_id"`
+	RequestId      string `json:"request_id"`
+}
+
+// ImageParams.RefMode
+const (
+	RefModeReference = "reference" // 参考图中的人物生成新的画面
+	RefModeSketch    = "sketch"    // 参考图作为草图
+	RefModeRepaint   = "repaint"   // 重绘参考图中 MaskImage 标出的区域
+)
+
+// ImageEditParams 编辑已有图片的参数
+type ImageEditParams struct {
+	Mode      string       `json:"mode"` // 见 EditMode*
+	Image     string       `json:"image"`
+	Prompt    string       `json:"prompt"`
+	MaskImage string       `json:"mask_image"` // 局部重绘的掩码图片，白色为重绘区域
+	StyleRef  string       `json:"style_ref"`  // 风格重绘的风格参考图，为空时使用 StyleIndex
+	Style     int          `json:"style"`      // 风格重绘的预置风格
+	Expand    *ExpandScale `json:"expand"`     // 扩图时各方向的放大倍数
+	Seed      int64        `json:"seed"`
+	UserId    string       `json:"user_id"`
+	RequestId string       `json:"request_id"`
+}
+
+// ExpandScale 扩图时各方向相对原图的放大倍数，1 表示该方向不扩展
+type ExpandScale struct {
+	Top    float64 `json:"top"`
+	Bottom float64 `json:"bottom"`
+	Left   float64 `json:"left"`
+	Right  float64 `json:"right"`
+}
+
+// ImageEditParams.Mode
+const (
+	EditModeInpaint     = "inpaint"     // 按提示词重绘 MaskImage 标出的区域
+	EditModeInstruction = "instruction" // 按提示词修改整张图片
+	EditModeRestyle     = "restyle"     // 风格重绘
+	EditModeExpand      = "expand"      // 向四周扩展画面
+)
+
+// ImageResult 图片生成结果，异步平台只返回TaskId
+type ImageResult struct {
+	ImageUrls []string `json:"image_urls"`
+	TaskId    string   `json:"task_id"`
+}
+
+// Provider 大模型平台的统一接口，不支持的能力返回 ErrNotSupported
+type Provider interface {
+	Name() string
+	GenerateText(ctx context.Context, params *TextParams) (*TextResult, error)
+	Chat(ctx context.Context, params *ChatParams) (*ChatResult, error)
+	GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error)
+}
+
+// TextStreamer 由支持流式输出的平台实现，onDelta 依次收到增量内容，返回错误时中止生成
+type TextStreamer interface {
+	StreamText(ctx context.Context, params *TextParams, onDelta func(delta string) error) (*TextResult, error)
+}
+
+// VisionChatter 由支持图片输入的平台实现，Message.Images 中的图片和文字一起发给模型
+type VisionChatter interface {
+	ChatWithImages(ctx context.Context, params *ChatParams) (*ChatResult, error)
+}
+
+// ReferenceImager 由支持参考图生成的平台实现，不支持 RefMode 时返回 ErrNotSupported
+type ReferenceImager interface {
+	GenerateImageWithRef(ctx context.Context, params *ImageParams) (*ImageResult, error)
+}
+
+// ImageEditor 由支持编辑已有图片的平台实现，不支持 Mode 时返回 ErrNotSupported
+type ImageEditor interface {
+	EditImage(ctx context.Context, params *ImageEditParams) (*ImageResult, error)
+}
+
+// EmbedParams 文本向量化参数
+type EmbedParams struct {
+	Model string `json:"model"` // 为空时使用平台默认模型
+	Input string `json:"input"`
+}
+
+// EmbedResult 文本向量化结果，Model 为实际使用的模型，不同模型的向量不能互相比较
+type EmbedResult struct {
+	Model    string    `json:"model"`
+	Vector   []float32 `json:"vector"`
+	TokenNum int       `json:"token_num"`
+}
+
+// Embedder 由支持文本向量化的平台实现
+type Embedder interface {
+	Embed(ctx context.Context, params *EmbedParams) (*EmbedResult, error)
+}
+
+// StoryboardRole 参与故事板的角色
+type StoryboardRole struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	Description string `json:"description"`
+}
+
+// StoryboardParams 故事板写作参数
+type StoryboardParams struct {
+	StoryName   string           `json:"story_name"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Background  string           `json:"background"`
+	Characters  string           `json:"characters"`
+	Roles       []StoryboardRole `json:"roles"`
+	PrevContent string           `json:"prev_content"`
+	ImageStyle  string           `json:"image_style"`
+	Feedback    string           `json:"feedback"` // 上一次输出未通过校验时的错误提示
+}
+
+// StoryboardWriter 由内置故事板工作流的平台实现（如coze），
+// 其余平台由故事引擎通过 GenerateText 和提示词模板完成
+type StoryboardWriter interface {
+	WriteStoryboard(ctx context.Context, params *StoryboardParams) (string, error)
+	ContinueStoryboard(ctx context.Context, params *StoryboardParams) (string, error)
+	// InitStoryboard 写故事的第一个章节，只返回章节简述和参与人物
+	InitStoryboard(ctx context.Context, params *StoryboardParams) (string, error)
+}
+
+// StoryboardStreamer 由支持流式输出的故事板工作流实现，onDelta 依次收到增量内容，返回错误时中止生成
+type StoryboardStreamer interface {
+	StreamStoryboard(ctx context.Context, params *StoryboardParams, onDelta func(delta string) error) (string, error)
+	StreamContinueStoryboard(ctx context.Context, params *StoryboardParams, onDelta func(delta string) error) (string, error)
+}
+
+// StoryParams 故事大纲写作参数
+type StoryParams struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Feedback    string `json:"feedback"`
+}
+
+// RoleParams 角色详情写作参数
+type RoleParams struct {
+	StoryName   string `json:"story_name"`
+	StoryDesc   string `json:"story_desc"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	OtherRoles  string `json:"other_roles"`
+	History     string `json:"history"` // 续写角色详情时角色经历过的情节
+	Feedback    string `json:"feedback"`
+}
+
+// RolePosterParams 角色海报参数
+type RolePosterParams struct {
+	StoryTitle  string `json:"story_title"`
+	StoryDesc   string `json:"story_desc"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Image       string `json:"image"` // 角色头像，作为参考图
+	Style       string `json:"style"`
+}
+
+// StoryWriter 由内置故事和角色工作流的平台实现（如coze），
+// 其余平台由故事引擎通过 GenerateText 和提示词模板完成
+type StoryWriter interface {
+	WriteStory(ctx context.Context, params *StoryParams) (string, error)
+	WriteRole(ctx context.Context, params *RoleParams) (string, error)
+	ContinueRole(ctx context.Context, params *RoleParams) (string, error)
+}
+
+// RolePosterMaker 由内置角色海报工作流的平台实现，其余平台使用 GenerateImage
+type RolePosterMaker interface {
+	RolePoster(ctx context.Context, params *RolePosterParams) (string, error)
+}
+
+// Registry 按平台名称管理 Provider
+type Registry struct {
+	mu             sync.RWMutex
+	providers      map[string]Provider
+	scenes         map[string]string   // 业务场景 -> 平台名称
+	chains         map[string][]string // 业务场景 -> 故障转移顺序
+	breakers       map[string]*CircuitBreaker
+	breakerOpts    BreakerOptions
+	attemptTimeout time.Duration
+	defaultName    string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providers:      make(map[string]Provider),
+		scenes:         make(map[string]string),
+		chains:         make(map[string][]string),
+		breakers:       make(map[string]*CircuitBreaker),
+		breakerOpts:    DefaultBreakerOptions,
+		attemptTimeout: DefaultAttemptTimeout,
+	}
+}
+
+// Register 注册平台，第一个注册的平台作为默认平台
+func (r *Registry) Register(p Provider) {
+	if p == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := normalizeName(p.Name())
+	r.providers[name] = p
+	if r.defaultName == "" {
+		r.defaultName = name
+	}
+}
+
+func (r *Registry) SetDefault(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = normalizeName(name)
+	if _, ok := r.providers[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrProviderNotFound, name)
+	}
+	r.defaultName = name
+	return nil
+}
+
+// Get 获取指定平台，name为空时返回默认平台
+func (r *Registry) Get(name string) (Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if name == "" {
+		name = r.defaultName
+	}
+	p, ok := r.providers[normalizeName(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProviderNotFound, name)
+	}
+	return p, nil
+}
+
+// Bind 指定业务场景使用的平台
+func (r *Registry) Bind(scene, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scenes[scene] = normalizeName(name)
+}
+
+// ForScene 获取业务场景绑定的平台，未绑定时返回默认平台
+func (r *Registry) ForScene(scene string) (Provider, error) {
+	r.mu.RLock()
+	name := r.scenes[scene]
+	r.mu.RUnlock()
+	return r.Get(name)
+}
+
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	return names
+}
+
+var defaultRegistry = NewRegistry()
+
+// GetRegistry 返回全局的 Provider 注册表
+func GetRegistry() *Registry {
+	return defaultRegistry
+}
+
+// chatMessagesToPrompt 为不支持多轮消息的平台拼接对话记录
+func chatMessagesToPrompt(messages []Message) string {
+	var sb strings.Builder
+	for _, m := range messages {
+		sb.WriteString(m.Role)
+		sb.WriteString(": ")
+		sb.WriteString(m.Content)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// maxImageSize 下载的图片大小上限
+const maxImageSize = 10 << 20
+
+// fetchImage 从对象存储读取图片，返回数据和格式(jpeg/png等)
+// 图片地址可能来自用户，只读取对象存储中的图片，不访问外部地址
+func fetchImage(ctx context.Context, url string) ([]byte, string, error) {
+	data, err := storage.ReadURL(ctx, url, maxImageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	format, ok := strings.CutPrefix(http.DetectContentType(data), "image/")
+	if !ok {
+		return nil, "", fmt.Errorf("fetch image %s: not an image", url)
+	}
+	return data, format, nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
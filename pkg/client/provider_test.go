@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockProvider 模拟平台实现
+type mockProvider struct {
+	name string
+}
+
+func (m *mockProvider) Name() string {
+	return m.name
+}
+
+func (m *mockProvider) GenerateText(ctx context.Context, params *TextParams) (*TextResult, error) {
+	return &TextResult{Content: m.name + ":" + params.Prompt}, nil
+}
+
+func (m *mockProvider) Chat(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *mockProvider) GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	return nil, ErrNotSupported
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	_, err := r.Get("")
+	assert.True(t, errors.Is(err, ErrProviderNotFound))
+
+	r.Register(&mockProvider{name: "Zhipu"})
+	r.Register(&mockProvider{name: "doubao"})
+
+	// 第一个注册的平台为默认平台，名称不区分大小写
+	p, err := r.Get("")
+	assert.NoError(t, err)
+	assert.Equal(t, "Zhipu", p.Name())
+	p, err = r.Get("ZHIPU")
+	assert.NoError(t, err)
+	assert.Equal(t, "Zhipu", p.Name())
+
+	assert.Error(t, r.SetDefault("coze"))
+	assert.NoError(t, r.SetDefault("doubao"))
+	p, err = r.ForScene("chat")
+	assert.NoError(t, err)
+	assert.Equal(t, "doubao", p.Name())
+
+	r.Bind("chat", "zhipu")
+	p, err = r.ForScene("chat")
+	assert.NoError(t, err)
+	assert.Equal(t, "Zhipu", p.Name())
+
+	r.Bind("image", "google")
+	_, err = r.ForScene("image")
+	assert.True(t, errors.Is(err, ErrProviderNotFound))
+	assert.ElementsMatch(t, []string{"zhipu", "doubao"}, r.Names())
+}
+
+func TestAliyunGenerateImageWithRefMode(t *testing.T) {
+	c := &AliyunStoryClient{}
+	_, err := c.GenerateImageWithRef(context.Background(), &ImageParams{Prompt: "a", RefImage: "https://example.com/a.png", RefMode: "unknown"})
+	assert.True(t, errors.Is(err, ErrNotSupported))
+	_, err = c.GenerateImageWithRef(context.Background(), &ImageParams{Prompt: "a", RefImage: "https://example.com/a.png", RefMode: RefModeRepaint})
+	assert.Error(t, err)
+}
+
+func TestAliyunEditImageMode(t *testing.T) {
+	c := &AliyunStoryClient{}
+	_, err := c.EditImage(context.Background(), &ImageEditParams{Mode: "unknown", Image: "https://example.com/a.png"})
+	assert.True(t, errors.Is(err, ErrNotSupported))
+	_, err = c.EditImage(context.Background(), &ImageEditParams{Mode: EditModeInpaint, Image: "https://example.com/a.png"})
+	assert.Error(t, err)
+	_, err = c.EditImage(context.Background(), &ImageEditParams{Mode: EditModeExpand, Image: "https://example.com/a.png"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package client
+
+// 请求前估算提示词的token数，各平台的分词器不同且大多没有公开，
+// 这里按模型的平均压缩率估算，结果略偏大以免超出上下文长度
+
+import (
+	"strings"
+	"unicode"
+)
+
+// messageTokenOverhead 每条消息的角色标记等额外开销
+const messageTokenOverhead = 4
+
+// ImageTokens 一张输入图片按该token数估算
+const ImageTokens = 1024
+
+// ModelSpec 模型的上下文长度和分词特点
+type ModelSpec struct {
+	ContextWindow int     // 上下文长度，包含回复
+	RunesPerToken float64 // 每个token平均对应的汉字数
+}
+
+var defaultModelSpec = ModelSpec{ContextWindow: 8192, RunesPerToken: 1}
+
+// modelSpecs 按模型名前缀匹配，前缀越长越优先
+var modelSpecs = map[string]ModelSpec{
+	"charglm":     {ContextWindow: 8192, RunesPerToken: 1.5},
+	"glm-4":       {ContextWindow: 128000, RunesPerToken: 1.5},
+	"glm-4v":      {ContextWindow: 8192, RunesPerToken: 1.5},
+	"qwen-plus":   {ContextWindow: 131072, RunesPerToken: 1.4},
+	"qwen-max":    {ContextWindow: 32768, RunesPerToken: 1.4},
+	"qwen-turbo":  {ContextWindow: 131072, RunesPerToken: 1.4},
+	"doubao-seed": {ContextWindow: 262144, RunesPerToken: 1.4},
+	"gpt-4o":      {ContextWindow: 128000, RunesPerToken: 1.1},
+	"gemini":      {ContextWindow: 32768, RunesPerToken: 1.2},
+}
+
+// platformChatModels 各平台多轮对话默认使用的模型，与各客户端中的默认值保持一致
+var platformChatModels = map[string]string{
+	PlatformNameZhipu:  "charglm-4",
+	PlatformNameAliyun: "qwen-plus",
+	PlatformNameDoubao: "doubao-seed-1-6-flash-250615",
+	PlatformNameAzure:  "gpt-4o",
+	PlatformNameGoogle: "gemini-pro",
+}
+
+// platformTextModels 文本生成默认模型与多轮对话不同的平台
+var platformTextModels = map[string]string{
+	PlatformNameZhipu: "glm-4-flash",
+}
+
+// platformVisionModels 支持图片输入的平台使用的模型
+var platformVisionModels = map[string]string{
+	PlatformNameZhipu:  "glm-4v-plus",
+	PlatformNameGoogle: "gemini-1.5-flash",
+}
+
+// ChatModel 平台多轮对话默认使用的模型，未知平台返回空
+func ChatModel(platform string) string {
+	return platformChatModels[normalizeName(platform)]
+}
+
+// TextModel 平台文本生成默认使用的模型，未知平台返回空
+func TextModel(platform string) string {
+	if model, ok := platformTextModels[normalizeName(platform)]; ok {
+		return model
+	}
+	return ChatModel(platform)
+}
+
+// VisionModel 平台图片理解使用的模型，不支持的平台返回空
+func VisionModel(platform string) string {
+	return platformVisionModels[normalizeName(platform)]
+}
+
+// LookupModel 获取模型的上下文长度和分词特点，未知模型使用保守的默认值
+func LookupModel(model string) ModelSpec {
+	model = strings.ToLower(model)
+	spec, matched := defaultModelSpec, 0
+	for prefix, s := range modelSpecs {
+		if len(prefix) > matched && strings.HasPrefix(model, prefix) {
+			spec, matched = s, len(prefix)
+		}
+	}
+	return spec
+}
+
+// CountTokens 估算文本的token数：汉字按模型的压缩率计算，
+// 连续的字母数字约4个字符一个token，其余符号各算一个
+func CountTokens(model, text string) int {
+	spec := LookupModel(model)
+	var cjk, word, other int
+	tokens := 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			word++
+			continue
+		case unicode.IsSpace(r):
+		default:
+			other++
+		}
+		tokens += (word + 3) / 4
+		word = 0
+	}
+	tokens += (word + 3) / 4
+	tokens += other
+	if cjk > 0 {
+		tokens += int(float64(cjk)/spec.RunesPerToken + 0.999)
+	}
+	return tokens
+}
+
+// CountChat 估算一次多轮对话请求的token数
+func CountChat(model, system string, messages []Message) int {
+	total := 0
+	if system != "" {
+		total += CountTokens(model, system) + messageTokenOverhead
+	}
+	for _, m := range messages {
+		total += CountTokens(model, m.Content) + messageTokenOverhead + len(m.Images)*ImageTokens
+	}
+	return total
+}
+
+// TruncateTokens 截断文本使其不超过 maxTokens，keepTail 为true时保留结尾，
+// 被截断时在截断处加上省略号
+func TruncateTokens(model, text string, maxTokens int, keepTail bool) string {
+	if CountTokens(model, text) <= maxTokens {
+		return text
+	}
+	if maxTokens <= 1 {
+		return ""
+	}
+	runes := []rune(text)
+	// 二分查找能放下的最多字符数，省略号占一个token
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		var part string
+		if keepTail {
+			part = string(runes[len(runes)-mid:])
+		} else {
+			part = string(runes[:mid])
+		}
+		if CountTokens(model, part)+1 <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if keepTail {
+		return "…" + string(runes[len(runes)-lo:])
+	}
+	return string(runes[:lo]) + "…"
+}
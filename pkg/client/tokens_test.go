@@ -0,0 +1,48 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupModel(t *testing.T) {
+	assert.Equal(t, 8192, LookupModel("charglm-4").ContextWindow)
+	assert.Equal(t, 128000, LookupModel("GLM-4-Flash").ContextWindow)
+	assert.Equal(t, 262144, LookupModel("doubao-seed-1-6-flash-250615").ContextWindow)
+	assert.Equal(t, defaultModelSpec, LookupModel("unknown"))
+	assert.Equal(t, "qwen-plus", ChatModel("Aliyun"))
+	assert.Equal(t, "", ChatModel("coze"))
+	assert.Equal(t, "glm-4-flash", TextModel("zhipu"))
+	assert.Equal(t, "qwen-plus", TextModel("aliyun"))
+	assert.Equal(t, 8192, LookupModel(VisionModel("zhipu")).ContextWindow)
+	assert.Equal(t, "", VisionModel("aliyun"))
+}
+
+func TestCountTokens(t *testing.T) {
+	assert.Equal(t, 0, CountTokens("qwen-plus", ""))
+	assert.Equal(t, 3, CountTokens("unknown", "你好吗"))
+	assert.Equal(t, 2, CountTokens("charglm-4", "你好吗"))
+	assert.Equal(t, 4, CountTokens("unknown", "hello world"))
+	assert.Equal(t, 4, CountTokens("unknown", "hi, 你好"))
+	assert.Equal(t, 11, CountChat("unknown", "", []Message{{Role: "user", Content: "hello"}, {Role: "assistant", Content: "ok"}}))
+	assert.Equal(t, 6+ImageTokens, CountChat("unknown", "", []Message{{Role: "user", Content: "hello", Images: []string{"a.png"}}}))
+}
+
+func TestTruncateTokens(t *testing.T) {
+	text := strings.Repeat("一二三四五", 10)
+	assert.Equal(t, text, TruncateTokens("unknown", text, 50, false))
+
+	head := TruncateTokens("unknown", text, 10, false)
+	assert.True(t, strings.HasPrefix(head, "一二三"))
+	assert.True(t, strings.HasSuffix(head, "…"))
+	assert.LessOrEqual(t, CountTokens("unknown", head), 10)
+
+	tail := TruncateTokens("unknown", text, 10, true)
+	assert.True(t, strings.HasPrefix(tail, "…"))
+	assert.True(t, strings.HasSuffix(tail, "三四五"))
+	assert.LessOrEqual(t, CountTokens("unknown", tail), 10)
+
+	assert.Equal(t, "", TruncateTokens("unknown", text, 1, false))
+}
@@ -145,6 +145,7 @@ type GenStoryImagesParams struct {
 	Prompt         string `json:"prompt"`          // 额外的提示词
 	NegativePrompt string `json:"negative_prompt"` // 负面提示词
 	MaskImageUrl   string `json:"mask_image_url"`  // 掩码图像
+	Seed           int64  `json:"seed"`            // 随机种子，为0时由平台决定
 }
 
 type GenStoryImagesResult struct {
@@ -287,3 +288,222 @@ func (c *ZhipuStoryClient) GenStoryRoleInfo(ctx context.Context, params *GenStor
 	ret.Content = completeMessage
 	return ret, nil
 }
+
+func (c *ZhipuStoryClient) Name() string {
+	return PlatformNameZhipu
+}
+
+func (c *ZhipuStoryClient) GenerateText(ctx context.Context, params *TextParams) (*TextResult, error) {
+	model := params.Model
+	if model == "" {
+		model = "glm-4-flash"
+	}
+	chatService := c.ZhipuClient.ChatCompletion(model)
+	if params.System != "" {
+		chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+			Role:    "system",
+			Content: params.System,
+		})
+	}
+	chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+		Role:    "user",
+		Content: params.Prompt,
+	})
+	if params.UserId != "" {
+		chatService.SetUserID(params.UserId)
+	}
+	if params.RequestId != "" {
+		chatService.SetRequestID(params.RequestId)
+	}
+	res, err := chatService.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zhipu generate text failed,code: %s, err: %v",
+			zhipuapi.GetAPIErrorCode(err), err)
+	}
+	if len(res.Choices) == 0 {
+		return nil, fmt.Errorf("zhipu return empty choices")
+	}
+	return &TextResult{
+		Content:  res.Choices[0].Message.Content,
+		TokenNum: int(res.Usage.TotalTokens),
+	}, nil
+}
+
+func (c *ZhipuStoryClient) StreamText(ctx context.Context, params *TextParams, onDelta func(delta string) error) (*TextResult, error) {
+	model := params.Model
+	if model == "" {
+		model = "glm-4-flash"
+	}
+	chatService := c.ZhipuClient.ChatCompletion(model)
+	if params.System != "" {
+		chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+			Role:    "system",
+			Content: params.System,
+		})
+	}
+	chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+		Role:    "user",
+		Content: params.Prompt,
+	})
+	if params.UserId != "" {
+		chatService.SetUserID(params.UserId)
+	}
+	if params.RequestId != "" {
+		chatService.SetRequestID(params.RequestId)
+	}
+	chatService.SetStreamHandler(func(chunk zhipuapi.ChatCompletionResponse) error {
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	res, err := chatService.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zhipu stream text failed,code: %s, err: %v",
+			zhipuapi.GetAPIErrorCode(err), err)
+	}
+	if len(res.Choices) == 0 {
+		return nil, fmt.Errorf("zhipu return empty choices")
+	}
+	return &TextResult{
+		Content:  res.Choices[0].Message.Content,
+		TokenNum: int(res.Usage.TotalTokens),
+	}, nil
+}
+
+func (c *ZhipuStoryClient) Chat(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	model := params.Model
+	if model == "" {
+		model = "charglm-4"
+	}
+	chatService := c.ZhipuClient.ChatCompletion(model)
+	if params.System != "" {
+		chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+			Role:    "system",
+			Content: params.System,
+		})
+	}
+	for _, m := range params.Messages {
+		chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+			Role:    m.Role,
+			Content: m.Content,
+		})
+	}
+	if params.UserId != "" {
+		chatService.SetUserID(params.UserId)
+	}
+	if params.RequestId != "" {
+		chatService.SetRequestID(params.RequestId)
+	}
+	res, err := chatService.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zhipu chat failed,code: %s, err: %v",
+			zhipuapi.GetAPIErrorCode(err), err)
+	}
+	if len(res.Choices) == 0 {
+		return nil, fmt.Errorf("zhipu return empty choices")
+	}
+	return &ChatResult{
+		Content:  res.Choices[0].Message.Content,
+		TokenNum: int(res.Usage.TotalTokens),
+	}, nil
+}
+
+// ChatWithImages 使用 glm-4v 系列模型理解图片，模型不支持系统消息，系统提示词放在第一条用户消息前
+func (c *ZhipuStoryClient) ChatWithImages(ctx context.Context, params *ChatParams) (*ChatResult, error) {
+	model := params.Model
+	if model == "" {
+		model = VisionModel(PlatformNameZhipu)
+	}
+	chatService := c.ZhipuClient.ChatCompletion(model)
+	system := params.System
+	for _, m := range params.Messages {
+		text := m.Content
+		if m.Role == "user" && system != "" {
+			text = system + "\n\n" + text
+			system = ""
+		}
+		if len(m.Images) == 0 {
+			chatService.AddMessage(zhipuapi.ChatCompletionMessage{
+				Role:    m.Role,
+				Content: text,
+			})
+			continue
+		}
+		contents := []zhipuapi.ChatCompletionMultiContent{{
+			Type: zhipuapi.MultiContentTypeText,
+			Text: text,
+		}}
+		for _, image := range m.Images {
+			contents = append(contents, zhipuapi.ChatCompletionMultiContent{
+				Type:     zhipuapi.MultiContentTypeImageURL,
+				ImageURL: &zhipuapi.URLItem{URL: image},
+			})
+		}
+		chatService.AddMessage(zhipuapi.ChatCompletionMultiMessage{
+			Role:    m.Role,
+			Content: contents,
+		})
+	}
+	if params.UserId != "" {
+		chatService.SetUserID(params.UserId)
+	}
+	if params.RequestId != "" {
+		chatService.SetRequestID(params.RequestId)
+	}
+	res, err := chatService.Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zhipu vision chat failed,code: %s, err: %v",
+			zhipuapi.GetAPIErrorCode(err), err)
+	}
+	if len(res.Choices) == 0 {
+		return nil, fmt.Errorf("zhipu return empty choices")
+	}
+	return &ChatResult{
+		Content:  res.Choices[0].Message.Content,
+		TokenNum: int(res.Usage.TotalTokens),
+	}, nil
+}
+
+func (c *ZhipuStoryClient) GenerateImage(ctx context.Context, params *ImageParams) (*ImageResult, error) {
+	ret, err := c.GenStoryBoardImages(ctx, &GenStoryImagesParams{
+		Content:   params.Prompt,
+		UserId:    params.UserId,
+		RequestId: params.RequestId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ImageResult{
+		ImageUrls: ret.ImageUrls,
+	}, nil
+}
+
+func (c *ZhipuStoryClient) Embed(ctx context.Context, params *EmbedParams) (*EmbedResult, error) {
+	model := params.Model
+	if model == "" {
+		model = "embedding-2"
+	}
+	res, err := c.ZhipuClient.Embedding(model).SetInput(params.Input).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("zhipu embedding failed,code: %s, err: %v",
+			zhipuapi.GetAPIErrorCode(err), err)
+	}
+	if len(res.Data) == 0 || len(res.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("zhipu return empty embedding")
+	}
+	vector := make([]float32, len(res.Data[0].Embedding))
+	for i, v := range res.Data[0].Embedding {
+		vector[i] = float32(v)
+	}
+	return &EmbedResult{
+		Model:    model,
+		Vector:   vector,
+		TokenNum: int(res.Usage.TotalTokens),
+	}, nil
+}
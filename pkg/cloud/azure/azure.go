@@ -74,11 +74,17 @@ func (c *AzureOpenAIClient) Chat(ctx context.Context, messages []ChatMessage) (s
 	for i, msg := range messages {
 		switch msg.Role {
 		case "user":
-			chatMessages[i] = &azopenai.ChatRequestUserMessage{}
+			chatMessages[i] = &azopenai.ChatRequestUserMessage{
+				Content: azopenai.NewChatRequestUserMessageContent(msg.Content),
+			}
 		case "assistant":
-			chatMessages[i] = &azopenai.ChatRequestAssistantMessage{}
+			chatMessages[i] = &azopenai.ChatRequestAssistantMessage{
+				Content: azopenai.NewChatRequestAssistantMessageContent(msg.Content),
+			}
 		case "system":
-			chatMessages[i] = &azopenai.ChatRequestSystemMessage{}
+			chatMessages[i] = &azopenai.ChatRequestSystemMessage{
+				Content: azopenai.NewChatRequestSystemMessageContent(msg.Content),
+			}
 		default:
 			return "", fmt.Errorf("unsupported role: %s", msg.Role)
 		}
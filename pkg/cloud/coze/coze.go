@@ -1,6 +1,7 @@
 package coze
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -11,6 +12,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/grapery/grapery/utils/llmjson"
 )
 
 var (
@@ -134,7 +137,103 @@ func (c *HuoShanCozeClient) WorkflowRun(ctx context.Context, workflowID string,
 	return ret["output"], nil
 }
 
-func (c *HuoShanCozeClient) InitStoryboard(ctx context.Context, params CozeInitStoryboardParams) (string, error) {
+// CozeWorkflowStreamMessage stream_run 的 Message 事件，结束节点开启流式输出时 content 为增量内容
+type CozeWorkflowStreamMessage struct {
+	Content      string `json:"content"`
+	NodeTitle    string `json:"node_title"`
+	NodeSeqID    string `json:"node_seq_id"`
+	NodeIsFinish bool   `json:"node_is_finish"`
+}
+
+// CozeWorkflowStreamError stream_run 的 Error 事件
+type CozeWorkflowStreamError struct {
+	ErrorCode    int    `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// WorkflowStreamRun 流式执行工作流，onDelta 依次收到 Message 事件的内容，返回错误时中止
+// 结束节点以变量方式返回时内容是 {"output": ...}，与 WorkflowRun 一样取出 output
+func (c *HuoShanCozeClient) WorkflowStreamRun(ctx context.Context, params CozeWorkflowRunParams, onDelta func(delta string) error) (string, error) {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", Endpoint+"/v1/workflow/stream_run", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("coze stream run http status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var (
+		content strings.Builder
+		event   string
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		case !strings.HasPrefix(line, "data:"):
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		switch event {
+		case "Message":
+			msg := new(CozeWorkflowStreamMessage)
+			if err := json.Unmarshal([]byte(data), msg); err != nil {
+				return "", err
+			}
+			if msg.Content == "" {
+				continue
+			}
+			content.WriteString(msg.Content)
+			if err := onDelta(msg.Content); err != nil {
+				return "", err
+			}
+		case "Error":
+			streamErr := new(CozeWorkflowStreamError)
+			if err := json.Unmarshal([]byte(data), streamErr); err != nil {
+				return "", fmt.Errorf("coze stream run error: %s", data)
+			}
+			return "", fmt.Errorf("coze stream run error %d: %s", streamErr.ErrorCode, streamErr.ErrorMessage)
+		case "Interrupt":
+			return "", errors.New("coze stream run interrupted")
+		case "Done":
+			return streamOutput(content.String()), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return streamOutput(content.String()), nil
+}
+
+// streamOutput 变量方式返回的结果取出 output，否则就是结束节点输出的文本
+func streamOutput(content string) string {
+	if ret, err := ParseCozeOutput(content); err == nil {
+		if output, ok := ret["output"]; ok {
+			return output
+		}
+	}
+	return content
+}
+
+func (c *HuoShanCozeClient) initStoryboard(ctx context.Context, params CozeInitStoryboardParams) (string, error) {
 	if APPID == "" {
 		return "", errors.New("workflowID and appID cannot be empty")
 	}
@@ -371,9 +470,22 @@ func (c CozeStoryboardWriterParams) String() string {
 }
 
 func (c *HuoShanCozeClient) StoryboardWriter(ctx context.Context, params CozeStoryboardWriterParams) (string, error) {
-	workflowID := "7521280682498015251"
-	apiParams := CozeWorkflowRunParams{
-		WorkflowID: workflowID,
+	apiParams := storyboardWriterRunParams(params)
+	ret, err := c.WorkflowRun(ctx, apiParams.WorkflowID, apiParams)
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// StoryboardWriterStream 流式生成章节
+func (c *HuoShanCozeClient) StoryboardWriterStream(ctx context.Context, params CozeStoryboardWriterParams, onDelta func(delta string) error) (string, error) {
+	return c.WorkflowStreamRun(ctx, storyboardWriterRunParams(params), onDelta)
+}
+
+func storyboardWriterRunParams(params CozeStoryboardWriterParams) CozeWorkflowRunParams {
+	return CozeWorkflowRunParams{
+		WorkflowID: "7521280682498015251",
 		Parameters: map[string]interface{}{
 			"app_id":           APPID,
 			"story_chapter":    params.StoryChapter,
@@ -384,11 +496,6 @@ func (c *HuoShanCozeClient) StoryboardWriter(ctx context.Context, params CozeSto
 			"prev_content":     params.PrevContent, // 可选参数
 		},
 	}
-	ret, err := c.WorkflowRun(ctx, workflowID, apiParams)
-	if err != nil {
-		return "", err
-	}
-	return ret, nil
 }
 
 type CozeStoryboardContinueParams struct {
@@ -406,9 +513,22 @@ func (c CozeStoryboardContinueParams) String() string {
 }
 
 func (c *HuoShanCozeClient) StoryboardContinue(ctx context.Context, params CozeStoryboardContinueParams) (string, error) {
-	workflowID := "7521279737222922276"
-	apiParams := CozeWorkflowRunParams{
-		WorkflowID: workflowID,
+	apiParams := storyboardContinueRunParams(params)
+	ret, err := c.WorkflowRun(ctx, apiParams.WorkflowID, apiParams)
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
+
+// StoryboardContinueStream 流式续写章节
+func (c *HuoShanCozeClient) StoryboardContinueStream(ctx context.Context, params CozeStoryboardContinueParams, onDelta func(delta string) error) (string, error) {
+	return c.WorkflowStreamRun(ctx, storyboardContinueRunParams(params), onDelta)
+}
+
+func storyboardContinueRunParams(params CozeStoryboardContinueParams) CozeWorkflowRunParams {
+	return CozeWorkflowRunParams{
+		WorkflowID: "7521279737222922276",
 		Parameters: map[string]interface{}{
 			"app_id":             APPID,
 			"title":              params.Title,
@@ -419,11 +539,6 @@ func (c *HuoShanCozeClient) StoryboardContinue(ctx context.Context, params CozeS
 			"roles":              params.Roles,
 		},
 	}
-	ret, err := c.WorkflowRun(ctx, workflowID, apiParams)
-	if err != nil {
-		return "", err
-	}
-	return ret, nil
 }
 
 type CozeStoryRoleDetailParams struct {
@@ -649,19 +764,12 @@ func (c *HuoShanCozeClient) ContinueChatWithAssistantStream(ctx context.Context,
 // 返回：map[string]string 或 error
 func ParseCozeOutput(output string) (map[string]string, error) {
 	// 1. 去掉前后的 --- 分隔符和多余空白
-	start := strings.Index(output, "{")
-	end := strings.LastIndex(output, "}")
-	if start == -1 || end == -1 || end <= start {
+	if !strings.Contains(output, "{") {
 		return nil, errors.New("output格式不正确，未找到json对象")
 	}
-	jsonStr := output[start : end+1]
-
-	// 2. 去掉多余的换行符
-	jsonStr = strings.ReplaceAll(jsonStr, "\n", "")
-
-	// 3. 反序列化
+	// 2. 修复代码块、尾逗号、截断等问题并反序列化，非字符串的值转为字符串
 	var result map[string]string
-	err := json.Unmarshal([]byte(jsonStr), &result)
+	err := llmjson.Parse(output, llmjson.Map(llmjson.String()), &result)
 	if err != nil {
 		return nil, err
 	}
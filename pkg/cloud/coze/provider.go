@@ -0,0 +1,198 @@
+package coze
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/grapery/grapery/pkg/client"
+)
+
+// BotID 通用对话智能体，未配置时coze不提供文本和对话能力
+var BotID = os.Getenv("COZE_BOT_ID")
+
+func (c *HuoShanCozeClient) Name() string {
+	return client.PlatformNameCoze
+}
+
+func (c *HuoShanCozeClient) GenerateText(ctx context.Context, params *client.TextParams) (*client.TextResult, error) {
+	if BotID == "" {
+		return nil, client.ErrNotSupported
+	}
+	query := params.Prompt
+	if params.System != "" {
+		query = params.System + "\n" + params.Prompt
+	}
+	content, err := c.ChatWithRole(ctx, CozeChatWithRoleParams{
+		StoryName: AppName,
+		StoryDesc: query,
+		RoleName:  BotID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &client.TextResult{
+		Content: content,
+	}, nil
+}
+
+func (c *HuoShanCozeClient) Chat(ctx context.Context, params *client.ChatParams) (*client.ChatResult, error) {
+	if BotID == "" {
+		return nil, client.ErrNotSupported
+	}
+	var sb strings.Builder
+	if params.System != "" {
+		sb.WriteString(params.System)
+		sb.WriteString("\n")
+	}
+	for _, m := range params.Messages {
+		sb.WriteString(m.Role)
+		sb.WriteString(": ")
+		sb.WriteString(m.Content)
+		sb.WriteString("\n")
+	}
+	content, err := c.ChatWithRole(ctx, CozeChatWithRoleParams{
+		StoryName: AppName,
+		StoryDesc: sb.String(),
+		RoleName:  BotID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &client.ChatResult{
+		Content: content,
+	}, nil
+}
+
+func (c *HuoShanCozeClient) GenerateImage(ctx context.Context, params *client.ImageParams) (*client.ImageResult, error) {
+	url, err := c.StoryboardImage(ctx, CozeStoryboardImageParams{
+		OriginPrompt:  params.Prompt,
+		SenceRefImage: params.RefImage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &client.ImageResult{
+		ImageUrls: []string{url},
+	}, nil
+}
+
+// GenerateImageWithRef coze 的故事板图片工作流支持场景参考图
+func (c *HuoShanCozeClient) GenerateImageWithRef(ctx context.Context, params *client.ImageParams) (*client.ImageResult, error) {
+	if params.RefMode != "" && params.RefMode != client.RefModeReference {
+		return nil, fmt.Errorf("%w: coze ref mode %s", client.ErrNotSupported, params.RefMode)
+	}
+	return c.GenerateImage(ctx, params)
+}
+
+func (c *HuoShanCozeClient) WriteStoryboard(ctx context.Context, params *client.StoryboardParams) (string, error) {
+	return c.StoryboardWriter(ctx, writerParams(params))
+}
+
+func (c *HuoShanCozeClient) ContinueStoryboard(ctx context.Context, params *client.StoryboardParams) (string, error) {
+	return c.StoryboardContinue(ctx, continueParams(params))
+}
+
+func (c *HuoShanCozeClient) StreamStoryboard(ctx context.Context, params *client.StoryboardParams, onDelta func(delta string) error) (string, error) {
+	return c.StoryboardWriterStream(ctx, writerParams(params), onDelta)
+}
+
+func (c *HuoShanCozeClient) StreamContinueStoryboard(ctx context.Context, params *client.StoryboardParams, onDelta func(delta string) error) (string, error) {
+	return c.StoryboardContinueStream(ctx, continueParams(params), onDelta)
+}
+
+func writerParams(params *client.StoryboardParams) CozeStoryboardWriterParams {
+	prevContent := params.PrevContent
+	if prevContent == "" {
+		prevContent = "暂无上一章节"
+	}
+	return CozeStoryboardWriterParams{
+		StoryChapter:    params.Title,
+		StoryContent:    withFeedback(params.Description, params.Feedback),
+		StoryCharacters: params.Characters,
+		StoryBackground: params.Background,
+		ImageStyle:      params.ImageStyle,
+		PrevContent:     prevContent,
+	}
+}
+
+func continueParams(params *client.StoryboardParams) CozeStoryboardContinueParams {
+	return CozeStoryboardContinueParams{
+		Title:            params.Title,
+		Description:      withFeedback(params.Description, params.Feedback),
+		Background:       params.Background,
+		StoryName:        params.StoryName,
+		StoryPrevContent: params.PrevContent,
+		Roles:            roleInfos(params.Roles),
+	}
+}
+
+func roleInfos(roles []client.StoryboardRole) []CozeRoleInfo {
+	infos := make([]CozeRoleInfo, 0, len(roles))
+	for _, role := range roles {
+		infos = append(infos, CozeRoleInfo{
+			RoleID:          role.ID,
+			RoleName:        role.Name,
+			RoleImage:       role.Image,
+			RoleDescription: role.Description,
+		})
+	}
+	return infos
+}
+
+// withFeedback 工作流没有单独的提示参数，把校验错误附加到描述中
+func withFeedback(description, feedback string) string {
+	if feedback == "" {
+		return description
+	}
+	return description + "\n" + feedback
+}
+
+func (c *HuoShanCozeClient) InitStoryboard(ctx context.Context, params *client.StoryboardParams) (string, error) {
+	return c.initStoryboard(ctx, CozeInitStoryboardParams{
+		Title:       params.Title,
+		Description: withFeedback(params.Description, params.Feedback),
+		Background:  params.Background,
+		Roles:       roleInfos(params.Roles),
+	})
+}
+
+func (c *HuoShanCozeClient) WriteStory(ctx context.Context, params *client.StoryParams) (string, error) {
+	return c.StoryWrite(ctx, CozeStoryWriteParams{
+		StoryTitle: params.Title,
+		StoryDesc:  withFeedback(params.Description, params.Feedback),
+	})
+}
+
+func (c *HuoShanCozeClient) WriteRole(ctx context.Context, params *client.RoleParams) (string, error) {
+	return c.StoryRoleDetail(ctx, CozeStoryRoleDetailParams{
+		StoryName:   params.StoryName,
+		StoryDesc:   params.StoryDesc,
+		RoleName:    params.Name,
+		Description: withFeedback(params.Description, params.Feedback),
+		OtherRoles:  params.OtherRoles,
+	})
+}
+
+func (c *HuoShanCozeClient) ContinueRole(ctx context.Context, params *client.RoleParams) (string, error) {
+	return c.StoryRoleDetailContinue(ctx, CozeStoryRoleDetailContinueParams{
+		StoryName:   params.StoryName,
+		StoryDesc:   params.StoryDesc,
+		RoleName:    params.Name,
+		Description: withFeedback(params.Description, params.Feedback),
+		OtherRoles:  params.OtherRoles,
+		History:     params.History,
+	})
+}
+
+func (c *HuoShanCozeClient) RolePoster(ctx context.Context, params *client.RolePosterParams) (string, error) {
+	return c.StoryRoleBackgroundImage(ctx, CozeStoryRoleBackgroundImageParams{
+		StoryTitle: params.StoryTitle,
+		StoryDesc:  params.StoryDesc,
+		RoleName:   params.Name,
+		RoleDesc:   params.Description,
+		RoleImage:  params.Image,
+		Style:      params.Style,
+	})
+}
@@ -55,6 +55,34 @@ func (c *GeminiClient) GenerateImage(ctx context.Context, prompt string) ([]byte
 	return resp.Candidates[0].Content.Parts[0].(genai.Blob).Data, nil
 }
 
+// ImagePart is an image sent to Gemini, Format is the image subtype such as "jpeg" or "png"
+type ImagePart struct {
+	Format string
+	Data   []byte
+}
+
+// GenerateTextWithImages generates text response based on prompt and images with a vision capable model
+func (c *GeminiClient) GenerateTextWithImages(ctx context.Context, model, prompt string, images []ImagePart) (string, error) {
+	parts := []genai.Part{genai.Text(prompt)}
+	for _, image := range images {
+		parts = append(parts, genai.ImageData(image.Format, image.Data))
+	}
+	resp, err := c.client.GenerativeModel(model).GenerateContent(ctx, parts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %v", err)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return "", fmt.Errorf("empty candidates")
+	}
+	var text string
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text += string(t)
+		}
+	}
+	return text, nil
+}
+
 // Chat initiates a chat session with Gemini
 func (c *GeminiClient) Chat(ctx context.Context) (*genai.ChatSession, error) {
 	chat := c.model.StartChat()
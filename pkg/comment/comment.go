@@ -8,6 +8,7 @@ import (
 
 	api "github.com/grapery/common-protoc/gen"
 	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/block"
 )
 
 var logger, _ = zap.NewDevelopment()
@@ -53,10 +54,16 @@ func (s *CommentService) CreateStoryComment(ctx context.Context, req *api.Create
 		LikeCount:    0,
 		DislikeCount: 0,
 	}
-	err := comment.Create()
+	held, err := createComment(ctx, comment)
 	if err != nil {
 		return nil, err
 	}
+	if held {
+		return &api.CreateStoryCommentResponse{
+			Code:    api.ResponseCode_OK,
+			Message: commentHeldMessage,
+		}, nil
+	}
 	story, err := models.GetStory(ctx, req.GetStoryId())
 	if err != nil {
 		return &api.CreateStoryCommentResponse{
@@ -203,10 +210,16 @@ func (s *CommentService) CreateStoryCommentReply(ctx context.Context, req *api.C
 	} else {
 		comment.RootCommentID = int64(rootComment.RootCommentID)
 	}
-	err = comment.Create()
+	held, err := createComment(ctx, comment)
 	if err != nil {
 		return nil, err
 	}
+	if held {
+		return &api.CreateStoryCommentReplyResponse{
+			Code:    api.ResponseCode_OK,
+			Message: commentHeldMessage,
+		}, nil
+	}
 	err = models.IncreaseReplyCount(uint64(rootComment.ID))
 	if err != nil {
 		logger.Error("increase story comment reply count failed", zap.Error(err))
@@ -258,10 +271,16 @@ func (s *CommentService) CreateStoryBoardComment(ctx context.Context, req *api.C
 		Status:        1,
 		LikeCount:     0,
 	}
-	err := comment.Create()
+	held, err := createComment(ctx, comment)
 	if err != nil {
 		return nil, err
 	}
+	if held {
+		return &api.CreateStoryBoardCommentResponse{
+			Code:    api.ResponseCode_OK,
+			Message: commentHeldMessage,
+		}, nil
+	}
 	storyBoard, err := models.GetStoryboard(ctx, req.GetBoardId())
 	if err != nil {
 		return &api.CreateStoryBoardCommentResponse{
@@ -324,8 +343,16 @@ func (s *CommentService) GetStoryBoardComments(ctx context.Context, req *api.Get
 			Comments: []*api.StoryComment{},
 		}, nil
 	}
-	createrIds := make([]int64, 0)
+	// 不返回被屏蔽用户的评论和包含屏蔽词的评论
+	filter := block.ViewerFilter(ctx)
+	visible := make([]*models.Comment, 0, len(*comments))
 	for _, comment := range *comments {
+		if !filter.Hidden(comment.UserID, string(comment.Content)) {
+			visible = append(visible, comment)
+		}
+	}
+	createrIds := make([]int64, 0)
+	for _, comment := range visible {
 		createrIds = append(createrIds, comment.UserID)
 	}
 	createrMap, err := models.GetUsersByIdsMap(ctx, createrIds)
@@ -336,7 +363,7 @@ func (s *CommentService) GetStoryBoardComments(ctx context.Context, req *api.Get
 	createrMapData, _ := json.Marshal(createrMap)
 	logger.Info("get user by ids map success", zap.String("creater_map", string(createrMapData)))
 	apiComments := make([]*api.StoryComment, 0)
-	for _, comment := range *comments {
+	for _, comment := range visible {
 		apiComments = append(apiComments, &api.StoryComment{
 			CommentId:  int64(comment.ID),
 			Content:    string(comment.Content),
@@ -357,7 +384,7 @@ func (s *CommentService) GetStoryBoardComments(ctx context.Context, req *api.Get
 	return &api.GetStoryBoardCommentsResponse{
 		Code:     api.ResponseCode_OK,
 		Message:  "success",
-		Total:    int64(len(*comments)),
+		Total:    int64(len(visible)),
 		Comments: apiComments,
 	}, nil
 }
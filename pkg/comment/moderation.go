@@ -0,0 +1,45 @@
+package comment
+
+import (
+	"context"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/utils/compliance"
+)
+
+// commentHeldMessage 评论需要人工审核时的提示
+const commentHeldMessage = "comment is under review"
+
+// createComment 审核后保存评论，被拒绝时返回 compliance.ErrRejected；
+// 需要人工审核的评论保存后隐藏，held 为true
+func createComment(ctx context.Context, comment *models.Comment) (held bool, err error) {
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityComment,
+		Field:      "comment",
+		GroupId:    comment.GroupID,
+		StoryId:    comment.StoryID,
+		AuthorId:   comment.UserID,
+	}
+	if target.StoryId == 0 && comment.StoryboardID != 0 {
+		if board, err := models.GetStoryboard(ctx, comment.StoryboardID); err == nil && board != nil {
+			target.StoryId = board.StoryID
+		}
+	}
+	content := string(comment.Content)
+	review, err := moderation.CheckText(ctx, target, content)
+	if err != nil {
+		return false, err
+	}
+	if err := comment.Create(); err != nil {
+		return false, err
+	}
+	if review.Verdict == compliance.VerdictPass {
+		return false, nil
+	}
+	target.EntityId = int64(comment.ID)
+	if err := moderation.Hold(ctx, target, review, content); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -9,8 +9,11 @@ import (
 	api "github.com/grapery/common-protoc/gen"
 	"github.com/grapery/grapery/models"
 	"github.com/grapery/grapery/pkg/active"
+	"github.com/grapery/grapery/pkg/block"
+	"github.com/grapery/grapery/pkg/search"
 	"github.com/grapery/grapery/utils"
 	"github.com/grapery/grapery/utils/convert"
+	"github.com/grapery/grapery/utils/elastic"
 	"github.com/grapery/grapery/utils/errors"
 )
 
@@ -212,6 +215,7 @@ func (g *GroupService) CreateGroup(ctx context.Context, req *api.CreateGroupRequ
 		logger.Info("create group member failed", zap.Error(err))
 		return nil, err
 	}
+	search.Sync(search.KindGroup, int64(group.ID))
 	return &api.CreateGroupResponse{
 		Code:    api.ResponseCode_OK,
 		Message: "ok",
@@ -235,6 +239,7 @@ func (g *GroupService) DeleteGroup(ctx context.Context, req *api.DeleteGroupRequ
 	if err != nil {
 		return &api.DeleteGroupResponse{Code: api.ResponseCode_OPERATION_FAILED, Message: err.Error()}, nil
 	}
+	search.Sync(search.KindGroup, req.GetGroupId())
 	return &api.DeleteGroupResponse{
 		Code:    api.ResponseCode_OK,
 		Message: "ok",
@@ -288,6 +293,7 @@ func (g *GroupService) UpdateGroupInfo(ctx context.Context, req *api.UpdateGroup
 	if err != nil {
 		return &api.UpdateGroupInfoResponse{Code: api.ResponseCode_OPERATION_FAILED, Message: err.Error()}, err
 	}
+	search.Sync(search.KindGroup, req.GetGroupId())
 	return &api.UpdateGroupInfoResponse{
 		Code:    api.ResponseCode_OK,
 		Message: "ok",
@@ -444,13 +450,22 @@ func (g *GroupService) SearchGroup(ctx context.Context, req *api.SearchGroupRequ
 	if req.GetOffset() < 0 || req.GetPageSize() < 0 {
 		return nil, errors.ErrInvalidParameter
 	}
-	groups, total, err := models.GetGroupByName(name, int(req.GetOffset()), int(req.GetPageSize()))
+	groups, total, err := search.Groups(ctx, &elastic.Query{
+		Keyword: name,
+		Offset:  int(req.GetOffset()),
+		Size:    int(req.GetPageSize()),
+	})
 	if err != nil {
 		return nil, err
 	}
-	list := make([]*api.GroupInfo, len(groups), len(groups))
-	for idx, val := range groups {
-		list[idx] = convert.ConvertGroupToApiGroupInfo(val)
+	filter := block.ViewerFilter(ctx)
+	list := make([]*api.GroupInfo, 0, len(groups))
+	for _, val := range groups {
+		if filter.Hidden(val.CreatorID, val.Name, val.ShortDesc) {
+			total--
+			continue
+		}
+		list = append(list, convert.ConvertGroupToApiGroupInfo(val))
 	}
 	return &api.SearchGroupResponse{
 		Code:    api.ResponseCode_OK,
@@ -0,0 +1,228 @@
+package moderation
+
+// 内容审核：发布前用 compliance 的本地规则和远程审核检查内容
+// 被拒绝的内容不能发布；需要人工审核的内容照常保存，但写入审核队列并隐藏，审核通过后恢复
+// 生成的内容（角色回复、生成的图片）保存后才审核，被拒绝的直接进入已拒绝状态，审核人可以改判
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/compliance"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var ErrNoPermission = errors.New("have no permission")
+
+// Target 被审核的对象，EntityId 在对象创建后才有
+type Target struct {
+	EntityType int
+	EntityId   int64
+	Field      string
+	GroupId    int64 // 为0时根据 StoryId 查找故事所属的小组
+	StoryId    int64
+	AuthorId   int64
+}
+
+func (t *Target) groupId(ctx context.Context) int64 {
+	if t.GroupId != 0 || t.StoryId == 0 {
+		return t.GroupId
+	}
+	story, err := models.GetStory(ctx, t.StoryId)
+	if err != nil || story == nil {
+		return 0
+	}
+	t.GroupId = story.GroupID
+	return t.GroupId
+}
+
+// CheckText 发布前检查文本，被拒绝时返回 compliance.ErrRejected
+// 需要审核时返回的结果在对象保存后交给 Hold
+func CheckText(ctx context.Context, target *Target, texts ...string) (*compliance.Result, error) {
+	content := strings.TrimSpace(strings.Join(texts, "\n"))
+	result := compliance.GetModerator().Text(ctx, target.groupId(ctx), content)
+	if err := result.Err(); err != nil {
+		log.Log().Info("content rejected", zap.Int("entity_type", target.EntityType),
+			zap.String("field", target.Field), zap.Strings("labels", result.Labels))
+		return nil, err
+	}
+	return result, nil
+}
+
+// Hold 需要审核的对象写入审核队列并隐藏，content 为被审核的内容
+func Hold(ctx context.Context, target *Target, result *compliance.Result, content string) error {
+	if result == nil || result.Verdict == compliance.VerdictPass {
+		return nil
+	}
+	item := &models.ModerationItem{
+		EntityType: target.EntityType,
+		EntityId:   target.EntityId,
+		Field:      target.Field,
+		GroupId:    target.groupId(ctx),
+		StoryId:    target.StoryId,
+		AuthorId:   target.AuthorId,
+		Content:    content,
+		Verdict:    int(result.Verdict),
+		Labels:     strings.Join(result.Labels, ","),
+		Reason:     result.Reason,
+		Source:     result.Source,
+		Status:     models.ModerationStatusPending,
+	}
+	if result.Verdict == compliance.VerdictReject {
+		item.Status = models.ModerationStatusRejected
+	}
+	if _, err := models.CreateModerationItem(ctx, item); err != nil {
+		log.Log().Error("create moderation item failed", zap.Int("entity_type", target.EntityType),
+			zap.Int64("entity_id", target.EntityId), zap.Error(err))
+		return err
+	}
+	if err := models.SetModerationHidden(ctx, target.EntityType, target.EntityId, true); err != nil {
+		log.Log().Error("hide moderated entity failed", zap.Int("entity_type", target.EntityType),
+			zap.Int64("entity_id", target.EntityId), zap.Error(err))
+		return err
+	}
+	syncSearch(target.EntityType, target.EntityId)
+	return nil
+}
+
+// CheckAndHold 对已经保存的对象检查文本，被拒绝或需要审核时都会隐藏
+func CheckAndHold(ctx context.Context, target *Target, texts ...string) *compliance.Result {
+	content := strings.TrimSpace(strings.Join(texts, "\n"))
+	result := compliance.GetModerator().Text(ctx, target.groupId(ctx), content)
+	_ = Hold(ctx, target, result, content)
+	return result
+}
+
+// CheckImageAndHold 对已经保存的对象检查生成的图片，不通过时隐藏对象
+func CheckImageAndHold(ctx context.Context, target *Target, image string) *compliance.Result {
+	result := compliance.GetModerator().Image(ctx, target.groupId(ctx), image)
+	_ = Hold(ctx, &Target{
+		EntityType: target.EntityType,
+		EntityId:   target.EntityId,
+		Field:      "image",
+		GroupId:    target.GroupId,
+		StoryId:    target.StoryId,
+		AuthorId:   target.AuthorId,
+	}, result, image)
+	return result
+}
+
+// ModerationItemList 审核队列
+type ModerationItemList struct {
+	Total int64                    `json:"total"`
+	List  []*models.ModerationItem `json:"list"`
+}
+
+func isReviewer(ctx context.Context) (int64, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cfg := config.GlobalConfig
+	if cfg == nil || cfg.Moderation == nil || !slices.Contains(cfg.Moderation.Reviewers, userId) {
+		return 0, ErrNoPermission
+	}
+	return userId, nil
+}
+
+// ListItems 审核人查看审核队列，status 见 models.ModerationStatus*
+func ListItems(ctx context.Context, status, entityType int, offset, pageSize int) (*ModerationItemList, error) {
+	if _, err := isReviewer(ctx); err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	items, total, err := models.ListModerationItems(ctx, status, entityType, offset, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ModerationItemList{Total: total, List: items}, nil
+}
+
+// Approve 审核通过，对象没有其他等待审核或被拒绝的记录时恢复显示
+func Approve(ctx context.Context, itemId int64, note string) (*models.ModerationItem, error) {
+	return review(ctx, itemId, models.ModerationStatusApproved, note)
+}
+
+// Reject 审核拒绝，对象保持隐藏
+func Reject(ctx context.Context, itemId int64, note string) (*models.ModerationItem, error) {
+	return review(ctx, itemId, models.ModerationStatusRejected, note)
+}
+
+func review(ctx context.Context, itemId int64, status int, note string) (*models.ModerationItem, error) {
+	reviewerId, err := isReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	item, err := models.GetModerationItem(ctx, itemId)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, errors.New("moderation item not found")
+	}
+	if item.Status == status {
+		return item, nil
+	}
+	err = models.ReviewModerationItem(ctx, itemId, item.Status, status, reviewerId, note)
+	if err != nil {
+		return nil, err
+	}
+	if models.ModerationHideable(item.EntityType) {
+		if err := updateVisibility(ctx, item.EntityType, item.EntityId, status); err != nil {
+			log.Log().Error("update moderated entity failed", zap.Int64("item_id", itemId), zap.Error(err))
+			return nil, err
+		}
+	}
+	log.Log().Info("moderation item reviewed", zap.Int64("item_id", itemId),
+		zap.Int64("reviewer", reviewerId), zap.Int("status", status))
+	return models.GetModerationItem(ctx, itemId)
+}
+
+// updateVisibility 被拒绝的对象保持隐藏，审核通过时对象没有其他隐藏中的记录才恢复
+func updateVisibility(ctx context.Context, entityType int, entityId int64, status int) error {
+	hidden := status == models.ModerationStatusRejected
+	if !hidden {
+		unapproved, err := models.CountUnapprovedModeration(ctx, entityType, entityId)
+		if err != nil {
+			return err
+		}
+		hidden = unapproved > 0
+	}
+	if err := models.SetModerationHidden(ctx, entityType, entityId, hidden); err != nil {
+		return err
+	}
+	syncSearch(entityType, entityId)
+	return nil
+}
+
+// searchKinds 审核对象对应的搜索索引
+var searchKinds = map[int]string{
+	models.ModerationEntityStory:      search.KindStory,
+	models.ModerationEntityStoryboard: search.KindStoryBoard,
+	models.ModerationEntityRole:       search.KindRole,
+}
+
+// syncSearch 对象隐藏或恢复后更新搜索索引
+func syncSearch(entityType int, entityId int64) {
+	if kind, ok := searchKinds[entityType]; ok {
+		search.Sync(kind, entityId)
+	}
+}
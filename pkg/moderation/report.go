@@ -0,0 +1,173 @@
+package moderation
+
+// 用户举报：举报写入审核队列，同一对象的举报合并为一条记录
+// 举报不会隐藏对象，举报次数达到配置的阈值后对象在审核前隐藏
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const maxReportDetailLen = 500
+
+var (
+	ErrInvalidReport   = errors.New("invalid report")
+	ErrReportNotFound  = errors.New("reported content not found")
+	ErrAlreadyReported = errors.New("already reported")
+)
+
+// reportTarget 查找被举报的对象，返回审核对象和被举报的内容
+func reportTarget(ctx context.Context, entityType int, entityId int64) (*Target, string, error) {
+	target := &Target{EntityType: entityType, EntityId: entityId, Field: models.ModerationSourceReport}
+	switch entityType {
+	case models.ModerationEntityStoryboard:
+		board, err := models.GetStoryboard(ctx, entityId)
+		if err != nil || board == nil {
+			return nil, "", ErrReportNotFound
+		}
+		target.StoryId, target.AuthorId = board.StoryID, board.CreatorID
+		return target, board.Title + "\n" + board.Description, nil
+	case models.ModerationEntityComment:
+		comment, err := models.GetCommentByID(ctx, entityId)
+		if err != nil || comment == nil {
+			return nil, "", ErrReportNotFound
+		}
+		target.GroupId, target.StoryId, target.AuthorId = comment.GroupID, comment.StoryID, comment.UserID
+		return target, string(comment.Content), nil
+	case models.ModerationEntityRole:
+		role, err := models.GetStoryRoleByID(ctx, entityId)
+		if err != nil || role == nil {
+			return nil, "", ErrReportNotFound
+		}
+		target.StoryId, target.AuthorId = role.StoryID, role.CreatorID
+		return target, role.CharacterName + "\n" + role.CharacterDescription, nil
+	case models.ModerationEntityUser:
+		user, err := models.GetUserById(ctx, entityId)
+		if err != nil || user == nil {
+			return nil, "", ErrReportNotFound
+		}
+		target.AuthorId = entityId
+		return target, user.Name + "\n" + user.ShortDesc, nil
+	}
+	return nil, "", ErrInvalidReport
+}
+
+// Report 举报故事板、评论、角色或用户，reason 见 models.ReportReason*
+func Report(ctx context.Context, entityType int, entityId int64, reason int, detail string) (*models.ContentReport, error) {
+	reporterId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	label, ok := models.ReportReasonNames[reason]
+	if !ok || entityId <= 0 {
+		return nil, ErrInvalidReport
+	}
+	detail = strings.TrimSpace(detail)
+	if len([]rune(detail)) > maxReportDetailLen {
+		detail = string([]rune(detail)[:maxReportDetailLen])
+	}
+	reported, err := models.HasContentReport(ctx, reporterId, entityType, entityId)
+	if err != nil {
+		return nil, err
+	}
+	if reported {
+		return nil, ErrAlreadyReported
+	}
+	target, content, err := reportTarget(ctx, entityType, entityId)
+	if err != nil {
+		return nil, err
+	}
+	if target.AuthorId == reporterId {
+		return nil, ErrInvalidReport
+	}
+	item, err := addReport(ctx, target, label, detail, content)
+	if err != nil {
+		log.Log().Error("add report to moderation queue failed", zap.Int("entity_type", entityType),
+			zap.Int64("entity_id", entityId), zap.Error(err))
+		return nil, err
+	}
+	report := &models.ContentReport{
+		ReporterId:       reporterId,
+		EntityType:       entityType,
+		EntityId:         entityId,
+		Reason:           reason,
+		Detail:           detail,
+		ModerationItemId: int64(item.ID),
+	}
+	if _, err := models.CreateContentReport(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := holdReported(ctx, item); err != nil {
+		log.Log().Error("hide reported entity failed", zap.Int64("item_id", int64(item.ID)), zap.Error(err))
+	}
+	log.Log().Info("content reported", zap.Int("entity_type", entityType), zap.Int64("entity_id", entityId),
+		zap.Int64("reporter", reporterId), zap.String("reason", label))
+	return report, nil
+}
+
+// addReport 对象没有等待处理的举报时新建审核记录，否则合并到已有的记录
+func addReport(ctx context.Context, target *Target, label, detail, content string) (*models.ModerationItem, error) {
+	item, err := models.GetPendingReportItem(ctx, target.EntityType, target.EntityId)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		item = &models.ModerationItem{
+			EntityType: target.EntityType,
+			EntityId:   target.EntityId,
+			Field:      target.Field,
+			GroupId:    target.groupId(ctx),
+			StoryId:    target.StoryId,
+			AuthorId:   target.AuthorId,
+			Content:    content,
+			Labels:     label,
+			Reason:     detail,
+			Source:     models.ModerationSourceReport,
+			Status:     models.ModerationStatusPending,
+			Visible:    true,
+			Reports:    1,
+		}
+		if _, err := models.CreateModerationItem(ctx, item); err != nil {
+			return nil, err
+		}
+		return item, nil
+	}
+	labels := strings.Split(item.Labels, ",")
+	if !slices.Contains(labels, label) {
+		item.Labels = strings.Join(append(labels, label), ",")
+	}
+	if err := models.AddModerationReport(ctx, int64(item.ID), item.Labels); err != nil {
+		return nil, err
+	}
+	item.Reports++
+	return item, nil
+}
+
+// holdReported 举报次数达到阈值时隐藏对象，用户不会被隐藏
+func holdReported(ctx context.Context, item *models.ModerationItem) error {
+	cfg := config.GlobalConfig
+	if cfg == nil || cfg.Moderation == nil || cfg.Moderation.ReportHideThreshold <= 0 {
+		return nil
+	}
+	if !item.Visible || item.Reports < cfg.Moderation.ReportHideThreshold ||
+		!models.ModerationHideable(item.EntityType) {
+		return nil
+	}
+	if err := models.SetModerationItemVisible(ctx, int64(item.ID), false); err != nil {
+		return err
+	}
+	if err := models.SetModerationHidden(ctx, item.EntityType, item.EntityId, true); err != nil {
+		return err
+	}
+	syncSearch(item.EntityType, item.EntityId)
+	return nil
+}
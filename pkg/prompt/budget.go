@@ -0,0 +1,212 @@
+package prompt
+
+// 按token预算组装多轮对话的提示词：系统提示词由多个段落组成，每段最多占用预算的一定比例，
+// 剩余预算从新到旧放入历史消息，再还给被截断的段落，
+// 最后还有剩余时把放不下的旧消息以节选的形式附在系统提示词后
+
+import (
+	"strings"
+
+	"github.com/grapery/grapery/pkg/client"
+)
+
+const (
+	excerptTitle     = "更早的对话（节选）"
+	excerptMinTokens = 64 // 剩余预算少于此值时不再附加节选
+	excerptLineMax   = 60 // 节选中每条消息最多的token数
+	inputMaxShare    = 0.25
+)
+
+// Section 系统提示词中的一段
+type Section struct {
+	Title    string  // 标题，为空时直接拼接内容
+	Text     string  // 内容，为空时忽略
+	Share    float64 // 最多占用预算的比例，为0时不限制
+	KeepTail bool    // 截断时保留结尾，用于按时间排列的内容
+}
+
+// Prompt 组装结果
+type Prompt struct {
+	System    string
+	Messages  []client.Message
+	TokenNum  int      // 估算的提示词token数
+	Dropped   int      // 超出预算未放入历史消息的消息数
+	Truncated []string // 被截断的段落标题
+}
+
+// Builder 提示词组装器，只在一次请求中使用
+type Builder struct {
+	model    string
+	budget   int
+	sections []Section
+	history  []client.Message
+}
+
+// NewBuilder 创建组装器，budget 为提示词的最大token数，会被限制在模型上下文长度减去 replyTokens 以内
+func NewBuilder(model string, budget, replyTokens int) *Builder {
+	limit := client.LookupModel(model).ContextWindow - replyTokens
+	if budget <= 0 || budget > limit {
+		budget = limit
+	}
+	return &Builder{model: model, budget: budget}
+}
+
+// Add 按优先级从高到低添加段落
+func (b *Builder) Add(section Section) *Builder {
+	if strings.TrimSpace(section.Text) != "" {
+		b.sections = append(b.sections, section)
+	}
+	return b
+}
+
+// History 设置对话记录，按时间从旧到新，最后一条为当前的用户输入，总会保留
+func (b *Builder) History(messages []client.Message) *Builder {
+	b.history = messages
+	return b
+}
+
+func (b *Builder) count(text string) int {
+	return client.CountTokens(b.model, text)
+}
+
+func (b *Builder) sectionText(section Section, text string) string {
+	if section.Title == "" {
+		return text
+	}
+	return section.Title + "：\n" + text
+}
+
+// fit 截断段落使其不超过 maxTokens，内容全部被截掉时返回空
+func (b *Builder) fit(section Section, maxTokens int) string {
+	text := client.TruncateTokens(b.model, section.Text, maxTokens-b.count(b.sectionText(section, "")), section.KeepTail)
+	if text == "" {
+		return ""
+	}
+	return b.sectionText(section, text)
+}
+
+// Build 组装提示词
+func (b *Builder) Build() *Prompt {
+	ret := &Prompt{Messages: make([]client.Message, 0, len(b.history)), Truncated: make([]string, 0)}
+	remaining := b.budget
+
+	// 当前输入总会保留，过长时截断
+	var input *client.Message
+	older := b.history
+	if n := len(b.history); n > 0 {
+		last := b.history[n-1]
+		limit := int(float64(b.budget) * inputMaxShare)
+		if cost := b.count(last.Content); cost > limit {
+			last.Content = client.TruncateTokens(b.model, last.Content, limit, false)
+			ret.Truncated = append(ret.Truncated, "input")
+		}
+		input = &last
+		older = b.history[:n-1]
+		remaining -= b.count(last.Content) + len(last.Images)*client.ImageTokens + 4
+	}
+
+	// 段落按比例分配预算
+	texts := make([]string, len(b.sections))
+	full := make([]bool, len(b.sections))
+	remaining -= 4 // 系统消息的开销
+	for i, section := range b.sections {
+		text := b.sectionText(section, section.Text)
+		cost := b.count(text) + 2
+		limit := remaining
+		if section.Share > 0 && int(float64(b.budget)*section.Share) < limit {
+			limit = int(float64(b.budget) * section.Share)
+		}
+		if cost > limit {
+			text = b.fit(section, limit-2)
+			cost = b.count(text) + 2
+		} else {
+			full[i] = true
+		}
+		texts[i] = text
+		remaining -= cost
+	}
+
+	// 历史消息从新到旧放入
+	kept := len(older)
+	for kept > 0 {
+		cost := b.count(older[kept-1].Content) + 4
+		if cost > remaining {
+			break
+		}
+		remaining -= cost
+		kept--
+	}
+	// 保持以用户消息开头
+	for kept < len(older) && older[kept].Role == "assistant" {
+		remaining += b.count(older[kept].Content) + 4
+		kept++
+	}
+	ret.Dropped = kept
+	ret.Messages = append(ret.Messages, older[kept:]...)
+	if input != nil {
+		ret.Messages = append(ret.Messages, *input)
+	}
+
+	// 剩余预算按优先级还给被截断的段落
+	for i, section := range b.sections {
+		if full[i] {
+			continue
+		}
+		if remaining > 0 {
+			old := b.count(texts[i])
+			text := b.sectionText(section, section.Text)
+			if b.count(text) <= old+remaining {
+				texts[i], full[i] = text, true
+			} else {
+				texts[i] = b.fit(section, old+remaining)
+			}
+			remaining -= b.count(texts[i]) - old
+		}
+		if !full[i] {
+			ret.Truncated = append(ret.Truncated, section.Title)
+		}
+	}
+
+	// 放不下的旧消息从新到旧节选
+	var excerpt string
+	if kept > 0 && remaining >= excerptMinTokens {
+		lines := make([]string, 0)
+		budget := remaining - b.count(excerptTitle+"：\n") - 2
+		for i := kept - 1; i >= 0 && budget > 0; i-- {
+			line := speaker(older[i].Role) + "：" + client.TruncateTokens(b.model, older[i].Content, excerptLineMax, false)
+			cost := b.count(line) + 1
+			if cost > budget {
+				break
+			}
+			budget -= cost
+			lines = append(lines, line)
+		}
+		if len(lines) > 0 {
+			for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+				lines[i], lines[j] = lines[j], lines[i]
+			}
+			excerpt = excerptTitle + "：\n" + strings.Join(lines, "\n")
+			remaining -= b.count(excerpt) + 2
+		}
+	}
+
+	parts := make([]string, 0, len(texts)+1)
+	for _, text := range texts {
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+	if excerpt != "" {
+		parts = append(parts, excerpt)
+	}
+	ret.System = strings.Join(parts, "\n\n")
+	ret.TokenNum = client.CountChat(b.model, ret.System, ret.Messages)
+	return ret
+}
+
+func speaker(role string) string {
+	if role == "assistant" {
+		return "你"
+	}
+	return "用户"
+}
@@ -0,0 +1,92 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/pkg/client"
+)
+
+func chatHistory(turns int, content string) []client.Message {
+	messages := make([]client.Message, 0, 2*turns+1)
+	for i := 0; i < turns; i++ {
+		messages = append(messages,
+			client.Message{Role: "user", Content: content},
+			client.Message{Role: "assistant", Content: content})
+	}
+	return append(messages, client.Message{Role: "user", Content: "现在呢"})
+}
+
+func TestBuildFitsEverything(t *testing.T) {
+	ret := NewBuilder("unknown", 1000, 0).
+		Add(Section{Text: "你是小明", Share: 0.5}).
+		Add(Section{Title: "故事背景", Text: "一个村庄", Share: 0.2}).
+		Add(Section{Title: "空段落"}).
+		History(chatHistory(2, "你好")).
+		Build()
+	assert.Equal(t, "你是小明\n\n故事背景：\n一个村庄", ret.System)
+	assert.Len(t, ret.Messages, 5)
+	assert.Equal(t, 0, ret.Dropped)
+	assert.Empty(t, ret.Truncated)
+	assert.Equal(t, client.CountChat("unknown", ret.System, ret.Messages), ret.TokenNum)
+}
+
+func TestBuildDropsOldTurns(t *testing.T) {
+	ret := NewBuilder("unknown", 200, 0).
+		Add(Section{Text: "你是小明", Share: 0.5}).
+		History(chatHistory(10, strings.Repeat("字", 20))).
+		Build()
+	assert.LessOrEqual(t, ret.TokenNum, 200)
+	assert.Greater(t, ret.Dropped, 0)
+	assert.Equal(t, "user", ret.Messages[0].Role)
+	assert.Equal(t, "现在呢", ret.Messages[len(ret.Messages)-1].Content)
+
+	// 放不下的长消息以节选的形式保留
+	history := append([]client.Message{{Role: "user", Content: "很久以前" + strings.Repeat("长", 500)}}, chatHistory(1, "你好")...)
+	ret = NewBuilder("unknown", 300, 0).
+		Add(Section{Text: "你是小明"}).
+		History(history).
+		Build()
+	assert.LessOrEqual(t, ret.TokenNum, 300)
+	assert.Equal(t, 1, ret.Dropped)
+	assert.Len(t, ret.Messages, 3)
+	assert.Contains(t, ret.System, excerptTitle+"：\n用户：很久以前")
+}
+
+func TestBuildTruncatesSections(t *testing.T) {
+	persona := strings.Repeat("设定", 200)
+	ret := NewBuilder("unknown", 300, 0).
+		Add(Section{Title: "角色", Text: persona, Share: 0.3}).
+		Add(Section{Title: "记忆", Text: strings.Repeat("记忆", 200), Share: 0.2}).
+		History(chatHistory(0, "")).
+		Build()
+	assert.LessOrEqual(t, ret.TokenNum, 300)
+	assert.Equal(t, []string{"角色", "记忆"}, ret.Truncated)
+
+	// 历史消息用不完的预算还给被截断的段落
+	ret = NewBuilder("unknown", 1000, 0).
+		Add(Section{Title: "角色", Text: persona, Share: 0.1}).
+		History(chatHistory(0, "")).
+		Build()
+	assert.Empty(t, ret.Truncated)
+	assert.Contains(t, ret.System, persona)
+}
+
+func TestBuildLimitedByContextWindow(t *testing.T) {
+	b := NewBuilder("charglm-4", 100000, 1024)
+	assert.Equal(t, 8192-1024, b.budget)
+}
+
+func TestBuildReservesImageTokens(t *testing.T) {
+	history := chatHistory(3, strings.Repeat("字", 100))
+	history[len(history)-1].Images = []string{"https://example.com/a.png"}
+	ret := NewBuilder("unknown", client.ImageTokens+400, 0).
+		Add(Section{Text: "你是小明"}).
+		History(history).
+		Build()
+	assert.LessOrEqual(t, ret.TokenNum, client.ImageTokens+400)
+	assert.Greater(t, ret.Dropped, 0)
+	assert.Equal(t, history[len(history)-1].Images, ret.Messages[len(ret.Messages)-1].Images)
+}
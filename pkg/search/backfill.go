@@ -0,0 +1,103 @@
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils/elastic"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const defaultBatchSize = 200
+
+// Backfill 把数据库中某类对象全部写入索引，返回写入的文档数
+// 不会删除索引中已经不存在的对象，需要完整重建时先删除索引
+func Backfill(ctx context.Context, kind string, batchSize int) (int, error) {
+	if !Enabled() {
+		return 0, fmt.Errorf("elastic search not enabled")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	total := 0
+	for offset := 0; ; offset += batchSize {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		docs, n, err := loadBatch(ctx, kind, offset, batchSize)
+		if err != nil {
+			return total, err
+		}
+		if err := elastic.BulkIndex(ctx, docs); err != nil {
+			return total, err
+		}
+		total += len(docs)
+		log.Log().Info("search backfill batch", zap.String("kind", kind),
+			zap.Int("offset", offset), zap.Int("indexed", total))
+		if n < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// loadBatch 按页加载对象的文档，返回文档和本页的对象数
+func loadBatch(ctx context.Context, kind string, offset, limit int) ([]elastic.ElasticDoc, int, error) {
+	l := newLoader()
+	docs := make([]elastic.ElasticDoc, 0, limit)
+	add := func(doc elastic.ElasticDoc) {
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	switch kind {
+	case KindStory:
+		stories, err := models.GetStoryList(ctx, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, story := range stories {
+			add(l.storyDoc(story))
+		}
+		return docs, len(stories), nil
+	case KindRole:
+		roles, err := models.GetStoryRoleList(ctx, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, role := range roles {
+			add(l.roleDoc(ctx, role))
+		}
+		return docs, len(roles), nil
+	case KindStoryBoard:
+		boards, err := models.GetStoryBoardList(ctx, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, board := range boards {
+			add(l.boardDoc(ctx, board))
+		}
+		return docs, len(boards), nil
+	case KindGroup:
+		groups, err := models.GetGroupList(ctx, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, group := range groups {
+			add(groupDoc(group))
+		}
+		return docs, len(groups), nil
+	case KindUser:
+		users, err := models.GetUserList(ctx, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, user := range users {
+			add(userDoc(user))
+		}
+		return docs, len(users), nil
+	}
+	return nil, 0, fmt.Errorf("unknown search kind %s", kind)
+}
@@ -0,0 +1,290 @@
+package search
+
+// 搜索索引：对象创建、修改、删除或者被隐藏后调用 Sync，后台从数据库重新加载对象写入索引
+// 对象不存在、被删除、被审核隐藏或者属于私有故事时从索引中删除
+// 索引失败不影响业务，遗漏的对象可以用 searchindex 命令回填
+// 故事、故事板和角色同时更新语义索引，见 semantic.go
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils/elastic"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// 索引的对象类型
+const (
+	KindStory      = "story"
+	KindRole       = "role"
+	KindStoryBoard = "storyboard"
+	KindGroup      = "group"
+	KindUser       = "user"
+)
+
+// Kinds 所有的对象类型，回填按这个顺序执行
+var Kinds = []string{KindGroup, KindStory, KindStoryBoard, KindRole, KindUser}
+
+const (
+	queueSize   = 1024
+	syncTimeout = 10 * time.Second
+)
+
+type task struct {
+	kind string
+	id   int64
+}
+
+var queue chan task
+
+// Enabled 是否配置了搜索服务
+func Enabled() bool {
+	return elastic.IsEnableElastic
+}
+
+// Start 启动后台索引，需要在 elastic.Init 之后调用
+func Start(ctx context.Context) {
+	if !Enabled() || queue != nil {
+		return
+	}
+	queue = make(chan task, queueSize)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-queue:
+				c, cancel := context.WithTimeout(context.Background(), syncTimeout)
+				if err := syncNow(c, t.kind, t.id); err != nil {
+					log.Log().Error("sync search index failed", zap.String("kind", t.kind),
+						zap.Int64("id", t.id), zap.Error(err))
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// Sync 异步更新对象的搜索索引和语义索引，队列满时丢弃
+func Sync(kind string, id int64) {
+	if id <= 0 {
+		return
+	}
+	push(queue, "search index", kind, id)
+	if slices.Contains(SemanticKinds, kind) {
+		push(semanticQueue, "semantic index", kind, id)
+	}
+}
+
+func push(q chan task, name, kind string, id int64) {
+	if q == nil {
+		return
+	}
+	select {
+	case q <- task{kind: kind, id: id}:
+	default:
+		log.Log().Warn(name+" queue full", zap.String("kind", kind), zap.Int64("id", id))
+	}
+}
+
+// emptyDocs 用于确定对象类型对应的索引
+var emptyDocs = map[string]elastic.ElasticDoc{
+	KindStory:      &elastic.ElasticStory{},
+	KindRole:       &elastic.ElasticStoryRole{},
+	KindStoryBoard: &elastic.ElasticStoryBoard{},
+	KindGroup:      &elastic.ElasticGroup{},
+	KindUser:       &elastic.ElasticUser{},
+}
+
+// syncNow 重新加载对象并写入索引，对象不应出现在搜索结果中时删除
+func syncNow(ctx context.Context, kind string, id int64) error {
+	empty, ok := emptyDocs[kind]
+	if !ok {
+		return fmt.Errorf("unknown search kind %s", kind)
+	}
+	doc, err := newLoader().load(ctx, kind, id)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return elastic.DeleteDoc(ctx, empty, id)
+	}
+	return elastic.IndexDoc(ctx, doc)
+}
+
+// load 从数据库加载对象的文档，对象不应出现在搜索结果中时返回nil
+func (l *loader) load(ctx context.Context, kind string, id int64) (elastic.ElasticDoc, error) {
+	switch kind {
+	case KindStory:
+		story, err := models.GetStory(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return l.storyDoc(story), nil
+	case KindRole:
+		role, err := models.GetStoryRoleByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return l.roleDoc(ctx, role), nil
+	case KindStoryBoard:
+		board, err := models.GetStoryboard(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return l.boardDoc(ctx, board), nil
+	case KindGroup:
+		return groupDoc(l.group(id)), nil
+	case KindUser:
+		user, err := models.GetUserById(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return userDoc(user), nil
+	}
+	return nil, nil
+}
+
+// loader 缓存文档需要的故事和小组，回填时同一批对象共用
+type loader struct {
+	stories map[int64]*models.Story
+	groups  map[int64]*models.Group
+}
+
+func newLoader() *loader {
+	return &loader{
+		stories: make(map[int64]*models.Story),
+		groups:  make(map[int64]*models.Group),
+	}
+}
+
+// story 不存在或者查询失败时返回nil
+func (l *loader) story(ctx context.Context, id int64) *models.Story {
+	if story, ok := l.stories[id]; ok {
+		return story
+	}
+	story, err := models.GetStory(ctx, id)
+	if err != nil {
+		log.Log().Error("get story for search index failed", zap.Int64("story_id", id), zap.Error(err))
+	}
+	l.stories[id] = story
+	return story
+}
+
+func (l *loader) group(id int64) *models.Group {
+	if id <= 0 {
+		return nil
+	}
+	if group, ok := l.groups[id]; ok {
+		return group
+	}
+	var group *models.Group
+	groups, err := models.GetGroupsByIds([]int64{id})
+	if err != nil {
+		log.Log().Error("get group for search index failed", zap.Int64("group_id", id), zap.Error(err))
+	}
+	if len(groups) != 0 {
+		group = groups[0]
+	}
+	l.groups[id] = group
+	return group
+}
+
+// groupTags 小组的标签，逗号分隔
+func groupTags(group *models.Group) []string {
+	if group == nil {
+		return nil
+	}
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(group.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// storyTags 故事所属小组的标签以及故事的风格和主题
+func (l *loader) storyTags(story *models.Story) []string {
+	tags := groupTags(l.group(story.GroupID))
+	for _, tag := range []string{story.Style, story.Subject} {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// searchable 私有故事以及其中的故事板和角色不出现在搜索结果中
+func searchable(story *models.Story) bool {
+	return story != nil && !story.IsPrivate
+}
+
+func (l *loader) storyDoc(story *models.Story) elastic.ElasticDoc {
+	if !searchable(story) {
+		return nil
+	}
+	l.stories[int64(story.ID)] = story
+	return &elastic.ElasticStory{
+		Story:       story,
+		GroupId:     story.GroupID,
+		Tags:        l.storyTags(story),
+		AIGenerated: story.AIGen,
+	}
+}
+
+func (l *loader) roleDoc(ctx context.Context, role *models.StoryRole) elastic.ElasticDoc {
+	if role == nil {
+		return nil
+	}
+	story := l.story(ctx, role.StoryID)
+	if !searchable(story) {
+		return nil
+	}
+	return &elastic.ElasticStoryRole{
+		StoryRole:   role,
+		GroupId:     story.GroupID,
+		Tags:        l.storyTags(story),
+		AIGenerated: story.AIGen,
+	}
+}
+
+func (l *loader) boardDoc(ctx context.Context, board *models.StoryBoard) elastic.ElasticDoc {
+	if board == nil || board.Status < 0 {
+		return nil
+	}
+	story := l.story(ctx, board.StoryID)
+	if !searchable(story) {
+		return nil
+	}
+	return &elastic.ElasticStoryBoard{
+		StoryBoard:  board,
+		GroupId:     story.GroupID,
+		Tags:        l.storyTags(story),
+		AIGenerated: board.IsAiGen,
+	}
+}
+
+func groupDoc(group *models.Group) elastic.ElasticDoc {
+	if group == nil || group.Deleted {
+		return nil
+	}
+	return &elastic.ElasticGroup{
+		Group:   group,
+		GroupId: int64(group.ID),
+		Tags:    groupTags(group),
+	}
+}
+
+func userDoc(user *models.User) elastic.ElasticDoc {
+	if user == nil {
+		return nil
+	}
+	return &elastic.ElasticUser{User: user}
+}
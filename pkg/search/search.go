@@ -0,0 +1,219 @@
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/block"
+	"github.com/grapery/grapery/utils/elastic"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Hit 搜索结果，按相关度排序，根据类型填充对应的对象
+type Hit struct {
+	*elastic.Hit
+	Story *models.Story      `json:"story,omitempty"`
+	Role  *models.StoryRole  `json:"role,omitempty"`
+	Board *models.StoryBoard `json:"board,omitempty"`
+	Group *models.Group      `json:"group,omitempty"`
+	User  *models.User       `json:"user,omitempty"`
+}
+
+type Result struct {
+	Total int64  `json:"total"`
+	List  []*Hit `json:"list"`
+}
+
+// Search 在索引中搜索并从数据库加载对象
+// 索引中已经被删除或者隐藏的对象、被屏蔽用户创建的对象和包含屏蔽词的对象不返回
+func Search(ctx context.Context, kind string, q *elastic.Query) (*Result, error) {
+	empty, ok := emptyDocs[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown search kind %s", kind)
+	}
+	if !Enabled() {
+		return nil, fmt.Errorf("elastic search not enabled")
+	}
+	if q.Size <= 0 {
+		q.Size = defaultPageSize
+	}
+	if q.Size > maxPageSize {
+		q.Size = maxPageSize
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	hits, total, err := elastic.Search(ctx, empty, q)
+	if err != nil {
+		log.Log().Error("elastic search failed", zap.String("kind", kind), zap.Error(err))
+		return nil, err
+	}
+	list, err := fillHits(ctx, kind, hits)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Total: total - int64(len(hits)-len(list)), List: list}, nil
+}
+
+// fillHits 按命中顺序从数据库加载对象，去掉已经删除的对象、被屏蔽用户创建的对象和包含屏蔽词的对象
+func fillHits(ctx context.Context, kind string, hits []*elastic.Hit) ([]*Hit, error) {
+	ids := make([]int64, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.Id)
+	}
+	found, err := loadHits(ctx, kind, ids)
+	if err != nil {
+		return nil, err
+	}
+	filter := block.ViewerFilter(ctx)
+	list := make([]*Hit, 0, len(hits))
+	for _, hit := range hits {
+		h, ok := found[hit.Id]
+		if !ok || filter.Hidden(h.creator(), h.texts()...) {
+			continue
+		}
+		h.Hit = hit
+		list = append(list, h)
+	}
+	return list, nil
+}
+
+// loadHits 从数据库加载命中的对象
+func loadHits(ctx context.Context, kind string, ids []int64) (map[int64]*Hit, error) {
+	found := make(map[int64]*Hit, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	switch kind {
+	case KindStory:
+		stories, err := models.GetStoriesByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, story := range stories {
+			found[int64(story.ID)] = &Hit{Story: story}
+		}
+	case KindRole:
+		roles, err := models.GetStoryRolesByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			found[int64(role.ID)] = &Hit{Role: role}
+		}
+	case KindStoryBoard:
+		boards, err := models.GetStoryboardsByIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, board := range boards {
+			found[int64(board.ID)] = &Hit{Board: board}
+		}
+	case KindGroup:
+		groups, err := models.GetGroupsByIds(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			if !group.Deleted {
+				found[int64(group.ID)] = &Hit{Group: group}
+			}
+		}
+	case KindUser:
+		users, err := models.GetUsersByIds(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if !user.Deleted {
+				found[int64(user.ID)] = &Hit{User: user}
+			}
+		}
+	}
+	return found, nil
+}
+
+func (h *Hit) creator() int64 {
+	switch {
+	case h.Story != nil:
+		return h.Story.CreatorID
+	case h.Role != nil:
+		return h.Role.CreatorID
+	case h.Board != nil:
+		return h.Board.CreatorID
+	case h.Group != nil:
+		return h.Group.CreatorID
+	case h.User != nil:
+		return int64(h.User.ID)
+	}
+	return 0
+}
+
+func (h *Hit) texts() []string {
+	switch {
+	case h.Story != nil:
+		return []string{h.Story.Name, h.Story.Title, h.Story.ShortDesc}
+	case h.Role != nil:
+		return []string{h.Role.CharacterName, h.Role.CharacterDescription}
+	case h.Board != nil:
+		return []string{h.Board.Title, h.Board.Description}
+	case h.Group != nil:
+		return []string{h.Group.Name, h.Group.ShortDesc}
+	case h.User != nil:
+		return []string{h.User.Name, h.User.ShortDesc}
+	}
+	return nil
+}
+
+// Stories 搜索故事，没有配置搜索服务或者搜索失败时使用数据库按名字查询
+func Stories(ctx context.Context, q *elastic.Query) ([]*models.Story, int64, error) {
+	if Enabled() {
+		result, err := Search(ctx, KindStory, q)
+		if err == nil {
+			stories := make([]*models.Story, 0, len(result.List))
+			for _, hit := range result.List {
+				stories = append(stories, hit.Story)
+			}
+			return stories, result.Total, nil
+		}
+	}
+	return models.GetStoriesByName(ctx, q.Keyword, q.Offset, q.Size)
+}
+
+// Roles 搜索角色，StoryId 不为0时只搜索故事内的角色
+func Roles(ctx context.Context, q *elastic.Query) ([]*models.StoryRole, int64, error) {
+	if Enabled() {
+		result, err := Search(ctx, KindRole, q)
+		if err == nil {
+			roles := make([]*models.StoryRole, 0, len(result.List))
+			for _, hit := range result.List {
+				roles = append(roles, hit.Role)
+			}
+			return roles, result.Total, nil
+		}
+	}
+	return models.GetStoryRolesByName(ctx, q.Keyword, q.StoryId, q.Offset, q.Size)
+}
+
+// Groups 搜索小组
+func Groups(ctx context.Context, q *elastic.Query) ([]*models.Group, int64, error) {
+	if Enabled() {
+		result, err := Search(ctx, KindGroup, q)
+		if err == nil {
+			groups := make([]*models.Group, 0, len(result.List))
+			for _, hit := range result.List {
+				groups = append(groups, hit.Group)
+			}
+			return groups, result.Total, nil
+		}
+	}
+	return models.GetGroupByName(q.Keyword, q.Offset, q.Size)
+}
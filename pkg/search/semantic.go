@@ -0,0 +1,372 @@
+package search
+
+// 语义索引：故事的名字、简介和来历，故事板的标题和描述，角色的描述向量化后保存在数据库，
+// 并加载到内存中按余弦相似度暴力检索，用于相似推荐和自然语言搜索
+// 对象变化时随 Sync 更新，内存索引定期从数据库重建，多个实例之间因此最终一致
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/elastic"
+	"github.com/grapery/grapery/utils/log"
+	"github.com/grapery/grapery/utils/vector"
+)
+
+const (
+	sceneEmbedding          = "embedding" // 与 story.SceneEmbedding 相同，使用同一个向量平台
+	defaultSemanticMinScore = 0.3
+	defaultRefreshInterval  = 30 * time.Minute
+	embedMaxTokens          = 500 // embedding-2 单条输入最多512个token
+	semanticSyncTimeout     = 30 * time.Second
+)
+
+// SemanticKinds 参与语义检索的对象类型
+var SemanticKinds = []string{KindStory, KindStoryBoard, KindRole}
+
+type semanticOptions struct {
+	enable   bool
+	model    string
+	minScore float64
+	refresh  time.Duration
+}
+
+func semanticConfig() semanticOptions {
+	opts := semanticOptions{
+		enable:   true,
+		minScore: defaultSemanticMinScore,
+		refresh:  defaultRefreshInterval,
+	}
+	if config.GlobalConfig == nil || config.GlobalConfig.Semantic == nil {
+		return opts
+	}
+	cfg := config.GlobalConfig.Semantic
+	opts.enable = !cfg.Disable
+	opts.model = cfg.EmbeddingModel
+	if cfg.MinScore > 0 {
+		opts.minScore = cfg.MinScore
+	}
+	if cfg.RefreshInterval > 0 {
+		opts.refresh = time.Duration(cfg.RefreshInterval) * time.Minute
+	}
+	return opts
+}
+
+func embedder() (client.Embedder, error) {
+	p, err := client.GetRegistry().ForScene(sceneEmbedding)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := p.(client.Embedder)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s embedding", client.ErrNotSupported, p.Name())
+	}
+	return e, nil
+}
+
+// entry 索引中的一个对象，storyId 为所属故事，故事本身为自己的ID
+type entry struct {
+	id      int64
+	storyId int64
+	vec     []float32
+}
+
+// vectorIndex 按对象类型保存向量的内存索引
+type vectorIndex struct {
+	mu    sync.RWMutex
+	kinds map[string]map[int64]*entry
+}
+
+func newVectorIndex() *vectorIndex {
+	return &vectorIndex{kinds: make(map[string]map[int64]*entry)}
+}
+
+func (x *vectorIndex) put(kind string, e *entry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	entries, ok := x.kinds[kind]
+	if !ok {
+		entries = make(map[int64]*entry)
+		x.kinds[kind] = entries
+	}
+	entries[e.id] = e
+}
+
+func (x *vectorIndex) remove(kind string, id int64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.kinds[kind], id)
+}
+
+func (x *vectorIndex) get(kind string, id int64) *entry {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.kinds[kind][id]
+}
+
+// replace 用重建的结果替换某类对象的全部向量
+func (x *vectorIndex) replace(kind string, entries map[int64]*entry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.kinds[kind] = entries
+}
+
+func (x *vectorIndex) size(kind string) int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.kinds[kind])
+}
+
+// search 返回与 query 最相似的 k 个对象，skip 返回true的对象不参与比较
+func (x *vectorIndex) search(kind string, query []float32, k int, minScore float64, skip func(e *entry) bool) []*elastic.Hit {
+	x.mu.RLock()
+	entries := make([]*entry, 0, len(x.kinds[kind]))
+	for _, e := range x.kinds[kind] {
+		if skip == nil || !skip(e) {
+			entries = append(entries, e)
+		}
+	}
+	x.mu.RUnlock()
+	candidates := make([][]float32, 0, len(entries))
+	for _, e := range entries {
+		candidates = append(candidates, e.vec)
+	}
+	matches := vector.TopK(query, candidates, k, minScore)
+	hits := make([]*elastic.Hit, 0, len(matches))
+	for _, m := range matches {
+		hits = append(hits, &elastic.Hit{Id: entries[m.Index].id, Score: m.Score})
+	}
+	return hits
+}
+
+var (
+	semanticIndex = newVectorIndex()
+	semanticQueue chan task
+)
+
+// SemanticEnabled 语义检索是否可用
+func SemanticEnabled() bool {
+	return semanticQueue != nil
+}
+
+// StartSemantic 后台从数据库构建语义索引并处理 Sync 的更新，需要在大模型平台初始化之后调用
+// 只在api服务中启动，其他进程的修改在下一次重建时生效
+func StartSemantic(ctx context.Context) {
+	opts := semanticConfig()
+	if !opts.enable || semanticQueue != nil {
+		return
+	}
+	if _, err := embedder(); err != nil {
+		log.Log().Warn("semantic search disabled", zap.Error(err))
+		return
+	}
+	semanticQueue = make(chan task, queueSize)
+	go func() {
+		ticker := time.NewTicker(opts.refresh)
+		defer ticker.Stop()
+		for {
+			if _, err := Rebuild(ctx); err != nil && ctx.Err() == nil {
+				log.Log().Error("rebuild semantic index failed", zap.Error(err))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-semanticQueue:
+				c, cancel := context.WithTimeout(context.Background(), semanticSyncTimeout)
+				if err := syncSemantic(c, opts, t.kind, t.id); err != nil {
+					log.Log().Error("sync semantic index failed", zap.String("kind", t.kind),
+						zap.Int64("id", t.id), zap.Error(err))
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// syncSemantic 重新加载对象并更新向量，对象不应出现在搜索结果中或者没有内容时删除
+func syncSemantic(ctx context.Context, opts semanticOptions, kind string, id int64) error {
+	doc, err := newLoader().load(ctx, kind, id)
+	if err != nil {
+		return err
+	}
+	item := semanticItemOf(doc)
+	if item == nil {
+		semanticIndex.remove(kind, id)
+		return models.DeleteContentEmbedding(ctx, kind, id)
+	}
+	entries, err := embedItems(ctx, opts, kind, []*semanticItem{item})
+	if e, ok := entries[id]; ok {
+		semanticIndex.put(kind, e)
+	}
+	return err
+}
+
+// Rebuild 从数据库重建语义索引，没有向量或者内容变化的对象重新向量化，返回索引的对象数
+// 向量化失败的对象使用旧的向量，没有旧向量时跳过，等待下一次重建
+func Rebuild(ctx context.Context) (int, error) {
+	opts := semanticConfig()
+	if !opts.enable {
+		return 0, fmt.Errorf("semantic search disabled")
+	}
+	if _, err := embedder(); err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, kind := range SemanticKinds {
+		entries, err := rebuildKind(ctx, opts, kind)
+		if err != nil {
+			return total, err
+		}
+		semanticIndex.replace(kind, entries)
+		total += len(entries)
+		log.Log().Info("semantic index rebuilt", zap.String("kind", kind), zap.Int("count", len(entries)))
+	}
+	return total, nil
+}
+
+func rebuildKind(ctx context.Context, opts semanticOptions, kind string) (map[int64]*entry, error) {
+	entries := make(map[int64]*entry, semanticIndex.size(kind))
+	for offset := 0; ; offset += defaultBatchSize {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		docs, n, err := loadBatch(ctx, kind, offset, defaultBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]*semanticItem, 0, len(docs))
+		for _, doc := range docs {
+			if item := semanticItemOf(doc); item != nil {
+				items = append(items, item)
+			}
+		}
+		found, err := embedItems(ctx, opts, kind, items)
+		if err != nil {
+			log.Log().Warn("embed semantic items failed", zap.String("kind", kind),
+				zap.Int("offset", offset), zap.Error(err))
+		}
+		maps.Copy(entries, found)
+		if n < defaultBatchSize {
+			return entries, nil
+		}
+	}
+}
+
+// semanticItem 需要向量化的对象
+type semanticItem struct {
+	id      int64
+	storyId int64
+	text    string
+}
+
+// semanticItemOf 取出索引文档中向量化的内容，文档为nil或者没有内容时返回nil
+func semanticItemOf(doc elastic.ElasticDoc) *semanticItem {
+	var item *semanticItem
+	switch d := doc.(type) {
+	case *elastic.ElasticStory:
+		item = &semanticItem{id: int64(d.ID), storyId: int64(d.ID),
+			text: joinTexts(d.Name, d.Title, d.ShortDesc, d.Origin)}
+	case *elastic.ElasticStoryBoard:
+		item = &semanticItem{id: int64(d.ID), storyId: d.StoryID,
+			text: joinTexts(d.Title, d.Description)}
+	case *elastic.ElasticStoryRole:
+		item = &semanticItem{id: int64(d.ID), storyId: d.StoryID,
+			text: joinTexts(d.CharacterDescription)}
+	}
+	if item == nil || item.text == "" {
+		return nil
+	}
+	return item
+}
+
+// joinTexts 去掉空白和重复的段落后按行拼接
+func joinTexts(texts ...string) string {
+	parts := make([]string, 0, len(texts))
+	for _, text := range texts {
+		text = strings.TrimSpace(text)
+		if text == "" || (len(parts) > 0 && parts[len(parts)-1] == text) {
+			continue
+		}
+		parts = append(parts, text)
+	}
+	return strings.Join(parts, "\n")
+}
+
+func contentHash(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\n" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// embedItems 返回对象的向量，数据库中内容没有变化的向量直接使用，其余的向量化后保存
+// 向量化失败后本批剩下的对象不再请求平台，返回最后一个错误
+func embedItems(ctx context.Context, opts semanticOptions, kind string, items []*semanticItem) (map[int64]*entry, error) {
+	entries := make(map[int64]*entry, len(items))
+	if len(items) == 0 {
+		return entries, nil
+	}
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.id)
+	}
+	stored, err := models.GetContentEmbeddings(ctx, kind, ids)
+	if err != nil {
+		return entries, err
+	}
+	e, embedErr := embedder()
+	for _, item := range items {
+		hash := contentHash(opts.model, item.text)
+		old := stored[item.id]
+		if old != nil && old.ContentHash == hash {
+			entries[item.id] = &entry{id: item.id, storyId: item.storyId, vec: vector.Decode(old.Vector)}
+			continue
+		}
+		if embedErr == nil {
+			var embedding *client.EmbedResult
+			embedding, embedErr = e.Embed(ctx, &client.EmbedParams{
+				Model: opts.model,
+				Input: client.TruncateTokens(opts.model, item.text, embedMaxTokens, false),
+			})
+			if embedErr == nil {
+				err := models.SaveContentEmbedding(ctx, &models.ContentEmbedding{
+					EntityType:  kind,
+					EntityId:    item.id,
+					StoryId:     item.storyId,
+					Model:       embedding.Model,
+					ContentHash: hash,
+					Vector:      vector.Encode(embedding.Vector),
+				})
+				if err != nil {
+					log.Log().Error("save content embedding failed", zap.String("kind", kind),
+						zap.Int64("id", item.id), zap.Error(err))
+				}
+				entries[item.id] = &entry{id: item.id, storyId: item.storyId, vec: embedding.Vector}
+				continue
+			}
+		}
+		// 模型没有变化时旧向量仍然可以比较
+		if old != nil && (opts.model == "" || old.Model == opts.model) {
+			entries[item.id] = &entry{id: item.id, storyId: item.storyId, vec: vector.Decode(old.Vector)}
+		}
+	}
+	return entries, embedErr
+}
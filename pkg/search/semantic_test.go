@@ -0,0 +1,62 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils/elastic"
+)
+
+func TestVectorIndexSearch(t *testing.T) {
+	x := newVectorIndex()
+	x.put(KindRole, &entry{id: 1, storyId: 10, vec: []float32{1, 0}})
+	x.put(KindRole, &entry{id: 2, storyId: 10, vec: []float32{0.9, 0.1}})
+	x.put(KindRole, &entry{id: 3, storyId: 20, vec: []float32{0.8, 0.3}})
+	x.put(KindRole, &entry{id: 4, storyId: 30, vec: []float32{0, 1}})
+	x.put(KindStory, &entry{id: 10, storyId: 10, vec: []float32{1, 0}})
+
+	target := x.get(KindRole, 1)
+	require.NotNil(t, target)
+	hits := x.search(KindRole, target.vec, 10, 0.5, func(e *entry) bool {
+		return e.storyId == target.storyId
+	})
+	require.Len(t, hits, 1)
+	assert.Equal(t, int64(3), hits[0].Id)
+
+	hits = x.search(KindRole, []float32{1, 0}, 2, 0, nil)
+	require.Len(t, hits, 2)
+	assert.Equal(t, []int64{1, 2}, []int64{hits[0].Id, hits[1].Id})
+
+	x.remove(KindRole, 1)
+	assert.Nil(t, x.get(KindRole, 1))
+	x.replace(KindRole, map[int64]*entry{})
+	assert.Equal(t, 0, x.size(KindRole))
+	assert.Equal(t, 1, x.size(KindStory))
+}
+
+func TestSemanticItemOf(t *testing.T) {
+	assert.Nil(t, semanticItemOf(nil))
+	assert.Nil(t, semanticItemOf(&elastic.ElasticGroup{Group: &models.Group{}}))
+
+	story := &models.Story{Name: "长夜", Title: "长夜", ShortDesc: " 守夜人的故事 ", Origin: ""}
+	story.ID = 7
+	item := semanticItemOf(&elastic.ElasticStory{Story: story})
+	require.NotNil(t, item)
+	assert.Equal(t, &semanticItem{id: 7, storyId: 7, text: "长夜\n守夜人的故事"}, item)
+
+	role := &models.StoryRole{StoryID: 7, CharacterName: "老守卫"}
+	role.ID = 3
+	assert.Nil(t, semanticItemOf(&elastic.ElasticStoryRole{StoryRole: role}))
+	role.CharacterDescription = "沉默寡言的老兵"
+	assert.Equal(t, &semanticItem{id: 3, storyId: 7, text: "沉默寡言的老兵"},
+		semanticItemOf(&elastic.ElasticStoryRole{StoryRole: role}))
+}
+
+func TestContentHash(t *testing.T) {
+	assert.Equal(t, contentHash("embedding-2", "a"), contentHash("embedding-2", "a"))
+	assert.NotEqual(t, contentHash("embedding-2", "a"), contentHash("embedding-3", "a"))
+	assert.Len(t, contentHash("", "a"), 64)
+}
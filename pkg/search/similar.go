@@ -0,0 +1,92 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/elastic"
+)
+
+// 过滤会去掉部分结果，检索时多取一些
+const semanticOversample = 2
+
+// SimilarStories 与故事内容相似的其他故事，读完一个故事后推荐
+func SimilarStories(ctx context.Context, storyId int64, size int) (*Result, error) {
+	return similar(ctx, KindStory, storyId, size, func(target, e *entry) bool {
+		return e.id == target.id
+	})
+}
+
+// SimilarRoles 与角色描述相似的角色，不包括同一个故事中的角色
+func SimilarRoles(ctx context.Context, roleId int64, size int) (*Result, error) {
+	return similar(ctx, KindRole, roleId, size, func(target, e *entry) bool {
+		return e.storyId == target.storyId
+	})
+}
+
+// similar 以对象自己的向量检索，对象不在索引中时（私有、被隐藏或者还没有向量化）返回空结果
+func similar(ctx context.Context, kind string, id int64, size int, skip func(target, e *entry) bool) (*Result, error) {
+	opts := semanticConfig()
+	if !SemanticEnabled() {
+		return nil, fmt.Errorf("semantic search not enabled")
+	}
+	size = pageSize(size)
+	target := semanticIndex.get(kind, id)
+	if target == nil {
+		return &Result{List: make([]*Hit, 0)}, nil
+	}
+	hits := semanticIndex.search(kind, target.vec, size*semanticOversample, opts.minScore, func(e *entry) bool {
+		return skip(target, e)
+	})
+	return semanticResult(ctx, kind, hits, size)
+}
+
+// Semantic 按自然语言描述检索故事、故事板或者角色，结果按相似度排序
+func Semantic(ctx context.Context, kind, text string, size int) (*Result, error) {
+	opts := semanticConfig()
+	if !SemanticEnabled() {
+		return nil, fmt.Errorf("semantic search not enabled")
+	}
+	if !slices.Contains(SemanticKinds, kind) {
+		return nil, fmt.Errorf("unknown semantic search kind %s", kind)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("empty semantic search text")
+	}
+	size = pageSize(size)
+	e, err := embedder()
+	if err != nil {
+		return nil, err
+	}
+	embedding, err := e.Embed(ctx, &client.EmbedParams{
+		Model: opts.model,
+		Input: client.TruncateTokens(opts.model, text, embedMaxTokens, false),
+	})
+	if err != nil {
+		return nil, err
+	}
+	hits := semanticIndex.search(kind, embedding.Vector, size*semanticOversample, opts.minScore, nil)
+	return semanticResult(ctx, kind, hits, size)
+}
+
+func pageSize(size int) int {
+	if size <= 0 {
+		return defaultPageSize
+	}
+	return min(size, maxPageSize)
+}
+
+func semanticResult(ctx context.Context, kind string, hits []*elastic.Hit, size int) (*Result, error) {
+	list, err := fillHits(ctx, kind, hits)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) > size {
+		list = list[:size]
+	}
+	return &Result{Total: int64(len(list)), List: list}, nil
+}
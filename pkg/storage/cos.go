@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
+
+	"github.com/grapery/grapery/config"
+)
+
+// COSStorage 腾讯云COS，Endpoint 为存储桶地址，例如 https://grapery-1301865260.cos.ap-shanghai.myqcloud.com
+type COSStorage struct {
+	client    *cos.Client
+	accessKey string
+	secretKey string
+	baseURL   string
+	prefixes  urlPrefixes
+}
+
+func NewCOSStorage(cfg *config.StorageConfig) (*COSStorage, error) {
+	if cfg.Endpoint == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("cos endpoint, access key and secret key are required")
+	}
+	bucketURL, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client := cos.NewClient(&cos.BaseURL{BucketURL: bucketURL}, &http.Client{
+		Transport: &cos.AuthorizationTransport{
+			SecretID:  cfg.AccessKey,
+			SecretKey: cfg.SecretKey,
+		},
+	})
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = cfg.Endpoint
+	}
+	return &COSStorage{
+		client:    client,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		prefixes:  newURLPrefixes(append([]string{baseURL, cfg.Endpoint}, cfg.AliasURLs...)...),
+	}, nil
+}
+
+func (s *COSStorage) Name() string {
+	return TypeCOS
+}
+
+func (s *COSStorage) url(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *COSStorage) KeyOf(rawURL string) (string, bool) {
+	return s.prefixes.keyOf(rawURL)
+}
+
+func (s *COSStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	var opt *cos.ObjectPutOptions
+	if contentType != "" {
+		opt = &cos.ObjectPutOptions{ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{ContentType: contentType}}
+	}
+	if _, err := s.client.Object.Put(ctx, key, r, opt); err != nil {
+		return "", err
+	}
+	return s.url(key), nil
+}
+
+func (s *COSStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.client.Object.Get(ctx, key, nil)
+	if err != nil {
+		if cos.IsNotFoundError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *COSStorage) SignedURL(ctx context.Context, key string, expire time.Duration) (string, error) {
+	u, err := s.client.Object.GetPresignedURL(ctx, http.MethodGet, key, s.accessKey, s.secretKey, expire, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *COSStorage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Object.Delete(ctx, key)
+	return err
+}
+
+func (s *COSStorage) List(ctx context.Context, prefix string, limit int) ([]*Object, error) {
+	ret, _, err := s.client.Bucket.Get(ctx, &cos.BucketGetOptions{Prefix: prefix, MaxKeys: listLimit(limit)})
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*Object, 0, len(ret.Contents))
+	for _, object := range ret.Contents {
+		modTime, _ := time.Parse(time.RFC3339, object.LastModified)
+		objects = append(objects, &Object{
+			Key:     object.Key,
+			Size:    object.Size,
+			ModTime: modTime,
+			URL:     s.url(object.Key),
+		})
+	}
+	return objects, nil
+}
+
+// Thumbnail 存储桶不一定开通数据万象，在本地缩放
+func (s *COSStorage) Thumbnail(ctx context.Context, key string, size int) (string, error) {
+	return genericThumbnail(ctx, s, key, size)
+}
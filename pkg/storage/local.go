@@ -0,0 +1,233 @@
+package storage
+
+// 本地磁盘存储，用于开发和测试时离线运行；文件通过 Handler 提供访问
+// 与公开读的存储桶一样，所有文件都可以直接访问，签名地址只是兼容 SignedURL，不能用于保护私有文件
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grapery/grapery/config"
+)
+
+const (
+	defaultLocalDir     = "./data/storage"
+	defaultLocalBaseURL = "http://127.0.0.1:12305/files"
+	// LocalPath 本地存储文件服务挂载的路径
+	LocalPath = "/files/"
+)
+
+// LocalStorage 把对象保存为 Dir 下的文件，签名地址使用 SecretKey 做 HMAC 签名
+// 本地存储是公开的：不带签名的请求同样可以读取文件
+type LocalStorage struct {
+	dir      string
+	baseURL  string
+	prefixes urlPrefixes
+	secret   []byte
+	now      func() time.Time
+}
+
+func NewLocalStorage(cfg *config.StorageConfig) (*LocalStorage, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = defaultLocalDir
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultLocalBaseURL
+	}
+	secret := []byte(cfg.SecretKey)
+	if len(secret) == 0 {
+		// 没有配置时每次启动随机生成，重启后之前的签名地址失效
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &LocalStorage{
+		dir:      dir,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		prefixes: newURLPrefixes(append([]string{baseURL}, cfg.AliasURLs...)...),
+		secret:   secret,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *LocalStorage) Name() string {
+	return TypeLocal
+}
+
+func (s *LocalStorage) url(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *LocalStorage) KeyOf(rawURL string) (string, bool) {
+	return s.prefixes.keyOf(rawURL)
+}
+
+// cleanKey 去掉 key 中的 .. 等，保证文件在 Dir 之内
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	if key == "" || key == "." {
+		return "", errors.New("empty object key")
+	}
+	return key, nil
+}
+
+func (s *LocalStorage) filePath(key string) (string, string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return key, filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put 先写临时文件再改名，读到一半的文件不会被访问到
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	key, file, err := s.filePath(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return "", err
+	}
+	return s.url(key), nil
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	_, file, err := s.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	_, file, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string, limit int) ([]*Object, error) {
+	limit = listLimit(limit)
+	objects := make([]*Object, 0)
+	errLimit := errors.New("limit reached")
+	err := filepath.WalkDir(s.dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, &Object{Key: key, Size: info.Size(), ModTime: info.ModTime(), URL: s.url(key)})
+		if len(objects) >= limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s *LocalStorage) Thumbnail(ctx context.Context, key string, size int) (string, error) {
+	return genericThumbnail(ctx, s, key, size)
+}
+
+func (s *LocalStorage) sign(key string, expires int64) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *LocalStorage) SignedURL(ctx context.Context, key string, expire time.Duration) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	expires := s.now().Add(expire).Unix()
+	return s.url(key) + "?expires=" + strconv.FormatInt(expires, 10) + "&signature=" + s.sign(key, expires), nil
+}
+
+// Handler 提供文件访问，挂载在 LocalPath 下；不带签名的请求直接返回文件，
+// 带签名参数的请求校验签名和过期时间，签名错误或过期时返回403
+func (s *LocalStorage) Handler() http.Handler {
+	return http.StripPrefix(strings.TrimSuffix(LocalPath, "/"), http.HandlerFunc(s.serveFile))
+}
+
+func (s *LocalStorage) serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, file, err := s.filePath(r.URL.Path)
+	if err != nil || strings.HasPrefix(path.Base(key), ".") {
+		http.NotFound(w, r)
+		return
+	}
+	if signature := r.URL.Query().Get("signature"); signature != "" {
+		expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+		if err != nil || s.now().Unix() > expires || !hmac.Equal([]byte(signature), []byte(s.sign(key, expires))) {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, file)
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+
+	"github.com/grapery/grapery/config"
+)
+
+// OSSStorage 阿里云OSS，缩略图使用OSS的图片处理
+type OSSStorage struct {
+	bucket   *oss.Bucket
+	name     string
+	baseURL  string
+	prefixes urlPrefixes
+}
+
+func NewOSSStorage(cfg *config.StorageConfig) (*OSSStorage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("oss endpoint, bucket, access key and secret key are required")
+	}
+	client, err := oss.New(cfg.Endpoint, cfg.AccessKey, cfg.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.Bucket(cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimPrefix(strings.TrimPrefix(cfg.Endpoint, "https://"), "http://")
+	bucketURL := fmt.Sprintf("https://%s.%s", cfg.Bucket, endpoint)
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = bucketURL
+	}
+	return &OSSStorage{
+		bucket:   bucket,
+		name:     cfg.Bucket,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		prefixes: newURLPrefixes(append([]string{baseURL, bucketURL}, cfg.AliasURLs...)...),
+	}, nil
+}
+
+func (s *OSSStorage) Name() string {
+	return TypeOSS
+}
+
+func (s *OSSStorage) url(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *OSSStorage) KeyOf(rawURL string) (string, bool) {
+	return s.prefixes.keyOf(rawURL)
+}
+
+func (s *OSSStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	options := []oss.Option{oss.WithContext(ctx)}
+	if contentType != "" {
+		options = append(options, oss.ContentType(contentType))
+	}
+	if err := s.bucket.PutObject(key, r, options...); err != nil {
+		return "", err
+	}
+	return s.url(key), nil
+}
+
+func (s *OSSStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.bucket.GetObject(key, oss.WithContext(ctx))
+	if err != nil {
+		var serviceErr oss.ServiceError
+		if errors.As(err, &serviceErr) && serviceErr.StatusCode == 404 {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *OSSStorage) SignedURL(ctx context.Context, key string, expire time.Duration) (string, error) {
+	return s.bucket.SignURL(key, oss.HTTPGet, int64(expire/time.Second))
+}
+
+func (s *OSSStorage) Delete(ctx context.Context, key string) error {
+	return s.bucket.DeleteObject(key, oss.WithContext(ctx))
+}
+
+func (s *OSSStorage) List(ctx context.Context, prefix string, limit int) ([]*Object, error) {
+	ret, err := s.bucket.ListObjectsV2(oss.Prefix(prefix), oss.MaxKeys(listLimit(limit)), oss.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]*Object, 0, len(ret.Objects))
+	for _, object := range ret.Objects {
+		objects = append(objects, &Object{
+			Key:     object.Key,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+			URL:     s.url(object.Key),
+		})
+	}
+	return objects, nil
+}
+
+// Thumbnail 用OSS的图片处理缩放并另存为缩略图
+func (s *OSSStorage) Thumbnail(ctx context.Context, key string, size int) (string, error) {
+	target := thumbnailKey(key)
+	process := fmt.Sprintf("image/resize,m_fixed,w_%d,h_%d|sys/saveas,o_%v,b_%v",
+		size, size,
+		base64.URLEncoding.EncodeToString([]byte(target)),
+		base64.URLEncoding.EncodeToString([]byte(s.name)))
+	if _, err := s.bucket.ProcessObject(key, process, oss.WithContext(ctx)); err != nil {
+		return "", err
+	}
+	return s.url(target), nil
+}
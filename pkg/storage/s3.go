@@ -0,0 +1,329 @@
+package storage
+
+// 兼容S3协议的对象存储（AWS S3、MinIO、R2 等），使用 AWS Signature V4 签名
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grapery/grapery/config"
+)
+
+const (
+	s3DefaultRegion  = "us-east-1"
+	s3Service        = "s3"
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3UnsignedBody   = "UNSIGNED-PAYLOAD"
+	s3TimeFormat     = "20060102T150405Z"
+	s3DateFormat     = "20060102"
+	s3MaxPresignTime = 7 * 24 * time.Hour
+)
+
+// S3Storage 兼容S3协议的对象存储
+type S3Storage struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	baseURL   string
+	prefixes  urlPrefixes
+	client    *http.Client
+	now       func() time.Time
+}
+
+func NewS3Storage(cfg *config.StorageConfig) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3 endpoint, bucket, access key and secret key are required")
+	}
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	region := cfg.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+	s := &S3Storage{
+		endpoint:  u,
+		bucket:    cfg.Bucket,
+		region:    region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		client:    &http.Client{Timeout: fetchTimeout},
+		now:       time.Now,
+	}
+	bucketURL := strings.TrimSuffix(s.objectURL("").String(), "/")
+	s.baseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if s.baseURL == "" {
+		s.baseURL = bucketURL
+	}
+	s.prefixes = newURLPrefixes(append([]string{s.baseURL, bucketURL}, cfg.AliasURLs...)...)
+	return s, nil
+}
+
+func (s *S3Storage) Name() string {
+	return TypeS3
+}
+
+// objectURL 对象的请求地址，key 为空时为存储桶地址
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + s.endpoint.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = ""
+	return &u
+}
+
+func (s *S3Storage) url(key string) string {
+	return s.baseURL + "/" + key
+}
+
+func (s *S3Storage) KeyOf(rawURL string) (string, bool) {
+	return s.prefixes.keyOf(rawURL)
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return s.url(key), nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string, limit int) ([]*Object, error) {
+	u := s.objectURL("")
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	query.Set("max-keys", strconv.Itoa(listLimit(limit)))
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ret s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+	objects := make([]*Object, 0, len(ret.Contents))
+	for _, object := range ret.Contents {
+		objects = append(objects, &Object{
+			Key:     object.Key,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+			URL:     s.url(object.Key),
+		})
+	}
+	return objects, nil
+}
+
+// SignedURL 预签名的下载地址，最长7天
+func (s *S3Storage) SignedURL(ctx context.Context, key string, expire time.Duration) (string, error) {
+	if expire <= 0 || expire > s3MaxPresignTime {
+		return "", fmt.Errorf("signed url expire must be between 1s and %v", s3MaxPresignTime)
+	}
+	now := s.now().UTC()
+	u := s.objectURL(key)
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expire/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = canonicalQuery(query)
+	header := http.Header{}
+	header.Set("Host", u.Host)
+	signature := s.signature(now, http.MethodGet, u, header, []string{"host"}, s3UnsignedBody)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+func (s *S3Storage) Thumbnail(ctx context.Context, key string, size int) (string, error) {
+	return genericThumbnail(ctx, s, key, size)
+}
+
+// do 签名并发送请求，非2xx的响应转为错误
+func (s *S3Storage) do(req *http.Request, body []byte) (*http.Response, error) {
+	s.sign(req, body)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s: status %d: %s", req.Method, req.URL.Path, resp.StatusCode, message)
+}
+
+// sign 在请求头中加上 Signature V4 签名
+func (s *S3Storage) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		signedHeaders = append(signedHeaders, "content-type")
+		sort.Strings(signedHeaders)
+	}
+	signature := s.signature(now, req.Method, req.URL, req.Header, signedHeaders, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, s.scope(now), strings.Join(signedHeaders, ";"), signature))
+}
+
+func (s *S3Storage) scope(now time.Time) string {
+	return now.Format(s3DateFormat) + "/" + s.region + "/" + s3Service + "/aws4_request"
+}
+
+func (s *S3Storage) signature(now time.Time, method string, u *url.URL, header http.Header, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		headers.WriteString(name + ":" + strings.TrimSpace(header.Get(name)) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalPath(u.Path),
+		canonicalQuery(u.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3TimeFormat),
+		s.scope(now),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode 按 S3 的规则编码，只保留非保留字符
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func canonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return uriEncode(p, false)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,337 @@
+package storage
+
+// 对象存储：生成的图片、视频和用户上传的文件都通过 Storage 保存，后端由配置选择
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/pkg/cloud/aliyun"
+)
+
+// 后端类型，对应配置 storage.type
+const (
+	TypeOSS   = "oss"
+	TypeCOS   = "cos"
+	TypeS3    = "s3"
+	TypeLocal = "local"
+)
+
+// 对象的目录
+const (
+	DirImages    = "images"
+	DirVideos    = "videos"
+	DirThumbnail = "thumbnail"
+)
+
+const (
+	// 从地址转存时的最大文件大小
+	maxFetchSize   = 512 << 20
+	fetchTimeout   = 5 * time.Minute
+	defaultListMax = 1000
+)
+
+var (
+	ErrNotFound      = errors.New("object not found")
+	ErrNotConfigured = errors.New("storage is not configured")
+	ErrForeignURL    = errors.New("url is not in storage")
+)
+
+// Object 存储中的一个对象
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	URL     string    `json:"url"`
+}
+
+// Storage 对象存储的统一接口，key 为对象在存储中的路径，例如 images/xxx.jpg
+type Storage interface {
+	Name() string
+	// Put 上传对象，返回对象的公开访问地址
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error)
+	// Get 读取对象，不存在时返回 ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// SignedURL 带过期时间的访问地址，用于私有对象
+	SignedURL(ctx context.Context, key string, expire time.Duration) (string, error)
+	Delete(ctx context.Context, key string) error
+	// List 列出前缀下的对象，最多 limit 个
+	List(ctx context.Context, prefix string, limit int) ([]*Object, error)
+	// Thumbnail 生成图片的缩略图，返回缩略图的公开访问地址
+	Thumbnail(ctx context.Context, key string, size int) (string, error)
+	// KeyOf 从访问地址解析出对象路径，地址不属于该存储时返回false
+	KeyOf(rawURL string) (string, bool)
+}
+
+var (
+	defaultStorage Storage
+	mu             sync.RWMutex
+)
+
+// Init 根据配置创建默认的对象存储
+func Init(cfg *config.Config) error {
+	s, err := New(storageConfig(cfg))
+	if err != nil {
+		return err
+	}
+	SetDefault(s)
+	return nil
+}
+
+// storageConfig 兼容旧的 s3store 配置和环境变量中的阿里云OSS
+func storageConfig(cfg *config.Config) *config.StorageConfig {
+	if cfg != nil && cfg.Storage != nil {
+		return cfg.Storage
+	}
+	if cfg != nil && cfg.S3Store != nil {
+		return &config.StorageConfig{
+			Type:      TypeS3,
+			Endpoint:  cfg.S3Store.Address,
+			Bucket:    cfg.S3Store.Bucket,
+			AccessKey: cfg.S3Store.Token,
+			SecretKey: cfg.S3Store.Secret,
+		}
+	}
+	return &config.StorageConfig{
+		Type:      TypeOSS,
+		Endpoint:  aliyun.Endpoint,
+		Bucket:    aliyun.Bucket,
+		AccessKey: aliyun.APIKey,
+		SecretKey: aliyun.SecretKey,
+	}
+}
+
+// New 按配置创建对象存储
+func New(cfg *config.StorageConfig) (Storage, error) {
+	switch cfg.Type {
+	case TypeOSS:
+		return NewOSSStorage(cfg)
+	case TypeCOS:
+		return NewCOSStorage(cfg)
+	case TypeS3:
+		return NewS3Storage(cfg)
+	case TypeLocal:
+		return NewLocalStorage(cfg)
+	}
+	return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+}
+
+// SetDefault 设置默认的对象存储
+func SetDefault(s Storage) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultStorage = s
+}
+
+// Default 默认的对象存储，没有初始化时使用环境变量中的阿里云OSS
+func Default() (Storage, error) {
+	mu.RLock()
+	s := defaultStorage
+	mu.RUnlock()
+	if s != nil {
+		return s, nil
+	}
+	s, err := New(storageConfig(nil))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotConfigured, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if defaultStorage == nil {
+		defaultStorage = s
+	}
+	return defaultStorage, nil
+}
+
+// NewKey 生成 dir 目录下的新对象路径
+func NewKey(dir, ext string) string {
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return dir + "/" + uuid.New().String() + ext
+}
+
+var contentTypeExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+	"video/mp4":  ".mp4",
+	"text/plain": ".txt",
+}
+
+// ExtByContentType 根据类型选择扩展名，未知类型时使用 fallback
+func ExtByContentType(contentType, fallback string) string {
+	contentType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+	if ext, ok := contentTypeExts[contentType]; ok {
+		return ext
+	}
+	return fallback
+}
+
+// PutBytes 上传数据到 dir 目录，返回公开访问地址和对象路径
+func PutBytes(ctx context.Context, s Storage, dir string, data []byte, ext string) (string, string, error) {
+	contentType := http.DetectContentType(data)
+	key := NewKey(dir, ExtByContentType(contentType, ext))
+	u, err := s.Put(ctx, key, bytes.NewReader(data), contentType)
+	if err != nil {
+		return "", "", err
+	}
+	return u, key, nil
+}
+
+// PutFromURL 把地址指向的文件转存到 dir 目录，返回公开访问地址和对象路径
+func PutFromURL(ctx context.Context, s Storage, dir, srcURL string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srcURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("fetch %s: status %d", srcURL, resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	key := NewKey(dir, ExtByContentType(contentType, path.Ext(strings.Split(srcURL, "?")[0])))
+	u, err := s.Put(ctx, key, io.LimitReader(resp.Body, maxFetchSize), contentType)
+	if err != nil {
+		return "", "", err
+	}
+	return u, key, nil
+}
+
+// ReadURL 读取地址对应的对象，超过 maxSize 时返回错误
+// 只读取默认存储中的对象，不访问外部地址，用于下载用户可以指定的图片
+func ReadURL(ctx context.Context, rawURL string, maxSize int64) ([]byte, error) {
+	s, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	key, ok := s.KeyOf(rawURL)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrForeignURL, rawURL)
+	}
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("read %s: object too large", key)
+	}
+	return data, nil
+}
+
+// urlPrefixes 对象访问地址的前缀，不区分http和https
+type urlPrefixes []string
+
+func newURLPrefixes(prefixes ...string) urlPrefixes {
+	ret := make(urlPrefixes, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		u, err := url.Parse(strings.TrimSpace(prefix))
+		if err != nil || u.Host == "" {
+			continue
+		}
+		ret = append(ret, strings.ToLower(u.Host)+strings.TrimSuffix(u.Path, "/")+"/")
+	}
+	return ret
+}
+
+// keyOf 地址去掉参数后按前缀匹配，路径中不能包含 ..
+func (p urlPrefixes) keyOf(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	target := strings.ToLower(u.Host) + u.Path
+	for _, prefix := range p {
+		key, ok := strings.CutPrefix(target, prefix)
+		if !ok || key == "" {
+			continue
+		}
+		if key != path.Clean(key) || key == ".." || strings.HasPrefix(key, "../") {
+			return "", false
+		}
+		return key, true
+	}
+	return "", false
+}
+
+// thumbnailKey 缩略图的对象路径
+func thumbnailKey(key string) string {
+	name := path.Base(key)
+	return DirThumbnail + "/" + strings.TrimSuffix(name, path.Ext(name)) + ".jpg"
+}
+
+// genericThumbnail 读出图片在本地缩放后上传，用于没有图片处理能力的后端
+func genericThumbnail(ctx context.Context, s Storage, key string, size int) (string, error) {
+	if size <= 0 {
+		return "", errors.New("invalid thumbnail size")
+	}
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resize(img, size), &jpeg.Options{Quality: 85}); err != nil {
+		return "", err
+	}
+	return s.Put(ctx, thumbnailKey(key), &buf, "image/jpeg")
+}
+
+// resize 按比例缩小到长边不超过 size，使用最近邻采样
+func resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+	newWidth, newHeight := size, height*size/width
+	if height > width {
+		newWidth, newHeight = width*size/height, size
+	}
+	newWidth, newHeight = max(newWidth, 1), max(newHeight, 1)
+	thumbnail := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			thumbnail.Set(x, y, img.At(bounds.Min.X+x*width/newWidth, bounds.Min.Y+y*height/newHeight))
+		}
+	}
+	return thumbnail
+}
+
+func listLimit(limit int) int {
+	if limit <= 0 || limit > defaultListMax {
+		return defaultListMax
+	}
+	return limit
+}
@@ -0,0 +1,517 @@
+package story
+
+// 角色Agent：开启 UseAgent 的会话中，角色回复前可以调用工具查找故事设定、生成场景图片、
+// 提出故事板续写。平台的对话接口不支持原生的工具调用，由模型按约定输出json动作，
+// 工具调用和结果都保存为对应类型的聊天消息
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	api "github.com/grapery/common-protoc/gen"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/llmjson"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// Agent可以调用的工具
+const (
+	AgentToolLookupStory       = "lookup_story"
+	AgentToolSceneImage        = "generate_scene_image"
+	AgentToolProposeStoryboard = "propose_storyboard"
+)
+
+const agentActionReply = "reply"
+
+const (
+	agentMaxSteps         = 4 // 每轮最多调用工具的次数
+	agentLookupMaxBoards  = 50
+	agentLookupMaxResults = 5
+	agentLookupMaxRunes   = 300
+	agentMaxPromptRunes   = 2000 // 用户设置的Agent提示词
+	agentMaxArgRunes      = 4000
+)
+
+const agentToolInstruction = `你可以在对话中使用工具。每次只输出一个json对象，不要包含其他内容：
+- 回复用户：{"action":"reply","content":"回复内容"}
+- 调用工具：{"action":"工具名","args":{参数}}
+可用的工具：
+- lookup_story：查找故事中的情节、章节和人物设定，参数 {"query":"要查找的内容"}
+- generate_scene_image：为当前对话中的场景生成一张图片，参数 {"description":"画面描述"}
+- propose_storyboard：向用户提出故事的续写，用户采纳后成为新的章节，参数 {"title":"章节标题","content":"章节内容","prev_board_id":上一章节ID，可省略}
+工具的结果以"工具结果："开头发给你。涉及故事中的事实时先查找，不要编造。最后必须以reply回复用户。`
+
+const agentFinalInstruction = `不能再使用工具，根据已有的信息回复用户，只输出json：{"action":"reply","content":"回复内容"}`
+
+var agentActionSchema = llmjson.Object(map[string]*llmjson.Schema{
+	"action":  llmjson.String(),
+	"content": llmjson.String(),
+	"args":    llmjson.Map(&llmjson.Schema{}),
+}).WithAliases(map[string]string{
+	"tool":      "action",
+	"name":      "action",
+	"reply":     "content",
+	"arguments": "args",
+	"params":    "args",
+})
+
+type agentAction struct {
+	Action  string         `json:"action"`
+	Content string         `json:"content"`
+	Args    map[string]any `json:"args"`
+}
+
+func (a *agentAction) arg(name string) string {
+	switch v := a.Args[name].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
+
+// StoryboardProposal 角色提出的故事板续写，保存在续写建议消息的 ToolData 中
+type StoryboardProposal struct {
+	StoryId     int64  `json:"story_id"`
+	PrevBoardId int64  `json:"prev_board_id"`
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	BoardId     int64  `json:"board_id,omitempty"` // 采纳后创建的故事板
+}
+
+// AgentTurn Agent模式的一轮对话，Steps 为本轮的工具调用和结果
+type AgentTurn struct {
+	Message *ChatMessageItem   `json:"message"`
+	Steps   []*ChatMessageItem `json:"steps"`
+	Reply   *ChatMessageItem   `json:"reply"`
+}
+
+// agentToolResult 工具的执行结果，Text 发给模型，Data 保存到消息
+type agentToolResult struct {
+	Text        string
+	Data        any
+	MessageType int64
+}
+
+// agentReply 以Agent模式回复 message，返回最终的回复和本轮保存的工具消息
+func (s *StoryService) agentReply(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage) (*models.ChatMessage, []*models.ChatMessage, error) {
+	role, err := models.GetStoryRoleByID(ctx, chatCtx.RoleID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if role == nil {
+		return nil, nil, errors.New("角色不存在")
+	}
+	r, err := s.chatRolePrompt(ctx, chatCtx, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	instruction := agentToolInstruction
+	if prompt := strings.TrimSpace(chatCtx.AgentPrompt); prompt != "" {
+		instruction += "\n" + prompt
+	}
+	userId := fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID)
+	steps := make([]*models.ChatMessage, 0)
+	for step := 0; ; step++ {
+		r.Instruction = instruction
+		if step == agentMaxSteps {
+			r.Instruction = agentFinalInstruction
+		}
+		chatResp, promptTokens, err := s.chatRole(ctx, r, userId, fmt.Sprintf("%s_%d", message.UUID, step))
+		if err != nil {
+			log.Log().Error("agent chat with role failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+			return nil, steps, err
+		}
+		action := &agentAction{}
+		// 模型没有按约定输出json时，把输出当作回复
+		if err := llmjson.Parse(chatResp.Content, agentActionSchema, action); err != nil {
+			action = &agentAction{Action: agentActionReply, Content: chatResp.Content}
+		}
+		action.Action = strings.TrimSpace(action.Action)
+		if action.Action == "" || action.Action == agentActionReply || step == agentMaxSteps || !isAgentTool(action.Action) {
+			content := strings.TrimSpace(action.Content)
+			if content == "" {
+				content = strings.TrimSpace(chatResp.Content)
+			}
+			reply, err := s.saveRoleReply(ctx, chatCtx, message, content, promptTokens, chatResp.TokenNum)
+			return reply, steps, err
+		}
+
+		args, _ := json.Marshal(action.Args)
+		call := s.newAgentMessage(chatCtx, message, models.ChatMessageTypeToolCall, action.Action,
+			truncateRunes(string(args), agentMaxArgRunes), "")
+		call.PromptTokens = promptTokens
+		call.TokenNum = chatResp.TokenNum
+		if err := models.CreateChatMessage(ctx, call); err != nil {
+			return nil, steps, err
+		}
+		recordChatUsage(ctx, chatCtx, call)
+		steps = append(steps, call)
+
+		result := s.runAgentTool(ctx, chatCtx, role, action)
+		data, _ := json.Marshal(result.Data)
+		resultMessage := s.newAgentMessage(chatCtx, message, result.MessageType, action.Action, result.Text, string(data))
+		if err := models.CreateChatMessage(ctx, resultMessage); err != nil {
+			return nil, steps, err
+		}
+		steps = append(steps, resultMessage)
+		log.Log().Info("agent tool called", zap.Uint("chat_id", chatCtx.ID), zap.String("tool", action.Action),
+			zap.Uint("message_id", resultMessage.ID))
+
+		r.History = append(r.History,
+			client.Message{Role: "assistant", Content: chatResp.Content},
+			client.Message{Role: "user", Content: "工具结果：" + result.Text})
+	}
+}
+
+func isAgentTool(name string) bool {
+	switch name {
+	case AgentToolLookupStory, AgentToolSceneImage, AgentToolProposeStoryboard:
+		return true
+	}
+	return false
+}
+
+func (s *StoryService) newAgentMessage(chatCtx *models.ChatContext, message *models.ChatMessage, messageType int64,
+	toolName, content, toolData string) *models.ChatMessage {
+	return &models.ChatMessage{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        message.UserID,
+		RoleID:        chatCtx.RoleID,
+		Sender:        chatCtx.RoleID,
+		MessageType:   messageType,
+		Content:       content,
+		Status:        models.ChatMessageStatusNormal,
+		UUID:          message.UUID,
+		SendTime:      time.Now().Unix(),
+		ReplyToID:     int64(message.ID),
+		ToolName:      toolName,
+		ToolData:      toolData,
+	}
+}
+
+// runAgentTool 执行工具，失败时把错误作为结果告诉模型
+func (s *StoryService) runAgentTool(ctx context.Context, chatCtx *models.ChatContext, role *models.StoryRole, action *agentAction) *agentToolResult {
+	var (
+		result *agentToolResult
+		err    error
+	)
+	switch action.Action {
+	case AgentToolLookupStory:
+		result, err = s.agentLookupStory(ctx, role.StoryID, action.arg("query"))
+	case AgentToolSceneImage:
+		result, err = s.agentSceneImage(ctx, role, action.arg("description"))
+	case AgentToolProposeStoryboard:
+		result, err = s.agentProposeStoryboard(ctx, role.StoryID, action)
+	}
+	if err != nil {
+		log.Log().Error("agent tool failed", zap.Uint("chat_id", chatCtx.ID), zap.String("tool", action.Action), zap.Error(err))
+		return &agentToolResult{
+			Text:        "执行失败：" + err.Error(),
+			Data:        map[string]string{"error": err.Error()},
+			MessageType: models.ChatMessageTypeToolResult,
+		}
+	}
+	return result
+}
+
+// storyFact 查找到的故事内容
+type storyFact struct {
+	BoardId int64  `json:"board_id,omitempty"`
+	Source  string `json:"source"`
+	Content string `json:"content"`
+	score   int
+}
+
+// agentLookupStory 在故事最近的章节、场景和角色设定中查找与 query 相关的内容
+func (s *StoryService) agentLookupStory(ctx context.Context, storyId int64, query string) (*agentToolResult, error) {
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+	story, err := models.GetStory(ctx, storyId)
+	if err != nil {
+		return nil, err
+	}
+	terms := lookupTerms(query)
+	facts := make([]*storyFact, 0)
+	add := func(fact *storyFact) {
+		if fact.score = lookupScore(fact.Source+fact.Content, terms); fact.score > 0 {
+			facts = append(facts, fact)
+		}
+	}
+	add(&storyFact{Source: "故事简介：" + story.Title, Content: story.ShortDesc})
+	roles, err := models.GetStoryRole(ctx, storyId)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		add(&storyFact{Source: "人物：" + role.CharacterName, Content: role.CharacterDescription})
+	}
+	boards, err := models.GetStoryboardsByStory(ctx, storyId)
+	if err != nil {
+		return nil, err
+	}
+	if len(boards) > agentLookupMaxBoards {
+		boards = boards[:agentLookupMaxBoards]
+	}
+	for _, board := range boards {
+		add(&storyFact{BoardId: int64(board.ID), Source: "章节：" + board.Title, Content: board.Description})
+		scenes, err := models.GetStoryBoardScenesByBoard(ctx, int64(board.ID))
+		if err != nil {
+			return nil, err
+		}
+		for _, scene := range scenes {
+			add(&storyFact{BoardId: int64(board.ID), Source: "章节：" + board.Title, Content: scene.Content})
+		}
+	}
+	sort.SliceStable(facts, func(i, j int) bool { return facts[i].score > facts[j].score })
+	if len(facts) > agentLookupMaxResults {
+		facts = facts[:agentLookupMaxResults]
+	}
+	if len(facts) == 0 {
+		return &agentToolResult{
+			Text:        "故事中没有找到相关的内容",
+			Data:        map[string]any{"query": query, "facts": facts},
+			MessageType: models.ChatMessageTypeToolResult,
+		}, nil
+	}
+	var sb strings.Builder
+	for _, fact := range facts {
+		fact.Content = truncateRunes(fact.Content, agentLookupMaxRunes)
+		if fact.BoardId > 0 {
+			sb.WriteString(fmt.Sprintf("[%s，章节ID %d] %s\n", fact.Source, fact.BoardId, fact.Content))
+		} else {
+			sb.WriteString(fmt.Sprintf("[%s] %s\n", fact.Source, fact.Content))
+		}
+	}
+	return &agentToolResult{
+		Text:        sb.String(),
+		Data:        map[string]any{"query": query, "facts": facts},
+		MessageType: models.ChatMessageTypeToolResult,
+	}, nil
+}
+
+// lookupTerms 把查询拆成关键词，较长的关键词再拆成相邻的两个字，以匹配没有空格分隔的中文
+func lookupTerms(query string) []string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, word)
+		runes := []rune(word)
+		if len(runes) <= 2 || !unicode.Is(unicode.Han, runes[0]) {
+			continue
+		}
+		for i := 0; i+2 <= len(runes); i++ {
+			terms = append(terms, string(runes[i:i+2]))
+		}
+	}
+	return terms
+}
+
+func lookupScore(text string, terms []string) int {
+	text = strings.ToLower(text)
+	score := 0
+	for _, term := range terms {
+		if strings.Contains(text, term) {
+			score += len([]rune(term))
+		}
+	}
+	return score
+}
+
+// agentSceneImage 按描述生成场景图片，和故事板场景图片使用相同的模板，画面中的角色保持自己的形象
+func (s *StoryService) agentSceneImage(ctx context.Context, role *models.StoryRole, description string) (*agentToolResult, error) {
+	if description == "" {
+		return nil, errors.New("description is empty")
+	}
+	story, err := models.GetStory(ctx, role.StoryID)
+	if err != nil {
+		return nil, err
+	}
+	storyGen, err := s.genSceneImage(ctx, story, 0, &models.StoryBoardParams{StoryContent: story.ShortDesc},
+		truncateRunes(description, agentLookupMaxRunes), 1, []*RoleReferenceSheet{newRoleReferenceSheet(role)})
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, url := range strings.Split(storyGen.ImageUrls, ",") {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("no image generated")
+	}
+	return &agentToolResult{
+		Text:        "图片已生成并展示给用户",
+		Data:        map[string]any{"gen_id": storyGen.ID, "image_urls": urls},
+		MessageType: models.ChatMessageTypeToolResult,
+	}, nil
+}
+
+// agentProposeStoryboard 保存续写建议，默认接在故事最新的章节之后
+func (s *StoryService) agentProposeStoryboard(ctx context.Context, storyId int64, action *agentAction) (*agentToolResult, error) {
+	proposal := &StoryboardProposal{
+		StoryId: storyId,
+		Title:   truncateRunes(action.arg("title"), 64),
+		Content: truncateRunes(action.arg("content"), agentMaxArgRunes),
+	}
+	if proposal.Title == "" || proposal.Content == "" {
+		return nil, errors.New("title and content are required")
+	}
+	boards, err := models.GetStoryboardsByStory(ctx, storyId)
+	if err != nil {
+		return nil, err
+	}
+	proposal.PrevBoardId = -1
+	if len(boards) > 0 {
+		proposal.PrevBoardId = int64(boards[0].ID)
+	}
+	if prevId := action.arg("prev_board_id"); prevId != "" {
+		for _, board := range boards {
+			if fmt.Sprint(board.ID) == prevId {
+				proposal.PrevBoardId = int64(board.ID)
+			}
+		}
+	}
+	return &agentToolResult{
+		Text:        "续写建议已展示给用户，等待用户采纳",
+		Data:        proposal,
+		MessageType: models.ChatMessageTypeProposal,
+	}, nil
+}
+
+// UpdateChatAgent 开启或关闭会话的Agent模式，agentPrompt 为附加给角色的要求
+func (s *StoryService) UpdateChatAgent(ctx context.Context, chatId int64, useAgent bool, agentPrompt string) error {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"use_agent":    0,
+		"agent_prompt": truncateRunes(strings.TrimSpace(agentPrompt), agentMaxPromptRunes),
+	}
+	if useAgent {
+		updates["use_agent"] = 1
+	}
+	return models.UpdateChatContext(ctx, int64(chatCtx.ID), updates)
+}
+
+// SendChatAgentMessage 在Agent模式的会话中发送消息，返回本轮的工具调用和角色回复
+func (s *StoryService) SendChatAgentMessage(ctx context.Context, chatId int64, content, uuid string) (*AgentTurn, error) {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if chatCtx.UseAgent != 1 {
+		return nil, errors.New("agent is not enabled for the chat")
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("message is empty")
+	}
+	message := &models.ChatMessage{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        chatCtx.UserID,
+		RoleID:        chatCtx.RoleID,
+		Sender:        chatCtx.UserID,
+		Content:       truncateRunes(content, chatEditMaxContent),
+		Status:        models.ChatMessageStatusNormal,
+		UUID:          uuid,
+		SendTime:      time.Now().Unix(),
+	}
+	if err := models.CreateChatMessage(ctx, message); err != nil {
+		return nil, err
+	}
+	if !moderateChatMessage(ctx, chatCtx, message) {
+		return nil, errChatMessageHeld
+	}
+	reply, steps, err := s.agentReply(ctx, chatCtx, message)
+	turn := &AgentTurn{Message: newChatMessageItem(message), Steps: make([]*ChatMessageItem, 0, len(steps))}
+	for _, step := range steps {
+		turn.Steps = append(turn.Steps, newChatMessageItem(step))
+	}
+	if err != nil {
+		return turn, err
+	}
+	turn.Reply = newChatMessageItem(reply)
+	if err := models.UpdateChatContext(ctx, int64(chatCtx.ID), map[string]interface{}{"update_at": time.Now()}); err != nil {
+		log.Log().Error("update chat time failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	return turn, nil
+}
+
+// AcceptStoryboardProposal 采纳角色提出的续写，在故事中创建新的故事板
+func (s *StoryService) AcceptStoryboardProposal(ctx context.Context, chatId, messageId int64) (*StoryboardProposal, error) {
+	chatCtx, err := userChatContext(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	message, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	if message.MessageType != models.ChatMessageTypeProposal {
+		return nil, errors.New("message is not a storyboard proposal")
+	}
+	proposal := &StoryboardProposal{}
+	if err := json.Unmarshal([]byte(message.ToolData), proposal); err != nil {
+		return nil, err
+	}
+	if proposal.BoardId > 0 {
+		return proposal, nil
+	}
+	if _, err := readableStory(ctx, proposal.StoryId); err != nil {
+		return nil, err
+	}
+	resp, err := s.CreateStoryboard(ctx, &api.CreateStoryboardRequest{
+		Board: &api.StoryBoard{
+			StoryId:     proposal.StoryId,
+			Creator:     chatCtx.UserID,
+			PrevBoardId: proposal.PrevBoardId,
+			Title:       proposal.Title,
+			Content:     proposal.Content,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.GetData().GetBoardId() == 0 {
+		return nil, errors.New(resp.GetMessage())
+	}
+	proposal.BoardId = resp.GetData().GetBoardId()
+	if _, err := models.CreateStoryBoardScene(ctx, &models.StoryBoardScene{
+		Content:   proposal.Content,
+		CreatorId: chatCtx.UserID,
+		StoryId:   proposal.StoryId,
+		BoardId:   proposal.BoardId,
+	}); err != nil {
+		log.Log().Error("create proposal scene failed", zap.Int64("board_id", proposal.BoardId), zap.Error(err))
+		return nil, err
+	}
+	data, _ := json.Marshal(proposal)
+	if err := models.UpdateChatMessage(ctx, int64(message.ID), map[string]interface{}{"tool_data": string(data)}); err != nil {
+		return nil, err
+	}
+	log.Log().Info("accept storyboard proposal success", zap.Uint("chat_id", chatCtx.ID),
+		zap.Int64("message_id", messageId), zap.Int64("board_id", proposal.BoardId))
+	return proposal, nil
+}
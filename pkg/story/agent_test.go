@@ -0,0 +1,24 @@
+package story
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{}},
+		{"Hello, World", []string{"hello", "world"}},
+		{"宝剑", []string{"宝剑"}},
+		{"青龙剑", []string{"青龙剑", "青龙", "龙剑"}},
+		{"剑的来历 sword2", []string{"剑的来历", "剑的", "的来", "来历", "sword2"}},
+		{"excalibur", []string{"excalibur"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, lookupTerms(tt.query), tt.query)
+	}
+}
@@ -0,0 +1,341 @@
+package story
+
+// 故事的分支结构：故事板通过 PrevId 指向上一章节，fork 的故事板与原故事板是兄弟节点
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+	"github.com/grapery/grapery/utils/textdiff"
+)
+
+// BranchNode 分支图中的一个故事板
+type BranchNode struct {
+	BoardId    int64   `json:"board_id"`
+	PrevId     int64   `json:"prev_id"`
+	Title      string  `json:"title"`
+	CreatorId  int64   `json:"creator_id"`
+	Depth      int     `json:"depth"` // 根故事板为0
+	LikeNum    int     `json:"like_num"`
+	ForkNum    int     `json:"fork_num"`
+	CommentNum int     `json:"comment_num"`
+	ShareNum   int     `json:"share_num"`
+	Children   []int64 `json:"children"`
+	Leaves     int     `json:"leaves"` // 子树中的分支数
+	Ctime      int64   `json:"ctime"`
+}
+
+// BranchTree 故事的完整分支图，Nodes 按深度和创建时间排序
+type BranchTree struct {
+	StoryId int64         `json:"story_id"`
+	Roots   []int64       `json:"roots"`
+	Nodes   []*BranchNode `json:"nodes"`
+}
+
+// DiffScene 比较的场景
+type DiffScene struct {
+	SceneId int64  `json:"scene_id"`
+	Content string `json:"content"`
+}
+
+// SceneDiff 场景的差异，Type 为 equal/insert/delete/modify，modify 时 Text 为句子级的差异
+type SceneDiff struct {
+	Type string        `json:"type"`
+	Old  *DiffScene    `json:"old,omitempty"`
+	New  *DiffScene    `json:"new,omitempty"`
+	Text []textdiff.Op `json:"text,omitempty"`
+}
+
+// ChapterDiff 同一层级的两个故事板的差异，只存在于一个分支时 Old 或 New 为空
+type ChapterDiff struct {
+	Old         *BranchNode   `json:"old,omitempty"`
+	New         *BranchNode   `json:"new,omitempty"`
+	Title       []textdiff.Op `json:"title,omitempty"`
+	Description []textdiff.Op `json:"description,omitempty"`
+	Scenes      []*SceneDiff  `json:"scenes"`
+}
+
+// BranchDiff 两个分支从分叉点开始的差异
+type BranchDiff struct {
+	AncestorId int64          `json:"ancestor_id"` // 最近的公共故事板，没有时为0
+	Chapters   []*ChapterDiff `json:"chapters"`
+}
+
+const (
+	SceneDiffEqual  = "equal"
+	SceneDiffInsert = "insert"
+	SceneDiffDelete = "delete"
+	SceneDiffModify = "modify"
+)
+
+// storyReadable 私有故事只有创建者和拥有者可以查看
+func storyReadable(story *models.Story, userId int64) bool {
+	return !story.IsPrivate || story.CreatorID == userId || story.OwnerID == userId
+}
+
+func readableStory(ctx context.Context, storyId int64) (*models.Story, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	story, err := models.GetStory(ctx, storyId)
+	if err != nil {
+		log.Log().Error("get story failed", zap.Error(err))
+		return nil, err
+	}
+	if story == nil {
+		return nil, errors.New("story not found")
+	}
+	if !storyReadable(story, userId) {
+		return nil, errors.New("have no permission")
+	}
+	return story, nil
+}
+
+func newBranchNode(board *models.StoryBoard) *BranchNode {
+	return &BranchNode{
+		BoardId:    int64(board.ID),
+		PrevId:     board.PrevId,
+		Title:      board.Title,
+		CreatorId:  board.CreatorID,
+		LikeNum:    board.LikeNum,
+		ForkNum:    board.ForkNum,
+		CommentNum: board.CommentNum,
+		ShareNum:   board.ShareNum,
+		Children:   make([]int64, 0),
+		Ctime:      board.CreateAt.Unix(),
+	}
+}
+
+// GetStoryBranchTree 返回故事的所有故事板及其父子关系
+func (s *StoryService) GetStoryBranchTree(ctx context.Context, storyId int64) (*BranchTree, error) {
+	if _, err := readableStory(ctx, storyId); err != nil {
+		return nil, err
+	}
+	boards, err := models.GetStoryboardsByStory(ctx, storyId)
+	if err != nil {
+		log.Log().Error("get story boards failed", zap.Int64("story_id", storyId), zap.Error(err))
+		return nil, err
+	}
+	sort.Slice(boards, func(i, j int) bool {
+		return boards[i].ID < boards[j].ID
+	})
+	nodes := make(map[int64]*BranchNode, len(boards))
+	for _, board := range boards {
+		nodes[int64(board.ID)] = newBranchNode(board)
+	}
+	tree := &BranchTree{StoryId: storyId, Roots: make([]int64, 0), Nodes: make([]*BranchNode, 0, len(boards))}
+	for _, board := range boards {
+		node := nodes[int64(board.ID)]
+		// 上一章节已删除或不属于该故事时作为根
+		if parent, ok := nodes[board.PrevId]; ok && board.PrevId != int64(board.ID) {
+			parent.Children = append(parent.Children, node.BoardId)
+		} else {
+			tree.Roots = append(tree.Roots, node.BoardId)
+		}
+	}
+	// 从根开始计算深度和分支数，visited 防止异常数据形成环
+	visited := make(map[int64]bool, len(nodes))
+	var walk func(id int64, depth int) int
+	walk = func(id int64, depth int) int {
+		node := nodes[id]
+		visited[id] = true
+		node.Depth = depth
+		tree.Nodes = append(tree.Nodes, node)
+		node.Leaves = 0
+		for _, child := range node.Children {
+			if !visited[child] {
+				node.Leaves += walk(child, depth+1)
+			}
+		}
+		if node.Leaves == 0 {
+			node.Leaves = 1
+		}
+		return node.Leaves
+	}
+	for _, root := range tree.Roots {
+		walk(root, 0)
+	}
+	sort.SliceStable(tree.Nodes, func(i, j int) bool {
+		if tree.Nodes[i].Depth != tree.Nodes[j].Depth {
+			return tree.Nodes[i].Depth < tree.Nodes[j].Depth
+		}
+		return tree.Nodes[i].BoardId < tree.Nodes[j].BoardId
+	})
+	return tree, nil
+}
+
+// GetStoryboardPath 返回从根故事板到 boardId 的路径
+func (s *StoryService) GetStoryboardPath(ctx context.Context, boardId int64) ([]*BranchNode, error) {
+	board, err := models.GetStoryboard(ctx, boardId)
+	if err != nil {
+		return nil, err
+	}
+	if board == nil {
+		return nil, errors.New("storyboard not found")
+	}
+	story, err := readableStory(ctx, board.StoryID)
+	if err != nil {
+		return nil, err
+	}
+	boards, err := storyBranch(ctx, story, boardId)
+	if err != nil {
+		return nil, err
+	}
+	path := make([]*BranchNode, 0, len(boards))
+	for depth, board := range boards {
+		node := newBranchNode(board)
+		node.Depth = depth
+		path = append(path, node)
+	}
+	return path, nil
+}
+
+// DiffStoryBranches 比较两个分支，从最近的公共故事板之后逐层比较章节和场景
+func (s *StoryService) DiffStoryBranches(ctx context.Context, boardId, otherBoardId int64) (*BranchDiff, error) {
+	board, err := models.GetStoryboard(ctx, boardId)
+	if err != nil {
+		return nil, err
+	}
+	other, err := models.GetStoryboard(ctx, otherBoardId)
+	if err != nil {
+		return nil, err
+	}
+	if board == nil || other == nil {
+		return nil, errors.New("storyboard not found")
+	}
+	if board.StoryID != other.StoryID {
+		return nil, errors.New("storyboards belong to different stories")
+	}
+	story, err := readableStory(ctx, board.StoryID)
+	if err != nil {
+		return nil, err
+	}
+	oldPath, err := storyBranch(ctx, story, boardId)
+	if err != nil {
+		return nil, err
+	}
+	newPath, err := storyBranch(ctx, story, otherBoardId)
+	if err != nil {
+		return nil, err
+	}
+	common := 0
+	for common < len(oldPath) && common < len(newPath) && oldPath[common].ID == newPath[common].ID {
+		common++
+	}
+	diff := &BranchDiff{Chapters: make([]*ChapterDiff, 0)}
+	if common > 0 {
+		diff.AncestorId = int64(oldPath[common-1].ID)
+	}
+	for i := common; i < len(oldPath) || i < len(newPath); i++ {
+		var oldBoard, newBoard *models.StoryBoard
+		if i < len(oldPath) {
+			oldBoard = oldPath[i]
+		}
+		if i < len(newPath) {
+			newBoard = newPath[i]
+		}
+		chapter, err := diffChapter(ctx, oldBoard, newBoard)
+		if err != nil {
+			log.Log().Error("diff storyboard failed", zap.Int64("board_id", boardId),
+				zap.Int64("other_board_id", otherBoardId), zap.Error(err))
+			return nil, err
+		}
+		diff.Chapters = append(diff.Chapters, chapter)
+	}
+	return diff, nil
+}
+
+func diffChapter(ctx context.Context, oldBoard, newBoard *models.StoryBoard) (*ChapterDiff, error) {
+	chapter := &ChapterDiff{Scenes: make([]*SceneDiff, 0)}
+	var oldScenes, newScenes []*models.StoryBoardScene
+	var err error
+	var oldTitle, newTitle, oldDesc, newDesc string
+	if oldBoard != nil {
+		chapter.Old = newBranchNode(oldBoard)
+		oldTitle, oldDesc = oldBoard.Title, oldBoard.Description
+		if oldScenes, err = sortedScenes(ctx, int64(oldBoard.ID)); err != nil {
+			return nil, err
+		}
+	}
+	if newBoard != nil {
+		chapter.New = newBranchNode(newBoard)
+		newTitle, newDesc = newBoard.Title, newBoard.Description
+		if newScenes, err = sortedScenes(ctx, int64(newBoard.ID)); err != nil {
+			return nil, err
+		}
+	}
+	chapter.Title = textdiff.DiffText(oldTitle, newTitle)
+	chapter.Description = textdiff.DiffText(oldDesc, newDesc)
+
+	oldContents := make([]string, 0, len(oldScenes))
+	for _, scene := range oldScenes {
+		oldContents = append(oldContents, scene.Content)
+	}
+	newContents := make([]string, 0, len(newScenes))
+	for _, scene := range newScenes {
+		newContents = append(newContents, scene.Content)
+	}
+	// 场景按内容比较，相邻的删除和新增视为修改
+	var deleted, inserted []*DiffScene
+	flush := func() {
+		n := len(deleted)
+		if len(inserted) < n {
+			n = len(inserted)
+		}
+		for i := 0; i < n; i++ {
+			chapter.Scenes = append(chapter.Scenes, &SceneDiff{
+				Type: SceneDiffModify,
+				Old:  deleted[i],
+				New:  inserted[i],
+				Text: textdiff.DiffText(deleted[i].Content, inserted[i].Content),
+			})
+		}
+		for _, scene := range deleted[n:] {
+			chapter.Scenes = append(chapter.Scenes, &SceneDiff{Type: SceneDiffDelete, Old: scene})
+		}
+		for _, scene := range inserted[n:] {
+			chapter.Scenes = append(chapter.Scenes, &SceneDiff{Type: SceneDiffInsert, New: scene})
+		}
+		deleted, inserted = nil, nil
+	}
+	i, j := 0, 0
+	for _, op := range textdiff.Diff(oldContents, newContents) {
+		switch op.Type {
+		case textdiff.OpEqual:
+			flush()
+			chapter.Scenes = append(chapter.Scenes, &SceneDiff{
+				Type: SceneDiffEqual,
+				Old:  &DiffScene{SceneId: int64(oldScenes[i].ID), Content: oldScenes[i].Content},
+				New:  &DiffScene{SceneId: int64(newScenes[j].ID), Content: newScenes[j].Content},
+			})
+			i++
+			j++
+		case textdiff.OpDelete:
+			deleted = append(deleted, &DiffScene{SceneId: int64(oldScenes[i].ID), Content: oldScenes[i].Content})
+			i++
+		case textdiff.OpInsert:
+			inserted = append(inserted, &DiffScene{SceneId: int64(newScenes[j].ID), Content: newScenes[j].Content})
+			j++
+		}
+	}
+	flush()
+	return chapter, nil
+}
+
+func sortedScenes(ctx context.Context, boardId int64) ([]*models.StoryBoardScene, error) {
+	scenes, err := models.GetStoryBoardScenesByBoard(ctx, boardId)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(scenes, func(i, j int) bool {
+		return scenes[i].ID < scenes[j].ID
+	})
+	return scenes, nil
+}
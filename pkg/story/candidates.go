@@ -0,0 +1,363 @@
+package story
+
+// 场景图片的候选：每次渲染生成多张候选图片，记录为同一批次的 ImageGen；
+// 用户选定之前场景使用最先生成好的候选，选定后场景固定为选中的图片，重新渲染也不会覆盖
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/pkg/storage"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultImageCandidates = 4
+	maxImageCandidates     = 8
+	// 同时生成的候选图片数，避免触发平台的并发限制
+	imageCandidateConcurrency = 4
+	sceneCandidateMaxList     = 100
+	imageSeedMax              = 2147483647
+)
+
+func imageCandidateNum() int {
+	cfg := config.GlobalConfig
+	if cfg == nil || cfg.Render == nil || cfg.Render.ImageCandidates <= 0 {
+		return defaultImageCandidates
+	}
+	if cfg.Render.ImageCandidates > maxImageCandidates {
+		return maxImageCandidates
+	}
+	return cfg.Render.ImageCandidates
+}
+
+// SceneCandidate 场景的一张候选图片，Status 见 models.MediaGenStatus*
+type SceneCandidate struct {
+	Id        int64    `json:"id"`
+	SceneId   int64    `json:"scene_id"`
+	Batch     string   `json:"batch"`
+	Status    int      `json:"status"`
+	ImageUrls []string `json:"image_urls"`
+	Seed      int64    `json:"seed"`
+	Prompt    string   `json:"prompt"`
+	Platform  string   `json:"platform"`
+	Starred   bool     `json:"starred"`
+	Selected  bool     `json:"selected"`
+	ParentId  int64    `json:"parent_id,omitempty"` // 编辑生成的候选，来源候选的ID
+	EditMode  string   `json:"edit_mode,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Ctime     int64    `json:"ctime"`
+}
+
+// SceneCandidateList 场景的候选图片，按时间从新到旧
+type SceneCandidateList struct {
+	SceneId         int64             `json:"scene_id"`
+	Batch           string            `json:"batch"` // 最近一次渲染的批次
+	SelectedImageId int64             `json:"selected_image_id"`
+	List            []*SceneCandidate `json:"list"`
+}
+
+func newSceneCandidate(scene *models.StoryBoardScene, imageGen *models.ImageGen) *SceneCandidate {
+	candidate := &SceneCandidate{
+		Id:        int64(imageGen.ID),
+		SceneId:   imageGen.SceneID,
+		Batch:     imageGen.Batch,
+		Status:    imageGen.Status,
+		ImageUrls: make([]string, 0),
+		Seed:      imageGen.Seed,
+		Prompt:    imageGen.Prompt,
+		Platform:  imageGen.Platform,
+		Starred:   imageGen.Starred == 1,
+		Selected:  int64(imageGen.ID) == scene.SelectedImageID,
+		ParentId:  imageGen.ParentID,
+		EditMode:  imageGen.EditMode,
+		Ctime:     imageGen.CreateAt.Unix(),
+	}
+	if imageGen.Status == models.MediaGenStatusFailed {
+		candidate.Message = imageGen.Message
+	}
+	if imageGen.ImageUrl != "" {
+		candidate.ImageUrls = strings.Split(imageGen.ImageUrl, ",")
+	}
+	return candidate
+}
+
+// renderSceneCandidates 按 params 为场景生成多张候选图片，每张使用不同的随机种子，
+// 最多同时生成 imageCandidateConcurrency 张，
+// 结果记录为批次 scene.TaskId 的 ImageGen 并更新场景（未保存），全部失败时返回错误
+func (s *StoryService) renderSceneCandidates(ctx context.Context, scene *models.StoryBoardScene, params *client.ImageParams) ([]*models.ImageGen, error) {
+	num := imageCandidateNum()
+	scene.GenStatus = int(models.StoryGenStatusRunning)
+	results := make([]*models.ImageGen, num)
+	errs := make([]error, num)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, imageCandidateConcurrency)
+	for i := 0; i < num; i++ {
+		candidateParams := *params
+		candidateParams.Seed = rand.Int63n(imageSeedMax) + 1
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], errs[i] = s.renderSceneCandidate(ctx, scene, &candidateParams)
+		}()
+	}
+	wg.Wait()
+	candidates := make([]*models.ImageGen, 0, num)
+	var lastErr error
+	for i, imageGen := range results {
+		if imageGen == nil {
+			// 记录没有保存
+			return nil, errs[i]
+		}
+		if errs[i] != nil {
+			lastErr = errs[i]
+			if errs[i] == errImageHeld {
+				continue
+			}
+		}
+		candidates = append(candidates, imageGen)
+	}
+	applySceneCandidates(scene, candidates)
+	if scene.GenStatus == int(models.StoryGenStatusError) {
+		return candidates, lastErr
+	}
+	return candidates, nil
+}
+
+// renderSceneCandidate 生成一张候选图片并保存为 ImageGen，生成失败时记录失败的 ImageGen 并返回错误，
+// 保存失败时返回nil
+func (s *StoryService) renderSceneCandidate(ctx context.Context, scene *models.StoryBoardScene, params *client.ImageParams) (*models.ImageGen, error) {
+	imageGen := &models.ImageGen{
+		OriginID: scene.StoryId,
+		BoardID:  scene.BoardId,
+		SceneID:  int64(scene.ID),
+		Batch:    scene.TaskId,
+		Seed:     params.Seed,
+		Uuid:     uuid.New().String(),
+		Prompt:   params.Prompt,
+	}
+	var genErr error
+	ret, platform, err := s.generateImage(ctx, params)
+	imageGen.Platform = platform
+	switch {
+	case err != nil:
+		genErr = err
+	case len(ret.ImageUrls) != 0:
+		storeUrls := mirrorToStorage(ctx, storage.DirImages, ret.ImageUrls)
+		if len(storeUrls) == 0 {
+			genErr = errors.New("upload result to storage failed")
+			break
+		}
+		imageGen.Status = models.MediaGenStatusSucceed
+		imageGen.ImageUrl = strings.Join(storeUrls, ",")
+	case ret.TaskId != "":
+		// 平台只返回任务ID，由 syncworker 获取结果后回写
+		imageGen.Status = models.MediaGenStatusPending
+		imageGen.TaskID = ret.TaskId
+	default:
+		genErr = errors.New("image platform returned no result")
+	}
+	if genErr != nil {
+		imageGen.Status = models.MediaGenStatusFailed
+		imageGen.Message = genErr.Error()
+	}
+	if _, err := models.CreateImageGen(ctx, imageGen); err != nil {
+		log.Log().Error("create image gen failed", zap.Int64("scene_id", int64(scene.ID)), zap.Error(err))
+		return nil, err
+	}
+	if genErr != nil {
+		return imageGen, genErr
+	}
+	if !moderateImageGen(ctx, imageGen) {
+		return imageGen, errImageHeld
+	}
+	return imageGen, nil
+}
+
+// applySceneCandidates 按一次渲染的候选图片更新场景的生成状态；
+// 用户没有选定图片时使用最先生成好的候选，之后完成的候选不再覆盖
+func applySceneCandidates(scene *models.StoryBoardScene, candidates []*models.ImageGen) {
+	var succeed *models.ImageGen
+	pending := false
+	for _, candidate := range candidates {
+		switch candidate.Status {
+		case models.MediaGenStatusSucceed:
+			if succeed == nil {
+				succeed = candidate
+			}
+		case models.MediaGenStatusPending:
+			pending = true
+		}
+	}
+	switch {
+	case succeed != nil:
+		if scene.SelectedImageID == 0 && scene.GenStatus != int(models.StoryGenStatusFinish) {
+			scene.GenResult = sceneGenResult(succeed)
+		}
+		scene.GenStatus = int(models.StoryGenStatusFinish)
+	case pending:
+		scene.GenStatus = int(models.StoryGenStatusRunning)
+	default:
+		scene.GenStatus = int(models.StoryGenStatusError)
+	}
+}
+
+// sceneGenResult 候选图片在场景 GenResult 中的格式
+func sceneGenResult(imageGen *models.ImageGen) string {
+	urls := strings.Split(imageGen.ImageUrl, ",")
+	data, _ := json.Marshal(urls)
+	return string(data)
+}
+
+// sceneForCandidates 获取可以查看的场景，edit 为true时要求当前用户可以修改场景
+func sceneForCandidates(ctx context.Context, sceneId int64, edit bool) (*models.StoryBoardScene, error) {
+	scene, err := models.GetStoryBoardScene(ctx, sceneId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("scene not found")
+		}
+		return nil, err
+	}
+	if scene == nil || scene.Status < 0 {
+		return nil, errors.New("scene not found")
+	}
+	story, err := readableStory(ctx, scene.StoryId)
+	if err != nil {
+		return nil, err
+	}
+	if !edit {
+		return scene, nil
+	}
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if scene.CreatorId != userId && story.CreatorID != userId && story.OwnerID != userId {
+		return nil, errors.New("have no permission")
+	}
+	return scene, nil
+}
+
+// sceneCandidate 获取场景的一张候选图片
+func sceneCandidate(ctx context.Context, scene *models.StoryBoardScene, candidateId int64) (*models.ImageGen, error) {
+	imageGen, err := models.GetImageGen(ctx, candidateId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("candidate not found")
+		}
+		return nil, err
+	}
+	if imageGen.SceneID != int64(scene.ID) || imageGen.Deleted != 0 {
+		return nil, errors.New("candidate not found")
+	}
+	return imageGen, nil
+}
+
+// ListSceneCandidates 获取场景的候选图片
+func (s *StoryService) ListSceneCandidates(ctx context.Context, sceneId int64) (*SceneCandidateList, error) {
+	scene, err := sceneForCandidates(ctx, sceneId, false)
+	if err != nil {
+		return nil, err
+	}
+	imageGens, err := models.GetSceneImageGens(ctx, sceneId, sceneCandidateMaxList)
+	if err != nil {
+		log.Log().Error("get scene image gens failed", zap.Int64("scene_id", sceneId), zap.Error(err))
+		return nil, err
+	}
+	list := &SceneCandidateList{
+		SceneId:         sceneId,
+		Batch:           scene.TaskId,
+		SelectedImageId: scene.SelectedImageID,
+		List:            make([]*SceneCandidate, 0, len(imageGens)),
+	}
+	for _, imageGen := range imageGens {
+		list.List = append(list.List, newSceneCandidate(scene, imageGen))
+	}
+	return list, nil
+}
+
+// SelectSceneCandidate 选定候选图片作为场景的图片，记录它的种子和提示词
+func (s *StoryService) SelectSceneCandidate(ctx context.Context, sceneId, candidateId int64) (*SceneCandidate, error) {
+	scene, err := sceneForCandidates(ctx, sceneId, true)
+	if err != nil {
+		return nil, err
+	}
+	imageGen, err := sceneCandidate(ctx, scene, candidateId)
+	if err != nil {
+		return nil, err
+	}
+	if err := selectSceneImage(ctx, scene, imageGen); err != nil {
+		return nil, err
+	}
+	return newSceneCandidate(scene, imageGen), nil
+}
+
+// selectSceneImage 把生成好的候选图片设为场景的图片并记录版本
+func selectSceneImage(ctx context.Context, scene *models.StoryBoardScene, imageGen *models.ImageGen) error {
+	if imageGen.Status != models.MediaGenStatusSucceed {
+		return errors.New("candidate is not ready")
+	}
+	before := sceneRevision(scene)
+	scene.GenResult = sceneGenResult(imageGen)
+	scene.GenStatus = int(models.StoryGenStatusFinish)
+	scene.SelectedImageID = int64(imageGen.ID)
+	scene.SelectedSeed = imageGen.Seed
+	scene.SelectedPrompt = imageGen.Prompt
+	if err := updateSceneWithRevision(ctx, before, scene, manualRevision(ctx)); err != nil {
+		log.Log().Error("select scene candidate failed", zap.Uint("scene_id", scene.ID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// StarSceneCandidate 收藏或取消收藏候选图片
+func (s *StoryService) StarSceneCandidate(ctx context.Context, sceneId, candidateId int64, starred bool) (*SceneCandidate, error) {
+	scene, err := sceneForCandidates(ctx, sceneId, true)
+	if err != nil {
+		return nil, err
+	}
+	imageGen, err := sceneCandidate(ctx, scene, candidateId)
+	if err != nil {
+		return nil, err
+	}
+	imageGen.Starred = 0
+	if starred {
+		imageGen.Starred = 1
+	}
+	if err := models.UpdateImageGenColumns(ctx, candidateId, map[string]interface{}{"starred": imageGen.Starred}); err != nil {
+		return nil, err
+	}
+	return newSceneCandidate(scene, imageGen), nil
+}
+
+// DiscardSceneCandidate 丢弃候选图片，场景正在使用的图片不能丢弃
+func (s *StoryService) DiscardSceneCandidate(ctx context.Context, sceneId, candidateId int64) error {
+	scene, err := sceneForCandidates(ctx, sceneId, true)
+	if err != nil {
+		return err
+	}
+	imageGen, err := sceneCandidate(ctx, scene, candidateId)
+	if err != nil {
+		return err
+	}
+	if scene.SelectedImageID == int64(imageGen.ID) {
+		return errors.New("the selected candidate can not be discarded")
+	}
+	return models.DeleteImageGen(ctx, candidateId)
+}
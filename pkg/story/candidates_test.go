@@ -0,0 +1,66 @@
+package story
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/models"
+)
+
+func TestApplySceneCandidates(t *testing.T) {
+	candidate := func(status int, url string) *models.ImageGen {
+		return &models.ImageGen{Status: status, ImageUrl: url}
+	}
+	tests := []struct {
+		name       string
+		scene      *models.StoryBoardScene
+		candidates []*models.ImageGen
+		wantStatus models.StoryGenStatus
+		wantResult string
+	}{
+		{
+			name:       "first succeed wins",
+			scene:      &models.StoryBoardScene{},
+			candidates: []*models.ImageGen{candidate(models.MediaGenStatusFailed, ""), candidate(models.MediaGenStatusSucceed, "a,b"), candidate(models.MediaGenStatusSucceed, "c")},
+			wantStatus: models.StoryGenStatusFinish,
+			wantResult: `["a","b"]`,
+		},
+		{
+			name:       "pending",
+			scene:      &models.StoryBoardScene{},
+			candidates: []*models.ImageGen{candidate(models.MediaGenStatusPending, ""), candidate(models.MediaGenStatusFailed, "")},
+			wantStatus: models.StoryGenStatusRunning,
+		},
+		{
+			name:       "all failed",
+			scene:      &models.StoryBoardScene{},
+			candidates: []*models.ImageGen{candidate(models.MediaGenStatusFailed, "")},
+			wantStatus: models.StoryGenStatusError,
+		},
+		{
+			name:       "no candidates",
+			scene:      &models.StoryBoardScene{},
+			wantStatus: models.StoryGenStatusError,
+		},
+		{
+			name:       "keep selected image",
+			scene:      &models.StoryBoardScene{SelectedImageID: 7, GenResult: `["x"]`},
+			candidates: []*models.ImageGen{candidate(models.MediaGenStatusSucceed, "a")},
+			wantStatus: models.StoryGenStatusFinish,
+			wantResult: `["x"]`,
+		},
+		{
+			name:       "keep finished result",
+			scene:      &models.StoryBoardScene{GenStatus: int(models.StoryGenStatusFinish), GenResult: `["x"]`},
+			candidates: []*models.ImageGen{candidate(models.MediaGenStatusSucceed, "a")},
+			wantStatus: models.StoryGenStatusFinish,
+			wantResult: `["x"]`,
+		},
+	}
+	for _, tt := range tests {
+		applySceneCandidates(tt.scene, tt.candidates)
+		assert.Equal(t, int(tt.wantStatus), tt.scene.GenStatus, tt.name)
+		assert.Equal(t, tt.wantResult, tt.scene.GenResult, tt.name)
+	}
+}
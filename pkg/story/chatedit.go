@@ -0,0 +1,470 @@
+package story
+
+// 角色聊天消息的重新生成、编辑和分支：
+// 重新生成最后一条回复时保留之前的回复供切换；编辑用户消息时替换它及之后的消息并重新回复；
+// 从任意一条消息分叉出新的会话，复制之前的消息和记忆
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	chatMaxAlternatives   = 10   // 每条用户消息最多保留的回复数
+	chatForkMaxMessages   = 2000 // 分支时最多复制的消息数
+	chatEditMaxContent    = 2000
+	chatForkTitleSuffix   = "（分支）"
+	chatForkMemoryMaxCopy = 1000
+)
+
+// ChatMessageItem 单聊消息，Status 见 models.ChatMessageStatus*
+type ChatMessageItem struct {
+	MessageId int64  `json:"message_id"`
+	ChatId    int64  `json:"chat_id"`
+	UserId    int64  `json:"user_id"`
+	RoleId    int64  `json:"role_id"` // 为0时是用户发送的消息
+	Content   string `json:"content"`
+	Uuid      string `json:"uuid"`
+	ReplyToId int64  `json:"reply_to_id,omitempty"`
+	OriginId  int64  `json:"origin_id,omitempty"`
+	Status    int64  `json:"status"`
+	Ctime     int64  `json:"ctime"`
+	// Agent模式的工具消息，MessageType 见 models.ChatMessageType*
+	MessageType int64           `json:"message_type,omitempty"`
+	ToolName    string          `json:"tool_name,omitempty"`
+	ToolData    json.RawMessage `json:"tool_data,omitempty"`
+	// 用户发送的图片或角色回复的插图，RenderStatus 见 models.ChatRender*
+	ImageUrls    []string `json:"image_urls,omitempty"`
+	RenderStatus int64    `json:"render_status,omitempty"`
+}
+
+// ChatTurn 一轮对话，重新生成时 Message 为原来的用户消息
+type ChatTurn struct {
+	Message *ChatMessageItem `json:"message"`
+	Reply   *ChatMessageItem `json:"reply"`
+}
+
+// ChatReplyAlternatives 用户消息的所有回复，当前使用的回复状态为正常
+type ChatReplyAlternatives struct {
+	MessageId int64              `json:"message_id"`
+	List      []*ChatMessageItem `json:"list"`
+}
+
+// ChatForkInfo 分叉出的新会话
+type ChatForkInfo struct {
+	ChatId        int64  `json:"chat_id"`
+	Title         string `json:"title"`
+	ChatType      int    `json:"chat_type"`
+	ForkFromId    int64  `json:"fork_from_id"`
+	ForkMessageId int64  `json:"fork_message_id"`
+	MessageNum    int    `json:"message_num"`
+}
+
+func newChatMessageItem(message *models.ChatMessage) *ChatMessageItem {
+	item := &ChatMessageItem{
+		MessageId:    int64(message.ID),
+		ChatId:       message.ChatContextID,
+		UserId:       message.UserID,
+		Content:      message.Content,
+		Uuid:         message.UUID,
+		ReplyToId:    message.ReplyToID,
+		OriginId:     message.OriginID,
+		Status:       message.Status,
+		Ctime:        message.CreateAt.Unix(),
+		MessageType:  message.MessageType,
+		ToolName:     message.ToolName,
+		ImageUrls:    chatMessageImages(message),
+		RenderStatus: message.NeedRender,
+	}
+	if chatMessageRole(message) == "assistant" {
+		item.RoleId = message.RoleID
+	}
+	if message.ToolData != "" && json.Valid([]byte(message.ToolData)) {
+		item.ToolData = json.RawMessage(message.ToolData)
+	}
+	return item
+}
+
+// userChatContext 获取当前用户的会话
+func userChatContext(ctx context.Context, chatId int64) (*models.ChatContext, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chatCtx, err := models.GetChatContextByID(ctx, chatId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("chat not found")
+		}
+		return nil, err
+	}
+	if chatCtx.UserID != userId {
+		return nil, errors.New("have no permission")
+	}
+	return chatCtx, nil
+}
+
+// userSingleChat 获取当前用户和一个角色的会话，群聊的回复由发言策略决定，不支持重新生成和编辑
+func userSingleChat(ctx context.Context, chatId int64) (*models.ChatContext, error) {
+	chatCtx, err := userChatContext(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if chatCtx.ChatType != models.ChatTypeSingle {
+		return nil, errors.New("only role chat is supported")
+	}
+	return chatCtx, nil
+}
+
+// lastChatReply 获取会话最后一条角色回复及其对应的用户消息，最后一条消息必须是角色回复
+func lastChatReply(ctx context.Context, chatCtx *models.ChatContext) (*models.ChatMessage, *models.ChatMessage, error) {
+	last, err := models.GetRecentChatMessages(ctx, int64(chatCtx.ID), 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(last) == 0 || chatMessageRole(last[0]) != "assistant" || last[0].MessageType != models.ChatMessageTypeText {
+		return nil, nil, errors.New("last message is not a role reply")
+	}
+	reply := last[0]
+	if reply.ReplyToID != 0 {
+		message, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), reply.ReplyToID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reply, message, nil
+	}
+	// 早期的回复没有记录对应的用户消息，取它之前的一条
+	before, err := models.GetChatMessagesBefore(ctx, int64(chatCtx.ID), int64(reply.ID), 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(before) == 0 || chatMessageRole(before[0]) != "user" {
+		return nil, nil, errors.New("reply message not found")
+	}
+	reply.ReplyToID = int64(before[0].ID)
+	if err := models.UpdateChatMessage(ctx, int64(reply.ID), map[string]interface{}{"reply_to_id": reply.ReplyToID}); err != nil {
+		return nil, nil, err
+	}
+	return reply, before[0], nil
+}
+
+// RegenerateChatReply 重新生成会话最后一条角色回复，之前的回复保留为候选
+func (s *StoryService) RegenerateChatReply(ctx context.Context, chatId int64) (*ChatTurn, error) {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	reply, message, err := lastChatReply(ctx, chatCtx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := models.GetChatReplies(ctx, int64(chatCtx.ID), int64(message.ID))
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) >= chatMaxAlternatives {
+		return nil, errors.New("too many regenerated replies")
+	}
+	if err := models.UpdateChatMessage(ctx, int64(reply.ID), map[string]interface{}{
+		"status": models.ChatMessageStatusAlternative,
+	}); err != nil {
+		return nil, err
+	}
+	if err := models.DeleteChatMemoriesAfter(ctx, int64(chatCtx.ID), int64(message.ID)); err != nil {
+		log.Log().Error("delete chat memories failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	newReply, err := s.ReplyChatMessage(ctx, chatCtx, message)
+	if err != nil {
+		// 生成失败时恢复原来的回复
+		if err := models.UpdateChatMessage(ctx, int64(reply.ID), map[string]interface{}{
+			"status": models.ChatMessageStatusNormal,
+		}); err != nil {
+			log.Log().Error("restore chat reply failed", zap.Uint("message_id", reply.ID), zap.Error(err))
+		}
+		s.RememberChatTurn(ctx, chatCtx, message, reply)
+		return nil, err
+	}
+	return &ChatTurn{
+		Message: newChatMessageItem(message),
+		Reply:   newChatMessageItem(newReply),
+	}, nil
+}
+
+// ListChatReplyAlternatives 获取用户消息的所有回复，messageId 为用户消息或它的任意一条回复
+func (s *StoryService) ListChatReplyAlternatives(ctx context.Context, chatId, messageId int64) (*ChatReplyAlternatives, error) {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	message, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	replyToId := int64(message.ID)
+	if chatMessageRole(message) == "assistant" {
+		replyToId = message.ReplyToID
+	}
+	ret := &ChatReplyAlternatives{MessageId: replyToId, List: make([]*ChatMessageItem, 0)}
+	if replyToId == 0 {
+		return ret, nil
+	}
+	replies, err := models.GetChatReplies(ctx, int64(chatCtx.ID), replyToId)
+	if err != nil {
+		return nil, err
+	}
+	for _, reply := range replies {
+		ret.List = append(ret.List, newChatMessageItem(reply))
+	}
+	return ret, nil
+}
+
+// SelectChatReply 把会话最后一轮的回复切换为 messageId 对应的候选回复
+func (s *StoryService) SelectChatReply(ctx context.Context, chatId, messageId int64) (*ChatTurn, error) {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	reply, message, err := lastChatReply(ctx, chatCtx)
+	if err != nil {
+		return nil, err
+	}
+	if int64(reply.ID) == messageId {
+		return &ChatTurn{Message: newChatMessageItem(message), Reply: newChatMessageItem(reply)}, nil
+	}
+	selected, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	if selected.Status != models.ChatMessageStatusAlternative || selected.ReplyToID != int64(message.ID) {
+		return nil, errors.New("message is not an alternative of the last reply")
+	}
+	if err := models.SwitchChatReply(ctx, int64(reply.ID), int64(selected.ID)); err != nil {
+		return nil, err
+	}
+	selected.Status = models.ChatMessageStatusNormal
+	if err := models.DeleteChatMemoriesAfter(ctx, int64(chatCtx.ID), int64(message.ID)); err != nil {
+		log.Log().Error("delete chat memories failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	s.RememberChatTurn(ctx, chatCtx, message, selected)
+	return &ChatTurn{Message: newChatMessageItem(message), Reply: newChatMessageItem(selected)}, nil
+}
+
+// EditChatMessage 编辑用户消息，原消息及之后的消息被替换，用新的内容重新生成回复
+func (s *StoryService) EditChatMessage(ctx context.Context, chatId, messageId int64, content string) (*ChatTurn, error) {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("message is empty")
+	}
+	content = truncateRunes(content, chatEditMaxContent)
+	origin, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	if origin.Status != models.ChatMessageStatusNormal || chatMessageRole(origin) != "user" {
+		return nil, errors.New("only user messages in the chat can be edited")
+	}
+	if err := models.ReplaceChatMessagesFrom(ctx, int64(chatCtx.ID), int64(origin.ID)); err != nil {
+		return nil, err
+	}
+	// 被替换的对话不再召回，已合并到用户档案的内容保留
+	if err := models.DeleteChatMemoriesAfter(ctx, int64(chatCtx.ID), int64(origin.ID)-1); err != nil {
+		log.Log().Error("delete chat memories failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	message := &models.ChatMessage{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        origin.UserID,
+		RoleID:        origin.RoleID,
+		Sender:        origin.Sender,
+		MessageType:   origin.MessageType,
+		Content:       content,
+		AfterRender:   origin.AfterRender,
+		Status:        models.ChatMessageStatusNormal,
+		UUID:          uuid.New().String(),
+		SendTime:      time.Now().Unix(),
+		OriginID:      int64(origin.ID),
+	}
+	if err := models.CreateChatMessage(ctx, message); err != nil {
+		log.Log().Error("create edited chat message failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return nil, err
+	}
+	reply, err := s.ReplyChatMessage(ctx, chatCtx, message)
+	if err != nil {
+		// 编辑后的消息已保存，可以重新生成回复
+		return &ChatTurn{Message: newChatMessageItem(message)}, err
+	}
+	if err := models.UpdateChatContext(ctx, int64(chatCtx.ID), map[string]interface{}{"update_at": time.Now()}); err != nil {
+		log.Log().Error("update chat time failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	return &ChatTurn{Message: newChatMessageItem(message), Reply: newChatMessageItem(reply)}, nil
+}
+
+// ForkChatContext 从 messageId 处分叉出新的会话，复制这条消息及之前的消息、记忆和用户档案，
+// 群聊同时复制角色列表
+func (s *StoryService) ForkChatContext(ctx context.Context, chatId, messageId int64) (*ChatForkInfo, error) {
+	chatCtx, err := userChatContext(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	point, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	if point.Status != models.ChatMessageStatusNormal {
+		return nil, errors.New("message is not in the chat")
+	}
+	messages, err := models.GetChatMessagesAfter(ctx, int64(chatCtx.ID), 0, messageId, chatForkMaxMessages+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) > chatForkMaxMessages {
+		return nil, errors.New("too many messages to fork")
+	}
+	fork := &models.ChatContext{
+		UserID:        chatCtx.UserID,
+		RoleID:        chatCtx.RoleID,
+		Title:         truncateRunes(chatCtx.Title, 64) + chatForkTitleSuffix,
+		Content:       chatCtx.Content,
+		Status:        1,
+		UseAgent:      chatCtx.UseAgent,
+		AgentPrompt:   chatCtx.AgentPrompt,
+		ChatType:      chatCtx.ChatType,
+		StoryID:       chatCtx.StoryID,
+		TurnPolicy:    chatCtx.TurnPolicy,
+		ForkFromID:    int64(chatCtx.ID),
+		ForkMessageID: messageId,
+	}
+	if err := models.CreateChatContext(ctx, fork); err != nil {
+		log.Log().Error("create forked chat context failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return nil, err
+	}
+	if chatCtx.ChatType == models.ChatTypeGroup {
+		members, err := models.GetChatContextRoles(ctx, int64(chatCtx.ID))
+		if err != nil {
+			return nil, err
+		}
+		roleIds := make([]int64, 0, len(members))
+		for _, member := range members {
+			roleIds = append(roleIds, member.RoleID)
+		}
+		if err := models.SetChatContextRoles(ctx, int64(fork.ID), roleIds); err != nil {
+			return nil, err
+		}
+	}
+
+	copies := make([]*models.ChatMessage, 0, len(messages))
+	for _, message := range messages {
+		copied := *message
+		copied.IDBase = models.IDBase{}
+		copied.ChatContextID = int64(fork.ID)
+		copied.OriginID = 0
+		copies = append(copies, &copied)
+	}
+	if err := models.CreateChatMessages(ctx, copies); err != nil {
+		log.Log().Error("copy chat messages failed", zap.Uint("chat_id", fork.ID), zap.Error(err))
+		return nil, err
+	}
+	idMap := make(map[int64]int64, len(messages))
+	for i, message := range messages {
+		idMap[int64(message.ID)] = int64(copies[i].ID)
+	}
+	for _, copied := range copies {
+		if copied.ReplyToID == 0 {
+			continue
+		}
+		if err := models.UpdateChatMessage(ctx, int64(copied.ID), map[string]interface{}{
+			"reply_to_id": idMap[copied.ReplyToID],
+		}); err != nil {
+			return nil, err
+		}
+	}
+	s.forkChatMemory(ctx, chatCtx, fork, messageId, messages, idMap)
+	log.Log().Info("fork chat context success", zap.Uint("chat_id", chatCtx.ID),
+		zap.Uint("fork_id", fork.ID), zap.Int64("message_id", messageId), zap.Int("message_num", len(copies)))
+	return &ChatForkInfo{
+		ChatId:        int64(fork.ID),
+		Title:         fork.Title,
+		ChatType:      fork.ChatType,
+		ForkFromId:    fork.ForkFromID,
+		ForkMessageId: fork.ForkMessageID,
+		MessageNum:    len(copies),
+	}, nil
+}
+
+// forkChatMemory 复制分叉点之前的记忆和用户档案，消息ID换成新会话中的ID，
+// 用户档案总结到分叉点之后时不复制，由新会话重新总结
+func (s *StoryService) forkChatMemory(ctx context.Context, chatCtx, fork *models.ChatContext, messageId int64,
+	messages []*models.ChatMessage, idMap map[int64]int64) {
+	// forkedID 返回不大于 id 的最后一条被复制消息在新会话中的ID
+	forkedID := func(id int64) int64 {
+		var ret int64
+		for _, message := range messages {
+			if int64(message.ID) > id {
+				break
+			}
+			ret = idMap[int64(message.ID)]
+		}
+		return ret
+	}
+	memories, err := models.GetChatMemoriesUpTo(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		log.Log().Error("get chat memories failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	if len(memories) > chatForkMemoryMaxCopy {
+		memories = memories[len(memories)-chatForkMemoryMaxCopy:]
+	}
+	for _, memory := range memories {
+		copied := *memory
+		copied.IDBase = models.IDBase{}
+		copied.ChatContextID = int64(fork.ID)
+		copied.MessageID = forkedID(memory.MessageID)
+		if _, err := models.CreateChatMemory(ctx, &copied); err != nil {
+			log.Log().Error("copy chat memory failed", zap.Uint("chat_id", fork.ID), zap.Error(err))
+			return
+		}
+	}
+	profile, err := models.GetChatMemoryProfile(ctx, int64(chatCtx.ID))
+	if err != nil {
+		log.Log().Error("get chat memory profile failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return
+	}
+	if profile == nil || profile.LastMessageID > messageId {
+		return
+	}
+	if err := models.SaveChatMemoryProfile(ctx, &models.ChatMemoryProfile{
+		ChatContextID: int64(fork.ID),
+		UserID:        profile.UserID,
+		RoleID:        profile.RoleID,
+		Profile:       profile.Profile,
+		LastMessageID: forkedID(profile.LastMessageID),
+		SummaryNum:    profile.SummaryNum,
+	}); err != nil {
+		log.Log().Error("copy chat memory profile failed", zap.Uint("chat_id", fork.ID), zap.Error(err))
+	}
+}
@@ -0,0 +1,199 @@
+package story
+
+// 角色聊天中的图片：用户可以给角色发送图片，由支持图片理解的模型回复；
+// 角色在对话需要时给回复配一张插图，插图异步生成并通过 models.ImageGen 跟踪
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/pkg/storage"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	chatMaxImages           = 4
+	chatIllustrationMaxLen  = 200
+	chatIllustrationTimeout = 3 * time.Minute
+)
+
+const chatIllustrationInstruction = `当对话描述了一个适合画出来的画面（例如你所在的场景、你展示的东西）时，可以在回复末尾另起一行写【插图：画面描述】，会为你生成一张插图。不要频繁使用，普通的聊天不需要插图。`
+
+var chatIllustrationTag = regexp.MustCompile(`\n?\s*【插图[：:]\s*([^】]*)】\s*$`)
+
+// splitIllustration 从角色回复中取出插图描述，返回去掉标记后的回复
+func splitIllustration(content string) (string, string) {
+	match := chatIllustrationTag.FindStringSubmatchIndex(content)
+	if match == nil {
+		return content, ""
+	}
+	prompt := strings.TrimSpace(content[match[2]:match[3]])
+	return strings.TrimSpace(content[:match[0]]), truncateRunes(prompt, chatIllustrationMaxLen)
+}
+
+// chatMessageImages 用户图片消息或角色插图的图片地址
+func chatMessageImages(message *models.ChatMessage) []string {
+	if message.AfterRender == "" {
+		return nil
+	}
+	if message.MessageType != models.ChatMessageTypeImage && message.NeedRender != models.ChatRenderDone {
+		return nil
+	}
+	return strings.Split(message.AfterRender, ",")
+}
+
+// renderChatIllustration 异步生成角色回复的插图，平台只返回任务ID时由 syncworker 回写消息
+func (s *StoryService) renderChatIllustration(ctx context.Context, chatCtx *models.ChatContext, reply *models.ChatMessage) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), chatIllustrationTimeout)
+	go func() {
+		defer cancel()
+		if err := s.saveChatIllustration(ctx, chatCtx, reply); err != nil {
+			log.Log().Error("render chat illustration failed", zap.Uint("message_id", reply.ID), zap.Error(err))
+			if err := models.UpdateChatMessage(ctx, int64(reply.ID), map[string]interface{}{
+				"is_need_render": models.ChatRenderFailed,
+			}); err != nil {
+				log.Log().Error("update chat message render status failed", zap.Uint("message_id", reply.ID), zap.Error(err))
+			}
+		}
+	}()
+}
+
+func (s *StoryService) saveChatIllustration(ctx context.Context, chatCtx *models.ChatContext, reply *models.ChatMessage) error {
+	params := &client.ImageParams{
+		Prompt:    reply.Prompt,
+		Num:       1,
+		UserId:    fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID),
+		RequestId: reply.UUID,
+	}
+	if role, err := models.GetStoryRoleByID(ctx, reply.RoleID); err == nil && role != nil {
+		params.RefImage = role.CharacterAvatar
+		if role.CharacterPrompt != "" {
+			params.Prompt = role.CharacterName + "，" + role.CharacterPrompt + "。" + reply.Prompt
+		}
+	}
+	imageGen := &models.ImageGen{
+		RoleID:    reply.RoleID,
+		MessageID: int64(reply.ID),
+		Uuid:      uuid.New().String(),
+		Prompt:    params.Prompt,
+	}
+	ret, platform, err := s.generateImage(ctx, params)
+	if err != nil {
+		imageGen.Status = models.MediaGenStatusFailed
+		imageGen.Message = err.Error()
+		if _, err := models.CreateImageGen(ctx, imageGen); err != nil {
+			log.Log().Error("create image gen failed", zap.Error(err))
+		}
+		return err
+	}
+	imageGen.Platform = platform
+	imageGen.TaskID = ret.TaskId
+	if len(ret.ImageUrls) == 0 {
+		if ret.TaskId == "" {
+			return errors.New("image platform returned no result")
+		}
+		imageGen.Status = models.MediaGenStatusPending
+		_, err := models.CreateImageGen(ctx, imageGen)
+		return err
+	}
+	storeUrls := mirrorToStorage(ctx, storage.DirImages, ret.ImageUrls)
+	if len(storeUrls) == 0 {
+		return errors.New("upload illustration to storage failed")
+	}
+	imageGen.Status = models.MediaGenStatusSucceed
+	imageGen.ImageUrl = strings.Join(storeUrls, ",")
+	if _, err := models.CreateImageGen(ctx, imageGen); err != nil {
+		log.Log().Error("create image gen failed", zap.Error(err))
+	}
+	if !moderateImageGen(ctx, imageGen) {
+		// 没有通过审核的插图按生成失败处理
+		storeUrls = nil
+	}
+	return updateChatIllustration(ctx, int64(reply.ID), storeUrls)
+}
+
+// updateChatIllustration 回写插图结果，urls 为空时标记为生成失败
+func updateChatIllustration(ctx context.Context, messageId int64, urls []string) error {
+	if len(urls) == 0 {
+		return models.UpdateChatMessage(ctx, messageId, map[string]interface{}{
+			"is_need_render": models.ChatRenderFailed,
+		})
+	}
+	return models.UpdateChatMessage(ctx, messageId, map[string]interface{}{
+		"is_need_render": models.ChatRenderDone,
+		"after_render":   strings.Join(urls, ","),
+	})
+}
+
+// SendChatMessage 给会话的角色发送消息，可以带图片，返回用户消息和角色的回复；
+// 回复的插图在 RenderStatus 为生成中时通过 GetChatMessage 查询
+func (s *StoryService) SendChatMessage(ctx context.Context, chatId int64, content string, images []string, uuid string) (*ChatTurn, error) {
+	chatCtx, err := userSingleChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	content = strings.TrimSpace(content)
+	if content == "" && len(images) == 0 {
+		return nil, errors.New("message is empty")
+	}
+	if len(images) > chatMaxImages {
+		return nil, fmt.Errorf("at most %d images in a message", chatMaxImages)
+	}
+	for _, image := range images {
+		if !isImageUrl(image) || strings.Contains(image, ",") {
+			return nil, errors.New("invalid image url")
+		}
+	}
+	message := &models.ChatMessage{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        chatCtx.UserID,
+		RoleID:        chatCtx.RoleID,
+		Sender:        chatCtx.UserID,
+		Content:       truncateRunes(content, chatEditMaxContent),
+		Status:        models.ChatMessageStatusNormal,
+		UUID:          uuid,
+		SendTime:      time.Now().Unix(),
+	}
+	if len(images) > 0 {
+		message.MessageType = models.ChatMessageTypeImage
+		message.AfterRender = strings.Join(images, ",")
+	}
+	if err := models.CreateChatMessage(ctx, message); err != nil {
+		log.Log().Error("create chat message failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return nil, err
+	}
+	reply, err := s.ReplyChatMessage(ctx, chatCtx, message)
+	if err != nil {
+		return &ChatTurn{Message: newChatMessageItem(message)}, err
+	}
+	if err := models.UpdateChatContext(ctx, int64(chatCtx.ID), map[string]interface{}{"update_at": time.Now()}); err != nil {
+		log.Log().Error("update chat time failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	return &ChatTurn{Message: newChatMessageItem(message), Reply: newChatMessageItem(reply)}, nil
+}
+
+// GetChatMessage 获取会话中的一条消息，用于查询插图的生成结果
+func (s *StoryService) GetChatMessage(ctx context.Context, chatId, messageId int64) (*ChatMessageItem, error) {
+	chatCtx, err := userChatContext(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	message, err := models.GetChatMessageByID(ctx, int64(chatCtx.ID), messageId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	return newChatMessageItem(message), nil
+}
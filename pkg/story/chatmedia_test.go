@@ -0,0 +1,29 @@
+package story
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitIllustration(t *testing.T) {
+	long := strings.Repeat("山", chatIllustrationMaxLen+10)
+	tests := []struct {
+		name        string
+		content     string
+		wantContent string
+		wantPrompt  string
+	}{
+		{"no tag", "你好呀", "你好呀", ""},
+		{"tag at end", "看，这是我的花园。\n【插图：开满玫瑰的花园】", "看，这是我的花园。", "开满玫瑰的花园"},
+		{"ascii colon", "到了\n【插图: 雪山 】  ", "到了", "雪山"},
+		{"tag in middle", "【插图：森林】然后我们走了", "【插图：森林】然后我们走了", ""},
+		{"truncated", "好\n【插图：" + long + "】", "好", long[:len("山")*chatIllustrationMaxLen]},
+	}
+	for _, tt := range tests {
+		content, prompt := splitIllustration(tt.content)
+		assert.Equal(t, tt.wantContent, content, tt.name)
+		assert.Equal(t, tt.wantPrompt, prompt, tt.name)
+	}
+}
@@ -1,3 +1,419 @@
 package story
 
 // 用来渲染故事，渲染场景、渲染图片、混合角色
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// 业务场景，对应配置 llm.scenes 中的key
+const (
+	SceneStory      = "story"
+	SceneStoryboard = "storyboard"
+	SceneRole       = "role"
+	SceneChat       = "chat"
+	SceneImage      = "image"
+	SceneImageRef   = "image_ref"  // 带参考图的图片生成，用于保持角色形象一致
+	SceneImageEdit  = "image_edit" // 编辑已生成的图片：局部重绘、风格重绘、扩图
+	SceneVision     = "vision"     // 用户发送图片时的角色聊天
+	SceneEmbedding  = "embedding"  // 向量模型不同时结果不能比较，不做故障转移
+)
+
+const storyboardWriterSystemPrompt = `你是一名小说作者，根据故事背景、上一章节内容和参与人物，写出下一章节。
+只返回json，格式如下：
+{"章节情节简述":{"章节题目":"","章节内容":"","参与人物":[{"角色id":"","角色姓名":"","角色描述":""}]},
+"章节详细情节":[{"情节id":"","情节内容":"","参与人物":[{"角色id":"","角色姓名":"","角色描述":""}],"图片提示词":""}]}`
+
+const storyboardContinueSystemPrompt = `你是一名小说作者，根据故事背景、之前的章节和参与人物，续写下一章节。
+只返回json，格式如下：
+{"章节情节简述":{"章节题目":"","章节内容":""},"参与人物":[{"角色id":"","角色姓名":"","角色描述":""}]}`
+
+const storyboardInitSystemPrompt = `你是一名小说作者，根据故事背景、章节描述和参与人物，写出故事的第一个章节。
+只返回json，格式如下：
+{"章节情节简述":{"章节题目":"","章节内容":""},"参与人物":[{"角色id":"","角色姓名":"","角色描述":""}]}`
+
+const storyWriterSystemPrompt = `你是一名小说作者，根据故事标题和故事描述，写出故事的名称、主题、简介和章节大纲。
+只返回json，格式如下：
+{"故事名称和主题":{"故事名称":"","故事主题":"","故事简介":""},"故事章节":[{"章节ID":"","章节题目":"","章节内容":""}]}`
+
+const roleWriterSystemPrompt = `你是一名小说作者，根据故事背景、角色的名字和描述，丰富角色的设定。
+只返回json，格式如下：
+{"角色描述":"","角色短期目标":"","角色长期目标":"","性格特征":"","角色背景":"","处事风格":"","认知范围":"","能力特点":"","外貌特征":"","穿着喜好":""}`
+
+const roleContinueSystemPrompt = `你是一名小说作者，根据故事背景、角色已有的设定和角色经历过的情节，更新角色的设定。
+只返回json，格式如下：
+{"角色描述":"","角色短期目标":"","角色长期目标":"","性格特征":"","角色背景":"","处事风格":"","认知范围":"","能力特点":"","外貌特征":"","穿着喜好":""}`
+
+// InitProviders 根据配置注册额外的平台并绑定各业务场景使用的平台
+func InitProviders(ctx context.Context, cfg *config.LLMConfig) error {
+	registry := client.GetRegistry()
+	if cfg == nil {
+		return nil
+	}
+	if cfg.Azure != nil && cfg.Azure.Key != "" {
+		azureClient, err := client.NewAzureClient(cfg.Azure.Address, cfg.Azure.Key, cfg.Azure.Name)
+		if err != nil {
+			log.Log().Error("init azure provider failed", zap.Error(err))
+		} else {
+			registry.Register(azureClient)
+		}
+	}
+	if cfg.Google != nil && cfg.Google.Key != "" {
+		googleClient, err := client.NewGoogleClient(ctx, cfg.Google.Key)
+		if err != nil {
+			log.Log().Error("init google provider failed", zap.Error(err))
+		} else {
+			registry.Register(googleClient)
+		}
+	}
+	if cfg.Default != "" {
+		if err := registry.SetDefault(cfg.Default); err != nil {
+			return err
+		}
+	}
+	for scene, name := range cfg.Scenes {
+		if _, err := registry.Get(name); err != nil {
+			return fmt.Errorf("scene %s: %w", scene, err)
+		}
+		registry.Bind(scene, name)
+	}
+	if cfg.ParseRetries > 0 {
+		parseRetries = cfg.ParseRetries
+	}
+	for scene, names := range cfg.Failover {
+		registry.SetChain(scene, names...)
+	}
+	if cfg.Breaker != nil {
+		opts := client.DefaultBreakerOptions
+		if cfg.Breaker.Window > 0 {
+			opts.Window = time.Duration(cfg.Breaker.Window) * time.Second
+		}
+		if cfg.Breaker.MinRequests > 0 {
+			opts.MinRequests = cfg.Breaker.MinRequests
+		}
+		if cfg.Breaker.ErrorRate > 0 {
+			opts.ErrorRate = cfg.Breaker.ErrorRate
+		}
+		if cfg.Breaker.Cooldown > 0 {
+			opts.Cooldown = time.Duration(cfg.Breaker.Cooldown) * time.Second
+		}
+		registry.SetBreakerOptions(opts)
+		if cfg.Breaker.Timeout > 0 {
+			registry.SetAttemptTimeout(time.Duration(cfg.Breaker.Timeout) * time.Second)
+		}
+	}
+	return nil
+}
+
+// registerBuiltinProviders 注册内置的平台，coze 的默认绑定保持原有的故事板工作流
+func (s *StoryService) registerBuiltinProviders() {
+	registry := client.GetRegistry()
+	if s.cozeClient != nil {
+		registry.Register(s.cozeClient)
+		registry.Bind(SceneStory, client.PlatformNameCoze)
+		registry.Bind(SceneStoryboard, client.PlatformNameCoze)
+		registry.Bind(SceneRole, client.PlatformNameCoze)
+	}
+	if s.doubaoClient != nil {
+		registry.Register(s.doubaoClient)
+		registry.Bind(SceneImage, client.PlatformNameDoubao)
+	}
+	if s.bailianClient != nil {
+		registry.Register(s.bailianClient)
+		registry.Bind(SceneChat, client.PlatformNameAliyun)
+		registry.Bind(SceneImageRef, client.PlatformNameAliyun)
+		registry.Bind(SceneImageEdit, client.PlatformNameAliyun)
+	}
+	if s.zhipuClient != nil && s.zhipuClient.ZhipuClient != nil {
+		registry.Register(s.zhipuClient)
+		registry.Bind(SceneEmbedding, client.PlatformNameZhipu)
+		registry.Bind(SceneVision, client.PlatformNameZhipu)
+	}
+}
+
+// failover 在业务场景的故障转移链上执行fn，返回实际提供服务的平台名称
+func (s *StoryService) failover(ctx context.Context, scene string, fn func(ctx context.Context, p client.Provider) error) (string, error) {
+	p, err := client.GetRegistry().Chain(scene).Do(ctx, fn)
+	if err != nil {
+		log.Log().Error("llm failover chain failed", zap.String("scene", scene), zap.Error(err))
+		return "", err
+	}
+	return p.Name(), nil
+}
+
+// failStoryGen 生成失败时将记录标记为失败，避免留下生成中的记录
+func failStoryGen(ctx context.Context, gen *models.StoryGen, genErr error) {
+	gen.GenStatus = models.StoryGenStatusError
+	gen.FinishTime = time.Now().Unix()
+	gen.Content = genErr.Error()
+	if err := models.UpdateStoryGen(ctx, gen); err != nil {
+		log.Log().Error("mark story gen failed error", zap.Error(err))
+	}
+}
+
+// writeStoryboard 生成新章节，平台没有故事板工作流时使用提示词模板
+func (s *StoryService) writeStoryboard(ctx context.Context, p client.Provider, params *client.StoryboardParams) (string, error) {
+	stream := storyboardStreamFromContext(ctx)
+	if stream != nil {
+		if err := stream.start(p.Name()); err != nil {
+			return "", err
+		}
+	}
+	if writer, ok := p.(client.StoryboardWriter); ok {
+		var (
+			ret string
+			err error
+		)
+		if streamer, ok := p.(client.StoryboardStreamer); ok && stream != nil {
+			ret, err = streamer.StreamStoryboard(ctx, params, stream.write)
+		} else {
+			ret, err = writer.WriteStoryboard(ctx, params)
+		}
+		if err == nil {
+			addTokenUsage(ctx, workflowTokens(p, params, ret))
+		}
+		if err == nil && stream != nil {
+			err = stream.flush(ret)
+		}
+		return ret, err
+	}
+	prevContent := params.PrevContent
+	if prevContent == "" {
+		prevContent = "暂无上一章节"
+	}
+	return s.generateText(ctx, p, &client.TextParams{
+		System: storyboardWriterSystemPrompt,
+		Prompt: fmt.Sprintf("章节题目：%s\n故事背景：%s\n上一章节：%s\n参与人物：\n%s\n图片风格：%s\n%s",
+			params.Title, params.Background, prevContent, params.Characters, params.ImageStyle, params.Feedback),
+		JsonMode: true,
+	})
+}
+
+// continueStoryboard 续写章节，平台没有故事板工作流时使用提示词模板
+func (s *StoryService) continueStoryboard(ctx context.Context, p client.Provider, params *client.StoryboardParams) (string, error) {
+	stream := storyboardStreamFromContext(ctx)
+	if stream != nil {
+		if err := stream.start(p.Name()); err != nil {
+			return "", err
+		}
+	}
+	characters := params.Characters
+	for _, role := range params.Roles {
+		characters += fmt.Sprintf("角色id:%s,角色姓名:%s,角色描述:%s;\n", role.ID, role.Name, role.Description)
+	}
+	fitPrevContent(p, params, storyboardContinueSystemPrompt+characters)
+	if writer, ok := p.(client.StoryboardWriter); ok {
+		var (
+			ret string
+			err error
+		)
+		if streamer, ok := p.(client.StoryboardStreamer); ok && stream != nil {
+			ret, err = streamer.StreamContinueStoryboard(ctx, params, stream.write)
+		} else {
+			ret, err = writer.ContinueStoryboard(ctx, params)
+		}
+		if err == nil {
+			addTokenUsage(ctx, workflowTokens(p, params, ret))
+		}
+		if err == nil && stream != nil {
+			err = stream.flush(ret)
+		}
+		return ret, err
+	}
+	return s.generateText(ctx, p, &client.TextParams{
+		System: storyboardContinueSystemPrompt,
+		Prompt: fmt.Sprintf("故事名称：%s\n章节题目：%s\n章节描述：%s\n故事背景：%s\n之前的章节：%s\n参与人物：\n%s\n%s",
+			params.StoryName, params.Title, params.Description, params.Background, params.PrevContent, characters, params.Feedback),
+		JsonMode: true,
+	})
+}
+
+// initStoryboard 写故事的第一个章节，平台没有故事板工作流时使用提示词模板
+func (s *StoryService) initStoryboard(ctx context.Context, p client.Provider, params *client.StoryboardParams) (string, error) {
+	if writer, ok := p.(client.StoryboardWriter); ok {
+		ret, err := writer.InitStoryboard(ctx, params)
+		if err == nil {
+			addTokenUsage(ctx, workflowTokens(p, params, ret))
+		}
+		return ret, err
+	}
+	characters := params.Characters
+	for _, role := range params.Roles {
+		characters += fmt.Sprintf("角色id:%s,角色姓名:%s,角色描述:%s;\n", role.ID, role.Name, role.Description)
+	}
+	return s.generateText(ctx, p, &client.TextParams{
+		System: storyboardInitSystemPrompt,
+		Prompt: fmt.Sprintf("故事名称：%s\n章节描述：%s\n故事背景：%s\n参与人物：\n%s\n%s",
+			params.Title, params.Description, params.Background, characters, params.Feedback),
+		JsonMode: true,
+	})
+}
+
+// writeStory 写故事大纲，平台没有故事工作流时使用提示词模板
+func (s *StoryService) writeStory(ctx context.Context, p client.Provider, params *client.StoryParams) (string, error) {
+	if writer, ok := p.(client.StoryWriter); ok {
+		return writer.WriteStory(ctx, params)
+	}
+	return s.generateText(ctx, p, &client.TextParams{
+		System:   storyWriterSystemPrompt,
+		Prompt:   fmt.Sprintf("故事标题：%s\n故事描述：%s\n%s", params.Title, params.Description, params.Feedback),
+		JsonMode: true,
+	})
+}
+
+// writeRole 生成角色详情，平台没有角色工作流时使用提示词模板
+func (s *StoryService) writeRole(ctx context.Context, p client.Provider, params *client.RoleParams) (string, error) {
+	if writer, ok := p.(client.StoryWriter); ok {
+		return writer.WriteRole(ctx, params)
+	}
+	return s.generateText(ctx, p, &client.TextParams{
+		System: roleWriterSystemPrompt,
+		Prompt: fmt.Sprintf("故事名称：%s\n故事背景：%s\n角色名字：%s\n角色描述：%s\n其他角色：\n%s\n%s",
+			params.StoryName, params.StoryDesc, params.Name, params.Description, params.OtherRoles, params.Feedback),
+		JsonMode: true,
+	})
+}
+
+// continueRole 根据角色经历过的情节更新角色详情，平台没有角色工作流时使用提示词模板
+func (s *StoryService) continueRole(ctx context.Context, p client.Provider, params *client.RoleParams) (string, error) {
+	if writer, ok := p.(client.StoryWriter); ok {
+		return writer.ContinueRole(ctx, params)
+	}
+	return s.generateText(ctx, p, &client.TextParams{
+		System: roleContinueSystemPrompt,
+		Prompt: fmt.Sprintf("故事名称：%s\n故事背景：%s\n角色名字：%s\n角色描述：%s\n其他角色：\n%s\n经历过的情节：\n%s\n%s",
+			params.StoryName, params.StoryDesc, params.Name, params.Description, params.OtherRoles, params.History, params.Feedback),
+		JsonMode: true,
+	})
+}
+
+// rolePoster 生成角色海报，平台没有海报工作流时以角色头像为参考图生成
+func (s *StoryService) rolePoster(ctx context.Context, p client.Provider, params *client.RolePosterParams) (string, error) {
+	if maker, ok := p.(client.RolePosterMaker); ok {
+		return maker.RolePoster(ctx, params)
+	}
+	imageParams := &client.ImageParams{
+		Prompt: fmt.Sprintf("%s风格的角色海报。故事：%s，%s。角色：%s，%s",
+			params.Style, params.StoryTitle, truncateRunes(params.StoryDesc, 200), params.Name, truncateRunes(params.Description, 300)),
+		RefImage: params.Image,
+		Num:      1,
+	}
+	var (
+		ret *client.ImageResult
+		err error
+	)
+	if r, ok := p.(client.ReferenceImager); ok && params.Image != "" {
+		ret, err = r.GenerateImageWithRef(ctx, imageParams)
+	} else {
+		imageParams.RefImage = ""
+		ret, err = p.GenerateImage(ctx, imageParams)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(ret.ImageUrls) == 0 {
+		return "", fmt.Errorf("%s returned no poster image", p.Name())
+	}
+	return ret.ImageUrls[0], nil
+}
+
+// generateText 生成文本并记录用量，流式渲染时优先使用平台的流式输出
+func (s *StoryService) generateText(ctx context.Context, p client.Provider, params *client.TextParams) (string, error) {
+	stream := storyboardStreamFromContext(ctx)
+	var (
+		ret *client.TextResult
+		err error
+	)
+	if streamer, ok := p.(client.TextStreamer); ok && stream != nil {
+		ret, err = streamer.StreamText(ctx, params, stream.write)
+	} else {
+		ret, err = p.GenerateText(ctx, params)
+	}
+	if err != nil {
+		return "", err
+	}
+	tokenNum := ret.TokenNum
+	if tokenNum <= 0 {
+		model := client.TextModel(p.Name())
+		tokenNum = client.CountTokens(model, params.System) + client.CountTokens(model, params.Prompt) +
+			client.CountTokens(model, ret.Content)
+	}
+	addTokenUsage(ctx, tokenNum)
+	if stream != nil {
+		if err := stream.flush(ret.Content); err != nil {
+			return "", err
+		}
+	}
+	return ret.Content, nil
+}
+
+// workflowTokens 工作流不返回用量，按输入和输出估算
+func workflowTokens(p client.Provider, params *client.StoryboardParams, content string) int {
+	model := client.TextModel(p.Name())
+	tokenNum := client.CountTokens(model, content)
+	for _, text := range []string{params.Title, params.Description, params.Background, params.Characters, params.PrevContent, params.Feedback} {
+		tokenNum += client.CountTokens(model, text)
+	}
+	for _, role := range params.Roles {
+		tokenNum += client.CountTokens(model, role.Name) + client.CountTokens(model, role.Description)
+	}
+	return tokenNum
+}
+
+// generateImage 使用图片场景的故障转移链生成图片，返回实际生成的平台；
+// 带参考图时先在参考图场景的平台上生成
+func (s *StoryService) generateImage(ctx context.Context, params *client.ImageParams) (*client.ImageResult, string, error) {
+	var ret *client.ImageResult
+	if params.RefImage != "" {
+		platform, err := s.failover(ctx, SceneImageRef, func(ctx context.Context, p client.Provider) error {
+			r, ok := p.(client.ReferenceImager)
+			if !ok {
+				return fmt.Errorf("%w: %s reference image", client.ErrNotSupported, p.Name())
+			}
+			var err error
+			ret, err = r.GenerateImageWithRef(ctx, params)
+			return err
+		})
+		if err == nil || (params.RefMode != "" && params.RefMode != client.RefModeReference) {
+			return ret, platform, err
+		}
+		// 参考图只是为了保持一致，失败时仍然按提示词生成
+		log.Log().Warn("generate image with reference failed", zap.String("ref_image", params.RefImage), zap.Error(err))
+	}
+	platform, err := s.failover(ctx, SceneImage, func(ctx context.Context, p client.Provider) error {
+		var err error
+		ret, err = p.GenerateImage(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return ret, platform, nil
+}
+
+// editImage 使用图片编辑场景的故障转移链编辑图片，返回实际编辑的平台
+func (s *StoryService) editImage(ctx context.Context, params *client.ImageEditParams) (*client.ImageResult, string, error) {
+	var ret *client.ImageResult
+	platform, err := s.failover(ctx, SceneImageEdit, func(ctx context.Context, p client.Provider) error {
+		e, ok := p.(client.ImageEditor)
+		if !ok {
+			return fmt.Errorf("%w: %s image edit", client.ErrNotSupported, p.Name())
+		}
+		var err error
+		ret, err = e.EditImage(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return ret, platform, nil
+}
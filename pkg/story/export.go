@@ -0,0 +1,150 @@
+package story
+
+// 导出故事的一个分支为 EPUB、Markdown 或可打印的 HTML
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/storage"
+	"github.com/grapery/grapery/utils/export"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	// exportImageMaxSize 导出时单张图片的最大尺寸
+	exportImageMaxSize = 20 << 20
+	// 一次导出最多包含的图片数和图片总大小
+	exportMaxImages = 300
+	exportMaxBytes  = 300 << 20
+)
+
+// exportFetcher 从对象存储读取封面和场景图片，不在存储中的图片不导出
+func exportFetcher(ctx context.Context, url string) ([]byte, string, error) {
+	data, err := storage.ReadURL(ctx, url, exportImageMaxSize)
+	return data, "", err
+}
+
+// ExportStory 导出从根故事板到 boardId 的分支，boardId 为0时导出主线（每一层最早创建的故事板）
+func (s *StoryService) ExportStory(ctx context.Context, storyId, boardId int64, format export.Format) (*export.File, error) {
+	story, err := readableStory(ctx, storyId)
+	if err != nil {
+		return nil, err
+	}
+	var boards []*models.StoryBoard
+	if boardId != 0 {
+		boards, err = storyBranch(ctx, story, boardId)
+	} else {
+		boards, err = storyMainline(ctx, story)
+	}
+	if err != nil {
+		log.Log().Error("get story branch failed", zap.Int64("story_id", storyId), zap.Int64("board_id", boardId), zap.Error(err))
+		return nil, err
+	}
+	book := &export.Book{
+		Id:          fmt.Sprintf("grapery-story-%d-%d", story.ID, boardId),
+		Title:       story.Title,
+		Description: story.ShortDesc,
+		Cover:       story.Avatar,
+		Chapters:    make([]*export.Chapter, 0, len(boards)),
+	}
+	if creator, err := models.GetUserById(ctx, story.CreatorID); err == nil && creator != nil {
+		book.Author = creator.Name
+	}
+	for _, board := range boards {
+		chapter, err := exportChapter(ctx, board)
+		if err != nil {
+			log.Log().Error("get storyboard scenes failed", zap.Int64("board_id", int64(board.ID)), zap.Error(err))
+			return nil, err
+		}
+		book.Chapters = append(book.Chapters, chapter)
+	}
+	return export.Export(ctx, book, format, export.LimitFetcher(exportFetcher, exportMaxImages, exportMaxBytes))
+}
+
+// storyBranch 从 boardId 沿 PrevId 回溯到根故事板，按阅读顺序返回
+func storyBranch(ctx context.Context, story *models.Story, boardId int64) ([]*models.StoryBoard, error) {
+	boards := make([]*models.StoryBoard, 0)
+	visited := make(map[int64]bool)
+	for id := boardId; id > 0 && !visited[id]; {
+		visited[id] = true
+		board, err := models.GetStoryboard(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if board == nil || board.StoryID != int64(story.ID) {
+			if id == boardId {
+				return nil, errors.New("storyboard not found in story")
+			}
+			break
+		}
+		boards = append(boards, board)
+		id = board.PrevId
+	}
+	for i, j := 0, len(boards)-1; i < j; i, j = i+1, j-1 {
+		boards[i], boards[j] = boards[j], boards[i]
+	}
+	return boards, nil
+}
+
+// storyMainline 从根故事板开始，每一层选择最早创建的故事板
+func storyMainline(ctx context.Context, story *models.Story) ([]*models.StoryBoard, error) {
+	if story.RootBoardID == 0 {
+		return nil, errors.New("story has no storyboard")
+	}
+	root, err := models.GetStoryboard(ctx, int64(story.RootBoardID))
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("root storyboard not found")
+	}
+	boards := []*models.StoryBoard{root}
+	visited := map[int64]bool{int64(root.ID): true}
+	for current := root; ; {
+		children, err := models.GetStoryboardsByPrevId(ctx, int64(current.ID))
+		if err != nil {
+			return nil, err
+		}
+		var next *models.StoryBoard
+		for _, child := range children {
+			if child.StoryID == int64(story.ID) && !visited[int64(child.ID)] && (next == nil || child.ID < next.ID) {
+				next = child
+			}
+		}
+		if next == nil {
+			return boards, nil
+		}
+		visited[int64(next.ID)] = true
+		boards = append(boards, next)
+		current = next
+	}
+}
+
+// exportChapter 故事板的场景按创建顺序作为章节的段落，生成结果中的图片随段落导出
+func exportChapter(ctx context.Context, board *models.StoryBoard) (*export.Chapter, error) {
+	scenes, err := sortedScenes(ctx, int64(board.ID))
+	if err != nil {
+		return nil, err
+	}
+	chapter := &export.Chapter{
+		Title:    board.Title,
+		Summary:  board.Description,
+		Sections: make([]*export.Section, 0, len(scenes)),
+	}
+	for _, scene := range scenes {
+		section := &export.Section{Text: scene.Content}
+		if scene.GenResult != "" {
+			if err := json.Unmarshal([]byte(scene.GenResult), &section.Images); err != nil {
+				log.Log().Warn("unmarshal scene gen result failed", zap.Int64("scene_id", int64(scene.ID)), zap.Error(err))
+			}
+		}
+		chapter.Sections = append(chapter.Sections, section)
+	}
+	return chapter, nil
+}
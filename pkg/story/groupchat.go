@@ -0,0 +1,647 @@
+package story
+
+// 多角色群聊：用户和同一故事中的多个角色对话，每轮由发言策略决定哪些角色回复，
+// 每个角色使用自己的角色设定和共同的聊天记录生成回复
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/llmjson"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// 群聊的发言策略，用户消息中 @角色名 时总是由被提到的角色回复
+const (
+	TurnPolicyRoundRobin = "round_robin" // 角色按顺序轮流回复，每轮一个
+	TurnPolicyAll        = "all"         // 每轮所有角色依次回复
+	TurnPolicyDirector   = "director"    // 由大模型根据对话选择回复的角色
+)
+
+const (
+	groupChatMinRoles    = 2
+	groupChatMaxRoles    = 8
+	groupChatMaxReplies  = 3 // director 策略每轮最多回复的角色数
+	groupChatMaxPageSize = 100
+	groupChatMaxContent  = 2000
+)
+
+const groupChatDirectorSystemPrompt = `你是群聊的导演，根据故事背景和对话记录，决定接下来由哪些角色回复用户。
+选择最自然会接话的角色，不必所有人都说话。
+只返回json数组，元素为角色姓名，按发言顺序，最多%d个，例如：["角色姓名"]`
+
+var groupChatDirectorSchema = llmjson.Array(llmjson.String(), 1)
+
+// GroupChatRole 群聊中的角色
+type GroupChatRole struct {
+	RoleId int64  `json:"role_id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+}
+
+// GroupChatInfo 群聊会话，Roles 按轮流发言的顺序
+type GroupChatInfo struct {
+	ChatId     int64            `json:"chat_id"`
+	UserId     int64            `json:"user_id"`
+	StoryId    int64            `json:"story_id"`
+	Title      string           `json:"title"`
+	TurnPolicy string           `json:"turn_policy"`
+	Roles      []*GroupChatRole `json:"roles"`
+	Ctime      int64            `json:"ctime"`
+	Mtime      int64            `json:"mtime"`
+}
+
+// GroupChatList 分页的群聊列表
+type GroupChatList struct {
+	Total int64            `json:"total"`
+	List  []*GroupChatInfo `json:"list"`
+}
+
+// GroupChatMessage 群聊消息，RoleId 为0时是用户发送的消息
+type GroupChatMessage struct {
+	MessageId int64  `json:"message_id"`
+	ChatId    int64  `json:"chat_id"`
+	UserId    int64  `json:"user_id"`
+	RoleId    int64  `json:"role_id"`
+	RoleName  string `json:"role_name,omitempty"`
+	Content   string `json:"content"`
+	Uuid      string `json:"uuid"`
+	Ctime     int64  `json:"ctime"`
+}
+
+// GroupChatReply 一轮群聊的结果
+type GroupChatReply struct {
+	Message *GroupChatMessage   `json:"message"`
+	Replies []*GroupChatMessage `json:"replies"`
+}
+
+// CreateGroupChatParams 创建群聊的参数，RoleIds 的顺序即轮流发言的顺序
+type CreateGroupChatParams struct {
+	StoryId    int64
+	RoleIds    []int64
+	Title      string
+	TurnPolicy string
+}
+
+func validTurnPolicy(policy string) bool {
+	switch policy {
+	case TurnPolicyRoundRobin, TurnPolicyAll, TurnPolicyDirector:
+		return true
+	}
+	return false
+}
+
+// loadGroupRoles 校验并按 roleIds 的顺序返回角色，角色必须属于同一个故事
+func loadGroupRoles(ctx context.Context, storyId int64, roleIds []int64) ([]*models.StoryRole, error) {
+	seen := make(map[int64]bool, len(roleIds))
+	ids := make([]int64, 0, len(roleIds))
+	for _, id := range roleIds {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < groupChatMinRoles || len(ids) > groupChatMaxRoles {
+		return nil, fmt.Errorf("group chat needs %d to %d roles", groupChatMinRoles, groupChatMaxRoles)
+	}
+	list, err := models.GetStoryRolesByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[int64]*models.StoryRole, len(list))
+	for _, role := range list {
+		byId[int64(role.ID)] = role
+	}
+	roles := make([]*models.StoryRole, 0, len(ids))
+	for _, id := range ids {
+		role, ok := byId[id]
+		if !ok || role.Status != 1 {
+			return nil, fmt.Errorf("role %d not found", id)
+		}
+		if role.StoryID != storyId {
+			return nil, fmt.Errorf("role %d does not belong to story %d", id, storyId)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// groupChatRoles 返回群聊当前的角色，按发言顺序
+func groupChatRoles(ctx context.Context, chatCtx *models.ChatContext) ([]*models.StoryRole, error) {
+	members, err := models.GetChatContextRoles(ctx, int64(chatCtx.ID))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.RoleID)
+	}
+	list, err := models.GetStoryRolesByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[int64]*models.StoryRole, len(list))
+	for _, role := range list {
+		byId[int64(role.ID)] = role
+	}
+	roles := make([]*models.StoryRole, 0, len(ids))
+	for _, id := range ids {
+		if role, ok := byId[id]; ok && role.Status == 1 {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// userGroupChat 获取当前用户的群聊
+func userGroupChat(ctx context.Context, chatId int64) (*models.ChatContext, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chatCtx, err := models.GetChatContextByID(ctx, chatId)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("group chat not found")
+		}
+		return nil, err
+	}
+	if chatCtx.ChatType != models.ChatTypeGroup {
+		return nil, errors.New("group chat not found")
+	}
+	if chatCtx.UserID != userId {
+		return nil, errors.New("have no permission")
+	}
+	return chatCtx, nil
+}
+
+func newGroupChatInfo(chatCtx *models.ChatContext, roles []*models.StoryRole) *GroupChatInfo {
+	info := &GroupChatInfo{
+		ChatId:     int64(chatCtx.ID),
+		UserId:     chatCtx.UserID,
+		StoryId:    chatCtx.StoryID,
+		Title:      chatCtx.Title,
+		TurnPolicy: chatCtx.TurnPolicy,
+		Roles:      make([]*GroupChatRole, 0, len(roles)),
+		Ctime:      chatCtx.CreateAt.Unix(),
+		Mtime:      chatCtx.UpdateAt.Unix(),
+	}
+	for _, role := range roles {
+		info.Roles = append(info.Roles, &GroupChatRole{
+			RoleId: int64(role.ID),
+			Name:   role.CharacterName,
+			Avatar: role.CharacterAvatar,
+		})
+	}
+	return info
+}
+
+func newGroupChatMessage(message *models.ChatMessage, names map[int64]string) *GroupChatMessage {
+	ret := &GroupChatMessage{
+		MessageId: int64(message.ID),
+		ChatId:    message.ChatContextID,
+		UserId:    message.UserID,
+		Content:   message.Content,
+		Uuid:      message.UUID,
+		Ctime:     message.CreateAt.Unix(),
+	}
+	if chatMessageRole(message) == "assistant" {
+		ret.RoleId = message.RoleID
+		ret.RoleName = names[message.RoleID]
+	}
+	return ret
+}
+
+func roleNames(roles []*models.StoryRole) map[int64]string {
+	names := make(map[int64]string, len(roles))
+	for _, role := range roles {
+		names[int64(role.ID)] = role.CharacterName
+	}
+	return names
+}
+
+// CreateGroupChat 创建群聊，当前用户需要能查看角色所属的故事
+func (s *StoryService) CreateGroupChat(ctx context.Context, params *CreateGroupChatParams) (*GroupChatInfo, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	story, err := readableStory(ctx, params.StoryId)
+	if err != nil {
+		return nil, err
+	}
+	policy := params.TurnPolicy
+	if policy == "" {
+		policy = TurnPolicyRoundRobin
+	}
+	if !validTurnPolicy(policy) {
+		return nil, fmt.Errorf("invalid turn policy %s", policy)
+	}
+	roles, err := loadGroupRoles(ctx, params.StoryId, params.RoleIds)
+	if err != nil {
+		return nil, err
+	}
+	title := strings.TrimSpace(params.Title)
+	if title == "" {
+		title = story.Title + "群聊"
+	}
+	chatCtx := &models.ChatContext{
+		UserID:     userId,
+		Title:      title,
+		Status:     1,
+		ChatType:   models.ChatTypeGroup,
+		StoryID:    params.StoryId,
+		TurnPolicy: policy,
+	}
+	if err := models.CreateChatContext(ctx, chatCtx); err != nil {
+		log.Log().Error("create group chat failed", zap.Error(err))
+		return nil, err
+	}
+	roleIds := make([]int64, 0, len(roles))
+	for _, role := range roles {
+		roleIds = append(roleIds, int64(role.ID))
+	}
+	if err := models.SetChatContextRoles(ctx, int64(chatCtx.ID), roleIds); err != nil {
+		log.Log().Error("set group chat roles failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return nil, err
+	}
+	return newGroupChatInfo(chatCtx, roles), nil
+}
+
+// GetGroupChats 当前用户的群聊列表
+func (s *StoryService) GetGroupChats(ctx context.Context, offset, pageSize int) (*GroupChatList, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 || pageSize > groupChatMaxPageSize {
+		pageSize = groupChatMaxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	chatCtxs, total, err := models.GetGroupChatContexts(ctx, userId, offset, pageSize)
+	if err != nil {
+		log.Log().Error("get group chats failed", zap.Error(err))
+		return nil, err
+	}
+	list := &GroupChatList{Total: total, List: make([]*GroupChatInfo, 0, len(chatCtxs))}
+	for _, chatCtx := range chatCtxs {
+		roles, err := groupChatRoles(ctx, chatCtx)
+		if err != nil {
+			return nil, err
+		}
+		list.List = append(list.List, newGroupChatInfo(chatCtx, roles))
+	}
+	return list, nil
+}
+
+// UpdateGroupChat 修改群聊的角色和发言策略，roleIds 为空时不修改角色，turnPolicy 为空时不修改策略
+func (s *StoryService) UpdateGroupChat(ctx context.Context, chatId int64, roleIds []int64, turnPolicy string) (*GroupChatInfo, error) {
+	chatCtx, err := userGroupChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if turnPolicy != "" && turnPolicy != chatCtx.TurnPolicy {
+		if !validTurnPolicy(turnPolicy) {
+			return nil, fmt.Errorf("invalid turn policy %s", turnPolicy)
+		}
+		if err := models.UpdateChatContext(ctx, chatId, map[string]interface{}{"turn_policy": turnPolicy}); err != nil {
+			return nil, err
+		}
+		chatCtx.TurnPolicy = turnPolicy
+	}
+	var roles []*models.StoryRole
+	if len(roleIds) > 0 {
+		if roles, err = loadGroupRoles(ctx, chatCtx.StoryID, roleIds); err != nil {
+			return nil, err
+		}
+		ids := make([]int64, 0, len(roles))
+		for _, role := range roles {
+			ids = append(ids, int64(role.ID))
+		}
+		if err := models.SetChatContextRoles(ctx, chatId, ids); err != nil {
+			log.Log().Error("set group chat roles failed", zap.Int64("chat_id", chatId), zap.Error(err))
+			return nil, err
+		}
+	} else if roles, err = groupChatRoles(ctx, chatCtx); err != nil {
+		return nil, err
+	}
+	return newGroupChatInfo(chatCtx, roles), nil
+}
+
+// GetGroupChatMessages 分页获取群聊消息，beforeId 为0时从最新的消息开始，按时间从新到旧
+func (s *StoryService) GetGroupChatMessages(ctx context.Context, chatId, beforeId int64, pageSize int) ([]*GroupChatMessage, error) {
+	chatCtx, err := userGroupChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 || pageSize > groupChatMaxPageSize {
+		pageSize = groupChatMaxPageSize
+	}
+	messages, err := models.GetChatMessagesBefore(ctx, int64(chatCtx.ID), beforeId, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	// 已移出群聊的角色也需要显示名字
+	ids := make([]int64, 0)
+	for _, message := range messages {
+		if chatMessageRole(message) == "assistant" {
+			ids = append(ids, message.RoleID)
+		}
+	}
+	roles, err := models.GetStoryRolesByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	names := roleNames(roles)
+	ret := make([]*GroupChatMessage, 0, len(messages))
+	for _, message := range messages {
+		ret = append(ret, newGroupChatMessage(message, names))
+	}
+	return ret, nil
+}
+
+// SendGroupChatMessage 发送一条消息，并依次生成被选中角色的回复
+func (s *StoryService) SendGroupChatMessage(ctx context.Context, chatId int64, content, uuid string) (*GroupChatReply, error) {
+	chatCtx, err := userGroupChat(ctx, chatId)
+	if err != nil {
+		return nil, err
+	}
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("message is empty")
+	}
+	content = truncateRunes(content, groupChatMaxContent)
+	roles, err := groupChatRoles(ctx, chatCtx)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("group chat has no roles")
+	}
+	story, err := models.GetStory(ctx, chatCtx.StoryID)
+	if err != nil {
+		return nil, err
+	}
+	if story == nil {
+		return nil, errors.New("story not found")
+	}
+	userMessage := &models.ChatMessage{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        chatCtx.UserID,
+		Sender:        chatCtx.UserID,
+		Content:       content,
+		Status:        1,
+		UUID:          uuid,
+		SendTime:      time.Now().Unix(),
+	}
+	if err := models.CreateChatMessage(ctx, userMessage); err != nil {
+		log.Log().Error("create group chat message failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return nil, err
+	}
+	if !moderateChatMessage(ctx, chatCtx, userMessage) {
+		return nil, errChatMessageHeld
+	}
+	opts := memoryConfig()
+	transcript, err := models.GetRecentChatMessages(ctx, int64(chatCtx.ID), opts.recentMessages)
+	if err != nil {
+		return nil, err
+	}
+	recall := &ChatMemoryRecall{}
+	s.recallLongTermMemory(ctx, opts, chatCtx, content, int64(transcript[0].ID), recall)
+
+	names := roleNames(roles)
+	reply := &GroupChatReply{
+		Message: newGroupChatMessage(userMessage, names),
+		Replies: make([]*GroupChatMessage, 0),
+	}
+	for _, role := range s.chooseSpeakers(ctx, chatCtx, story, roles, transcript, content) {
+		roleMessage, err := s.groupRoleReply(ctx, chatCtx, story, role, roles, transcript, recall)
+		if err != nil {
+			// 已生成的回复仍然返回
+			log.Log().Error("group chat role reply failed", zap.Uint("chat_id", chatCtx.ID),
+				zap.Uint("role_id", role.ID), zap.Error(err))
+			if len(reply.Replies) == 0 {
+				return nil, err
+			}
+			break
+		}
+		roleMessage.UUID = uuid
+		roleMessage.ReplyToID = int64(userMessage.ID)
+		if err := models.CreateChatMessage(ctx, roleMessage); err != nil {
+			log.Log().Error("create group chat message failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+			return nil, err
+		}
+		recordChatUsage(ctx, chatCtx, roleMessage)
+		if !moderateChatMessage(ctx, chatCtx, roleMessage) {
+			roleMessage.Content = chatReplyHeldContent
+			reply.Replies = append(reply.Replies, newGroupChatMessage(roleMessage, names))
+			continue
+		}
+		s.RememberChatTurn(ctx, chatCtx, userMessage, roleMessage)
+		transcript = append(transcript, roleMessage)
+		reply.Replies = append(reply.Replies, newGroupChatMessage(roleMessage, names))
+	}
+	if err := models.UpdateChatContext(ctx, int64(chatCtx.ID), map[string]interface{}{"update_at": time.Now()}); err != nil {
+		log.Log().Error("update group chat time failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	return reply, nil
+}
+
+// chooseSpeakers 按发言策略选择本轮回复的角色
+func (s *StoryService) chooseSpeakers(ctx context.Context, chatCtx *models.ChatContext, story *models.Story,
+	roles []*models.StoryRole, transcript []*models.ChatMessage, content string) []*models.StoryRole {
+	if mentioned := mentionedRoles(content, roles); len(mentioned) > 0 {
+		return mentioned
+	}
+	switch chatCtx.TurnPolicy {
+	case TurnPolicyAll:
+		return roles
+	case TurnPolicyDirector:
+		speakers, err := s.directSpeakers(ctx, chatCtx, story, roles, transcript)
+		if err == nil && len(speakers) > 0 {
+			return speakers
+		}
+		log.Log().Warn("group chat director failed, fallback to round robin",
+			zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+	return []*models.StoryRole{nextRole(roles, transcript)}
+}
+
+// mentionedRoles 消息中 @角色名 提到的角色，按提到的先后顺序
+func mentionedRoles(content string, roles []*models.StoryRole) []*models.StoryRole {
+	type mention struct {
+		pos  int
+		role *models.StoryRole
+	}
+	mentions := make([]mention, 0)
+	for _, role := range roles {
+		if role.CharacterName == "" {
+			continue
+		}
+		if pos := strings.Index(content, "@"+role.CharacterName); pos >= 0 {
+			mentions = append(mentions, mention{pos: pos, role: role})
+		}
+	}
+	sort.SliceStable(mentions, func(i, j int) bool {
+		return mentions[i].pos < mentions[j].pos
+	})
+	ret := make([]*models.StoryRole, 0, len(mentions))
+	for _, m := range mentions {
+		ret = append(ret, m.role)
+	}
+	return ret
+}
+
+// nextRole 最后发言角色的下一个角色，还没有角色发言时为第一个角色
+func nextRole(roles []*models.StoryRole, transcript []*models.ChatMessage) *models.StoryRole {
+	for i := len(transcript) - 1; i >= 0; i-- {
+		if chatMessageRole(transcript[i]) != "assistant" {
+			continue
+		}
+		for j, role := range roles {
+			if int64(role.ID) == transcript[i].RoleID {
+				return roles[(j+1)%len(roles)]
+			}
+		}
+		break
+	}
+	return roles[0]
+}
+
+// directSpeakers 由大模型选择回复的角色
+func (s *StoryService) directSpeakers(ctx context.Context, chatCtx *models.ChatContext, story *models.Story,
+	roles []*models.StoryRole, transcript []*models.ChatMessage) ([]*models.StoryRole, error) {
+	names := roleNames(roles)
+	var sb strings.Builder
+	sb.WriteString("故事背景：" + story.ShortDesc + "\n角色：\n")
+	for _, role := range roles {
+		sb.WriteString(fmt.Sprintf("- %s：%s\n", role.CharacterName, truncateRunes(role.CharacterDescription, 200)))
+	}
+	sb.WriteString("对话记录：\n")
+	for _, message := range transcript {
+		speaker := "用户"
+		if chatMessageRole(message) == "assistant" {
+			speaker = names[message.RoleID]
+		}
+		sb.WriteString(speaker + "：" + message.Content + "\n")
+	}
+	system := fmt.Sprintf(groupChatDirectorSystemPrompt, groupChatMaxReplies)
+	chosen := make([]string, 0)
+	_, err := s.failover(ctx, SceneChat, func(ctx context.Context, p client.Provider) error {
+		_, err := llmjson.ParseWithRetry(ctx, groupChatDirectorSchema, &chosen, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			ret, err := p.GenerateText(ctx, &client.TextParams{
+				System:   system,
+				Prompt:   sb.String() + feedback,
+				JsonMode: true,
+				UserId:   fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID),
+			})
+			if err != nil {
+				return "", err
+			}
+			return ret.Content, nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*models.StoryRole, len(roles))
+	for _, role := range roles {
+		byName[role.CharacterName] = role
+	}
+	speakers := make([]*models.StoryRole, 0, groupChatMaxReplies)
+	seen := make(map[uint]bool)
+	for _, name := range chosen {
+		role, ok := byName[strings.TrimSpace(name)]
+		if !ok || seen[role.ID] {
+			continue
+		}
+		seen[role.ID] = true
+		speakers = append(speakers, role)
+		if len(speakers) == groupChatMaxReplies {
+			break
+		}
+	}
+	return speakers, nil
+}
+
+// groupRoleReply 以 role 的身份生成回复消息，其他人的发言作为带名字的用户消息
+func (s *StoryService) groupRoleReply(ctx context.Context, chatCtx *models.ChatContext, story *models.Story, role *models.StoryRole,
+	roles []*models.StoryRole, transcript []*models.ChatMessage, recall *ChatMemoryRecall) (*models.ChatMessage, error) {
+	names := roleNames(roles)
+	others := make([]string, 0, len(roles)-1)
+	for _, other := range roles {
+		if other.ID != role.ID {
+			others = append(others, other.CharacterName)
+		}
+	}
+	persona := role.CharacterDescription
+	if role.CharacterDetail != "" && role.CharacterDetail != "{}" {
+		detail := &CharacterDetailConverter{}
+		if err := json.Unmarshal([]byte(role.CharacterDetail), detail); err == nil {
+			persona = detail.ToPrompt()
+		}
+	}
+
+	// 合并相邻的非本角色消息，保持用户和角色交替
+	messages := make([]client.Message, 0, len(transcript))
+	for _, message := range transcript {
+		if chatMessageRole(message) == "assistant" && message.RoleID == int64(role.ID) {
+			messages = append(messages, client.Message{Role: "assistant", Content: message.Content})
+			continue
+		}
+		speaker := "用户"
+		if chatMessageRole(message) == "assistant" {
+			speaker = names[message.RoleID]
+		}
+		line := speaker + "：" + message.Content
+		if n := len(messages); n > 0 && messages[n-1].Role == "user" {
+			messages[n-1].Content += "\n" + line
+			continue
+		}
+		messages = append(messages, client.Message{Role: "user", Content: line})
+	}
+	chatResp, promptTokens, err := s.chatRole(ctx, &rolePrompt{
+		Persona: persona,
+		Instruction: fmt.Sprintf("你是%s，正在和用户以及%s群聊。只以%s的身份回复，不要替其他人说话，回复前不要加名字。",
+			role.CharacterName, strings.Join(others, "、"), role.CharacterName),
+		Background: story.ShortDesc,
+		Recall:     recall,
+		History:    messages,
+	}, fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID), "")
+	if err != nil {
+		return nil, err
+	}
+	// 模型偶尔仍会在开头加上自己的名字
+	text := strings.TrimSpace(chatResp.Content)
+	for _, prefix := range []string{role.CharacterName + "：", role.CharacterName + ":"} {
+		text = strings.TrimSpace(strings.TrimPrefix(text, prefix))
+	}
+	if text == "" {
+		return nil, errors.New("empty reply")
+	}
+	return &models.ChatMessage{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        chatCtx.UserID,
+		RoleID:        int64(role.ID),
+		Sender:        int64(role.ID),
+		Content:       text,
+		Status:        1,
+		SendTime:      time.Now().Unix(),
+		PromptTokens:  promptTokens,
+		TokenNum:      chatResp.TokenNum,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package story
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/models"
+)
+
+func testRoles(names ...string) []*models.StoryRole {
+	roles := make([]*models.StoryRole, 0, len(names))
+	for i, name := range names {
+		role := &models.StoryRole{CharacterName: name}
+		role.ID = uint(i + 1)
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func roleReply(roleId int64) *models.ChatMessage {
+	return &models.ChatMessage{RoleID: roleId, Sender: roleId, Content: "reply"}
+}
+
+func userMessage(roleId int64) *models.ChatMessage {
+	return &models.ChatMessage{RoleID: roleId, Sender: 100, Content: "hi"}
+}
+
+func TestMentionedRoles(t *testing.T) {
+	roles := testRoles("小明", "小红", "", "老王")
+	names := func(roles []*models.StoryRole) []string {
+		ret := make([]string, 0, len(roles))
+		for _, role := range roles {
+			ret = append(ret, role.CharacterName)
+		}
+		return ret
+	}
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"大家好", []string{}},
+		{"@小红 你好", []string{"小红"}},
+		{"@老王 和 @小明 过来", []string{"老王", "小明"}},
+		{"@小红 @小红", []string{"小红"}},
+		{"小明你好", []string{}},
+		{"@ 空名字", []string{}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, names(mentionedRoles(tt.content, roles)), tt.content)
+	}
+}
+
+func TestNextRole(t *testing.T) {
+	roles := testRoles("a", "b", "c")
+	tests := []struct {
+		name       string
+		transcript []*models.ChatMessage
+		want       uint
+	}{
+		{"empty", nil, 1},
+		{"only user", []*models.ChatMessage{userMessage(2)}, 1},
+		{"after first", []*models.ChatMessage{userMessage(1), roleReply(1)}, 2},
+		{"wrap around", []*models.ChatMessage{roleReply(2), roleReply(3), userMessage(3)}, 1},
+		{"unknown role", []*models.ChatMessage{roleReply(2), roleReply(9)}, 1},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, nextRole(roles, tt.transcript).ID, tt.name)
+	}
+}
+
+func TestChooseSpeakers(t *testing.T) {
+	s := &StoryService{}
+	roles := testRoles("小明", "小红", "老王")
+	transcript := []*models.ChatMessage{roleReply(1)}
+	tests := []struct {
+		name    string
+		policy  string
+		content string
+		want    []uint
+	}{
+		{"mention wins", TurnPolicyAll, "@老王 说说", []uint{3}},
+		{"all", TurnPolicyAll, "大家好", []uint{1, 2, 3}},
+		{"round robin", "", "大家好", []uint{2}},
+	}
+	for _, tt := range tests {
+		chatCtx := &models.ChatContext{TurnPolicy: tt.policy}
+		speakers := s.chooseSpeakers(context.Background(), chatCtx, &models.Story{}, roles, transcript, tt.content)
+		ids := make([]uint, 0, len(speakers))
+		for _, role := range speakers {
+			ids = append(ids, role.ID)
+		}
+		assert.Equal(t, tt.want, ids, tt.name)
+	}
+}
@@ -0,0 +1,321 @@
+package story
+
+// 从 Markdown 或纯文本稿件导入故事，章节对应故事板，场景对应故事板场景
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	api "github.com/grapery/common-protoc/gen"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/active"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/compliance"
+	"github.com/grapery/grapery/utils/llmjson"
+	"github.com/grapery/grapery/utils/log"
+	"github.com/grapery/grapery/utils/manuscript"
+)
+
+const (
+	importMaxContentSize = 2 << 20 // 稿件最大字节数
+	importMaxChapters    = 300
+	importShortDescSize  = 200  // 简短描述的最大字数
+	importOriginSize     = 2000 // 故事背景的最大字数
+	importRolesInputSize = 6000
+	// 审核时每次提交的最大字数，稿件按场景拼接后分段审核
+	importModerationChunkSize = 2000
+)
+
+const importRolesSystemPrompt = `你是一名编辑，从小说稿件中找出主要人物。
+只返回json数组，格式如下：
+[{"角色姓名":"","角色描述":""}]`
+
+// importRolesSchema 抽取人物的结果
+var importRolesSchema = llmjson.Array(characterSchema, 0)
+
+// ImportStoryParams 导入稿件的参数
+type ImportStoryParams struct {
+	GroupId      int64
+	Title        string // 为空时使用稿件中的书名
+	Format       manuscript.Format
+	Content      string
+	SceneSize    int  // 没有场景分隔时每个场景的字数
+	ExtractRoles bool // 是否使用大模型抽取人物
+}
+
+// ImportStoryResult 导入结果
+type ImportStoryResult struct {
+	StoryId    int64   `json:"story_id"`
+	BoardIds   []int64 `json:"board_ids"`
+	SceneCount int     `json:"scene_count"`
+	RoleIds    []int64 `json:"role_ids"`
+}
+
+// ImportStory 拆分稿件并创建故事、故事板链和场景，当前用户为创建者
+func (s *StoryService) ImportStory(ctx context.Context, params *ImportStoryParams) (*ImportStoryResult, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(params.Content) > importMaxContentSize {
+		return nil, fmt.Errorf("manuscript is too large, max %d bytes", importMaxContentSize)
+	}
+	ms := manuscript.Parse(params.Content, params.Format, params.SceneSize)
+	if len(ms.Chapters) == 0 {
+		return nil, errors.New("manuscript is empty")
+	}
+	if len(ms.Chapters) > importMaxChapters {
+		return nil, fmt.Errorf("too many chapters, max %d", importMaxChapters)
+	}
+	title := strings.TrimSpace(params.Title)
+	if title == "" {
+		title = ms.Title
+	}
+	if title == "" {
+		title = ms.Chapters[0].Title
+	}
+	shortDesc := ms.Intro
+	if shortDesc == "" {
+		shortDesc = ms.Chapters[0].Scenes[0]
+	}
+	shortDesc = truncateRunes(shortDesc, importShortDescSize)
+	// 章节的描述取自第一个场景，所以审核全部场景即可覆盖
+	texts := []string{title, shortDesc, ms.Intro}
+	for _, chapter := range ms.Chapters {
+		texts = append(texts, chapter.Title)
+		texts = append(texts, chapter.Scenes...)
+	}
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityStory,
+		Field:      "story",
+		GroupId:    params.GroupId,
+		AuthorId:   userId,
+	}
+	review, reviewContent, err := checkImportText(ctx, target, texts)
+	if err != nil {
+		return nil, err
+	}
+	group := &models.Group{}
+	group.ID = uint(params.GroupId)
+	if err := group.GetByID(); err != nil {
+		log.Log().Error("get group by id failed", zap.Error(err))
+		return nil, err
+	}
+
+	newStory := &models.Story{
+		Title:       title,
+		ShortDesc:   shortDesc,
+		Origin:      truncateRunes(ms.Intro, importOriginSize),
+		Status:      models.StoryStatusDraft,
+		GroupID:     params.GroupId,
+		CreatorID:   userId,
+		OwnerID:     userId,
+		Params:      "{}",
+		FollowCount: 1,
+		LikeCount:   1,
+	}
+	boards := make([]*models.StoryBoard, 0, len(ms.Chapters))
+	scenes := make([][]*models.StoryBoardScene, 0, len(ms.Chapters))
+	for i, chapter := range ms.Chapters {
+		boards = append(boards, &models.StoryBoard{
+			Title:       chapter.Title,
+			Description: truncateRunes(chapter.Scenes[0], importShortDescSize),
+			CreatorID:   userId,
+			Status:      1,
+			ForkAble:    true,
+			Level:       i + 1,
+			Params:      "{}",
+		})
+		boardScenes := make([]*models.StoryBoardScene, 0, len(chapter.Scenes))
+		for _, content := range chapter.Scenes {
+			boardScenes = append(boardScenes, &models.StoryBoardScene{
+				Content:   content,
+				CreatorId: userId,
+			})
+		}
+		scenes = append(scenes, boardScenes)
+	}
+	// 故事、故事板和场景一起创建，失败时不留下不完整的故事
+	if err := models.CreateStoryWithBoards(ctx, newStory, boards, scenes); err != nil {
+		log.Log().Error("create imported story failed", zap.Error(err))
+		return nil, err
+	}
+	storyId := newStory.ID
+	result := &ImportStoryResult{StoryId: int64(storyId)}
+	for i, board := range boards {
+		result.BoardIds = append(result.BoardIds, int64(board.ID))
+		result.SceneCount += len(scenes[i])
+	}
+
+	if err := models.IncGroupProfileStoryCount(ctx, int64(group.ID)); err != nil {
+		log.Log().Error("inc group profile story count failed", zap.Error(err))
+	}
+	userProfile := &models.UserProfile{UserId: userId}
+	if err := userProfile.IncrementCreatedStoryNum(); err != nil {
+		log.Log().Error("increment created story num failed", zap.Error(err))
+	}
+	if err := models.CreateWatchStoryItem(ctx, int(userId), int64(storyId), int64(group.ID)); err != nil {
+		log.Log().Error("watch story failed", zap.Error(err))
+	}
+
+	if params.ExtractRoles {
+		// 人物抽取失败不影响导入结果
+		roleIds, err := s.importRoles(ctx, newStory, ms)
+		if err != nil {
+			log.Log().Error("extract imported roles failed", zap.Int64("story_id", int64(storyId)), zap.Error(err))
+		}
+		result.RoleIds = roleIds
+	}
+	// 查询和更新会跳过隐藏的对象，所以在最后写入审核队列
+	target.EntityId = int64(storyId)
+	target.StoryId = int64(storyId)
+	if review.Verdict != compliance.VerdictPass {
+		_ = moderation.Hold(ctx, target, review, reviewContent)
+	} else {
+		active.GetActiveServer().WriteStoryActive(ctx, group, newStory, nil, nil, userId, api.ActiveType_NewStory)
+	}
+	search.Sync(search.KindStory, int64(storyId))
+	for _, boardId := range result.BoardIds {
+		search.Sync(search.KindStoryBoard, boardId)
+	}
+	for _, roleId := range result.RoleIds {
+		search.Sync(search.KindRole, roleId)
+	}
+	log.Log().Info("import story success", zap.Int64("story_id", int64(storyId)),
+		zap.Int("boards", len(result.BoardIds)), zap.Int("scenes", result.SceneCount))
+	return result, nil
+}
+
+// checkImportText 分段审核稿件，任意一段被拒绝时拒绝导入；
+// 有需要人工审核的段落时返回该结果和段落内容，整个故事在审核通过前隐藏
+func checkImportText(ctx context.Context, target *moderation.Target, texts []string) (*compliance.Result, string, error) {
+	var (
+		review        = &compliance.Result{Verdict: compliance.VerdictPass}
+		reviewContent string
+	)
+	for _, chunk := range importModerationChunks(texts, importModerationChunkSize) {
+		result, err := moderation.CheckText(ctx, target, chunk)
+		if err != nil {
+			return nil, "", err
+		}
+		if review.Verdict == compliance.VerdictPass && result.Verdict != compliance.VerdictPass {
+			review, reviewContent = result, chunk
+		}
+	}
+	return review, reviewContent, nil
+}
+
+// importModerationChunks 按顺序把文本拼接成不超过 size 字的段落，超长的文本单独拆分
+func importModerationChunks(texts []string, size int) []string {
+	chunks := make([]string, 0)
+	var current strings.Builder
+	currentSize := 0
+	flush := func() {
+		if currentSize > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentSize = 0
+		}
+	}
+	for _, text := range texts {
+		runes := []rune(strings.TrimSpace(text))
+		for len(runes) > 0 {
+			partSize := min(len(runes), size)
+			part := string(runes[:partSize])
+			runes = runes[partSize:]
+			if currentSize > 0 && currentSize+1+partSize > size {
+				flush()
+			}
+			if currentSize > 0 {
+				current.WriteString("\n")
+				currentSize++
+			}
+			current.WriteString(part)
+			currentSize += partSize
+		}
+	}
+	flush()
+	return chunks
+}
+
+// importRoles 使用稿件开头的内容抽取人物，已存在的同名角色跳过
+func (s *StoryService) importRoles(ctx context.Context, story *models.Story, ms *manuscript.Manuscript) ([]int64, error) {
+	var input strings.Builder
+	input.WriteString(ms.Intro)
+	for _, chapter := range ms.Chapters {
+		if utf8.RuneCountInString(input.String()) >= importRolesInputSize {
+			break
+		}
+		input.WriteString("\n" + chapter.Title + "\n" + chapter.Content())
+	}
+	characters := make([]Character, 0)
+	_, err := s.failover(ctx, SceneRole, func(ctx context.Context, p client.Provider) error {
+		_, err := llmjson.ParseWithRetry(ctx, importRolesSchema, &characters, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			ret, err := p.GenerateText(ctx, &client.TextParams{
+				System:   importRolesSystemPrompt,
+				Prompt:   fmt.Sprintf("故事名称：%s\n稿件：\n%s\n%s", story.Title, truncateRunes(input.String(), importRolesInputSize), feedback),
+				JsonMode: true,
+			})
+			if err != nil {
+				return "", err
+			}
+			return ret.Content, nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	roleIds := make([]int64, 0, len(characters))
+	for _, character := range characters {
+		name := strings.TrimSpace(character.Name)
+		if name == "" {
+			continue
+		}
+		exist, err := models.GetStoryRoleByName(ctx, name, int64(story.ID))
+		if err != nil {
+			return roleIds, err
+		}
+		if exist != nil {
+			continue
+		}
+		role := &models.StoryRole{
+			StoryID:              int64(story.ID),
+			CharacterName:        name,
+			CharacterDescription: character.Description,
+			CreatorID:            story.CreatorID,
+			Status:               1,
+			FollowCount:          1,
+			LikeCount:            1,
+			CharacterDetail:      "{}",
+		}
+		roleId, err := models.CreateStoryRole(ctx, role)
+		if err != nil {
+			return roleIds, err
+		}
+		roleIds = append(roleIds, roleId)
+	}
+	if len(roleIds) > 0 {
+		if err := models.UpdateStorySpecColumns(ctx, int64(story.ID), map[string]interface{}{
+			"total_roles": len(roleIds),
+		}); err != nil {
+			log.Log().Error("update story total roles failed", zap.Error(err))
+		}
+	}
+	return roleIds, nil
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,19 @@
+package story
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportModerationChunks(t *testing.T) {
+	assert.Empty(t, importModerationChunks(nil, 5))
+	assert.Equal(t, []string{"标题\n简介"}, importModerationChunks([]string{"标题", " ", "简介"}, 5))
+	assert.Equal(t, []string{"标题\n简介", "第一章"}, importModerationChunks([]string{"标题", "简介", "第一章"}, 5))
+	// 超长的场景拆分后，剩余部分与后面的文本拼接
+	assert.Equal(t, []string{"一二三四五", "六七\n八"}, importModerationChunks([]string{"一二三四五六七", "八"}, 5))
+	for _, chunk := range importModerationChunks([]string{strings.Repeat("云", 12), "雨"}, 5) {
+		assert.LessOrEqual(t, len([]rune(chunk)), 5)
+	}
+}
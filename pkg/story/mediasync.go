@@ -0,0 +1,308 @@
+package story
+
+// 同步平台异步生成的图片和视频结果，由 syncworker 定时执行
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/pkg/storage"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultMediaSyncInterval = 5 * time.Second
+	// 超过该时间仍未完成的任务视为失败
+	mediaTaskExpire = 2 * time.Hour
+	// 同步生成图片时等待平台异步任务的时间
+	imageTaskWaitTimeout  = 2 * time.Minute
+	imageTaskWaitInterval = 3 * time.Second
+)
+
+// MediaSyncWorker 轮询未完成的 ImageGen/VideoGen，查询平台任务状态并转存到对象存储
+type MediaSyncWorker struct {
+	svc      *StoryService
+	interval time.Duration
+}
+
+func NewMediaSyncWorker(interval time.Duration) *MediaSyncWorker {
+	if interval <= 0 {
+		interval = defaultMediaSyncInterval
+	}
+	return &MediaSyncWorker{
+		svc:      storyServer.(*StoryService),
+		interval: interval,
+	}
+}
+
+// Run 阻塞执行直到ctx结束
+func (w *MediaSyncWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.syncImages(ctx)
+		w.syncVideos(ctx)
+	}
+}
+
+func (w *MediaSyncWorker) syncImages(ctx context.Context) {
+	list, err := models.GetImageGenListByStatus(ctx, models.MediaGenStatusPending)
+	if err != nil {
+		log.Log().Error("get pending image gen failed", zap.Error(err))
+		return
+	}
+	for _, imageGen := range list {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := w.syncImage(ctx, imageGen); err != nil {
+			log.Log().Error("sync image gen failed", zap.String("task_id", imageGen.TaskID), zap.Error(err))
+		}
+	}
+}
+
+// imageTaskStatus 查询平台图片任务的状态和结果
+func (s *StoryService) imageTaskStatus(ctx context.Context, platform, taskId string) (status string, urls []string, message string, err error) {
+	switch platform {
+	case client.PlatformNameAliyun:
+		ret, err := s.bailianClient.GetImageGenerationTaskStatus(ctx, taskId)
+		if err != nil {
+			return "", nil, "", err
+		}
+		for _, result := range ret.Output.Results {
+			if result.URL != "" {
+				urls = append(urls, result.URL)
+			}
+		}
+		urls = append(urls, ret.Output.ResultsUrls...)
+		return ret.Output.TaskStatus, urls, ret.String(), nil
+	}
+	log.Log().Warn("image task platform not supported", zap.String("platform", platform))
+	return client.TaskStatusUnknown, nil, "", nil
+}
+
+// waitImageTask 同步生成图片的流程中等待平台的异步任务完成
+func (s *StoryService) waitImageTask(ctx context.Context, platform, taskId string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, imageTaskWaitTimeout)
+	defer cancel()
+	ticker := time.NewTicker(imageTaskWaitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait image task %s: %w", taskId, ctx.Err())
+		case <-ticker.C:
+		}
+		status, urls, message, err := s.imageTaskStatus(ctx, platform, taskId)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case client.TaskStatusSucceeded:
+			return urls, nil
+		case client.TaskStatusFailed, client.TaskStatusCanceled, client.TaskStatusUnknown:
+			return nil, fmt.Errorf("image task %s %s: %s", taskId, status, message)
+		}
+	}
+}
+
+func (w *MediaSyncWorker) syncImage(ctx context.Context, imageGen *models.ImageGen) error {
+	status, urls, message, err := w.svc.imageTaskStatus(ctx, imageGen.Platform, imageGen.TaskID)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case client.TaskStatusSucceeded:
+		storeUrls := mirrorToStorage(ctx, storage.DirImages, urls)
+		if len(storeUrls) == 0 {
+			return w.failImage(ctx, imageGen, "upload result to storage failed")
+		}
+		imageGen.Status = models.MediaGenStatusSucceed
+		imageGen.ImageUrl = strings.Join(storeUrls, ",")
+		if err := models.UpdateImageGen(ctx, imageGen); err != nil {
+			return err
+		}
+		if !moderateImageGen(ctx, imageGen) {
+			storeUrls = nil
+		}
+		if imageGen.MessageID != 0 {
+			return updateChatIllustration(ctx, imageGen.MessageID, storeUrls)
+		}
+		return w.refreshSceneCandidates(ctx, imageGen)
+	case client.TaskStatusFailed, client.TaskStatusCanceled:
+		return w.failImage(ctx, imageGen, message)
+	}
+	if time.Since(imageGen.CreateAt) > mediaTaskExpire {
+		return w.failImage(ctx, imageGen, "task expired, last status: "+status)
+	}
+	return nil
+}
+
+func (w *MediaSyncWorker) failImage(ctx context.Context, imageGen *models.ImageGen, message string) error {
+	imageGen.Status = models.MediaGenStatusFailed
+	imageGen.Message = message
+	if err := models.UpdateImageGen(ctx, imageGen); err != nil {
+		return err
+	}
+	if imageGen.MessageID != 0 {
+		return updateChatIllustration(ctx, imageGen.MessageID, nil)
+	}
+	return w.refreshSceneCandidates(ctx, imageGen)
+}
+
+func (w *MediaSyncWorker) syncVideos(ctx context.Context) {
+	list, err := models.GetVideoGenListByStatus(ctx, models.MediaGenStatusPending)
+	if err != nil {
+		log.Log().Error("get pending video gen failed", zap.Error(err))
+		return
+	}
+	for _, videoGen := range list {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := w.syncVideo(ctx, videoGen); err != nil {
+			log.Log().Error("sync video gen failed", zap.String("task_id", videoGen.TaskID), zap.Error(err))
+		}
+	}
+}
+
+// videoTaskStatus 查询平台视频任务的状态和结果，状态统一为 client.TaskStatus*
+func (s *StoryService) videoTaskStatus(ctx context.Context, platform, taskId string) (status string, url string, message string, err error) {
+	switch platform {
+	case client.PlatformNameAliyun:
+		ret, err := s.bailianClient.GetVideoGenerationTaskStatus(ctx, taskId)
+		if err != nil {
+			return "", "", "", err
+		}
+		return ret.Output.TaskStatus, ret.Output.Video_url, ret.String(), nil
+	case client.PlatformNameDoubao:
+		ret, err := s.doubaoClient.QueryStoryboardVideoTaskStatus(ctx, taskId)
+		if err != nil {
+			return "", "", "", err
+		}
+		switch ret.Status {
+		case "succeeded":
+			status = client.TaskStatusSucceeded
+		case "failed":
+			status = client.TaskStatusFailed
+		case "cancelled":
+			status = client.TaskStatusCanceled
+		default:
+			status = client.TaskStatusRunning
+		}
+		return status, ret.VideoUrl, ret.Status, nil
+	}
+	log.Log().Warn("video task platform not supported", zap.String("platform", platform))
+	return client.TaskStatusUnknown, "", "", nil
+}
+
+func (w *MediaSyncWorker) syncVideo(ctx context.Context, videoGen *models.VideoGen) error {
+	status, url, message, err := w.svc.videoTaskStatus(ctx, videoGen.Platform, videoGen.TaskID)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case client.TaskStatusSucceeded:
+		storeUrls := mirrorToStorage(ctx, storage.DirVideos, []string{url})
+		if len(storeUrls) == 0 {
+			return w.failVideo(ctx, videoGen, "upload result to storage failed")
+		}
+		videoGen.Status = models.MediaGenStatusSucceed
+		videoGen.VideoUrl = storeUrls[0]
+		if err := models.UpdateVideoGen(ctx, videoGen); err != nil {
+			return err
+		}
+		return w.refreshSceneVideo(ctx, videoGen)
+	case client.TaskStatusFailed, client.TaskStatusCanceled:
+		return w.failVideo(ctx, videoGen, message)
+	}
+	if time.Since(videoGen.CreateAt) > mediaTaskExpire {
+		return w.failVideo(ctx, videoGen, "task expired, last status: "+status)
+	}
+	return nil
+}
+
+func (w *MediaSyncWorker) failVideo(ctx context.Context, videoGen *models.VideoGen, message string) error {
+	videoGen.Status = models.MediaGenStatusFailed
+	videoGen.Message = message
+	return models.UpdateVideoGen(ctx, videoGen)
+}
+
+// refreshSceneVideo 视频完成后写入场景，场景之后又提交了新的视频任务时跳过，以最新的任务为准
+func (w *MediaSyncWorker) refreshSceneVideo(ctx context.Context, videoGen *models.VideoGen) error {
+	if videoGen.SceneID == 0 {
+		return nil
+	}
+	latest, err := models.GetLatestSceneVideoGen(ctx, videoGen.SceneID)
+	if err != nil {
+		return err
+	}
+	if latest == nil || latest.ID != videoGen.ID {
+		return nil
+	}
+	return models.UpdateStoryBoardSceneMultiColumn(ctx, videoGen.SceneID, map[string]interface{}{
+		"video_url": videoGen.VideoUrl,
+	})
+}
+
+// refreshSceneCandidates 候选图片完成后按整批候选更新场景，场景已被重新渲染（TaskId变化）时跳过；
+// 没有批次的旧记录以平台任务ID作为批次
+func (w *MediaSyncWorker) refreshSceneCandidates(ctx context.Context, imageGen *models.ImageGen) error {
+	if imageGen.SceneID == 0 {
+		return nil
+	}
+	batch := imageGen.Batch
+	if batch == "" {
+		batch = imageGen.TaskID
+	}
+	scene, err := models.GetStoryBoardScene(ctx, imageGen.SceneID)
+	if err != nil {
+		return err
+	}
+	if scene == nil || scene.TaskId != batch {
+		return nil
+	}
+	candidates := []*models.ImageGen{imageGen}
+	if imageGen.Batch != "" {
+		candidates, err = models.GetImageGensByBatch(ctx, imageGen.SceneID, batch)
+		if err != nil {
+			return err
+		}
+	}
+	before := sceneRevision(scene)
+	applySceneCandidates(scene, candidates)
+	return updateSceneWithRevision(ctx, before, scene, generatedRevision(ctx, batch))
+}
+
+// mirrorToStorage 将平台返回的临时地址转存到对象存储的 dir 目录
+func mirrorToStorage(ctx context.Context, dir string, urls []string) []string {
+	store, err := storage.Default()
+	if err != nil {
+		log.Log().Error("get storage failed", zap.Error(err))
+		return nil
+	}
+	storeUrls := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+		storeUrl, _, err := storage.PutFromURL(ctx, store, dir, url)
+		if err != nil {
+			log.Log().Error("upload file from url failed", zap.Error(err))
+			continue
+		}
+		storeUrls = append(storeUrls, storeUrl)
+	}
+	return storeUrls
+}
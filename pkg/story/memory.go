@@ -0,0 +1,324 @@
+package story
+
+// 角色聊天的长期记忆：每轮对话向量化后按会话保存，回复前召回相关片段，
+// 超出最近消息窗口的旧对话定期总结为用户档案
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/log"
+	"github.com/grapery/grapery/utils/vector"
+)
+
+const (
+	defaultMemoryTopK           = 5
+	defaultMemoryMinScore       = 0.5
+	defaultMemoryRecentMessages = 10
+	defaultMemorySummaryEvery   = 40
+	memoryCandidateLimit        = 2000 // 每次检索最多比较的记忆数
+	memoryTurnMaxRunes          = 1000
+	memoryProfileMaxRunes       = 1500
+	memoryTaskTimeout           = 2 * time.Minute
+)
+
+const memorySummarySystemPrompt = `你是一名记录员，负责维护角色对用户的了解。
+根据已有的用户档案和新的对话记录，输出更新后的用户档案，包括用户的姓名或称呼、身份、喜好、
+提到过的重要经历和承诺、与角色之间发生过的事情。保留已有档案中仍然成立的内容，不要编造。
+只输出档案正文，不超过500字。`
+
+// summarizing 正在总结的会话，避免同一会话并发总结
+var summarizing sync.Map
+
+type memoryOptions struct {
+	enable         bool
+	model          string
+	topK           int
+	minScore       float64
+	recentMessages int
+	summaryEvery   int
+}
+
+func memoryConfig() memoryOptions {
+	opts := memoryOptions{
+		enable:         true,
+		topK:           defaultMemoryTopK,
+		minScore:       defaultMemoryMinScore,
+		recentMessages: defaultMemoryRecentMessages,
+		summaryEvery:   defaultMemorySummaryEvery,
+	}
+	if config.GlobalConfig == nil || config.GlobalConfig.Memory == nil {
+		return opts
+	}
+	cfg := config.GlobalConfig.Memory
+	opts.enable = !cfg.Disable
+	opts.model = cfg.EmbeddingModel
+	if cfg.TopK > 0 {
+		opts.topK = cfg.TopK
+	}
+	if cfg.MinScore > 0 {
+		opts.minScore = cfg.MinScore
+	}
+	if cfg.RecentMessages > 0 {
+		opts.recentMessages = cfg.RecentMessages
+	}
+	if cfg.SummaryEvery > 0 {
+		opts.summaryEvery = cfg.SummaryEvery
+	}
+	return opts
+}
+
+// ChatMemoryRecall 一轮对话召回的上下文
+type ChatMemoryRecall struct {
+	Profile  string           // 角色对用户的了解
+	Memories []string         // 与当前消息相关的旧对话
+	Messages []client.Message // 最近的消息，按时间从旧到新，不包含当前消息
+}
+
+// chatMessageRole 角色发送的消息 Sender 为角色ID
+func chatMessageRole(message *models.ChatMessage) string {
+	if message.RoleID != 0 && message.Sender == message.RoleID {
+		return "assistant"
+	}
+	return "user"
+}
+
+// isChatHistoryMessage 会出现在对话记录中的消息，Agent的工具消息只在当轮对话中使用
+func isChatHistoryMessage(message *models.ChatMessage) bool {
+	return message.MessageType == models.ChatMessageTypeText || message.MessageType == models.ChatMessageTypeImage
+}
+
+// chatHistoryContent 消息在对话记录中的文字，历史图片不再发送给模型
+func chatHistoryContent(message *models.ChatMessage) string {
+	if message.MessageType == models.ChatMessageTypeImage {
+		return message.Content + "（用户发送了图片）"
+	}
+	return message.Content
+}
+
+func embedder() (client.Embedder, error) {
+	p, err := client.GetRegistry().ForScene(SceneEmbedding)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := p.(client.Embedder)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s embedding", client.ErrNotSupported, p.Name())
+	}
+	return e, nil
+}
+
+// RecallChatMemory 召回回复 query 时需要的上下文，currentId 为当前消息的ID，不会出现在最近消息中
+// 向量检索失败时只返回最近消息和用户档案
+func (s *StoryService) RecallChatMemory(ctx context.Context, chatCtx *models.ChatContext, query string, currentId int64) (*ChatMemoryRecall, error) {
+	opts := memoryConfig()
+	recall := &ChatMemoryRecall{Messages: make([]client.Message, 0), Memories: make([]string, 0)}
+	recent, err := models.GetRecentChatMessages(ctx, int64(chatCtx.ID), opts.recentMessages+1)
+	if err != nil {
+		log.Log().Error("get recent chat messages failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return nil, err
+	}
+	history := make([]*models.ChatMessage, 0, len(recent))
+	for _, message := range recent {
+		if int64(message.ID) != currentId && isChatHistoryMessage(message) {
+			history = append(history, message)
+		}
+	}
+	if len(history) > opts.recentMessages {
+		history = history[len(history)-opts.recentMessages:]
+	}
+	oldestId := currentId
+	if len(history) > 0 {
+		oldestId = int64(history[0].ID)
+	}
+	for _, message := range history {
+		recall.Messages = append(recall.Messages, client.Message{
+			Role:    chatMessageRole(message),
+			Content: chatHistoryContent(message),
+		})
+	}
+	s.recallLongTermMemory(ctx, opts, chatCtx, query, oldestId, recall)
+	return recall, nil
+}
+
+// recallLongTermMemory 填充用户档案和相关记忆，oldestId 之后的对话已在最近消息中，不再召回
+func (s *StoryService) recallLongTermMemory(ctx context.Context, opts memoryOptions, chatCtx *models.ChatContext, query string, oldestId int64, recall *ChatMemoryRecall) {
+	if !opts.enable {
+		return
+	}
+	profile, err := models.GetChatMemoryProfile(ctx, int64(chatCtx.ID))
+	if err != nil {
+		log.Log().Error("get chat memory profile failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	} else if profile != nil {
+		recall.Profile = profile.Profile
+	}
+	if strings.TrimSpace(query) == "" {
+		return
+	}
+	e, err := embedder()
+	if err != nil {
+		log.Log().Warn("chat memory embedder not available", zap.Error(err))
+		return
+	}
+	embedding, err := e.Embed(ctx, &client.EmbedParams{Model: opts.model, Input: truncateRunes(query, memoryTurnMaxRunes)})
+	if err != nil {
+		log.Log().Error("embed chat query failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return
+	}
+	memories, err := models.GetChatMemories(ctx, int64(chatCtx.ID), embedding.Model, memoryCandidateLimit)
+	if err != nil {
+		log.Log().Error("get chat memories failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return
+	}
+	candidates := make([]*models.ChatMemory, 0, len(memories))
+	vectors := make([][]float32, 0, len(memories))
+	for _, memory := range memories {
+		if oldestId != 0 && memory.MessageID >= oldestId {
+			continue
+		}
+		candidates = append(candidates, memory)
+		vectors = append(vectors, vector.Decode(memory.Vector))
+	}
+	for _, match := range vector.TopK(embedding.Vector, vectors, opts.topK, opts.minScore) {
+		recall.Memories = append(recall.Memories, candidates[match.Index].Content)
+	}
+}
+
+// RememberChatTurn 异步保存一轮对话的记忆，并在需要时总结用户档案
+func (s *StoryService) RememberChatTurn(ctx context.Context, chatCtx *models.ChatContext, userMsg, replyMsg *models.ChatMessage) {
+	opts := memoryConfig()
+	if !opts.enable {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), memoryTaskTimeout)
+	go func() {
+		defer cancel()
+		if err := s.saveChatTurn(ctx, opts, chatCtx, userMsg, replyMsg); err != nil {
+			log.Log().Error("save chat memory failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		}
+		if err := s.summarizeChatMemory(ctx, opts, chatCtx); err != nil {
+			log.Log().Error("summarize chat memory failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		}
+	}()
+}
+
+func (s *StoryService) saveChatTurn(ctx context.Context, opts memoryOptions, chatCtx *models.ChatContext, userMsg, replyMsg *models.ChatMessage) error {
+	speaker := "角色"
+	if role, err := models.GetStoryRoleByID(ctx, replyMsg.RoleID); err == nil && role != nil && role.CharacterName != "" {
+		speaker = role.CharacterName
+	}
+	content := truncateRunes("用户："+chatHistoryContent(userMsg), memoryTurnMaxRunes/2) + "\n" +
+		truncateRunes(speaker+"："+replyMsg.Content, memoryTurnMaxRunes/2)
+	e, err := embedder()
+	if err != nil {
+		return err
+	}
+	embedding, err := e.Embed(ctx, &client.EmbedParams{Model: opts.model, Input: content})
+	if err != nil {
+		return err
+	}
+	_, err = models.CreateChatMemory(ctx, &models.ChatMemory{
+		ChatContextID: int64(chatCtx.ID),
+		UserID:        chatCtx.UserID,
+		RoleID:        replyMsg.RoleID,
+		MessageID:     int64(replyMsg.ID),
+		Kind:          models.ChatMemoryKindTurn,
+		Content:       content,
+		Model:         embedding.Model,
+		Vector:        vector.Encode(embedding.Vector),
+	})
+	return err
+}
+
+// summarizeChatMemory 最近消息窗口之外未总结的消息达到 summaryEvery 条时，将其合并到用户档案
+func (s *StoryService) summarizeChatMemory(ctx context.Context, opts memoryOptions, chatCtx *models.ChatContext) error {
+	if _, busy := summarizing.LoadOrStore(chatCtx.ID, struct{}{}); busy {
+		return nil
+	}
+	defer summarizing.Delete(chatCtx.ID)
+
+	profile, err := models.GetChatMemoryProfile(ctx, int64(chatCtx.ID))
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		profile = &models.ChatMemoryProfile{
+			ChatContextID: int64(chatCtx.ID),
+			UserID:        chatCtx.UserID,
+			RoleID:        chatCtx.RoleID,
+		}
+	}
+	total, err := models.CountChatMessagesAfter(ctx, int64(chatCtx.ID), profile.LastMessageID)
+	if err != nil {
+		return err
+	}
+	if total < int64(opts.summaryEvery+opts.recentMessages) {
+		return nil
+	}
+	recent, err := models.GetRecentChatMessages(ctx, int64(chatCtx.ID), opts.recentMessages)
+	if err != nil || len(recent) == 0 {
+		return err
+	}
+	messages, err := models.GetChatMessagesAfter(ctx, int64(chatCtx.ID), profile.LastMessageID,
+		int64(recent[0].ID)-1, opts.summaryEvery)
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+	// 群聊中每条消息的发言角色不同
+	roleNames := make(map[int64]string)
+	roleName := func(roleId int64) string {
+		if name, ok := roleNames[roleId]; ok {
+			return name
+		}
+		name := "角色"
+		if role, err := models.GetStoryRoleByID(ctx, roleId); err == nil && role != nil {
+			name = role.CharacterName
+		}
+		roleNames[roleId] = name
+		return name
+	}
+	var transcript strings.Builder
+	for _, message := range messages {
+		if !isChatHistoryMessage(message) {
+			continue
+		}
+		speaker := "用户"
+		if chatMessageRole(message) == "assistant" {
+			speaker = roleName(message.RoleID)
+		}
+		transcript.WriteString(speaker + "：" + truncateRunes(chatHistoryContent(message), memoryTurnMaxRunes) + "\n")
+	}
+	prompt := fmt.Sprintf("已有的用户档案：\n%s\n\n新的对话记录：\n%s", profile.Profile, transcript.String())
+	var result *client.TextResult
+	_, err = s.failover(ctx, SceneChat, func(ctx context.Context, p client.Provider) error {
+		var err error
+		result, err = p.GenerateText(ctx, &client.TextParams{
+			System: memorySummarySystemPrompt,
+			Prompt: prompt,
+			UserId: fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID),
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if text := strings.TrimSpace(result.Content); text != "" {
+		profile.Profile = truncateRunes(text, memoryProfileMaxRunes)
+	}
+	profile.LastMessageID = int64(messages[len(messages)-1].ID)
+	profile.SummaryNum++
+	if err := models.SaveChatMemoryProfile(ctx, profile); err != nil {
+		return err
+	}
+	log.Log().Info("summarize chat memory success", zap.Uint("chat_id", chatCtx.ID),
+		zap.Int64("last_message_id", profile.LastMessageID))
+	return nil
+}
@@ -0,0 +1,112 @@
+package story
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/compliance"
+)
+
+// roleModerationColumns 角色需要审核的文字字段
+var roleModerationColumns = []string{"character_name", "character_description", "character_detail"}
+
+func roleModerationTarget(ctx context.Context, role *models.StoryRole) *moderation.Target {
+	authorId, _ := utils.GetUserIDFromContext(ctx)
+	if authorId == 0 {
+		authorId = role.CreatorID
+	}
+	return &moderation.Target{
+		EntityType: models.ModerationEntityRole,
+		EntityId:   int64(role.ID),
+		Field:      "role",
+		StoryId:    role.StoryID,
+		AuthorId:   authorId,
+	}
+}
+
+func sceneModerationTarget(ctx context.Context, scene *models.StoryBoardScene) *moderation.Target {
+	authorId, _ := utils.GetUserIDFromContext(ctx)
+	if authorId == 0 {
+		authorId = scene.CreatorId
+	}
+	return &moderation.Target{
+		EntityType: models.ModerationEntityScene,
+		EntityId:   int64(scene.ID),
+		Field:      "scene",
+		StoryId:    scene.StoryId,
+		AuthorId:   authorId,
+	}
+}
+
+// updateRoleColumnsModerated 审核角色的文字字段后更新，需要人工审核的角色在更新后隐藏
+func updateRoleColumnsModerated(ctx context.Context, role *models.StoryRole, columns map[string]interface{}, src *revisionSource) error {
+	texts := make([]string, 0, len(roleModerationColumns))
+	for _, column := range roleModerationColumns {
+		if v, ok := columns[column].(string); ok && v != "" {
+			texts = append(texts, v)
+		}
+	}
+	target := roleModerationTarget(ctx, role)
+	review, err := moderation.CheckText(ctx, target, texts...)
+	if err != nil {
+		return err
+	}
+	if err := updateRoleColumns(ctx, role, columns, src); err != nil {
+		return err
+	}
+	return moderation.Hold(ctx, target, review, strings.Join(texts, "\n"))
+}
+
+// errChatMessageHeld 用户的消息没有通过审核，不再生成回复
+var errChatMessageHeld = errors.New("message is under review")
+
+// chatReplyHeldContent 角色的回复没有通过审核时返回给用户的内容
+const chatReplyHeldContent = "该回复正在审核中"
+
+// moderateChatMessage 审核已经保存的聊天消息，不通过时消息被隐藏
+// 返回 false 时消息不应该再发送给模型或者返回给用户；进入过审核队列的消息不重复审核
+func moderateChatMessage(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage) bool {
+	if reviewed, err := models.HasModerationItem(ctx, models.ModerationEntityChatMessage, int64(message.ID)); err == nil && reviewed {
+		return true
+	}
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityChatMessage,
+		EntityId:   int64(message.ID),
+		Field:      "chat_message",
+		AuthorId:   chatCtx.UserID,
+	}
+	if message.RoleID != 0 {
+		if role, err := models.GetStoryRoleByID(ctx, message.RoleID); err == nil && role != nil {
+			target.StoryId = role.StoryID
+		}
+	}
+	result := moderation.CheckAndHold(ctx, target, message.Content)
+	return result.Verdict == compliance.VerdictPass
+}
+
+// errImageHeld 生成的图片没有通过审核
+var errImageHeld = errors.New("generated image is under review")
+
+// moderateImageGen 审核生成成功的图片，不通过时图片记录被隐藏，审核通过后才出现在候选列表中
+func moderateImageGen(ctx context.Context, imageGen *models.ImageGen) bool {
+	if imageGen.Status != models.MediaGenStatusSucceed || imageGen.ImageUrl == "" {
+		return true
+	}
+	authorId, _ := utils.GetUserIDFromContext(ctx)
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityImage,
+		EntityId:   int64(imageGen.ID),
+		StoryId:    imageGen.OriginID,
+		AuthorId:   authorId,
+	}
+	for _, url := range strings.Split(imageGen.ImageUrl, ",") {
+		if result := moderation.CheckImageAndHold(ctx, target, url); result.Verdict != compliance.VerdictPass {
+			return false
+		}
+	}
+	return true
+}
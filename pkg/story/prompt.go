@@ -0,0 +1,200 @@
+package story
+
+// 按所用平台模型的token预算组装角色聊天和故事续写的提示词，并统计消耗的token数用于计费
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/pkg/prompt"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultChatMaxTokens  = 6000
+	defaultStoryMaxTokens = 12000
+	defaultReplyTokens    = 1024
+)
+
+type promptOptions struct {
+	chatMaxTokens  int
+	storyMaxTokens int
+	replyTokens    int
+}
+
+func promptConfig() promptOptions {
+	opts := promptOptions{
+		chatMaxTokens:  defaultChatMaxTokens,
+		storyMaxTokens: defaultStoryMaxTokens,
+		replyTokens:    defaultReplyTokens,
+	}
+	if config.GlobalConfig == nil || config.GlobalConfig.Prompt == nil {
+		return opts
+	}
+	cfg := config.GlobalConfig.Prompt
+	if cfg.ChatMaxTokens > 0 {
+		opts.chatMaxTokens = cfg.ChatMaxTokens
+	}
+	if cfg.StoryMaxTokens > 0 {
+		opts.storyMaxTokens = cfg.StoryMaxTokens
+	}
+	if cfg.ReplyTokens > 0 {
+		opts.replyTokens = cfg.ReplyTokens
+	}
+	return opts
+}
+
+// rolePrompt 角色聊天提示词的组成部分，按优先级从高到低
+type rolePrompt struct {
+	Persona     string            // 角色设定
+	Instruction string            // 群聊等场景的额外要求
+	Background  string            // 故事背景
+	Recall      *ChatMemoryRecall // 用户档案和相关记忆
+	History     []client.Message  // 对话记录，最后一条为当前的用户输入
+}
+
+// hasImages 当前的用户输入是否带有图片
+func (r *rolePrompt) hasImages() bool {
+	return len(r.History) > 0 && len(r.History[len(r.History)-1].Images) > 0
+}
+
+// build 按平台的对话模型组装提示词
+func (r *rolePrompt) build(p client.Provider) (*prompt.Prompt, string) {
+	opts := promptConfig()
+	model := client.ChatModel(p.Name())
+	if r.hasImages() {
+		model = client.VisionModel(p.Name())
+	}
+	b := prompt.NewBuilder(model, opts.chatMaxTokens, opts.replyTokens).
+		Add(prompt.Section{Text: r.Persona, Share: 0.35}).
+		Add(prompt.Section{Text: r.Instruction, Share: 0.1}).
+		Add(prompt.Section{Title: "故事背景", Text: r.Background, Share: 0.15})
+	if r.Recall != nil {
+		b.Add(prompt.Section{Title: "你对用户的了解", Text: r.Recall.Profile, Share: 0.1}).
+			Add(prompt.Section{Title: "你们之前聊过的相关内容", Text: strings.Join(r.Recall.Memories, "\n---\n"), Share: 0.15})
+	}
+	built := b.History(r.History).Build()
+	if built.Dropped > 0 || len(built.Truncated) > 0 {
+		log.Log().Info("role chat prompt over budget", zap.String("platform", p.Name()),
+			zap.Int("token_num", built.TokenNum), zap.Int("dropped", built.Dropped), zap.Strings("truncated", built.Truncated))
+	}
+	return built, model
+}
+
+// chatRole 按预算组装提示词后与角色对话，返回回复和提示词的token数
+func (s *StoryService) chatRole(ctx context.Context, r *rolePrompt, userId, requestId string) (*client.ChatResult, int, error) {
+	var (
+		chatResp    *client.ChatResult
+		promptToken int
+	)
+	scene := SceneChat
+	if r.hasImages() {
+		scene = SceneVision
+	}
+	_, err := s.failover(ctx, scene, func(ctx context.Context, p client.Provider) error {
+		built, model := r.build(p)
+		params := &client.ChatParams{
+			System:    built.System,
+			Messages:  built.Messages,
+			UserId:    userId,
+			RequestId: requestId,
+		}
+		chat := p.Chat
+		if r.hasImages() {
+			v, ok := p.(client.VisionChatter)
+			if !ok {
+				return fmt.Errorf("%w: %s vision chat", client.ErrNotSupported, p.Name())
+			}
+			params.Model = model
+			chat = v.ChatWithImages
+		}
+		ret, err := chat(ctx, params)
+		if err != nil {
+			return err
+		}
+		// 平台没有返回用量时按提示词和回复估算
+		if ret.TokenNum <= 0 {
+			ret.TokenNum = built.TokenNum + client.CountTokens(model, ret.Content)
+		}
+		chatResp, promptToken = ret, built.TokenNum
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return chatResp, promptToken, nil
+}
+
+// recordChatUsage 把回复消耗的token数累加到会话
+func recordChatUsage(ctx context.Context, chatCtx *models.ChatContext, reply *models.ChatMessage) {
+	if reply.TokenNum <= 0 {
+		return
+	}
+	if err := models.AddChatContextTokens(ctx, int64(chatCtx.ID), reply.TokenNum); err != nil {
+		log.Log().Error("record chat token usage failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+	}
+}
+
+// storyPromptBudget 故事生成提示词在平台文本模型上的token预算
+func storyPromptBudget(p client.Provider) (string, int) {
+	opts := promptConfig()
+	model := client.TextModel(p.Name())
+	budget := client.LookupModel(model).ContextWindow - opts.replyTokens
+	if opts.storyMaxTokens < budget {
+		budget = opts.storyMaxTokens
+	}
+	return model, budget
+}
+
+// fitPrevContent 截断之前的章节，使整个提示词不超过预算，保留最近的内容
+func fitPrevContent(p client.Provider, params *client.StoryboardParams, fixed string) {
+	model, budget := storyPromptBudget(p)
+	limit := budget - client.CountTokens(model, fixed) - client.CountTokens(model, params.Characters) -
+		client.CountTokens(model, params.Description) - client.CountTokens(model, params.Background) -
+		client.CountTokens(model, params.Feedback)
+	if limit < 0 {
+		limit = 0
+	}
+	if fitted := client.TruncateTokens(model, params.PrevContent, limit, true); fitted != params.PrevContent {
+		log.Log().Info("storyboard prev content over budget", zap.String("platform", p.Name()),
+			zap.Int("budget", budget), zap.Int("limit", limit))
+		params.PrevContent = fitted
+	}
+}
+
+// tokenUsage 一次生成任务中所有请求（包括重试和故障转移）消耗的token数
+type tokenUsage struct {
+	mu    sync.Mutex
+	total int
+}
+
+type tokenUsageKey struct{}
+
+// withTokenUsage 开始统计 ctx 中的token用量
+func withTokenUsage(ctx context.Context) (context.Context, *tokenUsage) {
+	usage := &tokenUsage{}
+	return context.WithValue(ctx, tokenUsageKey{}, usage), usage
+}
+
+func addTokenUsage(ctx context.Context, tokenNum int) {
+	usage, ok := ctx.Value(tokenUsageKey{}).(*tokenUsage)
+	if !ok || tokenNum <= 0 {
+		return
+	}
+	usage.mu.Lock()
+	usage.total += tokenNum
+	usage.mu.Unlock()
+}
+
+func (u *tokenUsage) Total() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.total
+}
@@ -0,0 +1,171 @@
+package story
+
+// 角色形象设定：生成场景图片时带上场景中角色的外貌描述和参考图，保持同一角色在不同画面中的形象一致
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const roleAppearanceMaxRunes = 200
+
+// RoleReferenceSheet 角色的形象设定，来自角色的详细信息、参考图、海报和头像
+type RoleReferenceSheet struct {
+	RoleId     int64    `json:"role_id"`
+	Name       string   `json:"name"`
+	Appearance string   `json:"appearance"`
+	Images     []string `json:"images"` // 按优先级排列：参考图、海报、头像
+}
+
+func newRoleReferenceSheet(role *models.StoryRole) *RoleReferenceSheet {
+	sheet := &RoleReferenceSheet{
+		RoleId: int64(role.ID),
+		Name:   role.CharacterName,
+		Images: make([]string, 0),
+	}
+	detail := &CharacterDetailConverter{}
+	if err := json.Unmarshal([]byte(role.CharacterDetail), detail); err == nil {
+		parts := make([]string, 0, 2)
+		for _, part := range []string{detail.Appearance, detail.DressPreference} {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		sheet.Appearance = strings.Join(parts, "，")
+	}
+	if sheet.Appearance == "" {
+		sheet.Appearance = strings.TrimSpace(role.CharacterPrompt)
+	}
+	sheet.Appearance = truncateRunes(sheet.Appearance, roleAppearanceMaxRunes)
+	seen := make(map[string]bool)
+	images := append(strings.Split(role.CharacterRefImages, ","), role.PosterURL, role.CharacterAvatar)
+	for _, image := range images {
+		if image = strings.TrimSpace(image); image != "" && !seen[image] {
+			seen[image] = true
+			sheet.Images = append(sheet.Images, image)
+		}
+	}
+	return sheet
+}
+
+// parseCharacterIds 解析场景中逗号分隔的角色ID，忽略无法解析的内容
+func parseCharacterIds(characterIds string) []int64 {
+	ids := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, item := range strings.Split(characterIds, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+		if err != nil || id <= 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// storyReferenceSheets 获取故事中 roleIds 对应角色的形象设定，保持 roleIds 的顺序，不属于故事的角色会被忽略
+func storyReferenceSheets(ctx context.Context, storyId int64, roleIds []int64) []*RoleReferenceSheet {
+	sheets := make([]*RoleReferenceSheet, 0, len(roleIds))
+	if len(roleIds) == 0 {
+		return sheets
+	}
+	roles, err := models.GetStoryRolesByIDs(ctx, roleIds)
+	if err != nil {
+		log.Log().Error("get scene roles failed", zap.Int64("story_id", storyId), zap.Error(err))
+		return sheets
+	}
+	byId := make(map[int64]*models.StoryRole, len(roles))
+	for _, role := range roles {
+		if role.StoryID == storyId {
+			byId[int64(role.ID)] = role
+		}
+	}
+	for _, id := range roleIds {
+		if role, ok := byId[id]; ok {
+			sheets = append(sheets, newRoleReferenceSheet(role))
+		}
+	}
+	return sheets
+}
+
+// storyReferenceSheetsByName 按角色名获取故事中角色的形象设定，用于只有角色名的生成结果
+func storyReferenceSheetsByName(ctx context.Context, storyId int64, names []string) []*RoleReferenceSheet {
+	sheets := make([]*RoleReferenceSheet, 0, len(names))
+	if len(names) == 0 {
+		return sheets
+	}
+	roles, err := models.GetStoryRole(ctx, storyId)
+	if err != nil {
+		log.Log().Error("get story roles failed", zap.Int64("story_id", storyId), zap.Error(err))
+		return sheets
+	}
+	byName := make(map[string]*models.StoryRole, len(roles))
+	for _, role := range roles {
+		byName[role.CharacterName] = role
+	}
+	for _, name := range names {
+		if role, ok := byName[strings.TrimSpace(name)]; ok {
+			sheets = append(sheets, newRoleReferenceSheet(role))
+			delete(byName, role.CharacterName)
+		}
+	}
+	return sheets
+}
+
+// referencePrompt 场景中人物的形象描述
+func referencePrompt(sheets []*RoleReferenceSheet) string {
+	parts := make([]string, 0, len(sheets))
+	for _, sheet := range sheets {
+		if sheet.Appearance == "" {
+			parts = append(parts, sheet.Name)
+			continue
+		}
+		parts = append(parts, sheet.Name+"（"+sheet.Appearance+"）")
+	}
+	return strings.Join(parts, "；")
+}
+
+// applyReferenceSheets 把人物的形象描述加到提示词中，没有指定参考图时使用角色的参考图
+// 参考图生成只能保持一个人物的形象，场景中有多个角色时使用排在最前、有参考图的角色作为主要人物，
+// 并在提示词中注明参考图对应的人物，避免其他角色被画成参考图中的人物
+func applyReferenceSheets(params *client.ImageParams, sheets []*RoleReferenceSheet) {
+	if len(sheets) == 0 {
+		return
+	}
+	params.Prompt += ",人物: " + referencePrompt(sheets)
+	if params.RefImage != "" {
+		return
+	}
+	for _, sheet := range sheets {
+		if len(sheet.Images) == 0 {
+			continue
+		}
+		params.RefImage = sheet.Images[0]
+		params.RefMode = client.RefModeReference
+		if len(sheets) > 1 {
+			params.Prompt += ",参考图中的人物为" + sheet.Name + "，其他人物按形象描述绘制"
+		}
+		return
+	}
+}
+
+// sceneImageParams 故事板场景的图片生成参数，带上场景中角色的形象设定
+func sceneImageParams(ctx context.Context, storyId int64, scene *models.StoryBoardScene) *client.ImageParams {
+	prompt := strings.Replace(models.PreDefineTemplateEnVersion[1].Prompt, "prompt", scene.ImagePrompts, -1)
+	params := &client.ImageParams{Prompt: prompt}
+	sheets := storyReferenceSheets(ctx, storyId, parseCharacterIds(scene.CharacterIds))
+	if len(sheets) == 0 {
+		params.Prompt += ",人物: " + scene.CharacterIds
+		return params
+	}
+	applyReferenceSheets(params, sheets)
+	return params
+}
@@ -0,0 +1,38 @@
+package story
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/pkg/client"
+)
+
+func TestApplyReferenceSheets(t *testing.T) {
+	alice := &RoleReferenceSheet{Name: "爱丽丝", Appearance: "金发", Images: []string{"a.png", "a2.png"}}
+	bob := &RoleReferenceSheet{Name: "鲍勃", Images: []string{"b.png"}}
+	carol := &RoleReferenceSheet{Name: "卡罗尔"}
+	tests := []struct {
+		name       string
+		refImage   string
+		sheets     []*RoleReferenceSheet
+		wantPrompt string
+		wantRef    string
+		wantMode   string
+	}{
+		{"no roles", "", nil, "p", "", ""},
+		{"single role", "", []*RoleReferenceSheet{alice}, "p,人物: 爱丽丝（金发）", "a.png", client.RefModeReference},
+		{"several roles with images", "", []*RoleReferenceSheet{alice, bob}, "p,人物: 爱丽丝（金发）；鲍勃,参考图中的人物为爱丽丝，其他人物按形象描述绘制", "a.png", client.RefModeReference},
+		{"several roles, first without image", "", []*RoleReferenceSheet{carol, bob, alice}, "p,人物: 卡罗尔；鲍勃；爱丽丝（金发）,参考图中的人物为鲍勃，其他人物按形象描述绘制", "b.png", client.RefModeReference},
+		{"several roles keep given ref", "x.png", []*RoleReferenceSheet{alice, bob}, "p,人物: 爱丽丝（金发）；鲍勃", "x.png", ""},
+		{"keep given ref", "x.png", []*RoleReferenceSheet{alice}, "p,人物: 爱丽丝（金发）", "x.png", ""},
+		{"no images", "", []*RoleReferenceSheet{carol}, "p,人物: 卡罗尔", "", ""},
+	}
+	for _, tt := range tests {
+		params := &client.ImageParams{Prompt: "p", RefImage: tt.refImage}
+		applyReferenceSheets(params, tt.sheets)
+		assert.Equal(t, tt.wantPrompt, params.Prompt, tt.name)
+		assert.Equal(t, tt.wantRef, params.RefImage, tt.name)
+		assert.Equal(t, tt.wantMode, params.RefMode, tt.name)
+	}
+}
@@ -0,0 +1,318 @@
+package story
+
+// 异步渲染任务，任务保存在 story_gen 表（next_run_at > 0），由 syncworker 领取执行
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	api "github.com/grapery/common-protoc/gen"
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	defaultRenderWorkers     = 2
+	defaultRenderMaxAttempts = 5
+	defaultRenderInterval    = 2 * time.Second
+	defaultRenderTimeout     = 10 * time.Minute
+	renderBackoffBase        = 10 * time.Second
+	renderBackoffMax         = 10 * time.Minute
+)
+
+// RenderJobQueued 异步提交成功时响应的 Message，进度通过故事板的渲染状态查询
+const RenderJobQueued = "queued"
+
+var (
+	ErrRenderJobNotFound = errors.New("render job not found")
+	// errRenderRejected 渲染接口返回了业务错误（Code != 0），重试没有意义
+	errRenderRejected = errors.New("render request rejected")
+)
+
+// 任务优先级，文本生成耗时短，优先执行
+var renderJobPriority = map[int]int{
+	models.StoryGenTaskStoryboard: 10,
+	models.StoryGenTaskRole:       10,
+	models.StoryGenTaskScenes:     5,
+}
+
+type renderJobKey struct{}
+
+// asyncRenderEnabled 是否将渲染请求提交为异步任务，worker 执行任务和流式渲染时总是同步调用
+func asyncRenderEnabled(ctx context.Context) bool {
+	if ctx.Value(renderJobKey{}) != nil || storyboardStreamFromContext(ctx) != nil {
+		return false
+	}
+	cfg := config.GlobalConfig
+	return cfg != nil && cfg.Render != nil && cfg.Render.Async
+}
+
+// renderJobFromContext worker 执行时返回正在执行的任务，同步调用时返回nil
+func renderJobFromContext(ctx context.Context) *models.StoryGen {
+	job, _ := ctx.Value(renderJobKey{}).(*models.StoryGen)
+	return job
+}
+
+// renderTaskId worker 执行时返回异步任务ID，同步调用时生成新的ID
+func renderTaskId(ctx context.Context) string {
+	if job := renderJobFromContext(ctx); job != nil {
+		return job.TaskId
+	}
+	return uuid.New().String()
+}
+
+// newRenderGen worker 执行时返回任务本身的记录，同步调用时返回新的生成记录
+func newRenderGen(ctx context.Context) *models.StoryGen {
+	if job := renderJobFromContext(ctx); job != nil {
+		return job
+	}
+	return &models.StoryGen{Uuid: uuid.New().String()}
+}
+
+// saveRenderGen 同步调用时创建生成记录，worker 执行时更新任务记录
+// 任务记录的 Params 是原始请求，重试时需要，不使用 params 覆盖
+func saveRenderGen(ctx context.Context, gen *models.StoryGen, params string) error {
+	if gen.ID != 0 {
+		return models.UpdateStoryGen(ctx, gen)
+	}
+	gen.Params = params
+	_, err := models.CreateStoryGen(ctx, gen)
+	return err
+}
+
+// enqueueRenderJob 提交异步渲染任务，gen 中需填好关联的故事/故事板/角色，req 为原始的请求参数
+func (s *StoryService) enqueueRenderJob(ctx context.Context, taskType int, req interface{}, gen *models.StoryGen) (*models.StoryGen, error) {
+	params, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	gen.Uuid = uuid.New().String()
+	gen.TaskId = uuid.New().String()
+	gen.TaskType = taskType
+	gen.Priority = renderJobPriority[taskType]
+	gen.GenStatus = models.StoryGenStatusInit
+	gen.Params = string(params)
+	gen.NextRunAt = time.Now().Unix()
+	if _, err := models.CreateStoryGen(ctx, gen); err != nil {
+		log.Log().Error("create render job failed", zap.Error(err))
+		return nil, err
+	}
+	log.Log().Info("render job queued", zap.String("task_id", gen.TaskId), zap.Int("task_type", taskType))
+	return gen, nil
+}
+
+// GetRenderJob 查询异步渲染任务，完成后 Content 为渲染接口响应的json
+func (s *StoryService) GetRenderJob(ctx context.Context, taskId string) (*models.StoryGen, error) {
+	gen, err := models.GetStoryGenByTaskId(ctx, taskId)
+	if err != nil || gen.NextRunAt == 0 {
+		return nil, ErrRenderJobNotFound
+	}
+	return gen, nil
+}
+
+// runRenderJob 执行任务，返回渲染接口的响应
+func (s *StoryService) runRenderJob(ctx context.Context, gen *models.StoryGen) (interface{ GetCode() int32 }, error) {
+	ctx = context.WithValue(ctx, renderJobKey{}, gen)
+	switch gen.TaskType {
+	case models.StoryGenTaskStoryboard:
+		req := new(api.RenderStoryboardRequest)
+		if err := json.Unmarshal([]byte(gen.Params), req); err != nil {
+			return nil, err
+		}
+		return s.RenderStoryboard(ctx, req)
+	case models.StoryGenTaskScenes:
+		req := new(api.RenderStoryBoardSencesRequest)
+		if err := json.Unmarshal([]byte(gen.Params), req); err != nil {
+			return nil, err
+		}
+		return s.RenderStoryBoardSences(ctx, req)
+	case models.StoryGenTaskRole:
+		req := new(api.RenderStoryRoleRequest)
+		if err := json.Unmarshal([]byte(gen.Params), req); err != nil {
+			return nil, err
+		}
+		return s.RenderStoryRole(ctx, req)
+	}
+	return nil, fmt.Errorf("unknown render task type %d", gen.TaskType)
+}
+
+// renderJobBackoff 第n次失败后的重试间隔，指数增长
+func renderJobBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := time.Duration(float64(renderBackoffBase) * math.Pow(2, float64(attempts-1)))
+	if backoff <= 0 || backoff > renderBackoffMax {
+		return renderBackoffMax
+	}
+	return backoff
+}
+
+// RenderWorker 轮询并执行异步渲染任务
+type RenderWorker struct {
+	svc         *StoryService
+	workers     int
+	maxAttempts int
+	interval    time.Duration
+	timeout     time.Duration
+}
+
+func NewRenderWorker(cfg *config.RenderConfig) *RenderWorker {
+	w := &RenderWorker{
+		svc:         storyServer.(*StoryService),
+		workers:     defaultRenderWorkers,
+		maxAttempts: defaultRenderMaxAttempts,
+		interval:    defaultRenderInterval,
+		timeout:     defaultRenderTimeout,
+	}
+	if cfg == nil {
+		return w
+	}
+	if cfg.Workers > 0 {
+		w.workers = cfg.Workers
+	}
+	if cfg.MaxAttempts > 0 {
+		w.maxAttempts = cfg.MaxAttempts
+	}
+	if cfg.Interval > 0 {
+		w.interval = time.Duration(cfg.Interval) * time.Second
+	}
+	if cfg.Timeout > 0 {
+		w.timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	return w
+}
+
+// Run 阻塞执行直到ctx结束，退出前等待执行中的任务完成
+func (w *RenderWorker) Run(ctx context.Context) {
+	if n, err := models.RequeueStaleStoryGenJobs(ctx, w.timeout); err != nil {
+		log.Log().Error("requeue stale render jobs failed", zap.Error(err))
+	} else if n > 0 {
+		log.Log().Info("requeue stale render jobs", zap.Int64("count", n))
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, w.workers)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+		for {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			}
+			gen, err := models.ClaimStoryGenJob(ctx, []int{
+				models.StoryGenTaskStoryboard,
+				models.StoryGenTaskRole,
+				models.StoryGenTaskScenes,
+			})
+			if err != nil || gen == nil {
+				<-sem
+				if err != nil {
+					log.Log().Error("claim render job failed", zap.Error(err))
+				}
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				w.process(ctx, gen)
+			}()
+		}
+	}
+}
+
+// process 执行任务并更新状态，失败时按退避时间重新排队
+func (w *RenderWorker) process(ctx context.Context, gen *models.StoryGen) {
+	jobCtx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := w.svc.runRenderJob(jobCtx, gen)
+	if err == nil && resp.GetCode() != 0 {
+		err = errRenderRejected
+	}
+	// 使用独立的ctx更新状态，避免任务超时后无法落库
+	updateCtx := context.Background()
+	if err == nil {
+		content, _ := json.Marshal(resp)
+		gen.GenStatus = models.StoryGenStatusFinish
+		gen.Content = string(content)
+		gen.ErrMsg = ""
+		gen.FinishTime = time.Now().Unix()
+		if err := models.UpdateStoryGenMultiColumn(updateCtx, int64(gen.ID), map[string]interface{}{
+			"gen_status":  gen.GenStatus,
+			"content":     gen.Content,
+			"err_msg":     gen.ErrMsg,
+			"finish_time": gen.FinishTime,
+		}); err != nil {
+			log.Log().Error("update render job failed", zap.String("task_id", gen.TaskId), zap.Error(err))
+		}
+		w.svc.onRenderJobDone(updateCtx, gen)
+		log.Log().Info("render job finished", zap.String("task_id", gen.TaskId),
+			zap.Int("attempts", gen.Attempts), zap.Duration("cost", time.Since(start)))
+		return
+	}
+	columns := map[string]interface{}{
+		"err_msg": err.Error(),
+	}
+	if errors.Is(err, errRenderRejected) {
+		content, _ := json.Marshal(resp)
+		columns["content"] = string(content)
+	}
+	if errors.Is(err, errRenderRejected) || gen.Attempts >= w.maxAttempts {
+		gen.GenStatus = models.StoryGenStatusError
+		columns["finish_time"] = time.Now().Unix()
+	} else {
+		gen.GenStatus = models.StoryGenStatusInit
+		columns["next_run_at"] = time.Now().Add(renderJobBackoff(gen.Attempts)).Unix()
+	}
+	columns["gen_status"] = gen.GenStatus
+	if err := models.UpdateStoryGenMultiColumn(updateCtx, int64(gen.ID), columns); err != nil {
+		log.Log().Error("update render job failed", zap.String("task_id", gen.TaskId), zap.Error(err))
+	}
+	if gen.GenStatus == models.StoryGenStatusError {
+		w.svc.onRenderJobDone(updateCtx, gen)
+	}
+	log.Log().Error("render job failed", zap.String("task_id", gen.TaskId),
+		zap.Int("attempts", gen.Attempts), zap.Error(err))
+}
+
+// onRenderJobDone 任务结束（完成或最终失败）后同步场景的生成状态
+func (s *StoryService) onRenderJobDone(ctx context.Context, gen *models.StoryGen) {
+	if gen.TaskType != models.StoryGenTaskScenes {
+		return
+	}
+	scenes, err := models.GetStoryBoardScenesByBoard(ctx, gen.BoardID)
+	if err != nil {
+		log.Log().Error("get storyboard scene failed", zap.Error(err))
+		return
+	}
+	for _, scene := range scenes {
+		if scene.TaskId != gen.TaskId {
+			continue
+		}
+		scene.GenStatus = int(gen.GenStatus)
+		if err := models.UpdateStoryBoardScene(ctx, scene); err != nil {
+			log.Log().Error("update storyboard scene failed", zap.Error(err))
+		}
+	}
+}
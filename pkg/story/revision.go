@@ -0,0 +1,441 @@
+package story
+
+// 故事板、场景、角色的版本记录：修改前后保存快照，支持查看、比较和回滚
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+	"github.com/grapery/grapery/utils/textdiff"
+)
+
+const revisionMaxPageSize = 100
+
+// revisionColumns 各类对象纳入版本记录的字段，快照的 key 为数据库列名
+var revisionColumns = map[int][]string{
+	models.RevisionEntityStoryboard: {"title", "description", "params", "avatar"},
+	models.RevisionEntityScene: {"content", "character_ids", "image_prompts", "audio_prompts",
+		"video_prompts", "gen_result"},
+	models.RevisionEntityRole: {"character_name", "character_description", "character_avatar",
+		"character_type", "character_prompt", "character_ref_images", "character_detail", "poster_url"},
+}
+
+var revisionSourceNames = map[int]string{
+	models.RevisionSourceOriginal: "original",
+	models.RevisionSourceManual:   "manual",
+	models.RevisionSourceGenerate: "generate",
+	models.RevisionSourceRollback: "rollback",
+}
+
+// RevisionInfo 一个历史版本，Version 为0时表示当前内容
+type RevisionInfo struct {
+	EntityType  int               `json:"entity_type"`
+	EntityId    int64             `json:"entity_id"`
+	StoryId     int64             `json:"story_id"`
+	Version     int64             `json:"version"`
+	AuthorId    int64             `json:"author_id"`
+	Source      string            `json:"source"`
+	StoryGenId  int64             `json:"story_gen_id,omitempty"`
+	TaskId      string            `json:"task_id,omitempty"`
+	BaseVersion int64             `json:"base_version,omitempty"`
+	Snapshot    map[string]string `json:"snapshot"`
+	Ctime       int64             `json:"ctime"`
+}
+
+// RevisionList 分页的版本列表，按版本从新到旧
+type RevisionList struct {
+	Total int64           `json:"total"`
+	List  []*RevisionInfo `json:"list"`
+}
+
+// RevisionFieldDiff 一个字段的差异
+type RevisionFieldDiff struct {
+	Field string        `json:"field"`
+	Ops   []textdiff.Op `json:"ops"`
+}
+
+// RevisionCompare 两个版本的比较结果，Fields 只包含有变化的字段
+type RevisionCompare struct {
+	Old    *RevisionInfo        `json:"old"`
+	New    *RevisionInfo        `json:"new"`
+	Fields []*RevisionFieldDiff `json:"fields"`
+}
+
+// revisionEntity 对象的当前内容
+type revisionEntity struct {
+	entityType int
+	entityId   int64
+	storyId    int64
+	creatorId  int64
+	snapshot   map[string]string
+}
+
+// revisionSource 一次修改的来源
+type revisionSource struct {
+	source      int
+	authorId    int64
+	storyGenId  int64
+	taskId      string
+	baseVersion int64
+}
+
+func boardRevision(board *models.StoryBoard) *revisionEntity {
+	return &revisionEntity{
+		entityType: models.RevisionEntityStoryboard,
+		entityId:   int64(board.ID),
+		storyId:    board.StoryID,
+		creatorId:  board.CreatorID,
+		snapshot: map[string]string{
+			"title":       board.Title,
+			"description": board.Description,
+			"params":      board.Params,
+			"avatar":      board.Avatar,
+		},
+	}
+}
+
+func sceneRevision(scene *models.StoryBoardScene) *revisionEntity {
+	return &revisionEntity{
+		entityType: models.RevisionEntityScene,
+		entityId:   int64(scene.ID),
+		storyId:    scene.StoryId,
+		creatorId:  scene.CreatorId,
+		snapshot: map[string]string{
+			"content":       scene.Content,
+			"character_ids": scene.CharacterIds,
+			"image_prompts": scene.ImagePrompts,
+			"audio_prompts": scene.AudioPrompts,
+			"video_prompts": scene.VideoPrompts,
+			"gen_result":    scene.GenResult,
+		},
+	}
+}
+
+func roleRevision(role *models.StoryRole) *revisionEntity {
+	return &revisionEntity{
+		entityType: models.RevisionEntityRole,
+		entityId:   int64(role.ID),
+		storyId:    role.StoryID,
+		creatorId:  role.CreatorID,
+		snapshot: map[string]string{
+			"character_name":        role.CharacterName,
+			"character_description": role.CharacterDescription,
+			"character_avatar":      role.CharacterAvatar,
+			"character_type":        role.CharacterType,
+			"character_prompt":      role.CharacterPrompt,
+			"character_ref_images":  role.CharacterRefImages,
+			"character_detail":      role.CharacterDetail,
+			"poster_url":            role.PosterURL,
+		},
+	}
+}
+
+// withColumns 返回按更新的列修改后的快照
+func (e *revisionEntity) withColumns(columns map[string]interface{}) map[string]string {
+	snapshot := make(map[string]string, len(e.snapshot))
+	for k, v := range e.snapshot {
+		snapshot[k] = v
+	}
+	for k, v := range columns {
+		if str, ok := v.(string); ok {
+			if _, tracked := snapshot[k]; tracked {
+				snapshot[k] = str
+			}
+		}
+	}
+	return snapshot
+}
+
+func manualRevision(ctx context.Context) *revisionSource {
+	userId, _ := utils.GetUserIDFromContext(ctx)
+	return &revisionSource{source: models.RevisionSourceManual, authorId: userId}
+}
+
+// generatedRevision 生成结果写入，异步任务中关联对应的 story_gen
+func generatedRevision(ctx context.Context, taskId string) *revisionSource {
+	src := &revisionSource{source: models.RevisionSourceGenerate, taskId: taskId}
+	src.authorId, _ = utils.GetUserIDFromContext(ctx)
+	if job := renderJobFromContext(ctx); job != nil {
+		src.storyGenId = int64(job.ID)
+		if src.authorId == 0 {
+			src.authorId = job.UserId
+		}
+	}
+	return src
+}
+
+// saveWithRevision 在一个事务中执行修改并记录修改后的内容，对象第一次修改时先保存修改前的内容作为原始版本
+// 内容没有变化时只执行修改
+func saveWithRevision(ctx context.Context, update models.RevisionUpdate, before *revisionEntity, after map[string]string, src *revisionSource) error {
+	var origin, rev *models.Revision
+	if !sameSnapshot(before.snapshot, after) {
+		origin = newRevision(before, before.snapshot,
+			&revisionSource{source: models.RevisionSourceOriginal, authorId: before.creatorId})
+		rev = newRevision(before, after, src)
+	}
+	if err := models.UpdateWithRevision(ctx, update, origin, rev); err != nil {
+		log.Log().Error("update with revision failed", zap.Int("entity_type", before.entityType),
+			zap.Int64("entity_id", before.entityId), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func newRevision(entity *revisionEntity, snapshot map[string]string, src *revisionSource) *models.Revision {
+	data, _ := json.Marshal(snapshot)
+	return &models.Revision{
+		EntityType:  entity.entityType,
+		EntityId:    entity.entityId,
+		StoryId:     entity.storyId,
+		AuthorId:    src.authorId,
+		Source:      src.source,
+		StoryGenId:  src.storyGenId,
+		TaskId:      src.taskId,
+		BaseVersion: src.baseVersion,
+		Snapshot:    string(data),
+	}
+}
+
+func sameSnapshot(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// updateStoryboardColumns 更新故事板并记录版本
+func updateStoryboardColumns(ctx context.Context, board *models.StoryBoard, columns map[string]interface{}, src *revisionSource) error {
+	before := boardRevision(board)
+	after := before.withColumns(columns)
+	if err := saveWithRevision(ctx, models.StoryboardColumnsUpdate(int64(board.ID), columns), before, after, src); err != nil {
+		return err
+	}
+	search.Sync(search.KindStoryBoard, int64(board.ID))
+	return nil
+}
+
+// updateSceneWithRevision 保存已修改的场景并记录版本，before 为修改前的内容
+func updateSceneWithRevision(ctx context.Context, before *revisionEntity, scene *models.StoryBoardScene, src *revisionSource) error {
+	return saveWithRevision(ctx, models.SceneUpdate(scene), before, sceneRevision(scene).snapshot, src)
+}
+
+// updateRoleColumns 更新角色并记录版本
+func updateRoleColumns(ctx context.Context, role *models.StoryRole, columns map[string]interface{}, src *revisionSource) error {
+	before := roleRevision(role)
+	after := before.withColumns(columns)
+	if err := saveWithRevision(ctx, models.RoleColumnsUpdate(int64(role.ID), columns), before, after, src); err != nil {
+		return err
+	}
+	search.Sync(search.KindRole, int64(role.ID))
+	return nil
+}
+
+func loadRevisionEntity(ctx context.Context, entityType int, entityId int64) (*revisionEntity, error) {
+	switch entityType {
+	case models.RevisionEntityStoryboard:
+		board, err := models.GetStoryboard(ctx, entityId)
+		if err != nil {
+			return nil, err
+		}
+		if board == nil || board.Status < 0 {
+			return nil, errors.New("storyboard not found")
+		}
+		return boardRevision(board), nil
+	case models.RevisionEntityScene:
+		scene, err := models.GetStoryBoardScene(ctx, entityId)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		if scene == nil {
+			return nil, errors.New("scene not found")
+		}
+		return sceneRevision(scene), nil
+	case models.RevisionEntityRole:
+		role, err := models.GetStoryRoleByID(ctx, entityId)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return nil, errors.New("role not found")
+		}
+		return roleRevision(role), nil
+	}
+	return nil, errors.New("invalid entity type")
+}
+
+func newRevisionInfo(rev *models.Revision) *RevisionInfo {
+	info := &RevisionInfo{
+		EntityType:  rev.EntityType,
+		EntityId:    rev.EntityId,
+		StoryId:     rev.StoryId,
+		Version:     rev.Version,
+		AuthorId:    rev.AuthorId,
+		Source:      revisionSourceNames[rev.Source],
+		StoryGenId:  rev.StoryGenId,
+		TaskId:      rev.TaskId,
+		BaseVersion: rev.BaseVersion,
+		Snapshot:    make(map[string]string),
+		Ctime:       rev.CreateAt.Unix(),
+	}
+	if err := json.Unmarshal([]byte(rev.Snapshot), &info.Snapshot); err != nil {
+		log.Log().Error("unmarshal revision snapshot failed", zap.Uint("revision_id", rev.ID), zap.Error(err))
+	}
+	return info
+}
+
+func currentRevisionInfo(entity *revisionEntity) *RevisionInfo {
+	return &RevisionInfo{
+		EntityType: entity.entityType,
+		EntityId:   entity.entityId,
+		StoryId:    entity.storyId,
+		Snapshot:   entity.snapshot,
+	}
+}
+
+// readableRevisionEntity 加载对象并检查当前用户是否可以查看所属故事
+func readableRevisionEntity(ctx context.Context, entityType int, entityId int64) (*revisionEntity, *models.Story, error) {
+	entity, err := loadRevisionEntity(ctx, entityType, entityId)
+	if err != nil {
+		return nil, nil, err
+	}
+	story, err := readableStory(ctx, entity.storyId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entity, story, nil
+}
+
+// ListRevisions 返回对象的历史版本
+func (s *StoryService) ListRevisions(ctx context.Context, entityType int, entityId int64, offset, pageSize int) (*RevisionList, error) {
+	if _, _, err := readableRevisionEntity(ctx, entityType, entityId); err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 || pageSize > revisionMaxPageSize {
+		pageSize = revisionMaxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	revs, total, err := models.ListRevisions(ctx, entityType, entityId, offset, pageSize)
+	if err != nil {
+		log.Log().Error("list revisions failed", zap.Int64("entity_id", entityId), zap.Error(err))
+		return nil, err
+	}
+	list := &RevisionList{Total: total, List: make([]*RevisionInfo, 0, len(revs))}
+	for _, rev := range revs {
+		list.List = append(list.List, newRevisionInfo(rev))
+	}
+	return list, nil
+}
+
+// CompareRevisions 按字段比较两个版本，otherVersion 为0时与当前内容比较
+func (s *StoryService) CompareRevisions(ctx context.Context, entityType int, entityId int64, version, otherVersion int64) (*RevisionCompare, error) {
+	entity, _, err := readableRevisionEntity(ctx, entityType, entityId)
+	if err != nil {
+		return nil, err
+	}
+	load := func(v int64) (*RevisionInfo, error) {
+		if v == 0 {
+			return currentRevisionInfo(entity), nil
+		}
+		rev, err := models.GetRevision(ctx, entityType, entityId, v)
+		if err != nil {
+			return nil, err
+		}
+		if rev == nil {
+			return nil, errors.New("revision not found")
+		}
+		return newRevisionInfo(rev), nil
+	}
+	compare := &RevisionCompare{Fields: make([]*RevisionFieldDiff, 0)}
+	if compare.Old, err = load(version); err != nil {
+		return nil, err
+	}
+	if compare.New, err = load(otherVersion); err != nil {
+		return nil, err
+	}
+	for _, field := range revisionColumns[entityType] {
+		oldText, newText := compare.Old.Snapshot[field], compare.New.Snapshot[field]
+		if oldText == newText {
+			continue
+		}
+		compare.Fields = append(compare.Fields, &RevisionFieldDiff{
+			Field: field,
+			Ops:   textdiff.DiffText(oldText, newText),
+		})
+	}
+	return compare, nil
+}
+
+// RollbackRevision 将对象恢复到指定版本，回滚本身也会记录为一个新版本
+// 只有对象的创建者和故事的创建者、拥有者可以回滚
+func (s *StoryService) RollbackRevision(ctx context.Context, entityType int, entityId int64, version int64) (*RevisionInfo, error) {
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entity, story, err := readableRevisionEntity(ctx, entityType, entityId)
+	if err != nil {
+		return nil, err
+	}
+	if entity.creatorId != userId && story.CreatorID != userId && story.OwnerID != userId {
+		return nil, errors.New("have no permission")
+	}
+	rev, err := models.GetRevision(ctx, entityType, entityId, version)
+	if err != nil {
+		return nil, err
+	}
+	if rev == nil {
+		return nil, errors.New("revision not found")
+	}
+	target := newRevisionInfo(rev)
+	columns := make(map[string]interface{})
+	for _, field := range revisionColumns[entityType] {
+		if v, ok := target.Snapshot[field]; ok {
+			columns[field] = v
+		}
+	}
+	var update models.RevisionUpdate
+	switch entityType {
+	case models.RevisionEntityStoryboard:
+		update = models.StoryboardColumnsUpdate(entityId, columns)
+	case models.RevisionEntityScene:
+		update = models.SceneColumnsUpdate(entityId, columns)
+	case models.RevisionEntityRole:
+		update = models.RoleColumnsUpdate(entityId, columns)
+	}
+	err = saveWithRevision(ctx, update, entity, entity.withColumns(columns), &revisionSource{
+		source:      models.RevisionSourceRollback,
+		authorId:    userId,
+		baseVersion: version,
+	})
+	if err != nil {
+		log.Log().Error("rollback revision failed", zap.Int("entity_type", entityType),
+			zap.Int64("entity_id", entityId), zap.Int64("version", version), zap.Error(err))
+		return nil, err
+	}
+	switch entityType {
+	case models.RevisionEntityStoryboard:
+		search.Sync(search.KindStoryBoard, entityId)
+	case models.RevisionEntityRole:
+		search.Sync(search.KindRole, entityId)
+	}
+	current, err := loadRevisionEntity(ctx, entityType, entityId)
+	if err != nil {
+		return nil, err
+	}
+	return currentRevisionInfo(current), nil
+}
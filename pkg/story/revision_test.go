@@ -0,0 +1,29 @@
+package story
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/models"
+)
+
+func TestRevisionWithColumns(t *testing.T) {
+	board := &models.StoryBoard{Title: "旧标题", Description: "描述"}
+	before := boardRevision(board)
+	after := before.withColumns(map[string]interface{}{
+		"title":     "新标题",
+		"stage":     "untracked",
+		"avatar":    123,
+		"update_at": "now",
+	})
+	assert.Equal(t, "新标题", after["title"])
+	assert.Equal(t, "描述", after["description"])
+	assert.Equal(t, "", after["avatar"])
+	assert.NotContains(t, after, "stage")
+	assert.NotContains(t, after, "update_at")
+	// 修改前的快照不变
+	assert.Equal(t, "旧标题", before.snapshot["title"])
+	assert.False(t, sameSnapshot(before.snapshot, after))
+	assert.True(t, sameSnapshot(before.snapshot, before.withColumns(map[string]interface{}{"title": "旧标题"})))
+}
@@ -0,0 +1,198 @@
+package story
+
+// 场景图片的局部修改：在已生成的图片上局部重绘、按指令修改、风格重绘或扩图，
+// 每次编辑的结果记录为场景的一张新候选图片，来源图片记录在 ParentID
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/pkg/storage"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	sceneEditPromptMaxLen = 500
+	maxExpandScale        = 2.0
+)
+
+// EditSceneImageParams 编辑场景图片的参数
+type EditSceneImageParams struct {
+	SceneId     int64               `json:"scene_id"`
+	CandidateId int64               `json:"candidate_id"` // 要编辑的候选图片，为0时编辑场景当前的图片
+	Mode        string              `json:"mode"`         // 见 client.EditMode*
+	Prompt      string              `json:"prompt"`
+	MaskImage   string              `json:"mask_image"` // 局部重绘的掩码图片，白色为重绘区域
+	StyleRef    string              `json:"style_ref"`  // 风格重绘的风格参考图
+	Style       int                 `json:"style"`      // 风格重绘的预置风格，StyleRef 为空时使用
+	Expand      *client.ExpandScale `json:"expand"`
+	Apply       bool                `json:"apply"` // 编辑完成后直接作为场景的图片
+}
+
+// isImageUrl 是否为 http(s) 图片地址
+func isImageUrl(image string) bool {
+	u, err := url.Parse(image)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validate 检查编辑方式需要的参数
+func (p *EditSceneImageParams) validate() error {
+	p.Prompt = truncateRunes(strings.TrimSpace(p.Prompt), sceneEditPromptMaxLen)
+	switch p.Mode {
+	case client.EditModeInpaint:
+		if !isImageUrl(p.MaskImage) {
+			return errors.New("inpaint needs a mask image")
+		}
+		if p.Prompt == "" {
+			return errors.New("prompt is empty")
+		}
+	case client.EditModeInstruction:
+		if p.Prompt == "" {
+			return errors.New("prompt is empty")
+		}
+	case client.EditModeRestyle:
+		if p.StyleRef != "" && !isImageUrl(p.StyleRef) {
+			return errors.New("invalid style reference image")
+		}
+		if p.StyleRef == "" && p.Style < 0 {
+			return errors.New("invalid style")
+		}
+	case client.EditModeExpand:
+		if p.Expand == nil {
+			return errors.New("expand needs scales")
+		}
+		scales := []float64{p.Expand.Top, p.Expand.Bottom, p.Expand.Left, p.Expand.Right}
+		extended := false
+		for _, scale := range scales {
+			if scale < 1 || scale > maxExpandScale {
+				return fmt.Errorf("expand scale must be between 1 and %v", maxExpandScale)
+			}
+			extended = extended || scale > 1
+		}
+		if !extended {
+			return errors.New("expand scales are all 1")
+		}
+	default:
+		return fmt.Errorf("unknown edit mode %s", p.Mode)
+	}
+	return nil
+}
+
+// sceneEditBase 获取要编辑的图片地址和它的候选ID，没有指定候选时使用场景当前的图片
+func sceneEditBase(ctx context.Context, scene *models.StoryBoardScene, candidateId int64) (string, int64, error) {
+	if candidateId == 0 {
+		candidateId = scene.SelectedImageID
+	}
+	if candidateId != 0 {
+		imageGen, err := sceneCandidate(ctx, scene, candidateId)
+		if err != nil {
+			return "", 0, err
+		}
+		if imageGen.Status != models.MediaGenStatusSucceed || imageGen.ImageUrl == "" {
+			return "", 0, errors.New("candidate is not ready")
+		}
+		return strings.Split(imageGen.ImageUrl, ",")[0], candidateId, nil
+	}
+	var urls []string
+	if err := json.Unmarshal([]byte(scene.GenResult), &urls); err != nil || len(urls) == 0 || urls[0] == "" {
+		return "", 0, errors.New("scene has no image to edit")
+	}
+	return urls[0], 0, nil
+}
+
+// EditSceneImage 编辑场景的图片，结果作为场景的新候选图片；
+// 平台在等待时间内没有完成时返回生成中的候选，由 syncworker 回写结果，此时 Apply 不生效，需要完成后再选定
+func (s *StoryService) EditSceneImage(ctx context.Context, params *EditSceneImageParams) (*SceneCandidate, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	scene, err := sceneForCandidates(ctx, params.SceneId, true)
+	if err != nil {
+		return nil, err
+	}
+	baseImage, parentId, err := sceneEditBase(ctx, scene, params.CandidateId)
+	if err != nil {
+		return nil, err
+	}
+	imageGen := &models.ImageGen{
+		OriginID: scene.StoryId,
+		BoardID:  scene.BoardId,
+		SceneID:  int64(scene.ID),
+		Batch:    uuid.New().String(),
+		ParentID: parentId,
+		EditMode: params.Mode,
+		Uuid:     uuid.New().String(),
+		Prompt:   params.Prompt,
+	}
+	ret, platform, err := s.editImage(ctx, &client.ImageEditParams{
+		Mode:      params.Mode,
+		Image:     baseImage,
+		Prompt:    params.Prompt,
+		MaskImage: params.MaskImage,
+		StyleRef:  params.StyleRef,
+		Style:     params.Style,
+		Expand:    params.Expand,
+		UserId:    fmt.Sprintf("grapery_scene_%d", scene.ID),
+		RequestId: imageGen.Uuid,
+	})
+	imageGen.Platform = platform
+	if err != nil {
+		log.Log().Error("edit scene image failed", zap.Int64("scene_id", params.SceneId), zap.Error(err))
+		s.saveSceneEdit(ctx, imageGen, models.MediaGenStatusFailed, nil, err.Error())
+		return nil, err
+	}
+	urls := ret.ImageUrls
+	if len(urls) == 0 && ret.TaskId != "" {
+		imageGen.TaskID = ret.TaskId
+		urls, err = s.waitImageTask(ctx, platform, ret.TaskId)
+		if errors.Is(err, context.DeadlineExceeded) {
+			if err := s.saveSceneEdit(ctx, imageGen, models.MediaGenStatusPending, nil, ""); err != nil {
+				return nil, err
+			}
+			return newSceneCandidate(scene, imageGen), nil
+		}
+		if err != nil {
+			s.saveSceneEdit(ctx, imageGen, models.MediaGenStatusFailed, nil, err.Error())
+			return nil, err
+		}
+	}
+	storeUrls := mirrorToStorage(ctx, storage.DirImages, urls)
+	if len(storeUrls) == 0 {
+		err := errors.New("upload edited image to storage failed")
+		s.saveSceneEdit(ctx, imageGen, models.MediaGenStatusFailed, nil, err.Error())
+		return nil, err
+	}
+	if err := s.saveSceneEdit(ctx, imageGen, models.MediaGenStatusSucceed, storeUrls, ""); err != nil {
+		return nil, err
+	}
+	if !moderateImageGen(ctx, imageGen) {
+		return nil, errImageHeld
+	}
+	if params.Apply {
+		if err := selectSceneImage(ctx, scene, imageGen); err != nil {
+			return nil, err
+		}
+	}
+	return newSceneCandidate(scene, imageGen), nil
+}
+
+// saveSceneEdit 记录一次编辑的结果
+func (s *StoryService) saveSceneEdit(ctx context.Context, imageGen *models.ImageGen, status int, urls []string, message string) error {
+	imageGen.Status = status
+	imageGen.ImageUrl = strings.Join(urls, ",")
+	imageGen.Message = message
+	if _, err := models.CreateImageGen(ctx, imageGen); err != nil {
+		log.Log().Error("create image gen failed", zap.Int64("scene_id", imageGen.SceneID), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package story
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grapery/grapery/pkg/client"
+)
+
+func TestEditSceneImageParamsValidate(t *testing.T) {
+	mask := "https://example.com/mask.png"
+	tests := []struct {
+		name    string
+		params  EditSceneImageParams
+		wantErr bool
+	}{
+		{"inpaint", EditSceneImageParams{Mode: client.EditModeInpaint, MaskImage: mask, Prompt: "红色的门"}, false},
+		{"inpaint without mask", EditSceneImageParams{Mode: client.EditModeInpaint, Prompt: "红色的门"}, true},
+		{"inpaint with bad mask", EditSceneImageParams{Mode: client.EditModeInpaint, MaskImage: "file:///etc/passwd", Prompt: "门"}, true},
+		{"inpaint without prompt", EditSceneImageParams{Mode: client.EditModeInpaint, MaskImage: mask, Prompt: "  "}, true},
+		{"instruction", EditSceneImageParams{Mode: client.EditModeInstruction, Prompt: "改成夜晚"}, false},
+		{"instruction without prompt", EditSceneImageParams{Mode: client.EditModeInstruction}, true},
+		{"restyle preset", EditSceneImageParams{Mode: client.EditModeRestyle, Style: 3}, false},
+		{"restyle reference", EditSceneImageParams{Mode: client.EditModeRestyle, StyleRef: mask}, false},
+		{"restyle bad reference", EditSceneImageParams{Mode: client.EditModeRestyle, StyleRef: "style.png"}, true},
+		{"restyle bad style", EditSceneImageParams{Mode: client.EditModeRestyle, Style: -1}, true},
+		{"expand", EditSceneImageParams{Mode: client.EditModeExpand, Expand: &client.ExpandScale{Top: 1, Bottom: 1, Left: 1.5, Right: 1.5}}, false},
+		{"expand without scales", EditSceneImageParams{Mode: client.EditModeExpand}, true},
+		{"expand nothing", EditSceneImageParams{Mode: client.EditModeExpand, Expand: &client.ExpandScale{Top: 1, Bottom: 1, Left: 1, Right: 1}}, true},
+		{"expand too large", EditSceneImageParams{Mode: client.EditModeExpand, Expand: &client.ExpandScale{Top: 3, Bottom: 1, Left: 1, Right: 1}}, true},
+		{"unknown mode", EditSceneImageParams{Mode: "blur"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.params.validate()
+		assert.Equal(t, tt.wantErr, err != nil, tt.name)
+	}
+
+	params := &EditSceneImageParams{Mode: client.EditModeInstruction, Prompt: "  " + strings.Repeat("云", sceneEditPromptMaxLen+5) + " "}
+	assert.NoError(t, params.validate())
+	assert.Equal(t, strings.Repeat("云", sceneEditPromptMaxLen), params.Prompt)
+}
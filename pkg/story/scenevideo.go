@@ -0,0 +1,154 @@
+package story
+
+// 场景视频：以场景当前的图片为首帧提交平台的图生视频任务，记录为 VideoGen，
+// 由 syncworker 查询结果、转存到对象存储后写入场景
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/client"
+	"github.com/grapery/grapery/utils/log"
+)
+
+const (
+	sceneVideoPromptMaxLen = 800
+	sceneVideoDuration     = 5
+	sceneVideoResolution   = "720P"
+)
+
+// SceneVideo 场景的一次视频生成，Status 见 models.MediaGenStatus*
+type SceneVideo struct {
+	Id         int64  `json:"id"`
+	SceneId    int64  `json:"scene_id"`
+	Status     int    `json:"status"`
+	Platform   string `json:"platform"`
+	Prompt     string `json:"prompt"`
+	FirstFrame string `json:"first_frame"`
+	VideoUrl   string `json:"video_url,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Ctime      int64  `json:"ctime"`
+}
+
+func newSceneVideo(videoGen *models.VideoGen) *SceneVideo {
+	video := &SceneVideo{
+		Id:         int64(videoGen.ID),
+		SceneId:    videoGen.SceneID,
+		Status:     videoGen.Status,
+		Platform:   videoGen.Platform,
+		Prompt:     videoGen.Prompt,
+		FirstFrame: videoGen.FisrtFrame,
+		VideoUrl:   videoGen.VideoUrl,
+		Ctime:      videoGen.CreateAt.Unix(),
+	}
+	if videoGen.Status == models.MediaGenStatusFailed {
+		video.Message = videoGen.Message
+	}
+	return video
+}
+
+// sceneVideoPrompt 场景的视频提示词，没有时使用场景内容
+func sceneVideoPrompt(scene *models.StoryBoardScene) string {
+	prompt := scene.VideoPrompts
+	if prompt == "" {
+		prompt = scene.Content
+	}
+	return truncateRunes(prompt, sceneVideoPromptMaxLen)
+}
+
+// RenderSceneVideo 以场景当前的图片为首帧生成视频，platform 为空时使用阿里云
+func (s *StoryService) RenderSceneVideo(ctx context.Context, sceneId int64, platform string) (*SceneVideo, error) {
+	if platform == "" {
+		platform = client.PlatformNameAliyun
+	}
+	if platform != client.PlatformNameAliyun && platform != client.PlatformNameDoubao {
+		return nil, fmt.Errorf("%w: %s video", client.ErrNotSupported, platform)
+	}
+	scene, err := sceneForCandidates(ctx, sceneId, true)
+	if err != nil {
+		return nil, err
+	}
+	firstFrame, _, err := sceneEditBase(ctx, scene, 0)
+	if err != nil {
+		return nil, err
+	}
+	videoGen := &models.VideoGen{
+		OriginID:   scene.StoryId,
+		BoardID:    scene.BoardId,
+		SceneID:    int64(scene.ID),
+		Platform:   platform,
+		Uuid:       uuid.New().String(),
+		Prompt:     sceneVideoPrompt(scene),
+		FisrtFrame: firstFrame,
+		Timelength: sceneVideoDuration,
+	}
+	videoGen.TaskID, err = s.submitSceneVideo(ctx, videoGen)
+	if err != nil {
+		log.Log().Error("submit scene video failed", zap.Int64("scene_id", sceneId),
+			zap.String("platform", platform), zap.Error(err))
+		return nil, err
+	}
+	videoGen.Status = models.MediaGenStatusPending
+	if _, err := models.CreateVideoGen(ctx, videoGen); err != nil {
+		log.Log().Error("create video gen failed", zap.Int64("scene_id", sceneId), zap.Error(err))
+		return nil, err
+	}
+	return newSceneVideo(videoGen), nil
+}
+
+// submitSceneVideo 提交平台的图生视频任务，返回平台的任务ID
+func (s *StoryService) submitSceneVideo(ctx context.Context, videoGen *models.VideoGen) (string, error) {
+	switch videoGen.Platform {
+	case client.PlatformNameAliyun:
+		ret, err := s.bailianClient.GenVideoFromFirstFrame(ctx, &client.DashScopeVideoRequestBody{
+			Input: client.DashScopeVideoInput{
+				Prompt:   videoGen.Prompt,
+				ImageURL: videoGen.FisrtFrame,
+			},
+			Parameters: client.DashScopeVideoParameters{
+				Resolution: sceneVideoResolution,
+				Duration:   videoGen.Timelength,
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+		if ret.Output.TaskID == "" {
+			return "", fmt.Errorf("aliyun video task not created: %s %s", ret.Code, ret.Message)
+		}
+		return ret.Output.TaskID, nil
+	case client.PlatformNameDoubao:
+		ret, err := s.doubaoClient.GenStoryboardVideo(ctx, &client.GenStoryboardVideoParams{
+			Content:     videoGen.Prompt,
+			RefImageUrl: videoGen.FisrtFrame,
+		})
+		if err != nil {
+			return "", err
+		}
+		if ret.ID == "" {
+			return "", errors.New("doubao video task not created")
+		}
+		return ret.ID, nil
+	}
+	return "", fmt.Errorf("%w: %s video", client.ErrNotSupported, videoGen.Platform)
+}
+
+// GetSceneVideo 场景最近一次的视频生成，没有时返回nil
+func (s *StoryService) GetSceneVideo(ctx context.Context, sceneId int64) (*SceneVideo, error) {
+	if _, err := sceneForCandidates(ctx, sceneId, false); err != nil {
+		return nil, err
+	}
+	videoGen, err := models.GetLatestSceneVideoGen(ctx, sceneId)
+	if err != nil {
+		return nil, err
+	}
+	if videoGen == nil {
+		return nil, nil
+	}
+	return newSceneVideo(videoGen), nil
+}
@@ -0,0 +1,127 @@
+package story
+
+// 大模型结构化输出的校验规则，与 types.go 中的json tag一致
+
+import (
+	"github.com/grapery/grapery/utils/llmjson"
+)
+
+// parseRetries 输出校验失败后带上错误重新生成的次数，对应配置 llm.parse_retries
+var parseRetries = 2
+
+var characterSchema = llmjson.Object(map[string]*llmjson.Schema{
+	"角色id": llmjson.String(),
+	"角色姓名": llmjson.String(),
+	"角色描述": llmjson.String(),
+}, "角色姓名").WithAliases(map[string]string{
+	"角色ID":        "角色id",
+	"id":          "角色id",
+	"角色名称":        "角色姓名",
+	"角色名":         "角色姓名",
+	"name":        "角色姓名",
+	"description": "角色描述",
+})
+
+var chapterSummarySchema = llmjson.Object(map[string]*llmjson.Schema{
+	"章节题目": llmjson.String(),
+	"章节内容": llmjson.String(),
+	"参与人物": llmjson.Array(characterSchema, 0),
+}, "章节题目", "章节内容").WithAliases(map[string]string{
+	"章节标题":    "章节题目",
+	"title":   "章节题目",
+	"content": "章节内容",
+})
+
+var detailSceneSchema = llmjson.Object(map[string]*llmjson.Schema{
+	"情节id":  llmjson.String(),
+	"情节内容":  llmjson.String(),
+	"参与人物":  llmjson.Array(characterSchema, 0),
+	"图片提示词": llmjson.String(),
+}, "情节内容").WithAliases(map[string]string{
+	"情节ID":         "情节id",
+	"id":           "情节id",
+	"content":      "情节内容",
+	"图像提示词":        "图片提示词",
+	"image_prompt": "图片提示词",
+})
+
+// storyChapterSchema 对应 StoryChapter
+var storyChapterSchema = llmjson.Object(map[string]*llmjson.Schema{
+	chapterSummaryKey: chapterSummarySchema,
+	chapterDetailKey:  llmjson.Array(detailSceneSchema, 1),
+}, chapterSummaryKey, chapterDetailKey).WithAliases(map[string]string{
+	"章节简述":    chapterSummaryKey,
+	"章节情节":    chapterDetailKey,
+	"详细情节":    chapterDetailKey,
+	"情节列表":    chapterDetailKey,
+	"summary": chapterSummaryKey,
+	"scenes":  chapterDetailKey,
+})
+
+// storyChapterV2Schema 对应 StoryChapterV2
+var storyChapterV2Schema = llmjson.Object(map[string]*llmjson.Schema{
+	chapterSummaryKey:    chapterSummarySchema,
+	chapterCharactersKey: llmjson.Array(characterSchema, 0),
+}, chapterSummaryKey).WithAliases(map[string]string{
+	"章节简述":       chapterSummaryKey,
+	"summary":    chapterSummaryKey,
+	"characters": chapterCharactersKey,
+})
+
+// characterDetailSchema 对应 CharacterDetail，英文字段名与 CharacterDetailConverter 一致
+var characterDetailSchema = llmjson.Object(map[string]*llmjson.Schema{
+	"角色描述":   llmjson.String(),
+	"角色短期目标": llmjson.String(),
+	"角色长期目标": llmjson.String(),
+	"性格特征":   llmjson.String(),
+	"角色背景":   llmjson.String(),
+	"处事风格":   llmjson.String(),
+	"认知范围":   llmjson.String(),
+	"能力特点":   llmjson.String(),
+	"外貌特征":   llmjson.String(),
+	"穿着喜好":   llmjson.String(),
+}, "角色描述").WithAliases(map[string]string{
+	"description":      "角色描述",
+	"short_term_goal":  "角色短期目标",
+	"long_term_goal":   "角色长期目标",
+	"personality":      "性格特征",
+	"background":       "角色背景",
+	"handling_style":   "处事风格",
+	"cognition_range":  "认知范围",
+	"ability_features": "能力特点",
+	"appearance":       "外貌特征",
+	"dress_preference": "穿着喜好",
+	"短期目标":             "角色短期目标",
+	"长期目标":             "角色长期目标",
+	"性格":               "性格特征",
+	"背景":               "角色背景",
+	"外貌":               "外貌特征",
+})
+
+var chapterInfoSchema = llmjson.Object(map[string]*llmjson.Schema{
+	"章节ID": llmjson.String(),
+	"章节题目": llmjson.String(),
+	"章节内容": llmjson.String(),
+}, "章节题目").WithAliases(map[string]string{
+	"章节id":    "章节ID",
+	"id":      "章节ID",
+	"章节标题":    "章节题目",
+	"title":   "章节题目",
+	"content": "章节内容",
+})
+
+// storyInfoSchema 对应 StoryInfo
+var storyInfoSchema = llmjson.Object(map[string]*llmjson.Schema{
+	"故事名称和主题": llmjson.Object(map[string]*llmjson.Schema{
+		"故事名称": llmjson.String(),
+		"故事主题": llmjson.String(),
+		"故事简介": llmjson.String(),
+	}, "故事名称").WithAliases(map[string]string{
+		"name":        "故事名称",
+		"theme":       "故事主题",
+		"description": "故事简介",
+	}),
+	"故事章节": llmjson.Array(chapterInfoSchema, 1),
+}, "故事名称和主题", "故事章节").WithAliases(map[string]string{
+	"chapters": "故事章节",
+})
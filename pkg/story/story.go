@@ -13,11 +13,17 @@ import (
 	api "github.com/grapery/common-protoc/gen"
 	"github.com/grapery/grapery/models"
 	"github.com/grapery/grapery/pkg/active"
+	"github.com/grapery/grapery/pkg/block"
 	"github.com/grapery/grapery/pkg/client"
 	"github.com/grapery/grapery/pkg/cloud/coze"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/pkg/search"
 	"github.com/grapery/grapery/utils"
 	"github.com/grapery/grapery/utils/compliance"
 	"github.com/grapery/grapery/utils/convert"
+	"github.com/grapery/grapery/utils/elastic"
+	"github.com/grapery/grapery/utils/export"
+	"github.com/grapery/grapery/utils/llmjson"
 	"github.com/grapery/grapery/utils/log"
 	"github.com/grapery/grapery/utils/prompt"
 )
@@ -57,6 +63,41 @@ type StoryServer interface {
 	GetStoryBoardRender(ctx context.Context, req *api.GetStoryBoardRenderRequest) (*api.GetStoryBoardRenderResponse, error)
 
 	ContinueRenderStory(ctx context.Context, req *api.ContinueRenderStoryRequest) (*api.ContinueRenderStoryResponse, error)
+	RenderStoryboardStream(ctx context.Context, req *api.RenderStoryboardRequest, send func(*StoryboardStreamEvent) error) error
+	ContinueRenderStoryStream(ctx context.Context, req *api.ContinueRenderStoryRequest, send func(*StoryboardStreamEvent) error) error
+	ExportStory(ctx context.Context, storyId, boardId int64, format export.Format) (*export.File, error)
+	ImportStory(ctx context.Context, params *ImportStoryParams) (*ImportStoryResult, error)
+	GetStoryBranchTree(ctx context.Context, storyId int64) (*BranchTree, error)
+	GetStoryboardPath(ctx context.Context, boardId int64) ([]*BranchNode, error)
+	DiffStoryBranches(ctx context.Context, boardId, otherBoardId int64) (*BranchDiff, error)
+	ListRevisions(ctx context.Context, entityType int, entityId int64, offset, pageSize int) (*RevisionList, error)
+	CompareRevisions(ctx context.Context, entityType int, entityId int64, version, otherVersion int64) (*RevisionCompare, error)
+	RollbackRevision(ctx context.Context, entityType int, entityId int64, version int64) (*RevisionInfo, error)
+	ListSceneCandidates(ctx context.Context, sceneId int64) (*SceneCandidateList, error)
+	SelectSceneCandidate(ctx context.Context, sceneId, candidateId int64) (*SceneCandidate, error)
+	StarSceneCandidate(ctx context.Context, sceneId, candidateId int64, starred bool) (*SceneCandidate, error)
+	DiscardSceneCandidate(ctx context.Context, sceneId, candidateId int64) error
+	EditSceneImage(ctx context.Context, params *EditSceneImageParams) (*SceneCandidate, error)
+	RenderSceneVideo(ctx context.Context, sceneId int64, platform string) (*SceneVideo, error)
+	GetSceneVideo(ctx context.Context, sceneId int64) (*SceneVideo, error)
+	RecallChatMemory(ctx context.Context, chatCtx *models.ChatContext, query string, currentId int64) (*ChatMemoryRecall, error)
+	RememberChatTurn(ctx context.Context, chatCtx *models.ChatContext, userMsg, replyMsg *models.ChatMessage)
+	ReplyChatMessage(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage) (*models.ChatMessage, error)
+	CreateGroupChat(ctx context.Context, params *CreateGroupChatParams) (*GroupChatInfo, error)
+	GetGroupChats(ctx context.Context, offset, pageSize int) (*GroupChatList, error)
+	UpdateGroupChat(ctx context.Context, chatId int64, roleIds []int64, turnPolicy string) (*GroupChatInfo, error)
+	SendGroupChatMessage(ctx context.Context, chatId int64, content, uuid string) (*GroupChatReply, error)
+	GetGroupChatMessages(ctx context.Context, chatId, beforeId int64, pageSize int) ([]*GroupChatMessage, error)
+	RegenerateChatReply(ctx context.Context, chatId int64) (*ChatTurn, error)
+	ListChatReplyAlternatives(ctx context.Context, chatId, messageId int64) (*ChatReplyAlternatives, error)
+	SelectChatReply(ctx context.Context, chatId, messageId int64) (*ChatTurn, error)
+	EditChatMessage(ctx context.Context, chatId, messageId int64, content string) (*ChatTurn, error)
+	ForkChatContext(ctx context.Context, chatId, messageId int64) (*ChatForkInfo, error)
+	UpdateChatAgent(ctx context.Context, chatId int64, useAgent bool, agentPrompt string) error
+	SendChatAgentMessage(ctx context.Context, chatId int64, content, uuid string) (*AgentTurn, error)
+	AcceptStoryboardProposal(ctx context.Context, chatId, messageId int64) (*StoryboardProposal, error)
+	SendChatMessage(ctx context.Context, chatId int64, content string, images []string, uuid string) (*ChatTurn, error)
+	GetChatMessage(ctx context.Context, chatId, messageId int64) (*ChatMessageItem, error)
 
 	GetStoryboardScene(ctx context.Context, req *api.GetStoryBoardSencesRequest) (*api.GetStoryBoardSencesResponse, error)
 	CreateStoryBoardScene(ctx context.Context, req *api.CreateStoryBoardSenceRequest) (*api.CreateStoryBoardSenceResponse, error)
@@ -129,13 +170,16 @@ type StoryService struct {
 }
 
 func NewStoryService() *StoryService {
-	return &StoryService{
+	s := &StoryService{
 		zhipuClient: client.NewStoryClient(
 			client.PlatformZhipu,
 		),
 		bailianClient: client.NewAliyunClient(),
 		doubaoClient:  client.NewDoubaoClient(),
+		cozeClient:    coze.GetCozeClient(),
 	}
+	s.registerBuiltinProviders()
+	return s
 }
 
 func ConvertStoryToApiStory(story *models.Story) *api.Story {
@@ -178,34 +222,26 @@ func ConvertApiStoryToStory(apiStory *api.Story) *models.Story {
 }
 
 func (s *StoryService) CreateStory(ctx context.Context, req *api.CreateStoryRequest) (resp *api.CreateStoryResponse, err error) {
-	err = compliance.GetComplianceTool().TextCompliance(req.GetShortDesc())
-	if err != nil {
-		return nil, err
-	}
 	if !req.GetIsAiGen() {
 		log.Log().Info("not AI gen story task")
 		return nil, fmt.Errorf("not AI gen story task")
 	}
-	if req.GetParams().Background != "" {
-		err = compliance.GetComplianceTool().TextCompliance(req.GetParams().Background)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		req.Params.Background = req.Origin
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityStory,
+		Field:      "story",
+		GroupId:    req.GetGroupId(),
+		AuthorId:   req.GetCreatorId(),
 	}
-	if req.GetParams().StoryDescription != "" {
-		err = compliance.GetComplianceTool().TextCompliance(req.GetParams().StoryDescription)
-		if err != nil {
-			return nil, err
-		}
+	texts := []string{req.GetTitle(), req.GetShortDesc(), req.GetOrigin(),
+		req.GetParams().Background, req.GetParams().StoryDescription, req.GetParams().NegativePrompt}
+	review, err := moderation.CheckText(ctx, target, texts...)
+	if err != nil {
+		return nil, err
 	}
-	if req.GetParams().NegativePrompt != "" {
-		err = compliance.GetComplianceTool().TextCompliance(req.GetParams().NegativePrompt)
-		if err != nil {
-			return nil, err
-		}
-	} else {
+	if req.GetParams().Background == "" {
+		req.Params.Background = req.Origin
+	}
+	if req.GetParams().NegativePrompt == "" {
 		req.Params.NegativePrompt = models.NegativePrompt
 	}
 	group := &models.Group{}
@@ -259,7 +295,15 @@ func (s *StoryService) CreateStory(ctx context.Context, req *api.CreateStoryRequ
 		log.Log().Error("watch story failed", zap.Error(err))
 	}
 	newStory.ID = uint(storyId)
-	active.GetActiveServer().WriteStoryActive(ctx, group, newStory, nil, nil, req.GetCreatorId(), api.ActiveType_NewStory)
+	target.EntityId = int64(storyId)
+	target.StoryId = int64(storyId)
+	if review.Verdict != compliance.VerdictPass {
+		// 审核通过前不公开，也不写入动态
+		_ = moderation.Hold(ctx, target, review, strings.Join(texts, "\n"))
+	} else {
+		active.GetActiveServer().WriteStoryActive(ctx, group, newStory, nil, nil, req.GetCreatorId(), api.ActiveType_NewStory)
+	}
+	search.Sync(search.KindStory, int64(storyId))
 	return &api.CreateStoryResponse{
 		Code:    0,
 		Message: "create story success",
@@ -322,10 +366,24 @@ func (s *StoryService) UpdateStory(ctx context.Context, req *api.UpdateStoryRequ
 	if len(needUpdateData) == 0 {
 		return &api.UpdateStoryResponse{}, nil
 	}
+	userId, _ := utils.GetUserIDFromContext(ctx)
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityStory,
+		EntityId:   req.StoryId,
+		Field:      "story",
+		StoryId:    req.StoryId,
+		AuthorId:   userId,
+	}
+	review, err := moderation.CheckText(ctx, target, req.GetShortDesc(), req.GetOrigin())
+	if err != nil {
+		return nil, err
+	}
 	err = models.UpdateStorySpecColumns(ctx, req.StoryId, needUpdateData)
 	if err != nil {
 		return nil, err
 	}
+	_ = moderation.Hold(ctx, target, review, strings.Join([]string{req.GetShortDesc(), req.GetOrigin()}, "\n"))
+	search.Sync(search.KindStory, req.StoryId)
 
 	return &api.UpdateStoryResponse{
 		Code:    0,
@@ -489,23 +547,41 @@ func (s *StoryService) RenderStory(ctx context.Context, req *api.RenderStoryRequ
 		return nil, err
 	}
 	renderDetail := new(api.RenderStoryDetail)
-	renderStoryParams := coze.CozeStoryWriteParams{
-		StoryTitle: story.Title,
-		StoryDesc:  story.Origin,
+	renderStoryParams := &client.StoryParams{
+		Title:       story.Title,
+		Description: story.Origin,
 	}
 	start := time.Now()
 	var (
 		resp         = &api.RenderStoryResponse{}
 		storyContent string
+		result       = new(StoryInfo)
 	)
 	if req.RenderType == api.RenderType_RENDER_TYPE_TEXT_UNSPECIFIED {
 		renderDetail.StoryId = req.StoryId
 		renderDetail.BoardId = req.BoardId
-		storyContent, err = s.cozeClient.StoryWrite(ctx, renderStoryParams)
+		// 返回内容无法解析时带上错误重新生成，仍然失败时切换到下一个平台
+		platform, err := s.failover(ctx, SceneStory, func(ctx context.Context, p client.Provider) error {
+			info := new(StoryInfo)
+			content, err := llmjson.ParseWithRetry(ctx, storyInfoSchema, info, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+				params := *renderStoryParams
+				params.Feedback = feedback
+				return s.writeStory(ctx, p, &params)
+			})
+			if err != nil {
+				log.Log().Error("parse story gen result failed", zap.String("platform", p.Name()), zap.Error(err))
+				return err
+			}
+			result = info
+			storyContent = content
+			return nil
+		})
 		if err != nil {
 			log.Log().Error("gen story info failed", zap.Error(err))
+			failStoryGen(ctx, storyGen, err)
 			return nil, err
 		}
+		storyGen.LLmPlatform = platform
 	} else if req.RenderType == api.RenderType_RENDER_TYPE_STORYSENCE {
 		renderDetail.StoryId = req.StoryId
 		renderDetail.BoardId = req.BoardId
@@ -524,13 +600,6 @@ func (s *StoryService) RenderStory(ctx context.Context, req *api.RenderStoryRequ
 	}
 
 	// 渲染剧情
-	result := new(StoryInfo)
-	cleanResult := utils.CleanLLmJsonResult(storyContent)
-	err = json.Unmarshal([]byte(cleanResult), &result)
-	if err != nil {
-		log.Log().Error("unmarshal story gen result failed", zap.Error(err))
-		return nil, err
-	}
 	renderDetail.Text = storyContent
 	renderDetail.RenderType = req.RenderType
 	renderDetail.Timecost = int32(time.Since(start).Seconds())
@@ -727,13 +796,22 @@ func (s *StoryService) UnLikeStory(ctx context.Context, req *api.UnLikeStoryRequ
 }
 
 func (s *StoryService) SearchStories(ctx context.Context, req *api.SearchStoriesRequest) (*api.SearchStoriesResponse, error) {
-	stories, total, err := models.GetStoriesByName(ctx, req.GetKeyword(), int(req.GetOffset()), int(req.GetPageSize()))
+	stories, total, err := search.Stories(ctx, &elastic.Query{
+		Keyword: req.GetKeyword(),
+		Offset:  int(req.GetOffset()),
+		Size:    int(req.GetPageSize()),
+	})
 	if err != nil {
 		log.Log().Error("get story roles failed", zap.Error(err))
 		return nil, err
 	}
+	filter := block.ViewerFilter(ctx)
 	apiStories := make([]*api.Story, 0)
 	for _, story := range stories {
+		if filter.Hidden(story.CreatorID, story.Name, story.ShortDesc) {
+			total--
+			continue
+		}
 		info := convert.ConvertStoryToApiStory(story)
 		info.CurrentUserStatus, err = s.GetStoryCurrentUserStatus(ctx, int64(story.ID))
 		if err != nil {
@@ -750,13 +828,23 @@ func (s *StoryService) SearchStories(ctx context.Context, req *api.SearchStories
 }
 
 func (s *StoryService) SearchRoles(ctx context.Context, req *api.SearchRolesRequest) (*api.SearchRolesResponse, error) {
-	roles, total, err := models.GetStoryRolesByName(ctx, req.GetKeyword(), req.GetStoryId(), int(req.GetOffset()), int(req.GetPageSize()))
+	roles, total, err := search.Roles(ctx, &elastic.Query{
+		Keyword: req.GetKeyword(),
+		StoryId: req.GetStoryId(),
+		Offset:  int(req.GetOffset()),
+		Size:    int(req.GetPageSize()),
+	})
 	if err != nil {
 		log.Log().Error("get story roles failed", zap.Error(err))
 		return nil, err
 	}
+	filter := block.ViewerFilter(ctx)
 	apiRoles := make([]*api.StoryRole, 0)
 	for _, role := range roles {
+		if filter.Hidden(role.CreatorID, role.CharacterName, role.CharacterDescription) {
+			total--
+			continue
+		}
 		info := convert.ConvertStoryRoleToApiStoryRoleInfo(role)
 		if role.CharacterDetail != "" {
 			roleDetail := &CharacterDetailConverter{}
@@ -804,10 +892,9 @@ func (s *StoryService) UpdateStoryRoleAvator(ctx context.Context, req *api.Updat
 		fmt.Errorf("have no permission", roleinfo.CreatorID, req.GetUserId())
 		//return nil, errors.New("have no permission")
 	}
-	roleinfo.CharacterAvatar = req.GetAvator()
-	err = models.UpdateStoryRole(ctx, int64(roleinfo.ID), map[string]interface{}{
+	err = updateRoleColumns(ctx, roleinfo, map[string]interface{}{
 		"character_avatar": req.GetAvator(),
-	})
+	}, manualRevision(ctx))
 	if err != nil {
 		fmt.Errorf("update story role failed", zap.Error(err))
 		return nil, err
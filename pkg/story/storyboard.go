@@ -16,10 +16,13 @@ import (
 	"github.com/grapery/grapery/models"
 	"github.com/grapery/grapery/pkg/active"
 	"github.com/grapery/grapery/pkg/client"
-	"github.com/grapery/grapery/pkg/cloud/aliyun"
-	"github.com/grapery/grapery/pkg/cloud/coze"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/pkg/storage"
 	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/compliance"
 	"github.com/grapery/grapery/utils/convert"
+	"github.com/grapery/grapery/utils/llmjson"
 	"github.com/grapery/grapery/utils/log"
 	"github.com/grapery/grapery/utils/prompt"
 )
@@ -37,6 +40,17 @@ func (s *StoryService) CreateStoryboard(ctx context.Context, req *api.CreateStor
 			Message: "story is closed",
 		}, nil
 	}
+	target := &moderation.Target{
+		EntityType: models.ModerationEntityStoryboard,
+		Field:      "storyboard",
+		GroupId:    storyInfo.GroupID,
+		StoryId:    req.Board.StoryId,
+		AuthorId:   req.Board.Creator,
+	}
+	review, err := moderation.CheckText(ctx, target, newStroyBoard.Title, newStroyBoard.Description)
+	if err != nil {
+		return nil, err
+	}
 	newStroyBoard.IsAiGen = storyInfo.AIGen
 	newStroyBoard.StoryID = req.Board.StoryId
 	newStroyBoard.CreatorID = req.Board.Creator
@@ -48,6 +62,7 @@ func (s *StoryService) CreateStoryboard(ctx context.Context, req *api.CreateStor
 	}
 	log.Log().Info("create storyboard success", zap.Int64("storyBoardId", storyBoardId))
 	newStroyBoard.ID = uint(storyBoardId)
+	search.Sync(search.KindStoryBoard, storyBoardId)
 	if storyInfo.RootBoardID == 0 {
 		err = models.UpdateStorySpecColumns(ctx, req.Board.StoryId, map[string]interface{}{
 			"root_board_id": storyBoardId,
@@ -81,6 +96,18 @@ func (s *StoryService) CreateStoryboard(ctx context.Context, req *api.CreateStor
 	if err != nil {
 		log.Log().Error("increment created board num failed", zap.Error(err))
 	}
+	if review.Verdict != compliance.VerdictPass {
+		// 审核通过前不公开，也不写入动态
+		target.EntityId = storyBoardId
+		_ = moderation.Hold(ctx, target, review, newStroyBoard.Title+"\n"+newStroyBoard.Description)
+		return &api.CreateStoryboardResponse{
+			Code:    0,
+			Message: "storyboard is under review",
+			Data: &api.CreateStoryboardResponse_Data{
+				BoardId: storyBoardId,
+			},
+		}, nil
+	}
 	group := &models.Group{}
 	group.ID = uint(storyInfo.GroupID)
 	err = group.GetByID()
@@ -181,7 +208,7 @@ func (s *StoryService) UpdateStoryboard(ctx context.Context, req *api.UpdateStor
 	if len(needUpdateData) == 0 {
 		return &api.UpdateStoryboardResponse{}, nil
 	}
-	err = models.UpdateStoryboardMultiColumn(ctx, req.BoardId, needUpdateData)
+	err = updateStoryboardColumns(ctx, boardInfo, needUpdateData, manualRevision(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -307,6 +334,7 @@ func (s *StoryService) DelStoryboard(ctx context.Context, req *api.DelStoryboard
 	if err != nil {
 		return nil, err
 	}
+	search.Sync(search.KindStoryBoard, req.BoardId)
 	userProfile := &models.UserProfile{
 		UserId: int64(currentBoard.CreatorID),
 	}
@@ -346,6 +374,7 @@ func (s *StoryService) ForkStoryboard(ctx context.Context, req *api.ForkStoryboa
 		log.Log().Error("create new story board failed", zap.Error(err))
 		return nil, err
 	}
+	search.Sync(search.KindStoryBoard, id)
 	story, err := models.GetStory(ctx, originStoryBoard.StoryID)
 	if err != nil {
 		return nil, err
@@ -464,6 +493,36 @@ func (s *StoryService) RenderStoryboard(ctx context.Context, req *api.RenderStor
 			Message: "storyboard is rendering",
 		}, nil
 	}
+	if asyncRenderEnabled(ctx) {
+		job, err := models.GetLatestStoryGenJob(ctx, req.GetBoardId(), models.StoryGenTaskStoryboard)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil && (job.GenStatus == models.StoryGenStatusInit || job.GenStatus == models.StoryGenStatusRunning) {
+			return &api.RenderStoryboardResponse{
+				Code:    -1,
+				Message: "storyboard is rendering",
+			}, nil
+		}
+		_, err = s.enqueueRenderJob(ctx, models.StoryGenTaskStoryboard, req, &models.StoryGen{
+			BoardID:  req.GetBoardId(),
+			OriginID: int64(story.ID),
+			UserId:   req.GetUserId(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &api.RenderStoryboardResponse{
+			Code:    0,
+			Message: RenderJobQueued,
+			Data: &api.RenderStoryboardDetail{
+				RenderType: req.RenderType,
+				BoardId:    req.BoardId,
+				StoryId:    req.StoryId,
+				UserId:     req.UserId,
+			},
+		}, nil
+	}
 	genParams := new(models.StoryBoardParams)
 	genParams.StoryContent = story.Origin
 	err = json.Unmarshal([]byte(board.Params), genParams)
@@ -471,8 +530,7 @@ func (s *StoryService) RenderStoryboard(ctx context.Context, req *api.RenderStor
 		log.Log().Error("unmarshal storyboard gen params failed", zap.Error(err))
 		return nil, err
 	}
-	storyGen := new(models.StoryGen)
-	storyGen.Uuid = uuid.New().String()
+	storyGen := newRenderGen(ctx)
 	storyGenData, _ := json.Marshal(genParams)
 	storyParam := new(api.StoryParams)
 	json.Unmarshal([]byte(story.Params), &storyParam)
@@ -504,11 +562,13 @@ func (s *StoryService) RenderStoryboard(ctx context.Context, req *api.RenderStor
 		log.Log().Error("get story board roles failed", zap.Error(err))
 	}
 
-	var storyboardParams = coze.CozeStoryboardWriterParams{
-		StoryCharacters: storyRolesStr,
-		StoryContent:    "",
-		StoryBackground: story.Origin,
-		StoryChapter:    story.Title,
+	var storyboardParams = &client.StoryboardParams{
+		StoryName:   story.Title,
+		Title:       story.Title,
+		Characters:  storyRolesStr,
+		Background:  story.Origin,
+		ImageStyle:  imageStyle,
+		Description: "",
 	}
 	if board.PrevId != -1 && board.PrevId != 0 {
 		prevBoard, err := models.GetStoryboard(ctx, board.PrevId)
@@ -516,41 +576,53 @@ func (s *StoryService) RenderStoryboard(ctx context.Context, req *api.RenderStor
 			log.Log().Error("get prev storyboard failed", zap.Error(err))
 			return nil, err
 		}
-		storyboardParams.StoryContent = prevBoard.Description
-	} else {
+		if prevBoard != nil {
+			storyboardParams.PrevContent = prevBoard.Description
+		}
+	}
+	if storyboardParams.PrevContent == "" {
 		storyboardParams.PrevContent = "暂无上一章节"
 	}
-	storyGen.LLmPlatform = "coze"
+	positivePrompt, _ := json.Marshal(storyboardParams)
+	storyGen.GenStatus = models.StoryGenStatusRunning
 	storyGen.NegativePrompt = ""
-	storyGen.PositivePrompt = storyboardParams.String()
+	storyGen.PositivePrompt = string(positivePrompt)
 	storyGen.Regen = 0
-	storyGen.Params = string(storyGenData)
 	storyGen.OriginID = int64(story.ID)
 	storyGen.StartTime = time.Now().Unix()
 	storyGen.BoardID = req.GetBoardId()
 	storyGen.GenType = int(req.GetRenderType())
-	storyGen.TaskType = 2
-	_, err = models.CreateStoryGen(ctx, storyGen)
+	storyGen.TaskType = models.StoryGenTaskStoryboard
+	err = saveRenderGen(ctx, storyGen, string(storyGenData))
 	if err != nil {
 		log.Log().Error("create storyboard gen failed", zap.Error(err))
 		return nil, err
 	}
-	result := new(StoryChapter)
+	var result *StoryChapter
 	start := time.Now()
-	ret, err := s.cozeClient.StoryboardWriter(ctx, storyboardParams)
+	genCtx, usage := withTokenUsage(ctx)
+	// 返回内容无法解析时同样切换到下一个平台
+	platform, err := s.failover(genCtx, SceneStoryboard, func(ctx context.Context, p client.Provider) error {
+		chapter := new(StoryChapter)
+		_, err := llmjson.ParseWithRetry(ctx, storyChapterSchema, chapter, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := *storyboardParams
+			params.Feedback = feedback
+			return s.writeStoryboard(ctx, p, &params)
+		})
+		if err != nil {
+			log.Log().Error("parse story gen result failed", zap.String("platform", p.Name()), zap.Error(err))
+			return err
+		}
+		result = chapter
+		return nil
+	})
+	storyGen.TokenNum = usage.Total()
 	if err != nil {
 		log.Log().Error("gen storyboard info failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
 		return nil, err
 	}
-	cleanResult := utils.CleanLLmJsonResult(ret)
-	fmt.Println("render storyboard cleanResult: ", cleanResult)
-	// 保存生成的故事板
-
-	err = json.Unmarshal([]byte(cleanResult), &result)
-	if err != nil {
-		log.Log().Error("unmarshal story gen result failed", zap.Error(err))
-		return nil, err
-	}
+	storyGen.LLmPlatform = platform
 	// 渲染剧情
 	renderDetail := new(api.RenderStoryboardDetail)
 	renderDetail.RenderType = req.RenderType
@@ -596,6 +668,7 @@ func (s *StoryService) RenderStoryboard(ctx context.Context, req *api.RenderStor
 
 	renderDetailData, _ := json.Marshal(renderDetail)
 	storyGen.Content = string(renderDetailData)
+	storyGen.GenStatus = models.StoryGenStatusFinish
 	storyGen.FinishTime = time.Now().Unix()
 	err = models.UpdateStoryGen(ctx, storyGen)
 	if err != nil {
@@ -663,72 +736,22 @@ func (s *StoryService) GenStoryboardImages(ctx context.Context, req *api.GenStor
 			for chapter, va := range value {
 				log.Log().Sugar().Info("章节详细情节: ", chapter)
 				charactorNum := 0
+				var sheets []*RoleReferenceSheet
 				for subchapter, subva := range va.(map[string]interface{}) {
 					if subchapter == "情节内容" {
 						log.Log().Sugar().Info("情节内容: ", subva.(string))
 					} else if subchapter == "参与人物" {
 						charactors := strings.Split(subva.(string), ",")
 						charactorNum = len(charactors)
+						sheets = storyReferenceSheetsByName(ctx, int64(story.ID), charactors)
 						log.Log().Sugar().Info("参与人物: ", subva.(string))
 					}
 				}
 				for subchapter, subva := range va.(map[string]interface{}) {
 					if subchapter == "图片提示词" {
-						preDefineTemplate := strings.Replace(models.PreDefineTemplateEnVersion[1].Prompt, "prompt", subva.(string), -1)
-						templatePrompt := preDefineTemplate + ",人物数量:" + strconv.Itoa(charactorNum)
-						storyGen := new(models.StoryGen)
-						storyGen.Uuid = uuid.New().String()
-						storyGenData, _ := json.Marshal(genParams)
-						storyGen.LLmPlatform = "coze"
-						storyGen.NegativePrompt = prompt.ZhipuNegativePrompt
-						storyGen.PositivePrompt = templatePrompt
-						storyGen.Regen = 0
-						storyGen.Params = string(storyGenData)
-						storyGen.OriginID = int64(story.ID)
-						storyGen.StartTime = time.Now().Unix()
-						storyGen.BoardID = req.GetBoardId()
-						storyGen.GenType = int(api.RenderType_RENDER_TYPE_STORYSENCE)
-						_, err = models.CreateStoryGen(ctx, storyGen)
-						if err != nil {
-							log.Log().Error("create storyboard gen failed", zap.Error(err))
+						if _, err := s.genSceneImage(ctx, story, req.GetBoardId(), genParams, subva.(string), charactorNum, sheets); err != nil {
 							return nil, err
 						}
-
-						renderStoryParams := &client.GenStoryImagesParams{
-							Content: templatePrompt,
-						}
-
-						ret, err := s.doubaoClient.GenStoryBoardImage(ctx, renderStoryParams)
-						if err != nil {
-							log.Log().Error("gen storyboard info failed", zap.Error(err))
-							return nil, err
-						}
-						aliyunUrls := make([]string, 0)
-
-						for _, imageUrl := range ret.ImageUrls {
-							aliyunClient := aliyun.GetGlobalClient()
-							aliyunUrl, err := aliyunClient.UploadFileFromURL("", imageUrl)
-							if err != nil {
-								log.Log().Error("upload file from url failed", zap.Error(err))
-								continue
-							}
-							aliyunThumbnailUrl, err := aliyunClient.GenerateThumbnailV2(aliyunUrl, 200)
-							if err != nil {
-								log.Log().Error("generate thumbnail failed", zap.Error(err))
-								continue
-							}
-							aliyunUrls = append(aliyunUrls, aliyunUrl, aliyunThumbnailUrl)
-							aliyunUrls = append(aliyunUrls, aliyunUrl)
-						}
-						storyGen.ImageUrls = strings.Join(aliyunUrls, ",")
-						storyGen.Content = ""
-						storyGen.FinishTime = time.Now().Unix()
-						storyGen.TaskType = 2
-						storyGen.TaskId = uuid.New().String()
-						err = models.UpdateStoryGen(ctx, storyGen)
-						if err != nil {
-							log.Log().Error("update storyboard image gen failed", zap.Error(err))
-						}
 					}
 				}
 			}
@@ -742,6 +765,82 @@ func (s *StoryService) GenStoryboardImages(ctx context.Context, req *api.GenStor
 	}, nil
 }
 
+// genSceneImage 按场景的图片提示词和角色的形象设定生成图片，上传后记录到故事板的生成记录
+func (s *StoryService) genSceneImage(ctx context.Context, story *models.Story, boardId int64, genParams any,
+	imagePrompt string, charactorNum int, sheets []*RoleReferenceSheet) (*models.StoryGen, error) {
+	preDefineTemplate := strings.Replace(models.PreDefineTemplateEnVersion[1].Prompt, "prompt", imagePrompt, -1)
+	templatePrompt := preDefineTemplate + ",人物数量:" + strconv.Itoa(charactorNum)
+	storyGen := newRenderGen(ctx)
+	storyGenData, _ := json.Marshal(genParams)
+	storyGen.NegativePrompt = prompt.ZhipuNegativePrompt
+	storyGen.PositivePrompt = templatePrompt
+	storyGen.Regen = 0
+	storyGen.Params = string(storyGenData)
+	storyGen.OriginID = int64(story.ID)
+	storyGen.StartTime = time.Now().Unix()
+	storyGen.BoardID = boardId
+	storyGen.GenType = int(api.RenderType_RENDER_TYPE_STORYSENCE)
+	_, err := models.CreateStoryGen(ctx, storyGen)
+	if err != nil {
+		log.Log().Error("create storyboard gen failed", zap.Error(err))
+		return nil, err
+	}
+
+	renderStoryParams := &client.ImageParams{
+		Prompt: templatePrompt,
+	}
+	applyReferenceSheets(renderStoryParams, sheets)
+	storyGen.PositivePrompt = renderStoryParams.Prompt
+
+	ret, platform, err := s.generateImage(ctx, renderStoryParams)
+	if err != nil {
+		log.Log().Error("gen storyboard info failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
+		return nil, err
+	}
+	storyGen.LLmPlatform = platform
+	if len(ret.ImageUrls) == 0 && ret.TaskId != "" {
+		ret.ImageUrls, err = s.waitImageTask(ctx, platform, ret.TaskId)
+		if err != nil {
+			log.Log().Error("wait storyboard image task failed", zap.Error(err))
+			failStoryGen(ctx, storyGen, err)
+			return nil, err
+		}
+	}
+	store, err := storage.Default()
+	if err != nil {
+		log.Log().Error("get storage failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
+		return nil, err
+	}
+	storeUrls := make([]string, 0)
+	for _, imageUrl := range ret.ImageUrls {
+		storeUrl, key, err := storage.PutFromURL(ctx, store, storage.DirImages, imageUrl)
+		if err != nil {
+			log.Log().Error("upload file from url failed", zap.Error(err))
+			continue
+		}
+		thumbnailUrl, err := store.Thumbnail(ctx, key, 200)
+		if err != nil {
+			log.Log().Error("generate thumbnail failed", zap.Error(err))
+			continue
+		}
+		storeUrls = append(storeUrls, storeUrl, thumbnailUrl)
+		storeUrls = append(storeUrls, storeUrl)
+	}
+	storyGen.ImageUrls = strings.Join(storeUrls, ",")
+	storyGen.Content = ""
+	storyGen.GenStatus = models.StoryGenStatusFinish
+	storyGen.FinishTime = time.Now().Unix()
+	storyGen.TaskType = 2
+	storyGen.TaskId = uuid.New().String()
+	err = models.UpdateStoryGen(ctx, storyGen)
+	if err != nil {
+		log.Log().Error("update storyboard image gen failed", zap.Error(err))
+	}
+	return storyGen, nil
+}
+
 func (s *StoryService) GenStoryboardText(ctx context.Context, req *api.GenStoryboardTextRequest) (*api.GenStoryboardTextResponse, error) {
 	board, err := models.GetStoryboard(ctx, req.GetBoardId())
 	if err != nil {
@@ -780,6 +879,37 @@ func (s *StoryService) GenStoryboardText(ctx context.Context, req *api.GenStoryb
 }
 
 func (s *StoryService) GetStoryBoardRender(ctx context.Context, req *api.GetStoryBoardRenderRequest) (*api.GetStoryBoardRenderResponse, error) {
+	// 异步渲染任务的进度
+	job, err := models.GetLatestStoryGenJob(ctx, req.GetBoardId(), models.StoryGenTaskStoryboard)
+	if err != nil {
+		return nil, err
+	}
+	if job != nil {
+		switch job.GenStatus {
+		case models.StoryGenStatusInit, models.StoryGenStatusRunning:
+			return &api.GetStoryBoardRenderResponse{
+				Code:    0,
+				Message: "storyboard is rendering",
+			}, nil
+		case models.StoryGenStatusError:
+			return &api.GetStoryBoardRenderResponse{
+				Code:    -1,
+				Message: job.ErrMsg,
+			}, nil
+		}
+		resp := new(api.RenderStoryboardResponse)
+		if err := json.Unmarshal([]byte(job.Content), resp); err == nil && resp.Data != nil {
+			return &api.GetStoryBoardRenderResponse{
+				Code:    0,
+				Message: "OK",
+				Data: &api.GetStoryBoardRenderResponse_Data{
+					List: []*api.RenderStoryboardDetail{
+						resp.Data,
+					},
+				},
+			}, nil
+		}
+	}
 	list, err := models.GetStoryGensByStoryBoard(ctx, req.GetBoardId(), 1)
 	if err != nil {
 		return nil, err
@@ -838,28 +968,29 @@ func (s *StoryService) InitRenderStory(ctx context.Context, req *api.ContinueRen
 			finalRols[role.CharacterName] = realRole
 		}
 	}
-	var rolesPrompt = make([]coze.CozeRoleInfo, 0)
+	var rolesPrompt = make([]client.StoryboardRole, 0)
 	for _, role := range finalRols {
-		rolePrompt := coze.CozeRoleInfo{
-			RoleID:          fmt.Sprintf("%d", role.ID),
-			RoleName:        role.CharacterName,
-			RoleImage:       role.CharacterAvatar,
-			RoleDescription: role.CharacterDescription,
+		rolePrompt := client.StoryboardRole{
+			ID:          fmt.Sprintf("%d", role.ID),
+			Name:        role.CharacterName,
+			Image:       role.CharacterAvatar,
+			Description: role.CharacterDescription,
 		}
 		rolesPrompt = append(rolesPrompt, rolePrompt)
 	}
 	storyGen := new(models.StoryGen)
 	storyGen.Uuid = uuid.New().String()
-	var storyboardParams = coze.CozeInitStoryboardParams{
+	var storyboardParams = &client.StoryboardParams{
+		StoryName:   story.Title,
 		Title:       story.Title,
 		Description: req.GetDescription(),
 		Background:  req.GetBackground(),
 		Roles:       rolesPrompt,
 	}
+	positivePrompt, _ := json.Marshal(storyboardParams)
 
-	storyGen.LLmPlatform = "coze"
 	storyGen.NegativePrompt = ""
-	storyGen.PositivePrompt = storyboardParams.String()
+	storyGen.PositivePrompt = string(positivePrompt)
 	storyGen.Regen = 1
 	storyGen.Params = ""
 	storyGen.OriginID = req.GetStoryId()
@@ -872,22 +1003,32 @@ func (s *StoryService) InitRenderStory(ctx context.Context, req *api.ContinueRen
 		log.Log().Error("create storyboard gen failed", zap.Error(err))
 		return nil, err
 	}
-	log.Log().Sugar().Info("gen storyboard prompt: ", storyboardParams.String())
+	log.Log().Sugar().Info("gen storyboard prompt: ", storyGen.PositivePrompt)
 
-	result := new(StoryChapterV2)
+	var result *StoryChapterV2
 	start := time.Now()
-	ret, err := s.cozeClient.InitStoryboard(ctx, storyboardParams)
+	genCtx, usage := withTokenUsage(ctx)
+	platform, err := s.failover(genCtx, SceneStoryboard, func(ctx context.Context, p client.Provider) error {
+		chapter := new(StoryChapterV2)
+		_, err := llmjson.ParseWithRetry(ctx, storyChapterV2Schema, chapter, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := *storyboardParams
+			params.Feedback = feedback
+			return s.initStoryboard(ctx, p, &params)
+		})
+		if err != nil {
+			log.Log().Error("parse story gen result failed", zap.String("platform", p.Name()), zap.Error(err))
+			return err
+		}
+		result = chapter
+		return nil
+	})
+	storyGen.TokenNum = usage.Total()
 	if err != nil {
 		log.Log().Error("gen storyboard info failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
 		return nil, err
 	}
-	// 保存生成的故事板
-	cleanResult := utils.CleanLLmJsonResult(ret)
-	err = json.Unmarshal([]byte(cleanResult), &result)
-	if err != nil {
-		log.Log().Error("unmarshal story gen result failed", zap.Error(err))
-		return nil, err
-	}
+	storyGen.LLmPlatform = platform
 	// 渲染剧情
 	renderDetail := new(api.RenderStoryboardDetail)
 	renderDetail.RenderType = req.RenderType
@@ -915,6 +1056,7 @@ func (s *StoryService) InitRenderStory(ctx context.Context, req *api.ContinueRen
 
 	renderDetailData, _ := json.Marshal(renderDetail)
 	storyGen.Content = string(renderDetailData)
+	storyGen.GenStatus = models.StoryGenStatusFinish
 	storyGen.FinishTime = time.Now().Unix()
 	err = models.UpdateStoryGen(ctx, storyGen)
 	if err != nil {
@@ -968,13 +1110,13 @@ func (s *StoryService) ContinueRenderStory(ctx context.Context, req *api.Continu
 			finalRols[role.CharacterName] = realRole
 		}
 	}
-	var rolesPrompt = make([]coze.CozeRoleInfo, 0)
+	var rolesPrompt = make([]client.StoryboardRole, 0)
 	for _, role := range finalRols {
-		rolePrompt := coze.CozeRoleInfo{
-			RoleID:          fmt.Sprintf("%d", role.ID),
-			RoleName:        role.CharacterName,
-			RoleImage:       role.CharacterAvatar,
-			RoleDescription: role.CharacterDescription,
+		rolePrompt := client.StoryboardRole{
+			ID:          fmt.Sprintf("%d", role.ID),
+			Name:        role.CharacterName,
+			Image:       role.CharacterAvatar,
+			Description: role.CharacterDescription,
 		}
 		rolesPrompt = append(rolesPrompt, rolePrompt)
 	}
@@ -1000,10 +1142,10 @@ func (s *StoryService) ContinueRenderStory(ctx context.Context, req *api.Continu
 		log.Log().Error("unmarshal storyboard gen params failed", zap.Error(err))
 		return nil, err
 	}
-	storyGen := new(models.StoryGen)
-	storyGen.Uuid = uuid.New().String()
+	storyGen := newRenderGen(ctx)
 	storyGenData, _ := json.Marshal(genParams)
-	var storyboardParams = coze.CozeStoryboardContinueParams{
+	var storyboardParams = &client.StoryboardParams{
+		StoryName:   story.Title,
 		Title:       req.GetTitle(),
 		Description: req.GetDescription(),
 		Background:  req.GetBackground(),
@@ -1034,14 +1176,14 @@ func (s *StoryService) ContinueRenderStory(ctx context.Context, req *api.Continu
 	}
 	if hasPrevContent {
 		story_prev_content_json, _ := json.Marshal(story_prev_content)
-		storyboardParams.StoryPrevContent = string(story_prev_content_json)
+		storyboardParams.PrevContent = string(story_prev_content_json)
 	} else {
-		storyboardParams.StoryPrevContent = "{{暂无上一章节}}"
+		storyboardParams.PrevContent = "{{暂无上一章节}}"
 	}
-
-	storyGen.LLmPlatform = "coze"
+	positivePrompt, _ := json.Marshal(storyboardParams)
+	storyGen.GenStatus = models.StoryGenStatusRunning
 	storyGen.NegativePrompt = ""
-	storyGen.PositivePrompt = storyboardParams.String()
+	storyGen.PositivePrompt = string(positivePrompt)
 	storyGen.Regen = 1
 	storyGen.Params = string(storyGenData)
 	storyGen.OriginID = req.GetStoryId()
@@ -1054,20 +1196,30 @@ func (s *StoryService) ContinueRenderStory(ctx context.Context, req *api.Continu
 		log.Log().Error("create storyboard gen failed", zap.Error(err))
 		return nil, err
 	}
-	result := new(StoryChapterV2)
+	var result *StoryChapterV2
 	start := time.Now()
-	ret, err := s.cozeClient.StoryboardContinue(ctx, storyboardParams)
+	genCtx, usage := withTokenUsage(ctx)
+	platform, err := s.failover(genCtx, SceneStoryboard, func(ctx context.Context, p client.Provider) error {
+		chapter := new(StoryChapterV2)
+		_, err := llmjson.ParseWithRetry(ctx, storyChapterV2Schema, chapter, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := *storyboardParams
+			params.Feedback = feedback
+			return s.continueStoryboard(ctx, p, &params)
+		})
+		if err != nil {
+			log.Log().Error("parse story gen result failed", zap.String("platform", p.Name()), zap.Error(err))
+			return err
+		}
+		result = chapter
+		return nil
+	})
+	storyGen.TokenNum = usage.Total()
 	if err != nil {
 		log.Log().Error("gen storyboard info failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
 		return nil, err
 	}
-	// 保存生成的故事板
-	cleanResult := utils.CleanLLmJsonResult(ret)
-	err = json.Unmarshal([]byte(cleanResult), &result)
-	if err != nil {
-		log.Log().Error("unmarshal story gen result failed", zap.Error(err))
-		return nil, err
-	}
+	storyGen.LLmPlatform = platform
 	// 渲染剧情
 	renderDetail := new(api.RenderStoryboardDetail)
 	renderDetail.RenderType = req.RenderType
@@ -1097,6 +1249,7 @@ func (s *StoryService) ContinueRenderStory(ctx context.Context, req *api.Continu
 	renderDetailData, _ := json.Marshal(renderDetail)
 	log.Log().Sugar().Info("renderDetailData: ", string(renderDetailData))
 	storyGen.Content = string(renderDetailData)
+	storyGen.GenStatus = models.StoryGenStatusFinish
 	storyGen.FinishTime = time.Now().Unix()
 	err = models.UpdateStoryGen(ctx, storyGen)
 	if err != nil {
@@ -1166,7 +1319,7 @@ func (s *StoryService) UpdateStoryRole(ctx context.Context, req *api.UpdateStory
 		needUpdateFields["character_description"] = req.Role.GetCharacterDescription()
 	}
 
-	err = models.UpdateStoryRole(ctx, int64(role.ID), needUpdateFields)
+	err = updateRoleColumns(ctx, role, needUpdateFields, manualRevision(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -1197,8 +1350,8 @@ func (s *StoryService) RenderStoryRoleDetail(ctx context.Context, req *api.Rende
 			Message: "story is closed",
 		}, nil
 	}
-	var storyroleParams = coze.CozeStoryRoleDetailParams{
-		RoleName:    role.CharacterName,
+	var storyroleParams = client.RoleParams{
+		Name:        role.CharacterName,
 		Description: role.CharacterDescription,
 		StoryDesc:   story.ShortDesc,
 		StoryName:   story.Title,
@@ -1208,9 +1361,9 @@ func (s *StoryService) RenderStoryRoleDetail(ctx context.Context, req *api.Rende
 	storyGen.Uuid = uuid.New().String()
 	reqData, _ := json.Marshal(req)
 	storyGenData, _ := json.Marshal(reqData)
-	storyGen.LLmPlatform = "doubao"
+	positivePrompt, _ := json.Marshal(storyroleParams)
 	storyGen.NegativePrompt = ""
-	storyGen.PositivePrompt = storyroleParams.String()
+	storyGen.PositivePrompt = string(positivePrompt)
 	storyGen.Regen = 1
 	storyGen.Params = string(storyGenData)
 	storyGen.OriginID = int64(role.ID)
@@ -1224,19 +1377,28 @@ func (s *StoryService) RenderStoryRoleDetail(ctx context.Context, req *api.Rende
 		log.Log().Error("create storyboard gen failed", zap.Error(err))
 		return nil, err
 	}
-	result := new(CharacterDetail)
-	ret, err := s.cozeClient.StoryRoleDetail(ctx, storyroleParams)
-	if err != nil {
-		log.Log().Error("gen storyboard info failed", zap.Error(err))
-		return nil, err
-	}
-	// 保存生成的故事板
-	cleanResult := utils.CleanLLmJsonResult(ret)
-	err = json.Unmarshal([]byte(cleanResult), &result)
+	var result *CharacterDetail
+	platform, err := s.failover(ctx, SceneRole, func(ctx context.Context, p client.Provider) error {
+		detail := new(CharacterDetail)
+		_, err := llmjson.ParseWithRetry(ctx, characterDetailSchema, detail, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := storyroleParams
+			params.Feedback = feedback
+			return s.writeRole(ctx, p, &params)
+		})
+		if err != nil {
+			return err
+		}
+		result = detail
+		return nil
+	})
 	if err != nil {
-		log.Log().Error("unmarshal story gen result failed", zap.Error(err))
+		log.Log().Error("gen story role detail failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
 		return nil, err
 	}
+	storyGen.LLmPlatform = platform
+	// 保存生成的角色详情
+	detailData, _ := json.Marshal(result)
 	apiRoleDetail := new(api.StoryRole)
 	apiRoleDetail.RoleId = int64(role.ID)
 	apiRoleDetail.CharacterName = role.CharacterName
@@ -1253,7 +1415,8 @@ func (s *StoryService) RenderStoryRoleDetail(ctx context.Context, req *api.Rende
 		Personality:   result.Personality,
 		Background:    result.Background,
 	}
-	storyGen.Content = cleanResult
+	storyGen.Content = string(detailData)
+	storyGen.GenStatus = models.StoryGenStatusFinish
 	storyGen.FinishTime = time.Now().Unix()
 	err = models.UpdateStoryGen(ctx, storyGen)
 	if err != nil {
@@ -1495,11 +1658,18 @@ func (s *StoryService) CreateStoryBoardScene(ctx context.Context, req *api.Creat
 	newScene.Status = 1
 	newScene.GenStatus = int(models.StoryGenStatusInit)
 	newScene.GenResult = req.Sence.GetGenResult()
-	_, err := models.CreateStoryBoardScene(ctx, newScene)
+	target := sceneModerationTarget(ctx, newScene)
+	review, err := moderation.CheckText(ctx, target, newScene.Content)
+	if err != nil {
+		return nil, err
+	}
+	_, err = models.CreateStoryBoardScene(ctx, newScene)
 	if err != nil {
 		log.Log().Error("create storyboard scene failed", zap.Error(err))
 		return nil, err
 	}
+	target.EntityId = int64(newScene.ID)
+	_ = moderation.Hold(ctx, target, review, newScene.Content)
 	newSceneData, _ := json.Marshal(newScene)
 	log.Log().Sugar().Infof("create storyboard scene success, scene: %s", string(newSceneData))
 	return &api.CreateStoryBoardSenceResponse{
@@ -1524,6 +1694,12 @@ func (s *StoryService) UpdateStoryBoardSence(ctx context.Context, req *api.Updat
 			Message: "scene not found",
 		}, nil
 	}
+	target := sceneModerationTarget(ctx, scene)
+	review, err := moderation.CheckText(ctx, target, req.Sence.GetContent())
+	if err != nil {
+		return nil, err
+	}
+	before := sceneRevision(scene)
 	scene.Content = req.Sence.GetContent()
 	scene.ImagePrompts = req.Sence.GetImagePrompts()
 	scene.AudioPrompts = req.Sence.GetAudioPrompts()
@@ -1531,11 +1707,12 @@ func (s *StoryService) UpdateStoryBoardSence(ctx context.Context, req *api.Updat
 	scene.Status = int(req.Sence.GetStatus())
 	scene.GenStatus = int(req.Sence.GetIsGenerating())
 	scene.GenResult = req.Sence.GetGenResult()
-	err = models.UpdateStoryBoardScene(ctx, scene)
+	err = updateSceneWithRevision(ctx, before, scene, manualRevision(ctx))
 	if err != nil {
 		log.Log().Error("update storyboard scene failed", zap.Error(err))
 		return nil, err
 	}
+	_ = moderation.Hold(ctx, target, review, scene.Content)
 	log.Log().Sugar().Infof("update storyboard scene success, scene: %s", req.Sence.String())
 	return &api.UpdateStoryBoardSenceResponse{
 		Code:    0,
@@ -1623,44 +1800,23 @@ func (s *StoryService) RenderStoryBoardSence(ctx context.Context, req *api.Rende
 			Message: "scene is not ready",
 		}, nil
 	}
+	before := sceneRevision(scene)
 	scene.GenStatus = int(models.StoryGenStatusInit)
 	scene.Status = 1
 	_ = models.UpdateStoryBoardScene(ctx, scene)
-	// 2. 生成指定场景的图片
-	templatePrompt := scene.ImagePrompts
-	preDefineTemplate := strings.Replace(models.PreDefineTemplateEnVersion[1].Prompt, "prompt", templatePrompt, -1)
-	templatePrompt = preDefineTemplate + ",人物: " + scene.CharacterIds
-	renderStoryParams := &client.GenStoryImagesParams{
-		Content: templatePrompt,
-	}
+	// 2. 生成指定场景的图片，带上场景中角色的形象设定
+	renderStoryParams := sceneImageParams(ctx, int64(story.ID), scene)
+	templatePrompt := renderStoryParams.Prompt
 	log.Log().Sugar().Infof("render storyboard scene, scene: %s, prompt: %s", scene.Content, templatePrompt)
-	ret, err := s.doubaoClient.GenStoryBoardImage(ctx, renderStoryParams)
-	if err != nil {
+	scene.Status = 1
+	scene.TaskId = uuid.New().String()
+	// 生成多张候选图片，用户选定前场景使用最先生成好的一张
+	if _, err := s.renderSceneCandidates(ctx, scene, renderStoryParams); err != nil {
 		log.Log().Error("gen storyboard info failed", zap.Error(err))
+		_ = models.UpdateStoryBoardScene(ctx, scene)
 		return nil, err
 	}
-	aliyunUrls := make([]string, 0)
-	for _, imageUrl := range ret.ImageUrls {
-		aliyunClient := aliyun.GetGlobalClient()
-		aliyunUrl, err := aliyunClient.UploadFileFromURL("", imageUrl)
-		if err != nil {
-			log.Log().Error("upload file from url failed", zap.Error(err))
-			continue
-		}
-		// aliyunThumbnailUrl, err := aliyunClient.GenerateThumbnailV2(aliyunUrl, 200)
-		// log.Log().Sugar().Infof("aliyunThumbnailUrl: %s", aliyunThumbnailUrl)
-		// if err != nil {
-		// 	log.Log().Error("generate thumbnail failed", zap.Error(err))
-		// 	continue
-		// }
-		// aliyunUrls = append(aliyunUrls, aliyunUrl, aliyunThumbnailUrl)
-		aliyunUrls = append(aliyunUrls, aliyunUrl)
-	}
-	retData, _ := json.Marshal(aliyunUrls)
-	scene.GenResult = string(retData)
-	scene.Status = 1
-	scene.TaskId = uuid.New().String()
-	err = models.UpdateStoryBoardScene(ctx, scene)
+	err = updateSceneWithRevision(ctx, before, scene, generatedRevision(ctx, scene.TaskId))
 	if err != nil {
 		log.Log().Error("update storyboard scene failed", zap.Error(err))
 		return nil, err
@@ -1742,38 +1898,48 @@ func (s *StoryService) RenderStoryBoardSences(ctx context.Context, req *api.Rend
 	// 用于返回的API场景列表
 	apiScenes := make([]*api.StoryBoardSence, 0)
 
+	if asyncRenderEnabled(ctx) {
+		job, err := s.enqueueRenderJob(ctx, models.StoryGenTaskScenes, req, &models.StoryGen{
+			BoardID:  int64(req.GetBoardId()),
+			OriginID: int64(story.ID),
+			UserId:   board.CreatorID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, scene := range scenes {
+			scene.GenStatus = int(models.StoryGenStatusRunning)
+			scene.TaskId = job.TaskId
+			if err := models.UpdateStoryBoardScene(ctx, scene); err != nil {
+				log.Log().Error("update storyboard scene failed", zap.Error(err))
+				return nil, err
+			}
+			apiScenes = append(apiScenes, convert.ConvertStoryBoardSceneToApiStoryBoardScene(scene))
+		}
+		return &api.RenderStoryBoardSencesResponse{
+			Code:    0,
+			Message: RenderJobQueued,
+			List:    apiScenes,
+		}, nil
+	}
+
 	// 顺序遍历每个场景，依次发起图片生成任务
 	for _, scene := range scenes {
-		// 1. 生成图片prompt
-		templatePrompt := scene.ImagePrompts
-		preDefineTemplate := strings.Replace(models.PreDefineTemplateEnVersion[1].Prompt, "prompt", templatePrompt, -1)
-		templatePrompt = preDefineTemplate + ",人物: " + scene.CharacterIds
+		before := sceneRevision(scene)
+		// 1. 生成图片prompt，带上场景中角色的形象设定
+		renderStoryParams := sceneImageParams(ctx, int64(story.ID), scene)
+		templatePrompt := renderStoryParams.Prompt
 
 		// 2. 调用GenStoryBoardImages，获取task_id
-		renderStoryParams := &client.GenStoryImagesParams{
-			Content: templatePrompt,
-		}
 		log.Log().Sugar().Infof("render storyboard scene, scene: %s, prompt: %s", scene.Content, templatePrompt)
-		ret, err := s.doubaoClient.GenStoryBoardImage(ctx, renderStoryParams)
-		if err != nil {
+		scene.Status = 1
+		scene.TaskId = renderTaskId(ctx)
+		if _, err := s.renderSceneCandidates(ctx, scene, renderStoryParams); err != nil {
 			log.Log().Error("gen storyboard info failed", zap.Error(err))
+			_ = models.UpdateStoryBoardScene(ctx, scene)
 			return nil, err
 		}
-		aliyunUrls := make([]string, 0)
-		for _, imageUrl := range ret.ImageUrls {
-			aliyunClient := aliyun.GetGlobalClient()
-			aliyunUrl, err := aliyunClient.UploadFileFromURL("", imageUrl)
-			if err != nil {
-				log.Log().Error("upload file from url failed", zap.Error(err))
-				continue
-			}
-			aliyunUrls = append(aliyunUrls, aliyunUrl)
-		}
-		retData, _ := json.Marshal(aliyunUrls)
-		scene.GenResult = string(retData)
-		scene.Status = 1
-		scene.TaskId = uuid.New().String()
-		err = models.UpdateStoryBoardScene(ctx, scene)
+		err = updateSceneWithRevision(ctx, before, scene, generatedRevision(ctx, scene.TaskId))
 		if err != nil {
 			log.Log().Error("update storyboard scene failed", zap.Error(err))
 			return nil, err
@@ -1807,6 +1973,7 @@ func (s *StoryService) GetStoryBoardSenceGenerate(ctx context.Context, req *api.
 		return &api.GetStoryBoardSenceGenerateResponse{
 			Code:    0,
 			Message: "scene is already generating",
+			Data:    convert.ConvertStoryBoardSceneToApiStoryBoardScene(scene),
 		}, nil
 	}
 	if scene.Status == -1 {
@@ -1844,7 +2011,7 @@ func (s *StoryService) GetStoryBoardGenerate(ctx context.Context, req *api.GetSt
 		if scene.Status == 1 {
 			log.Log().Error("scene is already generating")
 		}
-		if scene.Status == 0 {
+		if scene.Status == 0 || scene.GenStatus == int(models.StoryGenStatusRunning) {
 			generating++
 		}
 		if scene.Status == -1 {
@@ -15,9 +15,10 @@ import (
 	api "github.com/grapery/common-protoc/gen"
 	"github.com/grapery/grapery/models"
 	"github.com/grapery/grapery/pkg/client"
-	"github.com/grapery/grapery/pkg/cloud/coze"
-	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/pkg/search"
 	"github.com/grapery/grapery/utils/convert"
+	"github.com/grapery/grapery/utils/llmjson"
 	"github.com/grapery/grapery/utils/log"
 )
 
@@ -217,10 +218,16 @@ func (s *StoryService) CreateStoryRole(ctx context.Context, req *api.CreateStory
 	newRole.LikeCount = 1
 	newRole.Status = 1
 	newRole.CharacterDetail = "{}"
+	target := roleModerationTarget(ctx, newRole)
+	review, err := moderation.CheckText(ctx, target, newRole.CharacterName, newRole.CharacterDescription)
+	if err != nil {
+		return nil, err
+	}
 	roleId, err := models.CreateStoryRole(ctx, newRole)
 	if err != nil {
 		return nil, err
 	}
+	search.Sync(search.KindRole, roleId)
 	userProfille := new(models.UserProfile)
 	userProfille.UserId = req.GetUserId()
 	err = userProfille.GetByUserId()
@@ -233,6 +240,8 @@ func (s *StoryService) CreateStoryRole(ctx context.Context, req *api.CreateStory
 		log.Log().Error("create watch story item failed", zap.Error(err))
 		return nil, err
 	}
+	target.EntityId = roleId
+	_ = moderation.Hold(ctx, target, review, newRole.CharacterName+"\n"+newRole.CharacterDescription)
 	log.Log().Info("create role success", zap.String("role", newRole.String()))
 	return &api.CreateStoryRoleResponse{
 		Code:    0,
@@ -296,48 +305,72 @@ func (s *StoryService) RenderStoryRole(ctx context.Context, req *api.RenderStory
 	if err != nil {
 		return nil, err
 	}
-	roleParams := coze.CozeStoryRoleDetailParams{
+	if asyncRenderEnabled(ctx) {
+		_, err := s.enqueueRenderJob(ctx, models.StoryGenTaskRole, req, &models.StoryGen{
+			OriginID: int64(story.ID),
+			RoleID:   req.GetRoleId(),
+			UserId:   req.GetUserId(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &api.RenderStoryRoleResponse{
+			Code:    0,
+			Message: RenderJobQueued,
+		}, nil
+	}
+	roleParams := &client.RoleParams{
 		StoryName:   story.Name,
 		StoryDesc:   story.ShortDesc,
-		RoleName:    role.CharacterName,
+		Name:        role.CharacterName,
 		Description: req.GetPrompt(),
 	}
 	if roleParams.Description == "" {
 		roleParams.Description = role.CharacterDescription
 	}
-	roleContent, err := s.cozeClient.StoryRoleDetail(ctx, roleParams)
-	if err != nil {
-		log.Log().Error("get story role detail prompt failed", zap.Error(err))
-		return nil, err
-	}
 	// 调用生成器
-	storyGen := new(models.StoryGen)
-	storyGen.Uuid = uuid.New().String()
-	storyGen.LLmPlatform = "coze"
+	storyGen := newRenderGen(ctx)
 	storyGen.NegativePrompt = ""
 	storyGen.PositivePrompt = req.GetPrompt()
 	storyGen.Regen = 0
-	storyGen.Params = req.String()
 	storyGen.OriginID = req.GetRoleId()
 	storyGen.StartTime = time.Now().Unix()
 	storyGen.BoardID = 0
 	storyGen.GenType = int(api.RenderType_RENDER_TYPE_STORYCHARACTERS)
-	storyGen.TaskType = 3
+	storyGen.TaskType = models.StoryGenTaskRole
 	storyGen.Status = 1
-	_, err = models.CreateStoryGen(ctx, storyGen)
+	err = saveRenderGen(ctx, storyGen, req.String())
 	if err != nil {
 		return nil, err
 	}
-	var renderDetail = new(api.RenderStoryRoleDetail)
-	result := new(CharacterDetail)
-	cleanResult := utils.CleanLLmJsonResult(roleContent)
-	err = json.Unmarshal([]byte(cleanResult), &result)
+	var (
+		result      *CharacterDetail
+		roleContent string
+	)
+	// 返回内容无法解析时带上错误重新生成，仍然失败时切换到下一个平台
+	platform, err := s.failover(ctx, SceneRole, func(ctx context.Context, p client.Provider) error {
+		detail := new(CharacterDetail)
+		content, err := llmjson.ParseWithRetry(ctx, characterDetailSchema, detail, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := *roleParams
+			params.Feedback = feedback
+			return s.writeRole(ctx, p, &params)
+		})
+		if err != nil {
+			log.Log().Error("parse story role gen result failed", zap.String("platform", p.Name()), zap.Error(err))
+			return err
+		}
+		result = detail
+		roleContent = content
+		return nil
+	})
 	if err != nil {
-		log.Log().Error("unmarshal gen result failed", zap.Error(err))
+		log.Log().Error("get story role detail prompt failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
 		return nil, err
 	}
-	log.Log().Info("cleaned LLM result for role description", zap.String("content", cleanResult))
-	storyGen.Content = cleanResult
+	var renderDetail = new(api.RenderStoryRoleDetail)
+	storyGen.LLmPlatform = platform
+	storyGen.Content = roleContent
 	storyGen.FinishTime = time.Now().Unix()
 	renderDetail.Background = result.Background
 	renderDetail.Appearance = result.Appearance
@@ -529,43 +562,11 @@ func (s *StoryService) ChatWithStoryRole(ctx context.Context, req *api.ChatWithS
 			return nil, err
 		}
 		reply = append(reply, convert.ConvertChatMessageToApiChatMessage(chatMessage))
-		{
-			roleInfo, err := models.GetStoryRoleByID(ctx, int64(req.GetRoleId()))
-			if err != nil {
-				log.Log().Error("get story role by id failed", zap.Error(err))
-				return nil, err
-			}
-			characterDetail := &CharacterDetailConverter{}
-			json.Unmarshal([]byte(roleInfo.CharacterDetail), characterDetail)
-			var chatParams = &client.ChatWithRoleParams{
-				MessageContent: message.GetMessage(),
-				Role:           characterDetail.ToPrompt(),
-				SenseDesc:      "", // sence
-				RolePositive:   "", // 角色的描述
-				RoleNegative:   "",
-				RequestId:      message.GetUuid(),
-				UserId:         fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID),
-			}
-			chatResp, err := s.bailianClient.ChatWithRole(ctx, chatParams)
-			if err != nil {
-				log.Log().Error("chat with role failed", zap.Error(err))
-				return nil, err
-			}
-			roleReplyMessage := new(models.ChatMessage)
-			roleReplyMessage.ChatContextID = int64(chatCtx.ID)
-			roleReplyMessage.UserID = int64(message.GetUserId())
-			roleReplyMessage.Content = chatResp.Content
-			roleReplyMessage.Status = 1
-			roleReplyMessage.RoleID = int64(message.GetRoleId())
-			roleReplyMessage.Sender = int64(message.GetRoleId())
-			roleReplyMessage.UUID = message.GetUuid()
-			err = models.CreateChatMessage(ctx, roleReplyMessage)
-			if err != nil {
-				log.Log().Error("create story role chat message failed", zap.Error(err))
-				return nil, err
-			}
-			reply = append(reply, convert.ConvertChatMessageToApiChatMessage(roleReplyMessage))
+		roleReplyMessage, err := s.ReplyChatMessage(ctx, chatCtx, chatMessage)
+		if err != nil {
+			return nil, err
 		}
+		reply = append(reply, convert.ConvertChatMessageToApiChatMessage(roleReplyMessage))
 	}
 	return &api.ChatWithStoryRoleResponse{
 		Code:          0,
@@ -574,6 +575,102 @@ func (s *StoryService) ChatWithStoryRole(ctx context.Context, req *api.ChatWithS
 	}, nil
 }
 
+// ReplyChatMessage 以会话的角色身份回复 message 并保存，提示词按所用模型的token预算组装，
+// 带上角色设定、故事背景、召回的记忆和最近的消息；Agent模式的会话中角色可以先调用工具
+func (s *StoryService) ReplyChatMessage(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage) (*models.ChatMessage, error) {
+	if !moderateChatMessage(ctx, chatCtx, message) {
+		return nil, errChatMessageHeld
+	}
+	if chatCtx.UseAgent == 1 {
+		reply, _, err := s.agentReply(ctx, chatCtx, message)
+		return reply, err
+	}
+	r, err := s.chatRolePrompt(ctx, chatCtx, message)
+	if err != nil {
+		return nil, err
+	}
+	chatResp, promptTokens, err := s.chatRole(ctx, r, fmt.Sprintf("grapery_chat_ctx_%d_user_%d", chatCtx.ID, chatCtx.UserID), message.UUID)
+	if err != nil {
+		log.Log().Error("chat with role failed", zap.Error(err))
+		return nil, err
+	}
+	return s.saveRoleReply(ctx, chatCtx, message, chatResp.Content, promptTokens, chatResp.TokenNum)
+}
+
+// chatRolePrompt 组装会话角色回复 message 的提示词，用户发送的图片只随当前消息发给模型
+func (s *StoryService) chatRolePrompt(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage) (*rolePrompt, error) {
+	roleInfo, err := models.GetStoryRoleByID(ctx, chatCtx.RoleID)
+	if err != nil {
+		log.Log().Error("get story role by id failed", zap.Error(err))
+		return nil, err
+	}
+	if roleInfo == nil {
+		return nil, errors.New("角色不存在")
+	}
+	persona := roleInfo.CharacterDescription
+	characterDetail := &CharacterDetailConverter{}
+	if err := json.Unmarshal([]byte(roleInfo.CharacterDetail), characterDetail); err == nil {
+		persona = characterDetail.ToPrompt()
+	}
+	recall, err := s.RecallChatMemory(ctx, chatCtx, message.Content, int64(message.ID))
+	if err != nil {
+		return nil, err
+	}
+	background := ""
+	if story, err := models.GetStory(ctx, roleInfo.StoryID); err == nil && story != nil {
+		background = story.ShortDesc
+	}
+	return &rolePrompt{
+		Persona:     persona,
+		Instruction: chatIllustrationInstruction,
+		Background:  background,
+		Recall:      recall,
+		History: append(recall.Messages, client.Message{
+			Role:    "user",
+			Content: message.Content,
+			Images:  chatMessageImages(message),
+		}),
+	}, nil
+}
+
+// saveRoleReply 保存角色对 message 的回复，记录token用量和记忆
+func (s *StoryService) saveRoleReply(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage,
+	content string, promptTokens, tokenNum int) (*models.ChatMessage, error) {
+	roleReplyMessage := new(models.ChatMessage)
+	roleReplyMessage.ChatContextID = int64(chatCtx.ID)
+	roleReplyMessage.UserID = message.UserID
+	roleReplyMessage.Content = content
+	roleReplyMessage.Status = 1
+	roleReplyMessage.RoleID = chatCtx.RoleID
+	roleReplyMessage.Sender = chatCtx.RoleID
+	roleReplyMessage.UUID = message.UUID
+	roleReplyMessage.ReplyToID = int64(message.ID)
+	roleReplyMessage.PromptTokens = promptTokens
+	roleReplyMessage.TokenNum = tokenNum
+	if text, illustration := splitIllustration(content); illustration != "" {
+		roleReplyMessage.Content = text
+		roleReplyMessage.Prompt = illustration
+		roleReplyMessage.NeedRender = models.ChatRenderPending
+	}
+	err := models.CreateChatMessage(ctx, roleReplyMessage)
+	if err != nil {
+		log.Log().Error("create story role chat message failed", zap.Error(err))
+		return nil, err
+	}
+	recordChatUsage(ctx, chatCtx, roleReplyMessage)
+	if !moderateChatMessage(ctx, chatCtx, roleReplyMessage) {
+		// 没有通过审核的回复不写入记忆，也不生成插图
+		roleReplyMessage.Content = chatReplyHeldContent
+		roleReplyMessage.NeedRender = models.ChatRenderNone
+		return roleReplyMessage, nil
+	}
+	s.RememberChatTurn(ctx, chatCtx, message, roleReplyMessage)
+	if roleReplyMessage.NeedRender == models.ChatRenderPending {
+		s.renderChatIllustration(ctx, chatCtx, roleReplyMessage)
+	}
+	return roleReplyMessage, nil
+}
+
 // 获取角色聊天列表
 func (s *StoryService) GetUserWithRoleChatList(ctx context.Context, req *api.GetUserWithRoleChatListRequest) (*api.GetUserWithRoleChatListResponse, error) {
 	log.Log().Info("get user with role chat list", zap.Any("req", req.String()))
@@ -672,7 +769,7 @@ func (s *StoryService) UpdateStoryRoleDetail(ctx context.Context, req *api.Updat
 	if len(req.GetRole().GetCharacterRefImages()) > 0 {
 		updates["character_ref_images"] = strings.Join(req.GetRole().GetCharacterRefImages(), ",")
 	}
-	err = models.UpdateStoryRole(ctx, int64(role.ID), updates)
+	err = updateRoleColumnsModerated(ctx, role, updates, manualRevision(ctx))
 	if err != nil {
 		log.Log().Error("update story role detail failed", zap.Error(err))
 		return nil, err
@@ -836,13 +933,11 @@ func (s *StoryService) RenderStoryRoleContinuously(ctx context.Context, req *api
 		}, nil
 	}
 
-	storyroleParams := coze.CozeStoryRoleDetailContinueParams{
-		RoleName:    role.CharacterName,
+	storyroleParams := &client.RoleParams{
+		Name:        role.CharacterName,
 		Description: role.CharacterDescription,
 		StoryDesc:   story.ShortDesc,
 		StoryName:   story.Title,
-		OtherRoles:  "",
-		History:     "",
 	}
 
 	histroryStoryBoardSences, err := models.GetStoryBoardSencesByRoleID(ctx, role.StoryID)
@@ -863,9 +958,9 @@ func (s *StoryService) RenderStoryRoleContinuously(ctx context.Context, req *api
 	// 调用生成器
 	storyGen := new(models.StoryGen)
 	storyGen.Uuid = uuid.New().String()
-	storyGen.LLmPlatform = "coze"
+	positivePrompt, _ := json.Marshal(storyroleParams)
 	storyGen.NegativePrompt = ""
-	storyGen.PositivePrompt = storyroleParams.String()
+	storyGen.PositivePrompt = string(positivePrompt)
 	storyGen.Regen = 2
 	storyGen.Params = req.String()
 	storyGen.OriginID = req.GetRoleId()
@@ -880,21 +975,34 @@ func (s *StoryService) RenderStoryRoleContinuously(ctx context.Context, req *api
 		return nil, err
 	}
 
-	ret, err := s.cozeClient.StoryRoleDetailContinue(ctx, storyroleParams)
+	var (
+		result *CharacterDetail
+		ret    string
+	)
+	// 返回内容无法解析时带上错误重新生成，仍然失败时切换到下一个平台
+	platform, err := s.failover(ctx, SceneRole, func(ctx context.Context, p client.Provider) error {
+		detail := new(CharacterDetail)
+		content, err := llmjson.ParseWithRetry(ctx, characterDetailSchema, detail, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := *storyroleParams
+			params.Feedback = feedback
+			return s.continueRole(ctx, p, &params)
+		})
+		if err != nil {
+			log.Log().Error("parse story role gen result failed", zap.String("platform", p.Name()), zap.Error(err))
+			return err
+		}
+		result = detail
+		ret = content
+		return nil
+	})
 	if err != nil {
 		log.Log().Error("gen story info failed", zap.Error(err))
+		failStoryGen(ctx, storyGen, err)
 		return nil, err
 	}
+	storyGen.LLmPlatform = platform
 	var renderDetail = new(api.RenderStoryRoleDetail)
-	result := new(CharacterDetail)
-	cleanResult := utils.CleanLLmJsonResult(ret)
-	err = json.Unmarshal([]byte(cleanResult), &result)
-	if err != nil {
-		log.Log().Error("unmarshal gen result failed", zap.Error(err))
-		return nil, err
-	}
-	log.Log().Info("cleaned LLM result for role description", zap.String("content", cleanResult))
-	storyGen.Content = cleanResult
+	storyGen.Content = ret
 	storyGen.FinishTime = time.Now().Unix()
 	renderDetail.RoleCharacter = result.Description
 	renderDetail.RoleDescription = result.DressPreference
@@ -943,8 +1051,8 @@ func (s *StoryService) GenerateRoleDescription(ctx context.Context, req *api.Gen
 			otherRolesInfo.WriteString(fmt.Sprintf("角色名称: %s\n角色描述: %s\n\n", role.CharacterName, role.CharacterDescription))
 		}
 	}
-	var storyroleParams = coze.CozeStoryRoleDetailParams{
-		RoleName:    roleinfo.CharacterName,
+	var storyroleParams = client.RoleParams{
+		Name:        roleinfo.CharacterName,
 		Description: req.GetDescription(),
 		StoryName:   storyinfo.Title,
 		StoryDesc:   storyinfo.ShortDesc,
@@ -958,24 +1066,37 @@ func (s *StoryService) GenerateRoleDescription(ctx context.Context, req *api.Gen
 		storyroleParams.OtherRoles = "没有其他角色信息"
 	}
 
-	result, err := s.cozeClient.StoryRoleDetail(ctx, storyroleParams)
-	if err != nil {
-		log.Log().Error("generate role description failed", zap.Error(err))
-		return nil, errors.New("failed to generate role description")
-	}
-
-	// Clean and parse the AI response
-	cleanResult := utils.CleanLLmJsonResult(result)
-	log.Log().Info("cleaned LLM result for role description", zap.String("content", cleanResult))
-	var genRoleDetail = new(CharacterDetail)
-	err = json.Unmarshal([]byte(cleanResult), &genRoleDetail)
-	if err != nil {
-		log.Log().Error("unmarshal gen result failed", zap.Error(err))
+	// Generate, repair and validate the AI response, re-prompting with the validation error
+	var (
+		genRoleDetail *CharacterDetail
+		result        string
+	)
+	_, err = s.failover(ctx, SceneRole, func(ctx context.Context, p client.Provider) error {
+		detail := new(CharacterDetail)
+		content, err := llmjson.ParseWithRetry(ctx, characterDetailSchema, detail, parseRetries, func(ctx context.Context, feedback string) (string, error) {
+			params := storyroleParams
+			params.Feedback = feedback
+			return s.writeRole(ctx, p, &params)
+		})
+		result = content
+		if err != nil {
+			return err
+		}
+		genRoleDetail = detail
+		return nil
+	})
+	var validationErr *llmjson.ValidationError
+	if errors.As(err, &validationErr) {
+		log.Log().Error("parse gen result failed", zap.String("content", result), zap.Error(err))
 		return &api.GenerateRoleDescriptionResponse{
 			Code:    -1,
 			Message: err.Error(),
 		}, nil
 	}
+	if err != nil {
+		log.Log().Error("generate role description failed", zap.Error(err))
+		return nil, errors.New("failed to generate role description")
+	}
 	apiCharacterDetail := &api.CharacterDetail{
 		Description:     genRoleDetail.Description,
 		ShortTermGoal:   genRoleDetail.ShortTermGoal,
@@ -1014,10 +1135,9 @@ func (s *StoryService) UpdateStoryRoleDescriptionDetail(ctx context.Context, req
 		}, nil
 	}
 	descStr, _ := json.Marshal(req.GetCharacterDetail())
-	roleinfo.CharacterDetail = string(descStr)
-	err = models.UpdateStoryRole(ctx, int64(roleinfo.ID), map[string]interface{}{
-		"character_detail": roleinfo.CharacterDetail,
-	})
+	err = updateRoleColumnsModerated(ctx, roleinfo, map[string]interface{}{
+		"character_detail": string(descStr),
+	}, manualRevision(ctx))
 	if err != nil {
 		log.Log().Error("update story role description failed", zap.Error(err))
 		return nil, err
@@ -1042,10 +1162,9 @@ func (s *StoryService) UpdateRoleDescription(ctx context.Context, req *api.Updat
 	if roleinfo.CreatorID != req.GetUserId() {
 		return nil, errors.New("have no permission")
 	}
-	roleinfo.CharacterDescription = req.GetDescription()
-	err = models.UpdateStoryRole(ctx, int64(roleinfo.ID), map[string]interface{}{
+	err = updateRoleColumnsModerated(ctx, roleinfo, map[string]interface{}{
 		"character_description": req.GetDescription(),
-	})
+	}, manualRevision(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -1063,10 +1182,9 @@ func (s *StoryService) UpdateStoryRolePrompt(ctx context.Context, req *api.Updat
 	if roleinfo.CreatorID != req.GetRoleId() {
 		return nil, errors.New("have no permission")
 	}
-	roleinfo.CharacterPrompt = req.GetPrompt()
-	err = models.UpdateStoryRole(ctx, int64(roleinfo.ID), map[string]interface{}{
+	err = updateRoleColumns(ctx, roleinfo, map[string]interface{}{
 		"character_prompt": req.GetPrompt(),
-	})
+	}, manualRevision(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -1100,10 +1218,9 @@ func (s *StoryService) UpdateRolePrompt(ctx context.Context, req *api.UpdateRole
 	if roleinfo.CreatorID != req.GetUserId() {
 		return nil, errors.New("have no permission")
 	}
-	roleinfo.CharacterPrompt = req.GetPrompt()
-	err = models.UpdateStoryRole(ctx, int64(roleinfo.ID), map[string]interface{}{
+	err = updateRoleColumns(ctx, roleinfo, map[string]interface{}{
 		"character_prompt": req.GetPrompt(),
-	})
+	}, manualRevision(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -1127,14 +1244,14 @@ func (s *StoryService) UpdateStoryRolePoster(ctx context.Context, req *api.Updat
 		log.Log().Error("have no permission", zap.Any("roleinfo", roleinfo))
 		return nil, errors.New("have no permission")
 	}
-	roleinfo.PosterURL = req.GetImageUrl()
-	err = models.UpdateStoryRole(ctx, int64(roleinfo.ID), map[string]interface{}{
+	err = updateRoleColumns(ctx, roleinfo, map[string]interface{}{
 		"poster_url": req.GetImageUrl(),
-	})
+	}, manualRevision(ctx))
 	if err != nil {
 		log.Log().Error("update story role poster failed", zap.Error(err))
 		return nil, err
 	}
+	roleinfo.PosterURL = req.GetImageUrl()
 	log.Log().Info("update story role poster success", zap.Any("roleinfo", roleinfo))
 	return &api.UpdateStoryRolePosterResponse{
 		Code:    0,
@@ -1157,15 +1274,20 @@ func (s *StoryService) GenerateStoryRolePoster(ctx context.Context, req *api.Gen
 		log.Log().Error("have no permission", zap.Any("roleinfo", roleinfo))
 		return nil, errors.New("have no permission")
 	}
-	rolePosterParams := coze.CozeStoryRoleBackgroundImageParams{
-		RoleName:   roleinfo.CharacterName,
-		RoleDesc:   roleinfo.CharacterDetail,
-		RoleImage:  roleinfo.CharacterAvatar,
-		StoryDesc:  storyinfo.Origin,
-		StoryTitle: storyinfo.Title,
-		Style:      "吉卜力",
-	}
-	imageUrl, err := s.cozeClient.StoryRoleBackgroundImage(ctx, rolePosterParams)
+	rolePosterParams := &client.RolePosterParams{
+		Name:        roleinfo.CharacterName,
+		Description: roleinfo.CharacterDetail,
+		Image:       roleinfo.CharacterAvatar,
+		StoryDesc:   storyinfo.Origin,
+		StoryTitle:  storyinfo.Title,
+		Style:       "吉卜力",
+	}
+	var imageUrl string
+	_, err = s.failover(ctx, SceneRole, func(ctx context.Context, p client.Provider) error {
+		var err error
+		imageUrl, err = s.rolePoster(ctx, p, rolePosterParams)
+		return err
+	})
 	if err != nil {
 		log.Log().Error("generate story role poster failed", zap.Error(err))
 		return nil, err
@@ -0,0 +1,169 @@
+package story
+
+// 故事板流式渲染，边生成边推送章节简述、情节和图片提示词
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	api "github.com/grapery/common-protoc/gen"
+	"github.com/grapery/grapery/utils"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// 流式渲染的事件类型
+const (
+	StreamEventStart       = "start"        // 开始生成，之前收到的内容作废（故障转移后重新生成）
+	StreamEventSummary     = "summary"      // 章节简述
+	StreamEventScene       = "scene"        // 一个详细情节
+	StreamEventCharacters  = "characters"   // 参与人物
+	StreamEventImagePrompt = "image_prompt" // 情节的图片提示词
+	StreamEventDone        = "done"
+	StreamEventError       = "error"
+)
+
+// 章节json中的字段，与 StoryChapter/StoryChapterV2 的json tag一致
+const (
+	chapterSummaryKey    = "章节情节简述"
+	chapterDetailKey     = "章节详细情节"
+	chapterCharactersKey = "参与人物"
+)
+
+// StoryboardStreamEvent 流式渲染推送的事件
+type StoryboardStreamEvent struct {
+	Type        string            `json:"type"`
+	Platform    string            `json:"platform,omitempty"`
+	Summary     *ChapterSummary   `json:"summary,omitempty"`
+	Scene       *DetailScene      `json:"scene,omitempty"`
+	Characters  []Character       `json:"characters,omitempty"`
+	ImagePrompt *SceneImagePrompt `json:"image_prompt,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// SceneImagePrompt 情节对应的图片提示词
+type SceneImagePrompt struct {
+	SceneId string `json:"scene_id"`
+	Prompt  string `json:"prompt"`
+}
+
+type storyboardStreamKey struct{}
+
+// storyboardStream 解析模型的增量输出，并把已完整的部分推送给客户端
+type storyboardStream struct {
+	send           func(*StoryboardStreamEvent) error
+	scanner        utils.JSONStreamScanner
+	platform       string
+	summarySent    bool
+	charactersSent bool
+	// 已处理的情节数，包括无法解析而跳过的
+	sceneItems int
+	scenes     []*DetailScene
+}
+
+func storyboardStreamFromContext(ctx context.Context) *storyboardStream {
+	stream, _ := ctx.Value(storyboardStreamKey{}).(*storyboardStream)
+	return stream
+}
+
+// start 每个平台开始生成前调用
+func (st *storyboardStream) start(platform string) error {
+	st.scanner.Reset()
+	st.platform = platform
+	st.summarySent = false
+	st.charactersSent = false
+	st.sceneItems = 0
+	st.scenes = nil
+	return st.send(&StoryboardStreamEvent{Type: StreamEventStart, Platform: platform})
+}
+
+func (st *storyboardStream) write(delta string) error {
+	st.scanner.Write(delta)
+	return st.emit()
+}
+
+// flush 使用完整的输出补发未推送的部分，用于不支持流式输出的平台
+func (st *storyboardStream) flush(content string) error {
+	st.scanner.Reset()
+	st.scanner.Write(content)
+	return st.emit()
+}
+
+func (st *storyboardStream) emit() error {
+	fields, items := st.scanner.Scan()
+	if raw, ok := fields[chapterSummaryKey]; ok && !st.summarySent {
+		summary := new(ChapterSummary)
+		if err := json.Unmarshal(raw, summary); err == nil {
+			st.summarySent = true
+			if err := st.send(&StoryboardStreamEvent{Type: StreamEventSummary, Summary: summary}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, raw := range items[chapterDetailKey][st.sceneItems:] {
+		st.sceneItems++
+		scene := new(DetailScene)
+		if err := json.Unmarshal(raw, scene); err != nil {
+			log.Log().Warn("skip unparsable storyboard scene", zap.String("platform", st.platform),
+				zap.String("scene", string(raw)), zap.Error(err))
+			continue
+		}
+		st.scenes = append(st.scenes, scene)
+		if err := st.send(&StoryboardStreamEvent{Type: StreamEventScene, Scene: scene}); err != nil {
+			return err
+		}
+	}
+	if raw, ok := fields[chapterCharactersKey]; ok && !st.charactersSent {
+		characters := make([]Character, 0)
+		if err := json.Unmarshal(raw, &characters); err == nil {
+			st.charactersSent = true
+			if err := st.send(&StoryboardStreamEvent{Type: StreamEventCharacters, Characters: characters}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finish 推送图片提示词和结束事件
+func (st *storyboardStream) finish() error {
+	for _, scene := range st.scenes {
+		if scene.ImagePrompt == "" {
+			continue
+		}
+		if err := st.send(&StoryboardStreamEvent{
+			Type:        StreamEventImagePrompt,
+			ImagePrompt: &SceneImagePrompt{SceneId: scene.ID, Prompt: scene.ImagePrompt},
+		}); err != nil {
+			return err
+		}
+	}
+	return st.send(&StoryboardStreamEvent{Type: StreamEventDone, Platform: st.platform})
+}
+
+// RenderStoryboardStream 流式渲染故事板，send 返回错误时中止生成
+func (s *StoryService) RenderStoryboardStream(ctx context.Context, req *api.RenderStoryboardRequest, send func(*StoryboardStreamEvent) error) error {
+	stream := &storyboardStream{send: send}
+	resp, err := s.RenderStoryboard(context.WithValue(ctx, storyboardStreamKey{}, stream), req)
+	if err != nil {
+		return err
+	}
+	if resp.GetCode() != 0 {
+		return send(&StoryboardStreamEvent{Type: StreamEventError, Error: resp.GetMessage()})
+	}
+	return stream.finish()
+}
+
+// ContinueRenderStoryStream 流式续写章节
+func (s *StoryService) ContinueRenderStoryStream(ctx context.Context, req *api.ContinueRenderStoryRequest, send func(*StoryboardStreamEvent) error) error {
+	stream := &storyboardStream{send: send}
+	resp, err := s.ContinueRenderStory(context.WithValue(ctx, storyboardStreamKey{}, stream), req)
+	if err != nil {
+		return err
+	}
+	if resp.GetCode() != 0 {
+		return send(&StoryboardStreamEvent{Type: StreamEventError, Error: resp.GetMessage()})
+	}
+	return stream.finish()
+}
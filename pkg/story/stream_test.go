@@ -0,0 +1,39 @@
+package story
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoryboardStream(t *testing.T) {
+	events := make([]*StoryboardStreamEvent, 0)
+	st := &storyboardStream{send: func(e *StoryboardStreamEvent) error {
+		events = append(events, e)
+		return nil
+	}}
+	assert.NoError(t, st.start("coze"))
+	content := `{"章节情节简述":{"章节题目":"t","章节内容":"c"},"章节详细情节":[` +
+		`{"情节id":"1","情节内容":"a","图片提示词":"p1"},` +
+		`{"情节id":2},` +
+		`{"情节id":"3","情节内容":"b"}]}`
+	// 分段写入，情节完整后才推送
+	for i := 0; i < len(content); i += 7 {
+		end := min(i+7, len(content))
+		assert.NoError(t, st.write(content[i:end]))
+	}
+	// 补发完整内容不会重复推送
+	assert.NoError(t, st.flush(content))
+	assert.NoError(t, st.finish())
+
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	// 无法解析的情节被跳过
+	assert.Equal(t, []string{StreamEventStart, StreamEventSummary, StreamEventScene, StreamEventScene,
+		StreamEventImagePrompt, StreamEventDone}, types)
+	assert.Equal(t, "1", events[2].Scene.ID)
+	assert.Equal(t, "3", events[3].Scene.ID)
+	assert.Equal(t, "p1", events[4].ImagePrompt.Prompt)
+}
@@ -11,6 +11,7 @@ import (
 
 	api "github.com/grapery/common-protoc/gen"
 	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/block"
 	"github.com/grapery/grapery/utils"
 	"github.com/grapery/grapery/utils/errors"
 )
@@ -490,6 +491,19 @@ func (user *UserService) FetchActives(ctx context.Context, req *api.FetchActives
 			roleMap[int64(role.ID)] = role
 		}
 	}
+	allActives = filterActives(block.LoadFilter(ctx, req.GetUserId()), allActives, groupMap, storyMap, roleMap)
+	if len(allActives) == 0 {
+		return &api.FetchActivesResponse{
+			Code: api.ResponseCode_OK,
+			Msg:  "success",
+			Data: &api.FetchActivesResponse_Data{
+				List:      nil,
+				Timestamp: lasttimeStamp,
+				PageSize:  int64(req.GetPageSize()),
+				Offset:    int64(req.GetOffset()),
+			},
+		}, nil
+	}
 	activeUsers := make(map[int64]*models.User)
 	userIds := make([]int64, 0)
 	for _, active := range allActives {
@@ -594,6 +608,31 @@ func (user *UserService) FetchActives(ctx context.Context, req *api.FetchActives
 	}, nil
 }
 
+// filterActives 去掉被屏蔽用户的动态和内容包含屏蔽词的动态
+func filterActives(filter *block.Filter, actives []*models.Active, groupMap map[int64]*models.Group,
+	storyMap map[int64]*models.Story, roleMap map[int64]*models.StoryRole) []*models.Active {
+	if filter.Empty() {
+		return actives
+	}
+	visible := make([]*models.Active, 0, len(actives))
+	for _, active := range actives {
+		var texts []string
+		if group, ok := groupMap[active.GroupId]; ok {
+			texts = append(texts, group.Name, group.ShortDesc)
+		}
+		if story, ok := storyMap[active.StoryId]; ok {
+			texts = append(texts, story.Name, story.ShortDesc)
+		}
+		if role, ok := roleMap[active.StoryRoleId]; ok {
+			texts = append(texts, role.CharacterName, role.CharacterDescription)
+		}
+		if !filter.Hidden(active.UserId, texts...) {
+			visible = append(visible, active)
+		}
+	}
+	return visible
+}
+
 // 组织内搜索指定用户
 func (user *UserService) SearchUser(ctx context.Context, req *api.SearchUserRequest) (
 	*api.SearchUserResponse, error) {
@@ -884,12 +923,16 @@ func convertModelUserProfileToApi(profile *models.UserProfile) *api.UserProfileI
 	}
 }
 
+// FollowUser 还没有实现，实现时通过 block.Follow 关注，拒绝有屏蔽关系的用户之间的关注
 func (user *UserService) FollowUser(ctx context.Context, req *api.FollowUserRequest) (*api.FollowUserResponse, error) {
 	return nil, errors.ErrFeatureNotImplemented
 }
 func (user *UserService) UnfollowUser(ctx context.Context, req *api.UnfollowUserRequest) (*api.UnfollowUserResponse, error) {
 	return nil, errors.ErrFeatureNotImplemented
 }
+
+// GetFollowList 和 GetFollowerList 还没有实现，实现时通过 block.ListFollowing、block.ListFollowers
+// 查询，过滤与当前用户之间有屏蔽关系的用户
 func (user *UserService) GetFollowList(ctx context.Context, req *api.GetFollowListRequest) (*api.GetFollowListResponse, error) {
 	return nil, errors.ErrFeatureNotImplemented
 }
@@ -126,6 +126,31 @@ func AuthFunc(ctx context.Context) (context.Context, error) {
 	return newCtx, nil
 }
 
+// ChatAuthFunc gRPC聊天服务的鉴权，在建立连接时校验 metadata 中的token，
+// 支持 authorization: bearer <token> 和网关转发的 cookie 两种方式
+func ChatAuthFunc(ctx context.Context) (context.Context, error) {
+	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, cookie := range md.Get(utils.GrpcGateWayCookie) {
+			for _, item := range strings.Split(cookie, ";") {
+				if value, ok := strings.CutPrefix(strings.TrimSpace(item), "token="); ok {
+					token = value
+				}
+			}
+		}
+	}
+	if token == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "empty auth token")
+	}
+	jwtInfo := jwt.NewJwtWrapper(utils.SecretKey, utils.ExpirationHours)
+	tokenInfo, err := jwtInfo.ValidateToken(token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid auth token: %v", err)
+	}
+	return context.WithValue(ctx, utils.UserIdKey, tokenInfo.UID), nil
+}
+
 type Result struct {
 	Code  int    `json:"code,omitempty"`
 	Token string `json:"token,omitempty"`
@@ -2,16 +2,30 @@ package common
 
 import (
 	// "net/http"
+	"bytes"
 	"context"
 	"errors"
+	"mime"
 	"strings"
 
 	connect "github.com/bufbuild/connect-go"
 
 	api "github.com/grapery/common-protoc/gen"
-	tencentcloud "github.com/grapery/grapery/pkg/cloud/tencentcloud"
+	"github.com/grapery/grapery/pkg/storage"
 )
 
+// uploadDirs 用户上传文件按类型保存的目录
+var uploadDirs = map[string]string{
+	"jpg":  "avator",
+	"jpeg": "avator",
+	"png":  "avator",
+	"bmp":  "avator",
+	"svg":  "avator",
+	"gif":  "pic",
+	"txt":  "content",
+	"mp4":  "video",
+}
+
 type CommonService struct {
 }
 
@@ -47,8 +61,12 @@ func (cs *CommonService) UploadImageFile(ctx context.Context, req *connect.Reque
 	} else {
 		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("unsupported image format"))
 	}
-	// TODO: save image data to storage
-	filepath, err := tencentcloud.UploadObject(req.Msg.ImageData, fileFormat)
+	store, err := storage.Default()
+	if err != nil {
+		return nil, connect.NewError(connect.CodeUnavailable, err)
+	}
+	key := storage.NewKey(uploadDirs[fileFormat], fileFormat)
+	filepath, err := store.Put(ctx, key, bytes.NewReader(req.Msg.ImageData), mime.TypeByExtension("."+fileFormat))
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
@@ -0,0 +1,90 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 角色Agent接口，只支持json编码
+const (
+	ChatAgentPath                     = "/common.ChatAgentAPI/"
+	UpdateChatAgentProcedure          = "/common.ChatAgentAPI/UpdateChatAgent"
+	SendChatAgentMessageProcedure     = "/common.ChatAgentAPI/SendChatAgentMessage"
+	AcceptStoryboardProposalProcedure = "/common.ChatAgentAPI/AcceptStoryboardProposal"
+)
+
+type UpdateChatAgentRequest struct {
+	ChatId      int64  `json:"chat_id"`
+	UseAgent    bool   `json:"use_agent"`
+	AgentPrompt string `json:"agent_prompt"`
+}
+
+type UpdateChatAgentResponse struct {
+	ChatId   int64 `json:"chat_id"`
+	UseAgent bool  `json:"use_agent"`
+}
+
+type SendChatAgentMessageRequest struct {
+	ChatId  int64  `json:"chat_id"`
+	Content string `json:"content"`
+	Uuid    string `json:"uuid"`
+}
+
+type AcceptStoryboardProposalRequest struct {
+	ChatId    int64 `json:"chat_id"`
+	MessageId int64 `json:"message_id"`
+}
+
+// NewChatAgentHandler 返回角色Agent接口的路径和handler
+func NewChatAgentHandler(s *StoryRoleService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(UpdateChatAgentProcedure, connect.NewUnaryHandler(
+		UpdateChatAgentProcedure, s.UpdateChatAgent, opts...))
+	mux.Handle(SendChatAgentMessageProcedure, connect.NewUnaryHandler(
+		SendChatAgentMessageProcedure, s.SendChatAgentMessage, opts...))
+	mux.Handle(AcceptStoryboardProposalProcedure, connect.NewUnaryHandler(
+		AcceptStoryboardProposalProcedure, s.AcceptStoryboardProposal, opts...))
+	return ChatAgentPath, mux
+}
+
+func (s *StoryRoleService) UpdateChatAgent(ctx context.Context, req *connect.Request[UpdateChatAgentRequest]) (*connect.Response[UpdateChatAgentResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	err = storyServer.GetStoryServer().UpdateChatAgent(ctx, req.Msg.ChatId, req.Msg.UseAgent, req.Msg.AgentPrompt)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&UpdateChatAgentResponse{ChatId: req.Msg.ChatId, UseAgent: req.Msg.UseAgent}), nil
+}
+
+func (s *StoryRoleService) SendChatAgentMessage(ctx context.Context, req *connect.Request[SendChatAgentMessageRequest]) (*connect.Response[storyServer.AgentTurn], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().SendChatAgentMessage(ctx, req.Msg.ChatId, req.Msg.Content, req.Msg.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) AcceptStoryboardProposal(ctx context.Context, req *connect.Request[AcceptStoryboardProposalRequest]) (*connect.Response[storyServer.StoryboardProposal], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().AcceptStoryboardProposal(ctx, req.Msg.ChatId, req.Msg.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
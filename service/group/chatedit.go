@@ -0,0 +1,150 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 角色聊天消息的发送、重新生成、编辑和分支接口，只支持json编码
+const (
+	ChatMessagePath                    = "/common.ChatMessageAPI/"
+	RegenerateChatReplyProcedure       = "/common.ChatMessageAPI/RegenerateChatReply"
+	ListChatReplyAlternativesProcedure = "/common.ChatMessageAPI/ListChatReplyAlternatives"
+	SelectChatReplyProcedure           = "/common.ChatMessageAPI/SelectChatReply"
+	EditChatMessageProcedure           = "/common.ChatMessageAPI/EditChatMessage"
+	ForkChatContextProcedure           = "/common.ChatMessageAPI/ForkChatContext"
+	SendChatMessageProcedure           = "/common.ChatMessageAPI/SendChatMessage"
+	GetChatMessageProcedure            = "/common.ChatMessageAPI/GetChatMessage"
+)
+
+type RegenerateChatReplyRequest struct {
+	ChatId int64 `json:"chat_id"`
+}
+
+type ChatMessageRequest struct {
+	ChatId    int64 `json:"chat_id"`
+	MessageId int64 `json:"message_id"`
+}
+
+type EditChatMessageRequest struct {
+	ChatId    int64  `json:"chat_id"`
+	MessageId int64  `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type SendChatMessageRequest struct {
+	ChatId  int64    `json:"chat_id"`
+	Content string   `json:"content"`
+	Images  []string `json:"images"`
+	Uuid    string   `json:"uuid"`
+}
+
+// NewChatMessageHandler 返回聊天消息编辑接口的路径和handler
+func NewChatMessageHandler(s *StoryRoleService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(RegenerateChatReplyProcedure, connect.NewUnaryHandler(
+		RegenerateChatReplyProcedure, s.RegenerateChatReply, opts...))
+	mux.Handle(ListChatReplyAlternativesProcedure, connect.NewUnaryHandler(
+		ListChatReplyAlternativesProcedure, s.ListChatReplyAlternatives, opts...))
+	mux.Handle(SelectChatReplyProcedure, connect.NewUnaryHandler(
+		SelectChatReplyProcedure, s.SelectChatReply, opts...))
+	mux.Handle(EditChatMessageProcedure, connect.NewUnaryHandler(
+		EditChatMessageProcedure, s.EditChatMessage, opts...))
+	mux.Handle(ForkChatContextProcedure, connect.NewUnaryHandler(
+		ForkChatContextProcedure, s.ForkChatContext, opts...))
+	mux.Handle(SendChatMessageProcedure, connect.NewUnaryHandler(
+		SendChatMessageProcedure, s.SendChatMessage, opts...))
+	mux.Handle(GetChatMessageProcedure, connect.NewUnaryHandler(
+		GetChatMessageProcedure, s.GetChatMessage, opts...))
+	return ChatMessagePath, mux
+}
+
+func (s *StoryRoleService) RegenerateChatReply(ctx context.Context, req *connect.Request[RegenerateChatReplyRequest]) (*connect.Response[storyServer.ChatTurn], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().RegenerateChatReply(ctx, req.Msg.ChatId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) ListChatReplyAlternatives(ctx context.Context, req *connect.Request[ChatMessageRequest]) (*connect.Response[storyServer.ChatReplyAlternatives], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().ListChatReplyAlternatives(ctx, req.Msg.ChatId, req.Msg.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) SelectChatReply(ctx context.Context, req *connect.Request[ChatMessageRequest]) (*connect.Response[storyServer.ChatTurn], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().SelectChatReply(ctx, req.Msg.ChatId, req.Msg.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) EditChatMessage(ctx context.Context, req *connect.Request[EditChatMessageRequest]) (*connect.Response[storyServer.ChatTurn], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().EditChatMessage(ctx, req.Msg.ChatId, req.Msg.MessageId, req.Msg.Content)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) ForkChatContext(ctx context.Context, req *connect.Request[ChatMessageRequest]) (*connect.Response[storyServer.ChatForkInfo], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().ForkChatContext(ctx, req.Msg.ChatId, req.Msg.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) SendChatMessage(ctx context.Context, req *connect.Request[SendChatMessageRequest]) (*connect.Response[storyServer.ChatTurn], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().SendChatMessage(ctx, req.Msg.ChatId, req.Msg.Content, req.Msg.Images, req.Msg.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) GetChatMessage(ctx context.Context, req *connect.Request[ChatMessageRequest]) (*connect.Response[storyServer.ChatMessageItem], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().GetChatMessage(ctx, req.Msg.ChatId, req.Msg.MessageId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
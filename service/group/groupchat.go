@@ -0,0 +1,138 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 多角色群聊接口，只支持json编码
+// turn_policy: round_robin 轮流回复, all 所有角色回复, director 由大模型选择
+const (
+	GroupChatPath                 = "/common.GroupChatAPI/"
+	CreateGroupChatProcedure      = "/common.GroupChatAPI/CreateGroupChat"
+	GetGroupChatsProcedure        = "/common.GroupChatAPI/GetGroupChats"
+	UpdateGroupChatProcedure      = "/common.GroupChatAPI/UpdateGroupChat"
+	SendGroupChatMessageProcedure = "/common.GroupChatAPI/SendGroupChatMessage"
+	GetGroupChatMessagesProcedure = "/common.GroupChatAPI/GetGroupChatMessages"
+)
+
+type CreateGroupChatRequest struct {
+	StoryId    int64   `json:"story_id"`
+	RoleIds    []int64 `json:"role_ids"` // 顺序即轮流发言的顺序
+	Title      string  `json:"title"`
+	TurnPolicy string  `json:"turn_policy"`
+}
+
+type GetGroupChatsRequest struct {
+	Offset   int `json:"offset"`
+	PageSize int `json:"page_size"`
+}
+
+type UpdateGroupChatRequest struct {
+	ChatId     int64   `json:"chat_id"`
+	RoleIds    []int64 `json:"role_ids"`    // 为空时不修改
+	TurnPolicy string  `json:"turn_policy"` // 为空时不修改
+}
+
+type SendGroupChatMessageRequest struct {
+	ChatId  int64  `json:"chat_id"`
+	Content string `json:"content"`
+	Uuid    string `json:"uuid"`
+}
+
+type GetGroupChatMessagesRequest struct {
+	ChatId   int64 `json:"chat_id"`
+	BeforeId int64 `json:"before_id"` // 为0时从最新的消息开始
+	PageSize int   `json:"page_size"`
+}
+
+type GroupChatMessages struct {
+	List []*storyServer.GroupChatMessage `json:"list"`
+}
+
+// NewGroupChatHandler 返回群聊接口的路径和handler
+func NewGroupChatHandler(s *StoryRoleService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(CreateGroupChatProcedure, connect.NewUnaryHandler(
+		CreateGroupChatProcedure, s.CreateGroupChat, opts...))
+	mux.Handle(GetGroupChatsProcedure, connect.NewUnaryHandler(
+		GetGroupChatsProcedure, s.GetGroupChats, opts...))
+	mux.Handle(UpdateGroupChatProcedure, connect.NewUnaryHandler(
+		UpdateGroupChatProcedure, s.UpdateGroupChat, opts...))
+	mux.Handle(SendGroupChatMessageProcedure, connect.NewUnaryHandler(
+		SendGroupChatMessageProcedure, s.SendGroupChatMessage, opts...))
+	mux.Handle(GetGroupChatMessagesProcedure, connect.NewUnaryHandler(
+		GetGroupChatMessagesProcedure, s.GetGroupChatMessages, opts...))
+	return GroupChatPath, mux
+}
+
+func (s *StoryRoleService) CreateGroupChat(ctx context.Context, req *connect.Request[CreateGroupChatRequest]) (*connect.Response[storyServer.GroupChatInfo], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().CreateGroupChat(ctx, &storyServer.CreateGroupChatParams{
+		StoryId:    req.Msg.StoryId,
+		RoleIds:    req.Msg.RoleIds,
+		Title:      req.Msg.Title,
+		TurnPolicy: req.Msg.TurnPolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) GetGroupChats(ctx context.Context, req *connect.Request[GetGroupChatsRequest]) (*connect.Response[storyServer.GroupChatList], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().GetGroupChats(ctx, req.Msg.Offset, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) UpdateGroupChat(ctx context.Context, req *connect.Request[UpdateGroupChatRequest]) (*connect.Response[storyServer.GroupChatInfo], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().UpdateGroupChat(ctx, req.Msg.ChatId, req.Msg.RoleIds, req.Msg.TurnPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) SendGroupChatMessage(ctx context.Context, req *connect.Request[SendGroupChatMessageRequest]) (*connect.Response[storyServer.GroupChatReply], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().SendGroupChatMessage(ctx, req.Msg.ChatId, req.Msg.Content, req.Msg.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryRoleService) GetGroupChatMessages(ctx context.Context, req *connect.Request[GetGroupChatMessagesRequest]) (*connect.Response[GroupChatMessages], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	list, err := storyServer.GetStoryServer().GetGroupChatMessages(ctx, req.Msg.ChatId, req.Msg.BeforeId, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&GroupChatMessages{List: list}), nil
+}
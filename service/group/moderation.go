@@ -0,0 +1,84 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 内容审核队列接口，只有配置的审核人可以调用，只支持json编码
+// status: 0 等待审核, 1 通过, 2 拒绝
+// entity_type: 1 故事, 2 故事板, 3 场景, 4 角色, 5 评论, 6 聊天消息, 7 生成的图片, 8 用户
+// 用户举报的记录 source 为 report，reports 为举报次数
+const (
+	ModerationPath                 = "/common.ModerationAPI/"
+	ListModerationItemsProcedure   = "/common.ModerationAPI/ListModerationItems"
+	ApproveModerationItemProcedure = "/common.ModerationAPI/ApproveModerationItem"
+	RejectModerationItemProcedure  = "/common.ModerationAPI/RejectModerationItem"
+)
+
+type ListModerationItemsRequest struct {
+	Status     int `json:"status"`
+	EntityType int `json:"entity_type"` // 为0时返回所有类型
+	Offset     int `json:"offset"`
+	PageSize   int `json:"page_size"`
+}
+
+type ReviewModerationItemRequest struct {
+	ItemId int64  `json:"item_id"`
+	Note   string `json:"note"`
+}
+
+// NewModerationHandler 返回审核队列接口的路径和handler
+func NewModerationHandler(s *GroupService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(ListModerationItemsProcedure, connect.NewUnaryHandler(
+		ListModerationItemsProcedure, s.ListModerationItems, opts...))
+	mux.Handle(ApproveModerationItemProcedure, connect.NewUnaryHandler(
+		ApproveModerationItemProcedure, s.ApproveModerationItem, opts...))
+	mux.Handle(RejectModerationItemProcedure, connect.NewUnaryHandler(
+		RejectModerationItemProcedure, s.RejectModerationItem, opts...))
+	return ModerationPath, mux
+}
+
+func (s *GroupService) ListModerationItems(ctx context.Context, req *connect.Request[ListModerationItemsRequest]) (*connect.Response[moderation.ModerationItemList], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := moderation.ListItems(ctx, req.Msg.Status, req.Msg.EntityType, req.Msg.Offset, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *GroupService) ApproveModerationItem(ctx context.Context, req *connect.Request[ReviewModerationItemRequest]) (*connect.Response[models.ModerationItem], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := moderation.Approve(ctx, req.Msg.ItemId, req.Msg.Note)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *GroupService) RejectModerationItem(ctx context.Context, req *connect.Request[ReviewModerationItemRequest]) (*connect.Response[models.ModerationItem], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := moderation.Reject(ctx, req.Msg.ItemId, req.Msg.Note)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
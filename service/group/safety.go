@@ -0,0 +1,163 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	"github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/block"
+	"github.com/grapery/grapery/pkg/moderation"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 举报、屏蔽用户和屏蔽词接口，只支持json编码
+// entity_type: 2 故事板, 4 角色, 5 评论, 8 用户
+// reason: 1 垃圾广告, 2 色情低俗, 3 暴力血腥, 4 骚扰辱骂, 5 侵权, 6 其他
+const (
+	SafetyPath                  = "/common.SafetyAPI/"
+	ReportContentProcedure      = "/common.SafetyAPI/ReportContent"
+	BlockUserProcedure          = "/common.SafetyAPI/BlockUser"
+	UnblockUserProcedure        = "/common.SafetyAPI/UnblockUser"
+	ListBlockedUsersProcedure   = "/common.SafetyAPI/ListBlockedUsers"
+	AddMutedKeywordProcedure    = "/common.SafetyAPI/AddMutedKeyword"
+	RemoveMutedKeywordProcedure = "/common.SafetyAPI/RemoveMutedKeyword"
+	ListMutedKeywordsProcedure  = "/common.SafetyAPI/ListMutedKeywords"
+)
+
+type ReportContentRequest struct {
+	EntityType int    `json:"entity_type"`
+	EntityId   int64  `json:"entity_id"`
+	Reason     int    `json:"reason"`
+	Detail     string `json:"detail"`
+}
+
+type BlockUserRequest struct {
+	UserId int64 `json:"user_id"`
+}
+
+type ListBlockedUsersRequest struct {
+	Offset   int `json:"offset"`
+	PageSize int `json:"page_size"`
+}
+
+type MutedKeywordRequest struct {
+	Keyword string `json:"keyword"`
+}
+
+type ListMutedKeywordsRequest struct{}
+
+type MutedKeywordList struct {
+	List []string `json:"list"`
+}
+
+type SafetyResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func safetyOK() *connect.Response[SafetyResponse] {
+	return connect.NewResponse(&SafetyResponse{Code: 0, Message: "OK"})
+}
+
+// NewSafetyHandler 返回举报和屏蔽接口的路径和handler
+func NewSafetyHandler(s *GroupService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(ReportContentProcedure, connect.NewUnaryHandler(
+		ReportContentProcedure, s.ReportContent, opts...))
+	mux.Handle(BlockUserProcedure, connect.NewUnaryHandler(
+		BlockUserProcedure, s.BlockUser, opts...))
+	mux.Handle(UnblockUserProcedure, connect.NewUnaryHandler(
+		UnblockUserProcedure, s.UnblockUser, opts...))
+	mux.Handle(ListBlockedUsersProcedure, connect.NewUnaryHandler(
+		ListBlockedUsersProcedure, s.ListBlockedUsers, opts...))
+	mux.Handle(AddMutedKeywordProcedure, connect.NewUnaryHandler(
+		AddMutedKeywordProcedure, s.AddMutedKeyword, opts...))
+	mux.Handle(RemoveMutedKeywordProcedure, connect.NewUnaryHandler(
+		RemoveMutedKeywordProcedure, s.RemoveMutedKeyword, opts...))
+	mux.Handle(ListMutedKeywordsProcedure, connect.NewUnaryHandler(
+		ListMutedKeywordsProcedure, s.ListMutedKeywords, opts...))
+	return SafetyPath, mux
+}
+
+func (s *GroupService) ReportContent(ctx context.Context, req *connect.Request[ReportContentRequest]) (*connect.Response[models.ContentReport], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := moderation.Report(ctx, req.Msg.EntityType, req.Msg.EntityId, req.Msg.Reason, req.Msg.Detail)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *GroupService) BlockUser(ctx context.Context, req *connect.Request[BlockUserRequest]) (*connect.Response[SafetyResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := block.Block(ctx, req.Msg.UserId); err != nil {
+		return nil, err
+	}
+	return safetyOK(), nil
+}
+
+func (s *GroupService) UnblockUser(ctx context.Context, req *connect.Request[BlockUserRequest]) (*connect.Response[SafetyResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := block.Unblock(ctx, req.Msg.UserId); err != nil {
+		return nil, err
+	}
+	return safetyOK(), nil
+}
+
+func (s *GroupService) ListBlockedUsers(ctx context.Context, req *connect.Request[ListBlockedUsersRequest]) (*connect.Response[block.BlockedUserList], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := block.ListBlocked(ctx, req.Msg.Offset, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *GroupService) AddMutedKeyword(ctx context.Context, req *connect.Request[MutedKeywordRequest]) (*connect.Response[SafetyResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := block.AddMutedKeyword(ctx, req.Msg.Keyword); err != nil {
+		return nil, err
+	}
+	return safetyOK(), nil
+}
+
+func (s *GroupService) RemoveMutedKeyword(ctx context.Context, req *connect.Request[MutedKeywordRequest]) (*connect.Response[SafetyResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := block.RemoveMutedKeyword(ctx, req.Msg.Keyword); err != nil {
+		return nil, err
+	}
+	return safetyOK(), nil
+}
+
+func (s *GroupService) ListMutedKeywords(ctx context.Context, req *connect.Request[ListMutedKeywordsRequest]) (*connect.Response[MutedKeywordList], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := block.ListMutedKeywords(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&MutedKeywordList{List: ret}), nil
+}
@@ -0,0 +1,138 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	connect "github.com/bufbuild/connect-go"
+
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/service/auth"
+	"github.com/grapery/grapery/utils/elastic"
+)
+
+// 全文搜索接口，需要配置搜索服务，只支持json编码
+// kind: story 故事, role 角色, storyboard 故事板, group 小组, user 用户
+// highlight 中命中的词用 <em></em> 包围
+const (
+	SearchPath      = "/common.SearchAPI/"
+	SearchProcedure = "/common.SearchAPI/Search"
+)
+
+// 语义检索接口，不依赖搜索服务，需要配置向量平台
+// Semantic 按自然语言描述检索，kind 只支持 story、storyboard、role
+// SimilarStories 读完故事后推荐的相似故事，SimilarRoles 其他故事中的相似角色
+const (
+	SemanticProcedure       = "/common.SearchAPI/Semantic"
+	SimilarStoriesProcedure = "/common.SearchAPI/SimilarStories"
+	SimilarRolesProcedure   = "/common.SearchAPI/SimilarRoles"
+)
+
+type SearchRequest struct {
+	Kind        string   `json:"kind"`
+	Keyword     string   `json:"keyword"`
+	GroupId     int64    `json:"group_id"`
+	StoryId     int64    `json:"story_id"`
+	CreatorId   int64    `json:"creator_id"`
+	Tags        []string `json:"tags"`         // 包含任意一个标签
+	AIGenerated *bool    `json:"ai_generated"` // 不传时不过滤
+	Offset      int      `json:"offset"`
+	PageSize    int      `json:"page_size"`
+}
+
+type SemanticRequest struct {
+	Kind     string `json:"kind"`
+	Text     string `json:"text"`
+	PageSize int    `json:"page_size"`
+}
+
+type SimilarRequest struct {
+	Id       int64 `json:"id"` // 故事ID或者角色ID
+	PageSize int   `json:"page_size"`
+}
+
+// NewSearchHandler 返回全文搜索和语义检索接口的路径和handler
+func NewSearchHandler(s *GroupService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(SearchProcedure, connect.NewUnaryHandler(
+		SearchProcedure, s.Search, opts...))
+	mux.Handle(SemanticProcedure, connect.NewUnaryHandler(
+		SemanticProcedure, s.Semantic, opts...))
+	mux.Handle(SimilarStoriesProcedure, connect.NewUnaryHandler(
+		SimilarStoriesProcedure, s.SimilarStories, opts...))
+	mux.Handle(SimilarRolesProcedure, connect.NewUnaryHandler(
+		SimilarRolesProcedure, s.SimilarRoles, opts...))
+	return SearchPath, mux
+}
+
+func (s *GroupService) Search(ctx context.Context, req *connect.Request[SearchRequest]) (*connect.Response[search.Result], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if !search.Enabled() {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("search not enabled"))
+	}
+	ret, err := search.Search(ctx, req.Msg.Kind, &elastic.Query{
+		Keyword:     req.Msg.Keyword,
+		GroupId:     req.Msg.GroupId,
+		StoryId:     req.Msg.StoryId,
+		CreatorId:   req.Msg.CreatorId,
+		Tags:        req.Msg.Tags,
+		AIGenerated: req.Msg.AIGenerated,
+		Offset:      req.Msg.Offset,
+		Size:        req.Msg.PageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *GroupService) Semantic(ctx context.Context, req *connect.Request[SemanticRequest]) (*connect.Response[search.Result], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if !search.SemanticEnabled() {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("semantic search not enabled"))
+	}
+	if strings.TrimSpace(req.Msg.Text) == "" {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("empty text"))
+	}
+	ret, err := search.Semantic(ctx, req.Msg.Kind, req.Msg.Text, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *GroupService) SimilarStories(ctx context.Context, req *connect.Request[SimilarRequest]) (*connect.Response[search.Result], error) {
+	return s.similar(ctx, req, search.SimilarStories)
+}
+
+func (s *GroupService) SimilarRoles(ctx context.Context, req *connect.Request[SimilarRequest]) (*connect.Response[search.Result], error) {
+	return s.similar(ctx, req, search.SimilarRoles)
+}
+
+func (s *GroupService) similar(ctx context.Context, req *connect.Request[SimilarRequest],
+	fn func(ctx context.Context, id int64, size int) (*search.Result, error)) (*connect.Response[search.Result], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	if !search.SemanticEnabled() {
+		return nil, connect.NewError(connect.CodeUnimplemented, errors.New("semantic search not enabled"))
+	}
+	if req.Msg.Id <= 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid id"))
+	}
+	ret, err := fn(ctx, req.Msg.Id, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
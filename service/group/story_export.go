@@ -0,0 +1,68 @@
+package group
+
+import (
+	"net/http"
+	"strconv"
+
+	connect "github.com/bufbuild/connect-go"
+	"go.uber.org/zap"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+	"github.com/grapery/grapery/utils/export"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// 故事导出接口，返回文件而不是proto消息，使用普通的http GET
+// 参数：story_id、board_id（分支的最后一个故事板，为空时导出主线）、format（epub/markdown/html）
+const StoryExportProcedure = "/common.StoryExportAPI/Export"
+
+// NewStoryExportHandler 返回故事导出接口的路径和handler
+func NewStoryExportHandler(s *StoryService) (string, http.Handler) {
+	return StoryExportProcedure, http.HandlerFunc(s.ExportStoryFile)
+}
+
+func (s *StoryService) ExportStoryFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	ctx, err := auth.ConnectAuthFuncfunc(r.Context(), connect.Spec{Procedure: StoryExportProcedure}, r.Header, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	storyId, err := strconv.ParseInt(query.Get("story_id"), 10, 64)
+	if err != nil || storyId <= 0 {
+		http.Error(w, "invalid story_id", http.StatusBadRequest)
+		return
+	}
+	var boardId int64
+	if query.Get("board_id") != "" {
+		boardId, err = strconv.ParseInt(query.Get("board_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid board_id", http.StatusBadRequest)
+			return
+		}
+	}
+	format := export.Format(query.Get("format"))
+	switch format {
+	case "":
+		format = export.FormatEPUB
+	case export.FormatEPUB, export.FormatMarkdown, export.FormatHTML:
+	default:
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+	file, err := storyServer.GetStoryServer().ExportStory(ctx, storyId, boardId, format)
+	if err != nil {
+		log.Log().Error("export story failed", zap.Int64("story_id", storyId), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", file.ContentDisposition())
+	w.Header().Set("Content-Length", strconv.Itoa(len(file.Data)))
+	w.Write(file.Data)
+}
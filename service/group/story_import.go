@@ -0,0 +1,104 @@
+package group
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	connect "github.com/bufbuild/connect-go"
+	"go.uber.org/zap"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+	"github.com/grapery/grapery/utils/log"
+	"github.com/grapery/grapery/utils/manuscript"
+)
+
+// 故事导入接口，上传稿件使用 multipart/form-data 的 POST
+// 参数：file（或 content 文本）、group_id、title、format（markdown/text，为空时按文件名或内容判断）、
+// scene_size、extract_roles（true 时使用大模型抽取人物）
+const StoryImportProcedure = "/common.StoryImportAPI/Import"
+
+// importMaxUploadSize 上传稿件的最大字节数，超出部分由 ImportStory 拒绝
+const importMaxUploadSize = 4 << 20
+
+type storyImportResponse struct {
+	Code    int32                          `json:"code"`
+	Message string                         `json:"message"`
+	Data    *storyServer.ImportStoryResult `json:"data,omitempty"`
+}
+
+// NewStoryImportHandler 返回故事导入接口的路径和handler
+func NewStoryImportHandler(s *StoryService) (string, http.Handler) {
+	return StoryImportProcedure, http.HandlerFunc(s.ImportStoryFile)
+}
+
+func (s *StoryService) ImportStoryFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxUploadSize)
+	if err := r.ParseMultipartForm(importMaxUploadSize); err != nil {
+		http.Error(w, "invalid form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, err := auth.ConnectAuthFuncfunc(r.Context(), connect.Spec{Procedure: StoryImportProcedure}, r.Header, r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	groupId, err := strconv.ParseInt(r.FormValue("group_id"), 10, 64)
+	if err != nil || groupId <= 0 {
+		http.Error(w, "invalid group_id", http.StatusBadRequest)
+		return
+	}
+	params := &storyServer.ImportStoryParams{
+		GroupId:      groupId,
+		Title:        r.FormValue("title"),
+		Format:       manuscript.Format(r.FormValue("format")),
+		Content:      r.FormValue("content"),
+		ExtractRoles: r.FormValue("extract_roles") == "true",
+	}
+	if size := r.FormValue("scene_size"); size != "" {
+		if params.SceneSize, err = strconv.Atoi(size); err != nil {
+			http.Error(w, "invalid scene_size", http.StatusBadRequest)
+			return
+		}
+	}
+	if file, header, err := r.FormFile("file"); err == nil {
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "read file failed", http.StatusBadRequest)
+			return
+		}
+		params.Content = string(data)
+		if params.Format == "" {
+			switch strings.ToLower(path.Ext(header.Filename)) {
+			case ".md", ".markdown":
+				params.Format = manuscript.FormatMarkdown
+			case ".txt":
+				params.Format = manuscript.FormatText
+			}
+		}
+	}
+	switch params.Format {
+	case "", manuscript.FormatMarkdown, manuscript.FormatText:
+	default:
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+	resp := &storyImportResponse{Code: 0, Message: "OK"}
+	resp.Data, err = storyServer.GetStoryServer().ImportStory(ctx, params)
+	if err != nil {
+		log.Log().Error("import story failed", zap.Int64("group_id", groupId), zap.Error(err))
+		resp.Code = -1
+		resp.Message = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
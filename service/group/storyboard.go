@@ -132,7 +132,7 @@ func (s *StoryBoardService) RenderStoryboard(ctx context.Context, req *connect.R
 	}
 	resp := &gen.RenderStoryboardResponse{
 		Code:    ret.Code,
-		Message: "OK",
+		Message: ret.Message,
 		Data:    ret.Data,
 	}
 	return connect.NewResponse(resp), nil
@@ -169,7 +169,7 @@ func (s *StoryBoardService) GetStoryBoardRender(ctx context.Context, req *connec
 	}
 	resp := &gen.GetStoryBoardRenderResponse{
 		Code:    ret.Code,
-		Message: "OK",
+		Message: ret.Message,
 		Data:    ret.Data,
 	}
 	return connect.NewResponse(resp), nil
@@ -282,9 +282,10 @@ func (s *StoryBoardService) GetStoryBoardGenerate(ctx context.Context, req *conn
 		return nil, err
 	}
 	resp := &gen.GetStoryBoardGenerateResponse{
-		Code:    ret.Code,
-		Message: "OK",
-		List:    ret.List,
+		Code:            ret.Code,
+		Message:         ret.Message,
+		GeneratingStage: ret.GeneratingStage,
+		List:            ret.List,
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -296,7 +297,7 @@ func (s *StoryBoardService) RenderStoryBoardSences(ctx context.Context, req *con
 	}
 	resp := &gen.RenderStoryBoardSencesResponse{
 		Code:    ret.Code,
-		Message: "OK",
+		Message: ret.Message,
 		List:    ret.List,
 	}
 	return connect.NewResponse(resp), nil
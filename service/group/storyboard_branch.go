@@ -0,0 +1,85 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 故事分支图接口，返回值不在proto中定义，只支持json编码
+const (
+	StoryBranchPath             = "/common.StoryBranchAPI/"
+	GetStoryBranchTreeProcedure = "/common.StoryBranchAPI/GetStoryBranchTree"
+	GetStoryboardPathProcedure  = "/common.StoryBranchAPI/GetStoryboardPath"
+	DiffStoryBranchesProcedure  = "/common.StoryBranchAPI/DiffStoryBranches"
+)
+
+type StoryBranchTreeRequest struct {
+	StoryId int64 `json:"story_id"`
+}
+
+type StoryboardPathRequest struct {
+	BoardId int64 `json:"board_id"`
+}
+
+type StoryboardPathResponse struct {
+	Path []*storyServer.BranchNode `json:"path"`
+}
+
+type DiffStoryBranchesRequest struct {
+	BoardId      int64 `json:"board_id"`
+	OtherBoardId int64 `json:"other_board_id"`
+}
+
+// NewStoryBranchHandler 返回分支图接口的路径和handler
+func NewStoryBranchHandler(s *StoryBoardService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(GetStoryBranchTreeProcedure, connect.NewUnaryHandler(
+		GetStoryBranchTreeProcedure, s.GetStoryBranchTree, opts...))
+	mux.Handle(GetStoryboardPathProcedure, connect.NewUnaryHandler(
+		GetStoryboardPathProcedure, s.GetStoryboardPath, opts...))
+	mux.Handle(DiffStoryBranchesProcedure, connect.NewUnaryHandler(
+		DiffStoryBranchesProcedure, s.DiffStoryBranches, opts...))
+	return StoryBranchPath, mux
+}
+
+func (s *StoryBoardService) GetStoryBranchTree(ctx context.Context, req *connect.Request[StoryBranchTreeRequest]) (*connect.Response[storyServer.BranchTree], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().GetStoryBranchTree(ctx, req.Msg.StoryId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) GetStoryboardPath(ctx context.Context, req *connect.Request[StoryboardPathRequest]) (*connect.Response[StoryboardPathResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().GetStoryboardPath(ctx, req.Msg.BoardId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&StoryboardPathResponse{Path: ret}), nil
+}
+
+func (s *StoryBoardService) DiffStoryBranches(ctx context.Context, req *connect.Request[DiffStoryBranchesRequest]) (*connect.Response[storyServer.BranchDiff], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().DiffStoryBranches(ctx, req.Msg.BoardId, req.Msg.OtherBoardId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
@@ -0,0 +1,119 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 场景候选图片接口，只支持json编码
+const (
+	SceneCandidatePath             = "/common.SceneCandidateAPI/"
+	ListSceneCandidatesProcedure   = "/common.SceneCandidateAPI/ListSceneCandidates"
+	SelectSceneCandidateProcedure  = "/common.SceneCandidateAPI/SelectSceneCandidate"
+	StarSceneCandidateProcedure    = "/common.SceneCandidateAPI/StarSceneCandidate"
+	DiscardSceneCandidateProcedure = "/common.SceneCandidateAPI/DiscardSceneCandidate"
+	// mode: inpaint 局部重绘, instruction 指令编辑, restyle 风格重绘, expand 扩图
+	EditSceneImageProcedure = "/common.SceneCandidateAPI/EditSceneImage"
+)
+
+type ListSceneCandidatesRequest struct {
+	SceneId int64 `json:"scene_id"`
+}
+
+type SceneCandidateRequest struct {
+	SceneId     int64 `json:"scene_id"`
+	CandidateId int64 `json:"candidate_id"`
+}
+
+type StarSceneCandidateRequest struct {
+	SceneId     int64 `json:"scene_id"`
+	CandidateId int64 `json:"candidate_id"`
+	Starred     bool  `json:"starred"`
+}
+
+type DiscardSceneCandidateResponse struct {
+	SceneId     int64 `json:"scene_id"`
+	CandidateId int64 `json:"candidate_id"`
+}
+
+// NewSceneCandidateHandler 返回场景候选图片接口的路径和handler
+func NewSceneCandidateHandler(s *StoryBoardService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(ListSceneCandidatesProcedure, connect.NewUnaryHandler(
+		ListSceneCandidatesProcedure, s.ListSceneCandidates, opts...))
+	mux.Handle(SelectSceneCandidateProcedure, connect.NewUnaryHandler(
+		SelectSceneCandidateProcedure, s.SelectSceneCandidate, opts...))
+	mux.Handle(StarSceneCandidateProcedure, connect.NewUnaryHandler(
+		StarSceneCandidateProcedure, s.StarSceneCandidate, opts...))
+	mux.Handle(DiscardSceneCandidateProcedure, connect.NewUnaryHandler(
+		DiscardSceneCandidateProcedure, s.DiscardSceneCandidate, opts...))
+	mux.Handle(EditSceneImageProcedure, connect.NewUnaryHandler(
+		EditSceneImageProcedure, s.EditSceneImage, opts...))
+	return SceneCandidatePath, mux
+}
+
+func (s *StoryBoardService) ListSceneCandidates(ctx context.Context, req *connect.Request[ListSceneCandidatesRequest]) (*connect.Response[storyServer.SceneCandidateList], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().ListSceneCandidates(ctx, req.Msg.SceneId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) SelectSceneCandidate(ctx context.Context, req *connect.Request[SceneCandidateRequest]) (*connect.Response[storyServer.SceneCandidate], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().SelectSceneCandidate(ctx, req.Msg.SceneId, req.Msg.CandidateId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) StarSceneCandidate(ctx context.Context, req *connect.Request[StarSceneCandidateRequest]) (*connect.Response[storyServer.SceneCandidate], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().StarSceneCandidate(ctx, req.Msg.SceneId, req.Msg.CandidateId, req.Msg.Starred)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) DiscardSceneCandidate(ctx context.Context, req *connect.Request[SceneCandidateRequest]) (*connect.Response[DiscardSceneCandidateResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	err = storyServer.GetStoryServer().DiscardSceneCandidate(ctx, req.Msg.SceneId, req.Msg.CandidateId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&DiscardSceneCandidateResponse{SceneId: req.Msg.SceneId, CandidateId: req.Msg.CandidateId}), nil
+}
+
+func (s *StoryBoardService) EditSceneImage(ctx context.Context, req *connect.Request[storyServer.EditSceneImageParams]) (*connect.Response[storyServer.SceneCandidate], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().EditSceneImage(ctx, req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
@@ -0,0 +1,91 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 故事板、场景、角色的版本记录接口，只支持json编码
+// entity_type: 1 故事板, 2 场景, 3 角色
+const (
+	StoryRevisionPath         = "/common.StoryRevisionAPI/"
+	ListRevisionsProcedure    = "/common.StoryRevisionAPI/ListRevisions"
+	CompareRevisionsProcedure = "/common.StoryRevisionAPI/CompareRevisions"
+	RollbackRevisionProcedure = "/common.StoryRevisionAPI/RollbackRevision"
+)
+
+type ListRevisionsRequest struct {
+	EntityType int   `json:"entity_type"`
+	EntityId   int64 `json:"entity_id"`
+	Offset     int   `json:"offset"`
+	PageSize   int   `json:"page_size"`
+}
+
+type CompareRevisionsRequest struct {
+	EntityType   int   `json:"entity_type"`
+	EntityId     int64 `json:"entity_id"`
+	Version      int64 `json:"version"`
+	OtherVersion int64 `json:"other_version"` // 为0时与当前内容比较
+}
+
+type RollbackRevisionRequest struct {
+	EntityType int   `json:"entity_type"`
+	EntityId   int64 `json:"entity_id"`
+	Version    int64 `json:"version"`
+}
+
+// NewStoryRevisionHandler 返回版本记录接口的路径和handler
+func NewStoryRevisionHandler(s *StoryBoardService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(ListRevisionsProcedure, connect.NewUnaryHandler(
+		ListRevisionsProcedure, s.ListRevisions, opts...))
+	mux.Handle(CompareRevisionsProcedure, connect.NewUnaryHandler(
+		CompareRevisionsProcedure, s.CompareRevisions, opts...))
+	mux.Handle(RollbackRevisionProcedure, connect.NewUnaryHandler(
+		RollbackRevisionProcedure, s.RollbackRevision, opts...))
+	return StoryRevisionPath, mux
+}
+
+func (s *StoryBoardService) ListRevisions(ctx context.Context, req *connect.Request[ListRevisionsRequest]) (*connect.Response[storyServer.RevisionList], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().ListRevisions(ctx, req.Msg.EntityType, req.Msg.EntityId,
+		req.Msg.Offset, req.Msg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) CompareRevisions(ctx context.Context, req *connect.Request[CompareRevisionsRequest]) (*connect.Response[storyServer.RevisionCompare], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().CompareRevisions(ctx, req.Msg.EntityType, req.Msg.EntityId,
+		req.Msg.Version, req.Msg.OtherVersion)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) RollbackRevision(ctx context.Context, req *connect.Request[RollbackRevisionRequest]) (*connect.Response[storyServer.RevisionInfo], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().RollbackRevision(ctx, req.Msg.EntityType, req.Msg.EntityId, req.Msg.Version)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
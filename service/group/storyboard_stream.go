@@ -0,0 +1,62 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	"github.com/grapery/common-protoc/gen"
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 故事板流式渲染接口，事件类型不在proto中定义，只支持json编码
+const (
+	StoryboardStreamPath               = "/common.StoryboardStreamAPI/"
+	RenderStoryboardStreamProcedure    = "/common.StoryboardStreamAPI/RenderStoryboard"
+	ContinueRenderStoryStreamProcedure = "/common.StoryboardStreamAPI/ContinueRenderStory"
+)
+
+// jsonCodec 使用标准库编解码，替换connect默认的protojson
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// NewStoryboardStreamHandler 返回流式渲染接口的路径和handler
+func NewStoryboardStreamHandler(s *StoryBoardService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(RenderStoryboardStreamProcedure, connect.NewServerStreamHandler(
+		RenderStoryboardStreamProcedure, s.RenderStoryboardStream, opts...))
+	mux.Handle(ContinueRenderStoryStreamProcedure, connect.NewServerStreamHandler(
+		ContinueRenderStoryStreamProcedure, s.ContinueRenderStoryStream, opts...))
+	return StoryboardStreamPath, mux
+}
+
+func (s *StoryBoardService) RenderStoryboardStream(ctx context.Context, req *connect.Request[gen.RenderStoryboardRequest], stream *connect.ServerStream[storyServer.StoryboardStreamEvent]) error {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return err
+	}
+	return storyServer.GetStoryServer().RenderStoryboardStream(ctx, req.Msg, stream.Send)
+}
+
+func (s *StoryBoardService) ContinueRenderStoryStream(ctx context.Context, req *connect.Request[gen.ContinueRenderStoryRequest], stream *connect.ServerStream[storyServer.StoryboardStreamEvent]) error {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return err
+	}
+	return storyServer.GetStoryServer().ContinueRenderStoryStream(ctx, req.Msg, stream.Send)
+}
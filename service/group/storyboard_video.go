@@ -0,0 +1,68 @@
+package group
+
+import (
+	"context"
+	"net/http"
+
+	connect "github.com/bufbuild/connect-go"
+
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/service/auth"
+)
+
+// 场景视频接口，只支持json编码
+const (
+	SceneVideoPath = "/common.SceneVideoAPI/"
+	// platform: aliyun 或 doubao，为空时使用 aliyun
+	RenderSceneVideoProcedure = "/common.SceneVideoAPI/RenderSceneVideo"
+	GetSceneVideoProcedure    = "/common.SceneVideoAPI/GetSceneVideo"
+)
+
+type RenderSceneVideoRequest struct {
+	SceneId  int64  `json:"scene_id"`
+	Platform string `json:"platform"`
+}
+
+type GetSceneVideoRequest struct {
+	SceneId int64 `json:"scene_id"`
+}
+
+type GetSceneVideoResponse struct {
+	// 场景还没有生成过视频时为空
+	Video *storyServer.SceneVideo `json:"video,omitempty"`
+}
+
+// NewSceneVideoHandler 返回场景视频接口的路径和handler
+func NewSceneVideoHandler(s *StoryBoardService, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append(opts, connect.WithCodec(jsonCodec{}))
+	mux := http.NewServeMux()
+	mux.Handle(RenderSceneVideoProcedure, connect.NewUnaryHandler(
+		RenderSceneVideoProcedure, s.RenderSceneVideo, opts...))
+	mux.Handle(GetSceneVideoProcedure, connect.NewUnaryHandler(
+		GetSceneVideoProcedure, s.GetSceneVideo, opts...))
+	return SceneVideoPath, mux
+}
+
+func (s *StoryBoardService) RenderSceneVideo(ctx context.Context, req *connect.Request[RenderSceneVideoRequest]) (*connect.Response[storyServer.SceneVideo], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().RenderSceneVideo(ctx, req.Msg.SceneId, req.Msg.Platform)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(ret), nil
+}
+
+func (s *StoryBoardService) GetSceneVideo(ctx context.Context, req *connect.Request[GetSceneVideoRequest]) (*connect.Response[GetSceneVideoResponse], error) {
+	ctx, err := auth.ConnectAuthFuncfunc(ctx, req.Spec(), req.Header(), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := storyServer.GetStoryServer().GetSceneVideo(ctx, req.Msg.SceneId)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&GetSceneVideoResponse{Video: ret}), nil
+}
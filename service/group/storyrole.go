@@ -73,7 +73,8 @@ func (s *StoryRoleService) RenderStoryRole(ctx context.Context, req *connect.Req
 	}
 	resp := &gen.RenderStoryRoleResponse{
 		Code:    ret.Code,
-		Message: "OK",
+		Message: ret.Message,
+		Detail:  ret.Detail,
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -3,16 +3,21 @@ package message
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
+	"strconv"
 	"sync"
 	"time"
 
 	api "github.com/grapery/common-protoc/gen"
 	"github.com/grapery/grapery/models"
 	"github.com/grapery/grapery/pkg/client"
+	storyServer "github.com/grapery/grapery/pkg/story"
+	"github.com/grapery/grapery/utils"
 	"github.com/grapery/grapery/utils/log"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
@@ -22,6 +27,14 @@ const (
 	ClientBufferSize = 1000
 )
 
+// 聊天流建立连接时 metadata 中的参数，token 放在 authorization: bearer <token> 中
+const (
+	MetadataRoleID        = "role-id"         // 会话的角色ID
+	MetadataLastMessageID = "last-message-id" // 客户端收到的最后一条消息ID，重连时补发之后的回复
+	ResumeMaxMessages     = 200               // 重连时最多补发的回复数
+	ReplyTimeout          = 3 * time.Minute   // 客户端断开后生成回复的超时时间
+)
+
 type Client struct {
 	ID       string
 	Messages chan *api.StreamChatMessage
@@ -79,112 +92,183 @@ func NewMessageService() *MessageService {
 	return ms
 }
 
-// 用户建立连接的消息，初始化本次连接，并管理此次的连接会话。如果用户长时间没有消息流，则关闭此次连接。
-
-func (s *MessageService) InitChatContext(stream api.StreamMessageService_StreamChatMessageServer) error {
-	userId := stream.Context().Value("user_id").(int64)
-	roleId := stream.Context().Value("role_id").(int64)
-	chatCtx, err := models.GetChatContextByUserIDAndRoleID(stream.Context(), userId, roleId)
-	if err != nil {
+// InitChatContext 获取用户和角色的会话，不存在时创建
+func (s *MessageService) InitChatContext(ctx context.Context, userId, roleId int64) (*models.ChatContext, error) {
+	chatCtx, err := models.GetChatContextByUserIDAndRoleID(ctx, userId, roleId)
+	if err == nil {
+		return chatCtx, nil
+	}
+	if err != gorm.ErrRecordNotFound {
 		log.Log().Error("get user chat context failed", zap.Error(err))
-		return err
+		return nil, err
 	}
-	chatCtx.Status = 1
-	chatCtx.UserID = userId
-	chatCtx.RoleID = roleId
-	err = models.CreateChatContext(stream.Context(), chatCtx)
-	if err != nil {
+	chatCtx = &models.ChatContext{
+		UserID: userId,
+		RoleID: roleId,
+		Title:  "聊天消息",
+		Status: 1,
+	}
+	if err := models.CreateChatContext(ctx, chatCtx); err != nil {
 		log.Log().Error("create user chat context failed", zap.Error(err))
-		return err
+		return nil, err
 	}
-	return nil
+	return chatCtx, nil
 }
 
+// ChatRecieveMessage 保存用户发送的消息并生成回复，用户ID以连接时校验的token为准，
+// 重连后重发的消息按uuid去重
 func (s *MessageService) ChatRecieveMessage(ctx context.Context, req *api.StreamChatMessageRequest, replyChan chan *models.ChatMessage) error {
-	chatCtx, err := models.GetChatContextByUserIDAndRoleID(ctx, int64(req.Message.GetUserId()), int64(req.Message.GetRoleId()))
-	if err != nil && err != gorm.ErrRecordNotFound {
-		log.Log().Error("get user chat context failed", zap.Error(err))
+	userId, err := utils.GetUserIDFromContext(ctx)
+	if err != nil {
 		return err
 	}
-	if chatCtx == nil {
-		return errors.New("没有开启聊天")
+	if req.Message == nil || len(req.Message.Messages) == 0 {
+		return errors.New("消息为空")
 	}
-	var (
-		userId int64 = int64(req.Message.GetUserId())
-	)
-	fmt.Printf("userId %d ChatWithStoryRole req %s \n", userId, req.String())
-	chatMessage := new(models.ChatMessage)
+	if req.Message.GetUserId() != 0 && req.Message.GetUserId() != userId {
+		return errors.New("have no permission")
+	}
+	roleId := req.Message.GetRoleId()
+	if roleId == 0 {
+		return errors.New("角色不存在")
+	}
+	chatCtx, err := s.InitChatContext(ctx, userId, roleId)
+	if err != nil {
+		return err
+	}
+	var chatMessage *models.ChatMessage
 	for _, message := range req.Message.Messages {
+		if message.GetUuid() != "" {
+			existing, err := models.GetChatMessageByUUID(ctx, int64(chatCtx.ID), userId, message.GetUuid())
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				chatMessage = existing
+				continue
+			}
+		}
+		chatMessage = new(models.ChatMessage)
 		chatMessage.ChatContextID = int64(chatCtx.ID)
-		chatMessage.UserID = int64(message.GetUserId())
+		chatMessage.UserID = userId
 		chatMessage.Content = message.GetMessage()
 		chatMessage.Status = 1
-		chatMessage.RoleID = int64(message.GetRoleId())
-		chatMessage.Sender = int64(message.GetSender())
+		chatMessage.RoleID = roleId
+		chatMessage.Sender = userId
 		chatMessage.UUID = message.GetUuid()
+		chatMessage.SendTime = time.Now().Unix()
 		err = models.CreateChatMessage(ctx, chatMessage)
 		if err != nil {
 			log.Log().Error("create story role chat message failed", zap.Error(err))
 			return err
 		}
 	}
+	return s.ChatReplyMessage(ctx, chatCtx, chatMessage, replyChan)
+}
+
+// ChatReplyMessage 生成角色对 message 的回复并发送到 replyChan，已经回复过的消息直接返回原来的回复。
+// 客户端断开后仍然生成并保存回复，重连时补发
+func (s *MessageService) ChatReplyMessage(ctx context.Context, chatCtx *models.ChatContext, message *models.ChatMessage, replyChan chan *models.ChatMessage) error {
+	var roleReplyMessage *models.ChatMessage
+	if message.UUID != "" {
+		reply, err := models.GetChatMessageByUUID(ctx, int64(chatCtx.ID), chatCtx.RoleID, message.UUID)
+		if err != nil {
+			return err
+		}
+		roleReplyMessage = reply
+	}
+	if roleReplyMessage == nil {
+		replyCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ReplyTimeout)
+		defer cancel()
+		reply, err := storyServer.GetStoryServer().ReplyChatMessage(replyCtx, chatCtx, message)
+		if err != nil {
+			return err
+		}
+		roleReplyMessage = reply
+	}
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case replyChan <- chatMessage:
-		log.Log().Info("reply message", zap.String("message", chatMessage.Content))
+	case replyChan <- roleReplyMessage:
+		log.Log().Info("reply message", zap.String("message", roleReplyMessage.Content))
 	}
 	return nil
 }
 
-func (s *MessageService) ChatReplyMessage(ctx context.Context, message models.ChatMessage, replyChan chan *models.ChatMessage) error {
-	roleInfo, err := models.GetStoryRoleByID(ctx, message.RoleID)
-	if err != nil {
-		log.Log().Error("get story role by id failed", zap.Error(err))
+// resumeChat 客户端在 metadata 中带上角色ID和最后收到的消息ID时，补发之后的角色回复
+func (s *MessageService) resumeChat(stream api.StreamMessageService_StreamChatMessageServer) error {
+	ctx := stream.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	roleIds, lastIds := md.Get(MetadataRoleID), md.Get(MetadataLastMessageID)
+	if len(roleIds) == 0 || len(lastIds) == 0 {
 		return nil
 	}
-	var chatParams = &client.ChatWithRoleParams{
-		MessageContent: message.Content,
-		Background:     roleInfo.CharacterDescription,
-		SenseDesc:      "", // sence
-		RolePositive:   "", // 角色的描述
-		RoleNegative:   "",
-		RequestId:      message.UUID,
-		UserId:         fmt.Sprintf("grapery_chat_ctx_%d_user_%d", message.ChatContextID, message.UserID),
+	roleId, err := strconv.ParseInt(roleIds[0], 10, 64)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid %s: %s", MetadataRoleID, roleIds[0])
 	}
-	select {
-	case <-ctx.Done():
-		return nil
-	default:
+	lastId, err := strconv.ParseInt(lastIds[0], 10, 64)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid %s: %s", MetadataLastMessageID, lastIds[0])
 	}
-	chatResp, err := s.client.ChatWithRole(ctx, chatParams)
+	userId, err := utils.GetUserIDFromContext(ctx)
 	if err != nil {
-		log.Log().Error("chat with role failed", zap.Error(err))
+		return err
+	}
+	chatCtx, err := models.GetChatContextByUserIDAndRoleID(ctx, userId, roleId)
+	if err == gorm.ErrRecordNotFound {
 		return nil
 	}
-	roleReplyMessage := new(models.ChatMessage)
-	roleReplyMessage.ChatContextID = int64(message.ChatContextID)
-	roleReplyMessage.UserID = int64(message.UserID)
-	roleReplyMessage.Content = chatResp.Content
-	roleReplyMessage.Status = 1
-	roleReplyMessage.RoleID = int64(message.RoleID)
-	roleReplyMessage.Sender = int64(message.RoleID)
-	roleReplyMessage.UUID = message.UUID
-	err = models.CreateChatMessage(ctx, roleReplyMessage)
 	if err != nil {
-		log.Log().Error("create story role chat message failed", zap.Error(err))
 		return err
 	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case replyChan <- roleReplyMessage:
-		log.Log().Info("reply message", zap.String("message", roleReplyMessage.Content))
+	replies, err := models.GetChatRepliesAfter(ctx, int64(chatCtx.ID), lastId, ResumeMaxMessages)
+	if err != nil {
+		log.Log().Error("get missed chat replies failed", zap.Uint("chat_id", chatCtx.ID), zap.Error(err))
+		return err
 	}
-	return nil
+	if len(replies) == 0 {
+		return nil
+	}
+	log.Log().Info("resume chat stream", zap.Uint("chat_id", chatCtx.ID),
+		zap.Int64("last_message_id", lastId), zap.Int("replies", len(replies)))
+	return stream.Send(&api.StreamChatMessageResponse{
+		Code:          0,
+		Message:       "resume",
+		ReplyMessages: []*api.StreamChatMessage{newStreamChatMessage(userId, roleId, replies...)},
+		Timestamp:     time.Now().Unix(),
+	})
+}
+
+// newStreamChatMessage 转换为流式消息，Id 为消息序号，客户端重连时作为最后收到的消息ID
+func newStreamChatMessage(userId, roleId int64, messages ...*models.ChatMessage) *api.StreamChatMessage {
+	ret := &api.StreamChatMessage{
+		UserId:   userId,
+		RoleId:   roleId,
+		Messages: make([]*api.ChatMessage, 0, len(messages)),
+	}
+	for _, message := range messages {
+		ret.Messages = append(ret.Messages, &api.ChatMessage{
+			Id:        int64(message.ID),
+			UserId:    message.UserID,
+			RoleId:    message.RoleID,
+			Message:   message.Content,
+			Sender:    int32(message.Sender),
+			Uuid:      message.UUID,
+			ChatId:    message.ChatContextID,
+			Timestamp: message.CreateAt.Unix(),
+			User:      &api.UserInfo{},
+			Role:      &api.StoryRole{},
+		})
+	}
+	return ret
 }
 
 func (s *MessageService) StreamChatMessage(stream api.StreamMessageService_StreamChatMessageServer) error {
+	if err := s.resumeChat(stream); err != nil {
+		log.Log().Error("resume chat stream failed", zap.Error(err))
+		return err
+	}
 	for {
 		// 从客户端接收消息
 		req, err := stream.Recv()
@@ -223,36 +307,19 @@ func (s *MessageService) StreamChatMessage(stream api.StreamMessageService_Strea
 			if err := stream.Send(response); err != nil {
 				continue
 			}
-			// TODO: 发送成功后，等待回复消息
 			select {
 			case roleReplyMessage := <-recRet:
 				if roleReplyMessage == nil {
 					log.Log().Info("role reply message is nil")
 					continue
 				}
-				replyMsg := make([]*api.StreamChatMessage, 0)
-				realMsg := &api.StreamChatMessage{
-					UserId:   roleReplyMessage.UserID,
-					RoleId:   roleReplyMessage.RoleID,
-					Messages: make([]*api.ChatMessage, 0),
-				}
-				realMsg.Messages = append(realMsg.Messages, &api.ChatMessage{
-					UserId:    roleReplyMessage.UserID,
-					RoleId:    roleReplyMessage.RoleID,
-					Message:   roleReplyMessage.Content,
-					Sender:    int32(roleReplyMessage.RoleID),
-					Uuid:      roleReplyMessage.UUID,
-					ChatId:    roleReplyMessage.ChatContextID,
-					Timestamp: time.Now().Unix(),
-					User:      &api.UserInfo{},
-					Role:      &api.StoryRole{},
-				})
-				replyMsg = append(replyMsg, realMsg)
 				roleReplyMessageResponse := &api.StreamChatMessageResponse{
-					Code:          0,
-					ReplyMessages: replyMsg,
-					Timestamp:     time.Now().Unix(),
-					RequestId:     req.RequestId,
+					Code: 0,
+					ReplyMessages: []*api.StreamChatMessage{
+						newStreamChatMessage(roleReplyMessage.UserID, roleReplyMessage.RoleID, roleReplyMessage),
+					},
+					Timestamp: time.Now().Unix(),
+					RequestId: req.RequestId,
 				}
 				if err := stream.Send(roleReplyMessageResponse); err != nil {
 					continue
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	connect "github.com/bufbuild/connect-go"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -16,12 +17,17 @@ import (
 	genconnect "github.com/grapery/common-protoc/gen/genconnect"
 	"github.com/grapery/grapery/config"
 	models "github.com/grapery/grapery/models"
+	"github.com/grapery/grapery/pkg/search"
+	"github.com/grapery/grapery/pkg/storage"
+	"github.com/grapery/grapery/pkg/story"
 	auth "github.com/grapery/grapery/service/auth"
 	"github.com/grapery/grapery/service/common"
 	"github.com/grapery/grapery/service/group"
 	"github.com/grapery/grapery/service/message"
 	"github.com/grapery/grapery/service/user"
 	"github.com/grapery/grapery/utils/cache"
+	"github.com/grapery/grapery/utils/compliance"
+	"github.com/grapery/grapery/utils/elastic"
 	"github.com/grapery/grapery/utils/jwt"
 	"github.com/grapery/grapery/version"
 )
@@ -87,13 +93,48 @@ func NewTeamsService() *TeamsService {
 	return ts
 }
 
-func Run(ts *TeamsService, cfg *config.Config) error {
+// InitBackend 初始化缓存、数据库和大模型平台，api服务和syncworker共用
+func InitBackend(ctx context.Context, cfg *config.Config) error {
 	cache.NewRedisClient(cfg)
 	err := models.Init(cfg.SqlDB.Username, cfg.SqlDB.Password, cfg.SqlDB.Database)
 	if err != nil {
 		logrus.Errorf("init sql database failed : [%s]", err.Error())
 		return err
 	}
+	err = story.InitProviders(ctx, cfg.LLM)
+	if err != nil {
+		logrus.Errorf("init llm providers failed : [%s]", err.Error())
+		return err
+	}
+	err = compliance.InitModerator(cfg.Moderation)
+	if err != nil {
+		logrus.Errorf("init content moderation failed : [%s]", err.Error())
+		return err
+	}
+	err = elastic.Init(cfg.Elastic)
+	if err != nil {
+		// 搜索服务不可用时搜索使用数据库查询
+		logrus.Warnf("init elastic search failed : [%s]", err.Error())
+	}
+	search.Start(ctx)
+	err = storage.Init(cfg)
+	if err != nil {
+		// 没有配置对象存储时不影响启动，上传和转存会失败
+		if cfg.Storage != nil {
+			logrus.Errorf("init object storage failed : [%s]", err.Error())
+			return err
+		}
+		logrus.Warnf("init object storage failed : [%s]", err.Error())
+	}
+	return nil
+}
+
+func Run(ts *TeamsService, cfg *config.Config) error {
+	if err := InitBackend(ts.Ctx, cfg); err != nil {
+		return err
+	}
+	// 语义索引在内存中，只在api服务中构建
+	search.StartSemantic(ts.Ctx)
 	opts := []connect.HandlerOption{
 		connect.WithInterceptors(
 			auth.AuthInterceptorFunc{
@@ -105,6 +146,37 @@ func Run(ts *TeamsService, cfg *config.Config) error {
 		mux := http.NewServeMux()
 		path, handler := genconnect.NewTeamsAPIHandler(ts, opts...)
 		mux.Handle(path, handler)
+		streamPath, streamHandler := group.NewStoryboardStreamHandler(ts.StoryBoardService)
+		mux.Handle(streamPath, streamHandler)
+		branchPath, branchHandler := group.NewStoryBranchHandler(ts.StoryBoardService)
+		mux.Handle(branchPath, branchHandler)
+		revisionPath, revisionHandler := group.NewStoryRevisionHandler(ts.StoryBoardService)
+		mux.Handle(revisionPath, revisionHandler)
+		candidatePath, candidateHandler := group.NewSceneCandidateHandler(ts.StoryBoardService)
+		mux.Handle(candidatePath, candidateHandler)
+		videoPath, videoHandler := group.NewSceneVideoHandler(ts.StoryBoardService)
+		mux.Handle(videoPath, videoHandler)
+		groupChatPath, groupChatHandler := group.NewGroupChatHandler(ts.StoryRoleService)
+		mux.Handle(groupChatPath, groupChatHandler)
+		chatMessagePath, chatMessageHandler := group.NewChatMessageHandler(ts.StoryRoleService)
+		mux.Handle(chatMessagePath, chatMessageHandler)
+		chatAgentPath, chatAgentHandler := group.NewChatAgentHandler(ts.StoryRoleService)
+		mux.Handle(chatAgentPath, chatAgentHandler)
+		exportPath, exportHandler := group.NewStoryExportHandler(ts.StoryService)
+		mux.Handle(exportPath, exportHandler)
+		importPath, importHandler := group.NewStoryImportHandler(ts.StoryService)
+		mux.Handle(importPath, importHandler)
+		moderationPath, moderationHandler := group.NewModerationHandler(ts.GroupService)
+		mux.Handle(moderationPath, moderationHandler)
+		safetyPath, safetyHandler := group.NewSafetyHandler(ts.GroupService)
+		mux.Handle(safetyPath, safetyHandler)
+		searchPath, searchHandler := group.NewSearchHandler(ts.GroupService)
+		mux.Handle(searchPath, searchHandler)
+		if store, err := storage.Default(); err == nil {
+			if local, ok := store.(*storage.LocalStorage); ok {
+				mux.Handle(storage.LocalPath, local.Handler())
+			}
+		}
 		serverAddr := "0.0.0.0:12305"
 		logrus.Infof("Starting http server on %s", serverAddr)
 		server := &http2.Server{}
@@ -129,13 +201,13 @@ func Run(ts *TeamsService, cfg *config.Config) error {
 			return
 		}
 
-		// 创建 gRPC 服务器
+		// 创建 gRPC 服务器，建立连接时校验token
 		grpcServer := grpc.NewServer(
 			grpc.ChainUnaryInterceptor(
-			// 可以添加拦截器
+				grpc_auth.UnaryServerInterceptor(auth.ChatAuthFunc),
 			),
 			grpc.ChainStreamInterceptor(
-			// 可以添加流拦截器
+				grpc_auth.StreamServerInterceptor(auth.ChatAuthFunc),
 			),
 		)
 
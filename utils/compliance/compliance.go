@@ -2,10 +2,13 @@ package compliance
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/grapery/grapery/utils/log"
 	"go.uber.org/zap"
@@ -19,6 +22,7 @@ func GetComplianceTool() *ComplianceTool {
 	return GlobalComplianceTool
 }
 
+// ComplianceTool 远程内容审核服务，没有配置地址时所有内容都通过
 type ComplianceTool struct {
 	Address string
 	Secret  string
@@ -35,18 +39,19 @@ func Init(address string, secret string) *ComplianceTool {
 	}
 }
 
-func (c *ComplianceTool) TextCompliance(content string) error {
-	return nil
-	if c.Address == "" || c.Secret == "" {
-		return fmt.Errorf("compliance tool not initialized")
-	}
-	// 构造请求体
-	type ServiceParameters struct {
-		Content string `json:"content"`
-	}
+func (c *ComplianceTool) Name() string {
+	return "remote"
+}
+
+func (c *ComplianceTool) configured() bool {
+	return c != nil && c.Address != "" && c.Secret != ""
+}
+
+// call 调用审核服务，out 为响应中的 Data
+func (c *ComplianceTool) call(ctx context.Context, service string, params interface{}, out interface{}) error {
 	reqBody := map[string]interface{}{
-		"Service":           "comment_detection",
-		"ServiceParameters": ServiceParameters{Content: content},
+		"Service":           service,
+		"ServiceParameters": params,
 	}
 	bodyBytes, err := json.Marshal(reqBody)
 	if err != nil {
@@ -54,21 +59,21 @@ func (c *ComplianceTool) TextCompliance(content string) error {
 		return err
 	}
 	// 发送 POST 请求
-	req, err := http.NewRequest("POST", c.Address, bytes.NewBuffer(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Address, bytes.NewBuffer(bodyBytes))
 	if err != nil {
 		log.Log().Error("create compliance request", zap.Error(err))
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Acs-Api-Key", c.Secret)
-	client := &http.Client{}
+	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Log().Error("send compliance request", zap.Error(err))
 		return err
 	}
 	defer resp.Body.Close()
-	respBody, err := ioutil.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Log().Error("read compliance response", zap.Error(err))
 		return err
@@ -79,12 +84,9 @@ func (c *ComplianceTool) TextCompliance(content string) error {
 	}
 	// 解析响应体
 	var respData struct {
-		Code int `json:"Code"`
-		Data struct {
-			Labels string `json:"Labels"`
-			Reason string `json:"Reason"`
-		} `json:"Data"`
-		Message string `json:"Message"`
+		Code    int             `json:"Code"`
+		Data    json.RawMessage `json:"Data"`
+		Message string          `json:"Message"`
 	}
 	if err := json.Unmarshal(respBody, &respData); err != nil {
 		log.Log().Error("unmarshal compliance response", zap.Error(err))
@@ -93,13 +95,89 @@ func (c *ComplianceTool) TextCompliance(content string) error {
 	if respData.Code != 200 {
 		return fmt.Errorf("compliance api business error: %d, %s", respData.Code, respData.Message)
 	}
-	if respData.Data.Labels != "" {
-		// 命中风险内容
-		return fmt.Errorf("content not compliant: %s, reason: %s", respData.Data.Labels, respData.Data.Reason)
+	return json.Unmarshal(respData.Data, out)
+}
+
+// CheckText 文本审核，命中风险标签的内容需要人工审核
+func (c *ComplianceTool) CheckText(ctx context.Context, content string) (*Result, error) {
+	if !c.configured() || strings.TrimSpace(content) == "" {
+		return passResult, nil
+	}
+	type ServiceParameters struct {
+		Content string `json:"content"`
 	}
-	return nil
+	var data struct {
+		Labels string `json:"Labels"`
+		Reason string `json:"Reason"`
+	}
+	if err := c.call(ctx, "comment_detection", ServiceParameters{Content: content}, &data); err != nil {
+		return nil, err
+	}
+	if data.Labels == "" {
+		return passResult, nil
+	}
+	// 命中风险内容
+	return &Result{
+		Verdict: VerdictReview,
+		Labels:  strings.Split(data.Labels, ","),
+		Reason:  data.Reason,
+		Source:  c.Name(),
+	}, nil
 }
 
-func (*ComplianceTool) ImageCompliance(image string) error {
-	return nil
+// CheckImage 图片审核，高风险的图片直接拒绝，中低风险的需要人工审核
+func (c *ComplianceTool) CheckImage(ctx context.Context, image string) (*Result, error) {
+	if !c.configured() || image == "" {
+		return passResult, nil
+	}
+	type ServiceParameters struct {
+		ImageUrl string `json:"imageUrl"`
+	}
+	var data struct {
+		RiskLevel string `json:"RiskLevel"`
+		Result    []struct {
+			Label      string  `json:"Label"`
+			Confidence float64 `json:"Confidence"`
+		} `json:"Result"`
+	}
+	if err := c.call(ctx, "baselineCheck", ServiceParameters{ImageUrl: image}, &data); err != nil {
+		return nil, err
+	}
+	result := &Result{Source: c.Name()}
+	for _, item := range data.Result {
+		if item.Label != "" && item.Label != "nonLabel" {
+			result.Labels = append(result.Labels, item.Label)
+		}
+	}
+	switch data.RiskLevel {
+	case "high":
+		result.Verdict = VerdictReject
+	case "medium", "low":
+		result.Verdict = VerdictReview
+	default:
+		if len(result.Labels) == 0 {
+			return passResult, nil
+		}
+		result.Verdict = VerdictReview
+	}
+	result.Reason = "image risk level " + data.RiskLevel
+	return result, nil
+}
+
+// TextCompliance 文本不合规时返回错误
+func (c *ComplianceTool) TextCompliance(content string) error {
+	result, err := c.CheckText(context.Background(), content)
+	if err != nil {
+		return err
+	}
+	return result.Err()
+}
+
+// ImageCompliance 图片不合规时返回错误
+func (c *ComplianceTool) ImageCompliance(image string) error {
+	result, err := c.CheckImage(context.Background(), image)
+	if err != nil {
+		return err
+	}
+	return result.Err()
 }
@@ -0,0 +1,109 @@
+package compliance
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/grapery/grapery/config"
+	"github.com/grapery/grapery/utils/log"
+)
+
+// Checker 远程审核服务
+type Checker interface {
+	Name() string
+	CheckText(ctx context.Context, content string) (*Result, error)
+	CheckImage(ctx context.Context, image string) (*Result, error)
+}
+
+// Moderator 先用本地规则检查，被拒绝的内容不再请求远程审核
+type Moderator struct {
+	disable     bool
+	rules       *RuleEngine
+	remote      Checker
+	passOnError bool
+}
+
+func NewModerator(cfg *config.ModerationConfig, remote Checker) (*Moderator, error) {
+	rules, err := NewRuleEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := &Moderator{rules: rules, remote: remote}
+	if cfg != nil {
+		m.disable = cfg.Disable
+		m.passOnError = cfg.PassOnError
+	}
+	return m, nil
+}
+
+// checkFailed 远程审核失败时的结果
+func (m *Moderator) checkFailed(err error) *Result {
+	if m.passOnError {
+		return passResult
+	}
+	return &Result{Verdict: VerdictReview, Labels: []string{"check_failed"}, Reason: err.Error(), Source: m.remote.Name()}
+}
+
+// Text 审核小组 groupId 中的文本
+func (m *Moderator) Text(ctx context.Context, groupId int64, content string) *Result {
+	if m.disable {
+		return passResult
+	}
+	result := m.rules.Check(groupId, content)
+	if result.Verdict == VerdictReject || m.remote == nil {
+		return result
+	}
+	remote, err := m.remote.CheckText(ctx, content)
+	if err != nil {
+		log.Log().Error("remote text moderation failed", zap.Error(err))
+		remote = m.checkFailed(err)
+	}
+	return m.rules.applyGroup(groupId, result.merge(remote))
+}
+
+// Image 审核小组 groupId 中的图片，本地规则不检查图片
+func (m *Moderator) Image(ctx context.Context, groupId int64, image string) *Result {
+	if m.disable || m.remote == nil {
+		return passResult
+	}
+	result, err := m.remote.CheckImage(ctx, image)
+	if err != nil {
+		log.Log().Error("remote image moderation failed", zap.String("image", image), zap.Error(err))
+		result = m.checkFailed(err)
+	}
+	return m.rules.applyGroup(groupId, result)
+}
+
+var (
+	moderator   *Moderator
+	moderatorMu sync.RWMutex
+)
+
+// InitModerator 根据配置创建全局的审核器，远程审核使用 ComplianceTool
+func InitModerator(cfg *config.ModerationConfig) error {
+	var remote Checker
+	if cfg != nil && cfg.Address != "" {
+		GlobalComplianceTool = Init(cfg.Address, cfg.Secret)
+		remote = GlobalComplianceTool
+	}
+	m, err := NewModerator(cfg, remote)
+	if err != nil {
+		return err
+	}
+	moderatorMu.Lock()
+	defer moderatorMu.Unlock()
+	moderator = m
+	return nil
+}
+
+// GetModerator 全局的审核器，没有初始化时所有内容都通过
+func GetModerator() *Moderator {
+	moderatorMu.RLock()
+	defer moderatorMu.RUnlock()
+	if moderator == nil {
+		return &Moderator{rules: &RuleEngine{groups: map[int64]*groupRules{}}}
+	}
+	return moderator
+}
@@ -0,0 +1,222 @@
+package compliance
+
+// 本地审核规则：关键词和正则表达式，小组可以增加规则或关闭部分全局规则
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/grapery/grapery/config"
+)
+
+// Verdict 审核结论，数值越大越严重
+type Verdict int
+
+const (
+	VerdictPass   Verdict = 0
+	VerdictReview Verdict = 1 // 需要人工审核
+	VerdictReject Verdict = 2
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictReview:
+		return "review"
+	case VerdictReject:
+		return "reject"
+	}
+	return "pass"
+}
+
+// ErrRejected 内容没有通过审核
+var ErrRejected = errors.New("content not compliant")
+
+// Result 一次审核的结果
+type Result struct {
+	Verdict Verdict  `json:"verdict"`
+	Labels  []string `json:"labels,omitempty"` // 命中的规则名或远程审核的标签
+	Reason  string   `json:"reason,omitempty"`
+	Source  string   `json:"source,omitempty"` // rules/remote
+}
+
+var passResult = &Result{Verdict: VerdictPass}
+
+// Err 被拒绝时返回 ErrRejected
+func (r *Result) Err() error {
+	if r == nil || r.Verdict != VerdictReject {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrRejected, strings.Join(r.Labels, ","))
+}
+
+// merge 合并两个结果，保留更严重的结论和所有标签
+func (r *Result) merge(other *Result) *Result {
+	if other == nil || other.Verdict == VerdictPass {
+		return r
+	}
+	if r == nil || r.Verdict == VerdictPass {
+		return other
+	}
+	merged := &Result{
+		Verdict: max(r.Verdict, other.Verdict),
+		Labels:  append(append([]string{}, r.Labels...), other.Labels...),
+		Reason:  strings.TrimPrefix(r.Reason+"; "+other.Reason, "; "),
+		Source:  r.Source,
+	}
+	if other.Source != r.Source {
+		merged.Source += "," + other.Source
+	}
+	return merged
+}
+
+type rule struct {
+	name     string
+	verdict  Verdict
+	keywords []string
+	patterns []*regexp.Regexp
+}
+
+func newRule(cfg *config.ModerationRule) (*rule, error) {
+	r := &rule{name: cfg.Name, verdict: VerdictReview}
+	switch cfg.Action {
+	case "", "review":
+	case "reject":
+		r.verdict = VerdictReject
+	default:
+		return nil, fmt.Errorf("rule %s: unknown action %q", cfg.Name, cfg.Action)
+	}
+	for _, keyword := range cfg.Keywords {
+		if keyword = normalize(keyword); keyword != "" {
+			r.keywords = append(r.keywords, keyword)
+		}
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", cfg.Name, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// match 返回命中的关键词或表达式
+func (r *rule) match(content, normalized string) (string, bool) {
+	for _, keyword := range r.keywords {
+		if strings.Contains(normalized, keyword) {
+			return keyword, true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(content) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// normalize 转小写并去掉空白和标点，避免用空格隔开关键词绕过检查
+func normalize(content string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(content) {
+		if unicode.IsSpace(c) || unicode.IsPunct(c) {
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+type groupRules struct {
+	rules  []*rule
+	allow  map[string]bool
+	strict bool
+}
+
+// RuleEngine 本地规则
+type RuleEngine struct {
+	rules  []*rule
+	groups map[int64]*groupRules
+}
+
+func NewRuleEngine(cfg *config.ModerationConfig) (*RuleEngine, error) {
+	e := &RuleEngine{groups: make(map[int64]*groupRules)}
+	if cfg == nil {
+		return e, nil
+	}
+	for _, ruleCfg := range cfg.Rules {
+		r, err := newRule(ruleCfg)
+		if err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, r)
+	}
+	for key, groupCfg := range cfg.Groups {
+		groupId, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation group id %q", key)
+		}
+		group := &groupRules{allow: make(map[string]bool), strict: groupCfg.Strict}
+		for _, name := range groupCfg.Allow {
+			group.allow[name] = true
+		}
+		for _, ruleCfg := range groupCfg.Rules {
+			r, err := newRule(ruleCfg)
+			if err != nil {
+				return nil, err
+			}
+			group.rules = append(group.rules, r)
+		}
+		e.groups[groupId] = group
+	}
+	return e, nil
+}
+
+func (e *RuleEngine) Name() string {
+	return "rules"
+}
+
+// Check 按全局规则和小组的规则检查文本，groupId 为0时只使用全局规则
+func (e *RuleEngine) Check(groupId int64, content string) *Result {
+	if strings.TrimSpace(content) == "" {
+		return passResult
+	}
+	normalized := normalize(content)
+	group := e.groups[groupId]
+	rules := e.rules
+	if group != nil {
+		rules = append(append([]*rule{}, e.rules...), group.rules...)
+	}
+	result := passResult
+	for _, r := range rules {
+		if group != nil && group.allow[r.name] {
+			continue
+		}
+		matched, ok := r.match(content, normalized)
+		if !ok {
+			continue
+		}
+		result = result.merge(&Result{
+			Verdict: r.verdict,
+			Labels:  []string{r.name},
+			Reason:  fmt.Sprintf("rule %s matched %q", r.name, matched),
+			Source:  e.Name(),
+		})
+	}
+	return e.applyGroup(groupId, result)
+}
+
+// applyGroup 严格的小组中需要审核的内容直接拒绝
+func (e *RuleEngine) applyGroup(groupId int64, result *Result) *Result {
+	group := e.groups[groupId]
+	if group == nil || !group.strict || result.Verdict != VerdictReview {
+		return result
+	}
+	strict := *result
+	strict.Verdict = VerdictReject
+	return &strict
+}
@@ -0,0 +1,109 @@
+package compliance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grapery/grapery/config"
+)
+
+func testModerationConfig() *config.ModerationConfig {
+	return &config.ModerationConfig{
+		Rules: []*config.ModerationRule{
+			{Name: "gamble", Keywords: []string{"赌博"}, Action: "reject"},
+			{Name: "contact", Patterns: []string{`1[3-9]\d{9}`}},
+			{Name: "violence", Keywords: []string{"Blood"}},
+		},
+		Groups: map[string]*config.ModerationGroup{
+			"10": {Allow: []string{"violence"}},
+			"20": {Strict: true, Rules: []*config.ModerationRule{{Name: "spoiler", Keywords: []string{"结局"}}}},
+		},
+	}
+}
+
+func TestRuleEngine(t *testing.T) {
+	e, err := NewRuleEngine(testModerationConfig())
+	require.NoError(t, err)
+
+	assert.Equal(t, VerdictPass, e.Check(0, "今天天气很好").Verdict)
+	// 关键词忽略大小写、空白和标点
+	ret := e.Check(0, "一起去 赌，博 吧")
+	assert.Equal(t, VerdictReject, ret.Verdict)
+	assert.Equal(t, []string{"gamble"}, ret.Labels)
+	assert.True(t, errors.Is(ret.Err(), ErrRejected))
+
+	ret = e.Check(0, "联系我 13812345678, so much BLOOD")
+	assert.Equal(t, VerdictReview, ret.Verdict)
+	assert.Equal(t, []string{"contact", "violence"}, ret.Labels)
+	assert.NoError(t, ret.Err())
+
+	// 小组关闭了全局规则
+	assert.Equal(t, VerdictPass, e.Check(10, "blood").Verdict)
+	// 严格的小组中需要审核的内容直接拒绝，小组的规则只在小组内生效
+	assert.Equal(t, VerdictReject, e.Check(20, "结局是他死了").Verdict)
+	assert.Equal(t, VerdictPass, e.Check(0, "结局是他死了").Verdict)
+}
+
+func TestRuleEngineInvalid(t *testing.T) {
+	_, err := NewRuleEngine(&config.ModerationConfig{
+		Rules: []*config.ModerationRule{{Name: "bad", Patterns: []string{"("}}},
+	})
+	assert.Error(t, err)
+	_, err = NewRuleEngine(&config.ModerationConfig{
+		Rules: []*config.ModerationRule{{Name: "bad", Action: "ban"}},
+	})
+	assert.Error(t, err)
+	_, err = NewRuleEngine(&config.ModerationConfig{
+		Groups: map[string]*config.ModerationGroup{"abc": {}},
+	})
+	assert.Error(t, err)
+}
+
+type fakeChecker struct {
+	result *Result
+	err    error
+	calls  int
+}
+
+func (f *fakeChecker) Name() string { return "fake" }
+
+func (f *fakeChecker) CheckText(ctx context.Context, content string) (*Result, error) {
+	f.calls++
+	return f.result, f.err
+}
+
+func (f *fakeChecker) CheckImage(ctx context.Context, image string) (*Result, error) {
+	f.calls++
+	return f.result, f.err
+}
+
+func TestModerator(t *testing.T) {
+	remote := &fakeChecker{result: &Result{Verdict: VerdictReview, Labels: []string{"porn"}, Source: "fake"}}
+	m, err := NewModerator(testModerationConfig(), remote)
+	require.NoError(t, err)
+
+	// 本地规则拒绝的内容不请求远程审核
+	assert.Equal(t, VerdictReject, m.Text(context.Background(), 0, "赌博").Verdict)
+	assert.Equal(t, 0, remote.calls)
+
+	ret := m.Text(context.Background(), 0, "blood")
+	assert.Equal(t, VerdictReview, ret.Verdict)
+	assert.Equal(t, []string{"violence", "porn"}, ret.Labels)
+	assert.Equal(t, "rules,fake", ret.Source)
+	assert.Equal(t, VerdictReject, m.Image(context.Background(), 20, "a.png").Verdict)
+
+	// 远程审核失败时默认进入人工审核
+	remote.err = errors.New("timeout")
+	assert.Equal(t, VerdictReview, m.Text(context.Background(), 0, "hello").Verdict)
+	m.passOnError = true
+	assert.Equal(t, VerdictPass, m.Text(context.Background(), 0, "hello").Verdict)
+
+	// 关闭审核
+	m, err = NewModerator(&config.ModerationConfig{Disable: true}, remote)
+	require.NoError(t, err)
+	assert.Equal(t, VerdictPass, m.Text(context.Background(), 0, "赌博").Verdict)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"github.com/grapery/grapery/config"
 	"github.com/grapery/grapery/utils/log"
 )
 
@@ -24,8 +25,14 @@ type ElasticDoc interface {
 var (
 	client          *elastic.Client
 	IsEnableElastic bool
+	indexPrefix     string
 )
 
+// indexName 加上配置的前缀后的索引名
+func indexName(name string) string {
+	return indexPrefix + name
+}
+
 func GetClient() *elastic.Client {
 	return client
 }
@@ -46,10 +53,15 @@ func (l *ErrorLogger) Printf(format string, v ...interface{}) {
 	l.logger.Error(fmt.Sprintf(format, v...))
 }
 
-func Init(address []string) {
+// Init 连接搜索服务并创建缺少的索引，没有配置地址时不启用搜索
+func Init(cfg *config.ElasticConfig) error {
+	if cfg == nil || len(cfg.Address) == 0 {
+		IsEnableElastic = false
+		return nil
+	}
 	var err error
 	client, err = elastic.NewClient(
-		elastic.SetURL(address...),
+		elastic.SetURL(cfg.Address...),
 		elastic.SetHealthcheckInterval(60*time.Second),
 		elastic.SetErrorLog(&ErrorLogger{
 			logger: log.Log(),
@@ -63,16 +75,28 @@ func Init(address []string) {
 		elastic.SetSniff(false),
 	)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer client.Stop()
 	c := context.Background()
-	for i := 0; i < len(address); i++ {
-		_, _, err := client.Ping(address[i]).Do(c)
+	for i := 0; i < len(cfg.Address); i++ {
+		_, _, err := client.Ping(cfg.Address[i]).Do(c)
 		if err != nil {
-			panic(errors.WithMessage(err, "Address at "+address[i]))
+			return errors.WithMessage(err, "Address at "+cfg.Address[i])
 		}
 	}
+	indexPrefix = cfg.Prefix
+	analyzer, searchAnalyzer = cfg.Analyzer, cfg.SearchAnalyzer
+	if analyzer == "" {
+		analyzer = defaultAnalyzer
+	}
+	if searchAnalyzer == "" {
+		searchAnalyzer = analyzer
+	}
+	if err := EnsureIndices(c); err != nil {
+		return err
+	}
+	IsEnableElastic = true
+	return nil
 }
 
 func GetMultiDocByIds(ctx context.Context, esDoc ElasticDoc, IDList []string) (results map[string]ElasticDoc, err error) {
@@ -121,6 +145,13 @@ func GetMultiDocByIds(ctx context.Context, esDoc ElasticDoc, IDList []string) (r
 				return nil, err
 			}
 			results[ret.ElasticID()] = ret
+		case *ElasticStoryRole:
+			var ret = new(ElasticStoryRole)
+			err = json.Unmarshal(*doc.Source, ret)
+			if err != nil {
+				return nil, err
+			}
+			results[ret.ElasticID()] = ret
 		case *ElasticStoryBoardRole:
 			var ret = new(ElasticStoryBoardRole)
 			err = json.Unmarshal(*doc.Source, ret)
@@ -0,0 +1,61 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/olivere/elastic"
+)
+
+// IndexDoc 写入或覆盖文档
+func IndexDoc(ctx context.Context, doc ElasticDoc) error {
+	_, err := GetClient().Index().
+		Index(doc.Index()).
+		Type(doc.Type()).
+		Id(doc.ElasticID()).
+		BodyJson(doc).
+		Do(ctx)
+	return err
+}
+
+// DeleteDoc 删除文档，doc 只用于确定索引，文档不存在时不报错
+func DeleteDoc(ctx context.Context, doc ElasticDoc, id int64) error {
+	_, err := GetClient().Delete().
+		Index(doc.Index()).
+		Type(doc.Type()).
+		Id(strconv.FormatInt(id, 10)).
+		Do(ctx)
+	if err != nil && !elastic.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// BulkIndex 批量写入文档，返回第一个失败的文档的错误
+func BulkIndex(ctx context.Context, docs []ElasticDoc) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	bulk := GetClient().Bulk()
+	for _, doc := range docs {
+		bulk.Add(elastic.NewBulkIndexRequest().
+			Index(doc.Index()).
+			Type(doc.Type()).
+			Id(doc.ElasticID()).
+			Doc(doc))
+	}
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if failed := resp.Failed(); len(failed) != 0 {
+		item := failed[0]
+		reason := ""
+		if item.Error != nil {
+			reason = item.Error.Reason
+		}
+		return fmt.Errorf("bulk index %d docs failed, first %s/%s: %s", len(failed), item.Index, item.Id, reason)
+	}
+	return nil
+}
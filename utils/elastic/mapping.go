@@ -0,0 +1,111 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/grapery/grapery/models"
+)
+
+// defaultAnalyzer 内置的 cjk 分词器按两个字切分中日韩文字，不需要安装插件
+const defaultAnalyzer = "cjk"
+
+var analyzer, searchAnalyzer = defaultAnalyzer, defaultAnalyzer
+
+// indexSpec 索引的全文字段和权重，其余字段只保存不索引
+type indexSpec struct {
+	text  []string
+	boost map[string]int
+}
+
+// indexSpecs 以表名为key
+var indexSpecs = map[string]*indexSpec{
+	(&models.Story{}).TableName(): {
+		text:  []string{"name", "title", "short_desc", "origin"},
+		boost: map[string]int{"name": 3, "title": 2},
+	},
+	models.StoryRole{}.TableName(): {
+		text:  []string{"character_name", "character_description", "character_detail"},
+		boost: map[string]int{"character_name": 3},
+	},
+	models.Group{}.TableName(): {
+		text:  []string{"name", "short_desc", "description"},
+		boost: map[string]int{"name": 3},
+	},
+	models.StoryBoard{}.TableName(): {
+		text:  []string{"title", "description"},
+		boost: map[string]int{"title": 2},
+	},
+	models.User{}.TableName(): {
+		text:  []string{"name", "short_desc"},
+		boost: map[string]int{"name": 3},
+	},
+}
+
+// specOf 根据带前缀的索引名查找索引配置
+func specOf(index string) (*indexSpec, error) {
+	spec, ok := indexSpecs[strings.TrimPrefix(index, indexPrefix)]
+	if !ok {
+		return nil, fmt.Errorf("unknown es index %s", index)
+	}
+	return spec, nil
+}
+
+// queryFields 带权重的查询字段，如 name^3
+func (s *indexSpec) queryFields() []string {
+	fields := make([]string, 0, len(s.text))
+	for _, f := range s.text {
+		if b, ok := s.boost[f]; ok {
+			f = fmt.Sprintf("%s^%d", f, b)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// mapping 不在映射中的字段不会被索引
+func (s *indexSpec) mapping() map[string]interface{} {
+	properties := map[string]interface{}{
+		"id":           map[string]string{"type": "long"},
+		"group_id":     map[string]string{"type": "long"},
+		"story_id":     map[string]string{"type": "long"},
+		"creator_id":   map[string]string{"type": "long"},
+		"tags":         map[string]string{"type": "keyword"},
+		"ai_generated": map[string]string{"type": "boolean"},
+	}
+	for _, f := range s.text {
+		properties[f] = map[string]string{
+			"type":            "text",
+			"analyzer":        analyzer,
+			"search_analyzer": searchAnalyzer,
+		}
+	}
+	return map[string]interface{}{
+		"mappings": map[string]interface{}{
+			docType: map[string]interface{}{
+				"dynamic":    false,
+				"properties": properties,
+			},
+		},
+	}
+}
+
+// EnsureIndices 创建不存在的索引，已经存在的索引不会修改映射
+// 修改分词器后需要删除索引并重新执行回填
+func EnsureIndices(ctx context.Context) error {
+	for table, spec := range indexSpecs {
+		name := indexName(table)
+		exists, err := GetClient().IndexExists(name).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := GetClient().CreateIndex(name).BodyJson(spec.mapping()).Do(ctx); err != nil {
+			return fmt.Errorf("create es index %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -6,16 +6,22 @@ import (
 	"github.com/grapery/grapery/models"
 )
 
+// docType 索引的文档类型，6.x 的索引只能有一个类型
+const docType = "_doc"
+
+// 文档公共的过滤字段 group_id、tags、ai_generated 由索引程序根据所属的故事和小组填充
+// tags 为小组标签以及故事的风格和主题，覆盖 models 中同名的字段
+
 type ElasticUser struct {
 	*models.User
 }
 
 func (e *ElasticUser) Index() string {
-	return e.TableName()
+	return indexName(models.User{}.TableName())
 }
 
 func (e *ElasticUser) Type() string {
-	return ""
+	return docType
 }
 func (e *ElasticUser) ElasticID() string {
 	return fmt.Sprintf("%d", e.ID)
@@ -31,21 +37,24 @@ func (e *ElasticUser) SetLastUsedTime(int64) {
 
 type ElasticGroup struct {
 	*models.Group
+	GroupId     int64    `json:"group_id"`
+	Tags        []string `json:"tags,omitempty"`
+	AIGenerated bool     `json:"ai_generated"`
 }
 
 func (e *ElasticGroup) Index() string {
-	return e.TableName()
+	return indexName(models.Group{}.TableName())
 }
 
 func (e *ElasticGroup) Type() string {
-	return ""
+	return docType
 }
 func (e *ElasticGroup) ElasticID() string {
-	return ""
+	return fmt.Sprintf("%d", e.ID)
 }
 
 func (e *ElasticGroup) LastUsedTime() int64 {
-	return 0
+	return int64(e.UpdateAt.Unix())
 }
 
 func (e *ElasticGroup) SetLastUsedTime(int64) {
@@ -54,21 +63,24 @@ func (e *ElasticGroup) SetLastUsedTime(int64) {
 
 type ElasticStory struct {
 	*models.Story
+	GroupId     int64    `json:"group_id"`
+	Tags        []string `json:"tags,omitempty"`
+	AIGenerated bool     `json:"ai_generated"`
 }
 
 func (e *ElasticStory) Index() string {
-	return e.TableName()
+	return indexName((&models.Story{}).TableName())
 }
 
 func (e *ElasticStory) Type() string {
-	return ""
+	return docType
 }
 func (e *ElasticStory) ElasticID() string {
-	return ""
+	return fmt.Sprintf("%d", e.ID)
 }
 
 func (e *ElasticStory) LastUsedTime() int64 {
-	return 0
+	return int64(e.UpdateAt.Unix())
 }
 
 func (e *ElasticStory) SetLastUsedTime(int64) {
@@ -77,45 +89,75 @@ func (e *ElasticStory) SetLastUsedTime(int64) {
 
 type ElasticStoryBoard struct {
 	*models.StoryBoard
+	GroupId     int64    `json:"group_id"`
+	Tags        []string `json:"tags,omitempty"`
+	AIGenerated bool     `json:"ai_generated"`
 }
 
 func (e *ElasticStoryBoard) Index() string {
-	return e.TableName()
+	return indexName(models.StoryBoard{}.TableName())
 }
 
 func (e *ElasticStoryBoard) Type() string {
-	return ""
+	return docType
 }
 func (e *ElasticStoryBoard) ElasticID() string {
-	return ""
+	return fmt.Sprintf("%d", e.ID)
 }
 
 func (e *ElasticStoryBoard) LastUsedTime() int64 {
-	return 0
+	return int64(e.UpdateAt.Unix())
 }
 
 func (e *ElasticStoryBoard) SetLastUsedTime(int64) {
 
 }
 
+type ElasticStoryRole struct {
+	*models.StoryRole
+	GroupId     int64    `json:"group_id"`
+	Tags        []string `json:"tags,omitempty"`
+	AIGenerated bool     `json:"ai_generated"`
+}
+
+func (e *ElasticStoryRole) Index() string {
+	return indexName(models.StoryRole{}.TableName())
+}
+
+func (e *ElasticStoryRole) Type() string {
+	return docType
+}
+
+func (e *ElasticStoryRole) ElasticID() string {
+	return fmt.Sprintf("%d", e.ID)
+}
+
+func (e *ElasticStoryRole) LastUsedTime() int64 {
+	return int64(e.UpdateAt.Unix())
+}
+
+func (e *ElasticStoryRole) SetLastUsedTime(int64) {
+
+}
+
 type ElasticStoryBoardRole struct {
 	*models.StoryBoardRole
 }
 
 func (e *ElasticStoryBoardRole) Index() string {
-	return e.TableName()
+	return indexName(models.StoryBoardRole{}.TableName())
 }
 
 func (e *ElasticStoryBoardRole) Type() string {
-	return ""
+	return docType
 }
 
 func (e *ElasticStoryBoardRole) ElasticID() string {
-	return ""
+	return fmt.Sprintf("%d", e.ID)
 }
 
 func (e *ElasticStoryBoardRole) LastUsedTime() int64 {
-	return 0
+	return int64(e.UpdateAt.Unix())
 }
 
 func (e *ElasticStoryBoardRole) SetLastUsedTime(int64) {
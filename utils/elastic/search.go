@@ -0,0 +1,111 @@
+package elastic
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic"
+)
+
+const (
+	highlightPreTag  = "<em>"
+	highlightPostTag = "</em>"
+)
+
+// Query 全文搜索条件，Keyword 为空时按ID倒序返回符合过滤条件的文档
+type Query struct {
+	Keyword     string
+	GroupId     int64
+	StoryId     int64
+	CreatorId   int64
+	Tags        []string // 包含任意一个标签
+	AIGenerated *bool
+	Offset      int
+	Size        int
+}
+
+// Hit 搜索结果，Highlight 的key为字段名，命中的词用 <em></em> 包围
+type Hit struct {
+	Id        int64               `json:"id"`
+	Score     float64             `json:"score"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+}
+
+func (q *Query) source(spec *indexSpec) elastic.Query {
+	query := elastic.NewBoolQuery()
+	if keyword := strings.TrimSpace(q.Keyword); keyword != "" {
+		query = query.Must(elastic.NewMultiMatchQuery(keyword, spec.queryFields()...).
+			Type("best_fields").
+			TieBreaker(0.3))
+	} else {
+		query = query.Must(elastic.NewMatchAllQuery())
+	}
+	if q.GroupId != 0 {
+		query = query.Filter(elastic.NewTermQuery("group_id", q.GroupId))
+	}
+	if q.StoryId != 0 {
+		query = query.Filter(elastic.NewTermQuery("story_id", q.StoryId))
+	}
+	if q.CreatorId != 0 {
+		query = query.Filter(elastic.NewTermQuery("creator_id", q.CreatorId))
+	}
+	if len(q.Tags) != 0 {
+		tags := make([]interface{}, 0, len(q.Tags))
+		for _, tag := range q.Tags {
+			tags = append(tags, tag)
+		}
+		query = query.Filter(elastic.NewTermsQuery("tags", tags...))
+	}
+	if q.AIGenerated != nil {
+		query = query.Filter(elastic.NewTermQuery("ai_generated", *q.AIGenerated))
+	}
+	return query
+}
+
+// Search 在 doc 对应的索引中搜索
+func Search(ctx context.Context, doc ElasticDoc, q *Query) ([]*Hit, int64, error) {
+	spec, err := specOf(doc.Index())
+	if err != nil {
+		return nil, 0, err
+	}
+	search := GetClient().Search(doc.Index()).
+		Type(doc.Type()).
+		Query(q.source(spec)).
+		From(q.Offset).
+		Size(q.Size)
+	if strings.TrimSpace(q.Keyword) == "" {
+		search = search.Sort("id", false)
+	} else {
+		fields := make([]*elastic.HighlighterField, 0, len(spec.text))
+		for _, f := range spec.text {
+			fields = append(fields, elastic.NewHighlighterField(f))
+		}
+		search = search.Highlight(elastic.NewHighlight().
+			Fields(fields...).
+			PreTags(highlightPreTag).
+			PostTags(highlightPostTag).
+			FragmentSize(100).
+			NumOfFragments(2))
+	}
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.Hits == nil {
+		return nil, 0, nil
+	}
+	hits := make([]*Hit, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		id, err := strconv.ParseInt(h.Id, 10, 64)
+		if err != nil {
+			continue
+		}
+		hit := &Hit{Id: id, Highlight: h.Highlight}
+		if h.Score != nil {
+			hit.Score = *h.Score
+		}
+		hits = append(hits, hit)
+	}
+	return hits, resp.Hits.TotalHits, nil
+}
@@ -0,0 +1,53 @@
+package elastic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grapery/grapery/models"
+)
+
+func TestSpecOf(t *testing.T) {
+	indexPrefix = "dev_"
+	defer func() { indexPrefix = "" }()
+	doc := &ElasticStory{}
+	assert.Equal(t, "dev_story", doc.Index())
+	spec, err := specOf(doc.Index())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name^3", "title^2", "short_desc", "origin"}, spec.queryFields())
+	_, err = specOf("dev_unknown")
+	assert.Error(t, err)
+}
+
+func TestQuerySource(t *testing.T) {
+	spec := indexSpecs[models.StoryRole{}.TableName()]
+	ai := false
+	q := &Query{Keyword: " 骑士 ", GroupId: 3, Tags: []string{"奇幻"}, AIGenerated: &ai}
+	src, err := q.source(spec).Source()
+	require.NoError(t, err)
+	data, err := json.Marshal(src)
+	require.NoError(t, err)
+	body := string(data)
+	assert.Contains(t, body, `"query":"骑士"`)
+	assert.Contains(t, body, `"character_name^3"`)
+	assert.Contains(t, body, `{"term":{"group_id":3}}`)
+	assert.Contains(t, body, `{"terms":{"tags":["奇幻"]}}`)
+	assert.Contains(t, body, `{"term":{"ai_generated":false}}`)
+	assert.NotContains(t, body, "creator_id")
+}
+
+func TestDocJSON(t *testing.T) {
+	group := &models.Group{Name: "g", Tags: "a,b"}
+	group.ID = 7
+	data, err := json.Marshal(&ElasticGroup{Group: group, GroupId: 7, Tags: []string{"a", "b"}})
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, []interface{}{"a", "b"}, doc["tags"])
+	assert.Equal(t, float64(7), doc["group_id"])
+	assert.Equal(t, false, doc["ai_generated"])
+	assert.Equal(t, "7", (&ElasticGroup{Group: group}).ElasticID())
+}
@@ -0,0 +1,215 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.6; }
+h1 { text-align: center; margin: 1em 0; }
+p { text-indent: 2em; margin: 0.5em 0; }
+p.summary { font-style: italic; text-indent: 0; }
+div.image { text-align: center; margin: 1em 0; }
+div.image img { max-width: 100%; }
+`
+
+// epubItem content.opf 中的一项资源
+type epubItem struct {
+	id, href, mediaType, properties string
+}
+
+// writeEPUB 生成 EPUB3，同时带上 toc.ncx 兼容旧的阅读器
+func writeEPUB(book *Book, images *imageSet) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	// mimetype 必须是第一个文件且不压缩
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte("application/epub+zip")); err != nil {
+		return nil, err
+	}
+	files := map[string]string{
+		"META-INF/container.xml": epubContainer,
+		"OEBPS/style.css":        epubStyle,
+	}
+	items := []epubItem{
+		{id: "nav", href: "nav.xhtml", mediaType: "application/xhtml+xml", properties: "nav"},
+		{id: "ncx", href: "toc.ncx", mediaType: "application/x-dtbncx+xml"},
+		{id: "style", href: "style.css", mediaType: "text/css"},
+	}
+	spine := make([]string, 0, len(book.Chapters)+1)
+
+	if cover := images.get(book.Cover); cover != nil {
+		items = append(items, epubItem{id: "cover-image", href: cover.name, mediaType: cover.contentType, properties: "cover-image"})
+		files["OEBPS/cover.xhtml"] = xhtmlPage(book.Language, book.Title,
+			fmt.Sprintf(`<div class="image"><img src="%s" alt="%s"/></div>`, cover.name, escapeXML(book.Title)))
+		items = append(items, epubItem{id: "cover", href: "cover.xhtml", mediaType: "application/xhtml+xml"})
+		spine = append(spine, "cover")
+	}
+	for i, chapter := range book.Chapters {
+		body := new(strings.Builder)
+		fmt.Fprintf(body, "<h1>%s</h1>\n", escapeXML(chapter.Title))
+		for _, p := range paragraphs(chapter.Summary) {
+			fmt.Fprintf(body, "<p class=\"summary\">%s</p>\n", escapeXML(p))
+		}
+		for _, section := range chapter.Sections {
+			for _, p := range paragraphs(section.Text) {
+				fmt.Fprintf(body, "<p>%s</p>\n", escapeXML(p))
+			}
+			for _, url := range section.Images {
+				// EPUB 不允许引用远程图片，下载失败的图片省略
+				if img := images.get(url); img != nil {
+					fmt.Fprintf(body, "<div class=\"image\"><img src=\"%s\" alt=\"\"/></div>\n", img.name)
+				}
+			}
+		}
+		id := fmt.Sprintf("chapter%03d", i+1)
+		files["OEBPS/"+id+".xhtml"] = xhtmlPage(book.Language, chapter.Title, body.String())
+		items = append(items, epubItem{id: id, href: id + ".xhtml", mediaType: "application/xhtml+xml"})
+		spine = append(spine, id)
+	}
+	for i, img := range images.images {
+		if img.name == itemHref(items, "cover-image") {
+			continue
+		}
+		items = append(items, epubItem{id: fmt.Sprintf("image%03d", i+1), href: img.name, mediaType: img.contentType})
+	}
+	files["OEBPS/nav.xhtml"] = epubNav(book)
+	files["OEBPS/toc.ncx"] = epubNCX(book)
+	files["OEBPS/content.opf"] = epubPackage(book, items, spine)
+
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/toc.ncx", "OEBPS/style.css"} {
+		if err := writeZipFile(zw, name, []byte(files[name])); err != nil {
+			return nil, err
+		}
+		delete(files, name)
+	}
+	for _, id := range spine {
+		name := "OEBPS/" + id + ".xhtml"
+		if err := writeZipFile(zw, name, []byte(files[name])); err != nil {
+			return nil, err
+		}
+	}
+	for _, img := range images.images {
+		if err := writeZipFile(zw, "OEBPS/"+img.name, img.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func itemHref(items []epubItem, id string) string {
+	for _, item := range items {
+		if item.id == id {
+			return item.href
+		}
+	}
+	return ""
+}
+
+func epubPackage(book *Book, items []epubItem, spine []string) string {
+	b := new(strings.Builder)
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(b, "    <dc:identifier id=\"book-id\">%s</dc:identifier>\n", escapeXML(book.Id))
+	fmt.Fprintf(b, "    <dc:title>%s</dc:title>\n", escapeXML(book.Title))
+	fmt.Fprintf(b, "    <dc:language>%s</dc:language>\n", escapeXML(book.Language))
+	if book.Author != "" {
+		fmt.Fprintf(b, "    <dc:creator>%s</dc:creator>\n", escapeXML(book.Author))
+	}
+	if book.Description != "" {
+		fmt.Fprintf(b, "    <dc:description>%s</dc:description>\n", escapeXML(book.Description))
+	}
+	fmt.Fprintf(b, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	if itemHref(items, "cover-image") != "" {
+		b.WriteString("    <meta name=\"cover\" content=\"cover-image\"/>\n")
+	}
+	b.WriteString("  </metadata>\n  <manifest>\n")
+	for _, item := range items {
+		fmt.Fprintf(b, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"", item.id, item.href, item.mediaType)
+		if item.properties != "" {
+			fmt.Fprintf(b, " properties=\"%s\"", item.properties)
+		}
+		b.WriteString("/>\n")
+	}
+	b.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n")
+	for _, id := range spine {
+		fmt.Fprintf(b, "    <itemref idref=\"%s\"/>\n", id)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+func epubNav(book *Book) string {
+	b := new(strings.Builder)
+	b.WriteString("<nav epub:type=\"toc\" id=\"toc\">\n<h1>目录</h1>\n<ol>\n")
+	for i, chapter := range book.Chapters {
+		fmt.Fprintf(b, "<li><a href=\"chapter%03d.xhtml\">%s</a></li>\n", i+1, escapeXML(chapter.Title))
+	}
+	b.WriteString("</ol>\n</nav>\n")
+	return xhtmlPage(book.Language, book.Title, b.String())
+}
+
+func epubNCX(book *Book) string {
+	b := new(strings.Builder)
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+`)
+	fmt.Fprintf(b, "  <head><meta name=\"dtb:uid\" content=\"%s\"/></head>\n", escapeXML(book.Id))
+	fmt.Fprintf(b, "  <docTitle><text>%s</text></docTitle>\n  <navMap>\n", escapeXML(book.Title))
+	for i, chapter := range book.Chapters {
+		fmt.Fprintf(b, "    <navPoint id=\"nav%03d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"chapter%03d.xhtml\"/></navPoint>\n",
+			i+1, i+1, escapeXML(chapter.Title), i+1)
+	}
+	b.WriteString("  </navMap>\n</ncx>\n")
+	return b.String()
+}
+
+func xhtmlPage(lang, title, body string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%s" lang="%s">
+<head>
+<meta charset="UTF-8"/>
+<title>%s</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+%s</body>
+</html>
+`, escapeXML(lang), escapeXML(lang), escapeXML(title), body)
+}
+
+func escapeXML(s string) string {
+	b := new(strings.Builder)
+	_ = xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,212 @@
+package export
+
+// 将故事导出为 EPUB、Markdown 压缩包和可打印的 HTML
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatEPUB     Format = "epub"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+// Book 导出的书籍，章节按阅读顺序排列
+type Book struct {
+	Id          string
+	Title       string
+	Author      string
+	Description string
+	Cover       string // 封面图片url
+	Language    string // 默认 zh-CN
+	Chapters    []*Chapter
+}
+
+// Chapter 对应一个故事板
+type Chapter struct {
+	Title    string
+	Summary  string
+	Sections []*Section
+}
+
+// Section 对应一个场景
+type Section struct {
+	Text   string
+	Images []string
+}
+
+// File 导出的文件
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Fetcher 下载图片，返回内容和 Content-Type
+type Fetcher func(ctx context.Context, url string) ([]byte, string, error)
+
+// ErrImageLimit 导出的图片数或总大小超过限制
+var ErrImageLimit = errors.New("export image limit exceeded")
+
+// LimitFetcher 限制一次导出下载的图片数和总大小，超过限制的图片视为下载失败
+// 返回的 Fetcher 有状态，每次导出使用新的
+func LimitFetcher(fetch Fetcher, maxImages int, maxBytes int64) Fetcher {
+	var (
+		images int
+		total  int64
+	)
+	return func(ctx context.Context, url string) ([]byte, string, error) {
+		if images >= maxImages || total >= maxBytes {
+			return nil, "", ErrImageLimit
+		}
+		images++
+		data, contentType, err := fetch(ctx, url)
+		if err != nil {
+			return nil, "", err
+		}
+		total += int64(len(data))
+		if total > maxBytes {
+			return nil, "", ErrImageLimit
+		}
+		return data, contentType, nil
+	}
+}
+
+// Export 按格式导出，fetch 为空时图片保留原始url（EPUB 中省略图片）
+func Export(ctx context.Context, book *Book, format Format, fetch Fetcher) (*File, error) {
+	if book.Language == "" {
+		book.Language = "zh-CN"
+	}
+	images := newImageSet(ctx, fetch)
+	var (
+		data []byte
+		err  error
+		file = &File{}
+	)
+	switch format {
+	case FormatEPUB:
+		data, err = writeEPUB(book, images)
+		file.Name = fileName(book.Title) + ".epub"
+		file.ContentType = "application/epub+zip"
+	case FormatMarkdown:
+		data, err = writeMarkdown(book, images)
+		file.Name = fileName(book.Title) + ".zip"
+		file.ContentType = "application/zip"
+	case FormatHTML:
+		data, err = writeHTML(book, images)
+		file.Name = fileName(book.Title) + ".html"
+		file.ContentType = "text/html; charset=utf-8"
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file.Data = data
+	return file, nil
+}
+
+// ContentDisposition 下载时使用的 Content-Disposition，文件名可能包含中文
+func (f *File) ContentDisposition() string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": f.Name})
+}
+
+// fileName 去掉文件名中不允许的字符
+func fileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', '\n', '\r', '\t':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		name = "story"
+	}
+	return name
+}
+
+// image 下载后的图片
+type image struct {
+	name        string // 压缩包中的文件名，例如 images/img001.png
+	contentType string
+	data        []byte
+}
+
+// imageSet 按url去重下载图片，下载失败的图片返回nil
+type imageSet struct {
+	ctx    context.Context
+	fetch  Fetcher
+	byUrl  map[string]*image
+	images []*image
+}
+
+func newImageSet(ctx context.Context, fetch Fetcher) *imageSet {
+	return &imageSet{ctx: ctx, fetch: fetch, byUrl: make(map[string]*image)}
+}
+
+func (s *imageSet) get(url string) *image {
+	if s.fetch == nil || url == "" {
+		return nil
+	}
+	if img, ok := s.byUrl[url]; ok {
+		return img
+	}
+	data, contentType, err := s.fetch(s.ctx, url)
+	if err != nil || len(data) == 0 {
+		s.byUrl[url] = nil
+		return nil
+	}
+	if contentType == "" || !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	img := &image{
+		name:        fmt.Sprintf("images/img%03d%s", len(s.images)+1, imageExt(contentType, url)),
+		contentType: contentType,
+		data:        data,
+	}
+	s.byUrl[url] = img
+	s.images = append(s.images, img)
+	return img
+}
+
+func imageExt(contentType, url string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/svg+xml":
+		return ".svg"
+	}
+	if ext := path.Ext(strings.SplitN(url, "?", 2)[0]); ext != "" {
+		return ext
+	}
+	return ".img"
+}
+
+// paragraphs 按换行拆分段落
+func paragraphs(text string) []string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	ret := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			ret = append(ret, line)
+		}
+	}
+	return ret
+}
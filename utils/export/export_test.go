@@ -0,0 +1,130 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var png = []byte("\x89PNG\r\n\x1a\n0000")
+
+func testBook() *Book {
+	return &Book{
+		Id:          "story-1",
+		Title:       "星河<旅人>",
+		Author:      "作者",
+		Description: "一段简介",
+		Cover:       "https://oss/cover.png",
+		Chapters: []*Chapter{
+			{Title: "第一章", Summary: "出发", Sections: []*Section{
+				{Text: "第一段\n第二段", Images: []string{"https://oss/a.png", "https://oss/missing.png"}},
+			}},
+			{Title: "第二章", Sections: []*Section{{Text: "结尾", Images: []string{"https://oss/a.png"}}}},
+		},
+	}
+}
+
+func testFetcher(ctx context.Context, url string) ([]byte, string, error) {
+	if strings.Contains(url, "missing") {
+		return nil, "", errors.New("not found")
+	}
+	return png, "", nil
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(r)
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestExportEPUB(t *testing.T) {
+	file, err := Export(context.Background(), testBook(), FormatEPUB, testFetcher)
+	assert.NoError(t, err)
+	assert.Equal(t, "星河_旅人_.epub", file.Name)
+	zr, err := zip.NewReader(bytes.NewReader(file.Data), int64(len(file.Data)))
+	assert.NoError(t, err)
+	// mimetype 必须是第一个且不压缩
+	assert.Equal(t, "mimetype", zr.File[0].Name)
+	assert.Equal(t, zip.Store, zr.File[0].Method)
+	files := readZip(t, file.Data)
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Contains(t, files["OEBPS/content.opf"], `properties="cover-image"`)
+	assert.Contains(t, files["OEBPS/content.opf"], "<dc:title>星河&lt;旅人&gt;</dc:title>")
+	assert.Contains(t, files["OEBPS/chapter001.xhtml"], "<p>第二段</p>")
+	assert.Contains(t, files["OEBPS/chapter001.xhtml"], `src="images/img002.png"`)
+	assert.NotContains(t, files["OEBPS/chapter001.xhtml"], "missing")
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `href="chapter002.xhtml"`)
+	// 相同url只下载一次
+	assert.Len(t, filesWithPrefix(files, "OEBPS/images/"), 2)
+}
+
+func TestExportMarkdown(t *testing.T) {
+	file, err := Export(context.Background(), testBook(), FormatMarkdown, testFetcher)
+	assert.NoError(t, err)
+	files := readZip(t, file.Data)
+	md := files["星河_旅人_.md"]
+	assert.Contains(t, md, "# 星河\\<旅人\\>")
+	assert.Contains(t, md, "![](images/img002.png)")
+	assert.Contains(t, md, "![](https://oss/missing.png)")
+	assert.Contains(t, files, "images/img001.png")
+}
+
+func TestExportHTML(t *testing.T) {
+	file, err := Export(context.Background(), testBook(), FormatHTML, testFetcher)
+	assert.NoError(t, err)
+	html := string(file.Data)
+	assert.Contains(t, html, "<title>星河&lt;旅人&gt;</title>")
+	assert.Contains(t, html, `src="data:image/png;base64,`)
+	assert.Contains(t, html, `src="https://oss/missing.png"`)
+	assert.Equal(t, 3, strings.Count(html, `<section class="chapter"`)+strings.Count(html, `<nav class="chapter"`))
+
+	_, err = Export(context.Background(), testBook(), Format("pdf"), nil)
+	assert.Error(t, err)
+}
+
+func TestLimitFetcher(t *testing.T) {
+	ctx := context.Background()
+	fetch := LimitFetcher(testFetcher, 2, 100)
+	for _, url := range []string{"https://oss/a.png", "https://oss/b.png"} {
+		data, _, err := fetch(ctx, url)
+		assert.NoError(t, err)
+		assert.Equal(t, png, data)
+	}
+	_, _, err := fetch(ctx, "https://oss/c.png")
+	assert.ErrorIs(t, err, ErrImageLimit)
+
+	// 超过总大小后不再下载
+	fetch = LimitFetcher(testFetcher, 10, int64(len(png))+1)
+	_, _, err = fetch(ctx, "https://oss/a.png")
+	assert.NoError(t, err)
+	_, _, err = fetch(ctx, "https://oss/b.png")
+	assert.ErrorIs(t, err, ErrImageLimit)
+
+	file, err := Export(ctx, testBook(), FormatMarkdown, LimitFetcher(testFetcher, 1, 100))
+	assert.NoError(t, err)
+	files := readZip(t, file.Data)
+	assert.Len(t, filesWithPrefix(files, "images/"), 1)
+}
+
+func filesWithPrefix(files map[string]string, prefix string) []string {
+	ret := make([]string, 0)
+	for name := range files {
+		if strings.HasPrefix(name, prefix) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
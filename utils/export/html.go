@@ -0,0 +1,105 @@
+package export
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"strings"
+)
+
+// htmlTemplate 单文件的可打印页面，每章从新的一页开始
+var htmlTemplate = template.Must(template.New("book").Funcs(template.FuncMap{
+	"paragraphs": paragraphs,
+}).Parse(`<!DOCTYPE html>
+<html lang="{{.Book.Language}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Book.Title}}</title>
+<style>
+body { font-family: "Songti SC", "SimSun", serif; line-height: 1.8; max-width: 42em; margin: 0 auto; padding: 2em; }
+h1.title { text-align: center; margin-top: 30vh; }
+p { text-indent: 2em; margin: 0.5em 0; }
+p.meta, p.summary { text-indent: 0; text-align: center; }
+p.summary { font-style: italic; }
+.image { text-align: center; margin: 1em 0; }
+.image img { max-width: 100%; }
+section.chapter { page-break-before: always; break-before: page; }
+img { page-break-inside: avoid; break-inside: avoid; }
+@page { size: A4; margin: 2cm; }
+@media print { body { padding: 0; } }
+</style>
+</head>
+<body>
+<section class="cover">
+<h1 class="title">{{.Book.Title}}</h1>
+{{if .Book.Author}}<p class="meta">{{.Book.Author}}</p>{{end}}
+{{with .Cover}}<div class="image"><img src="{{.}}" alt=""></div>{{end}}
+{{range paragraphs .Book.Description}}<p class="meta">{{.}}</p>
+{{end}}</section>
+<nav class="chapter">
+<h2>目录</h2>
+<ol>
+{{range $i, $c := .Book.Chapters}}<li><a href="#chapter-{{$i}}">{{$c.Title}}</a></li>
+{{end}}</ol>
+</nav>
+{{range $i, $c := .Chapters}}<section class="chapter" id="chapter-{{$i}}">
+<h2>{{$c.Title}}</h2>
+{{range paragraphs $c.Summary}}<p class="summary">{{.}}</p>
+{{end}}{{range $c.Sections}}{{range paragraphs .Text}}<p>{{.}}</p>
+{{end}}{{range .Images}}<div class="image"><img src="{{.}}" alt=""></div>
+{{end}}{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+type htmlChapter struct {
+	Title    string
+	Summary  string
+	Sections []htmlSection
+}
+
+type htmlSection struct {
+	Text   string
+	Images []template.URL
+}
+
+// writeHTML 生成单个html文件，图片以data url内嵌，下载失败时引用原始url
+func writeHTML(book *Book, images *imageSet) ([]byte, error) {
+	data := struct {
+		Book     *Book
+		Cover    template.URL
+		Chapters []htmlChapter
+	}{Book: book, Cover: htmlImage(images, book.Cover)}
+	for _, chapter := range book.Chapters {
+		c := htmlChapter{Title: chapter.Title, Summary: chapter.Summary}
+		for _, section := range chapter.Sections {
+			s := htmlSection{Text: section.Text}
+			for _, url := range section.Images {
+				if img := htmlImage(images, url); img != "" {
+					s.Images = append(s.Images, img)
+				}
+			}
+			c.Sections = append(c.Sections, s)
+		}
+		data.Chapters = append(data.Chapters, c)
+	}
+	buf := new(bytes.Buffer)
+	if err := htmlTemplate.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func htmlImage(images *imageSet, url string) template.URL {
+	if url == "" {
+		return ""
+	}
+	if img := images.get(url); img != nil {
+		return template.URL("data:" + img.contentType + ";base64," + base64.StdEncoding.EncodeToString(img.data))
+	}
+	// 原始url由模型或用户提供，只允许http(s)
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return ""
+	}
+	return template.URL(url)
+}
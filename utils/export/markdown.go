@@ -0,0 +1,76 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// writeMarkdown 生成包含 markdown 文件和 images 目录的压缩包
+func writeMarkdown(book *Book, images *imageSet) ([]byte, error) {
+	md := new(strings.Builder)
+	fmt.Fprintf(md, "# %s\n\n", escapeMarkdown(book.Title))
+	if book.Author != "" {
+		fmt.Fprintf(md, "作者：%s\n\n", escapeMarkdown(book.Author))
+	}
+	if img := markdownImage(images, book.Cover); img != "" {
+		fmt.Fprintf(md, "![封面](%s)\n\n", img)
+	}
+	for _, p := range paragraphs(book.Description) {
+		fmt.Fprintf(md, "> %s\n", escapeMarkdown(p))
+	}
+	if book.Description != "" {
+		md.WriteString("\n")
+	}
+	for _, chapter := range book.Chapters {
+		fmt.Fprintf(md, "## %s\n\n", escapeMarkdown(chapter.Title))
+		for _, p := range paragraphs(chapter.Summary) {
+			fmt.Fprintf(md, "*%s*\n\n", escapeMarkdown(p))
+		}
+		for _, section := range chapter.Sections {
+			for _, p := range paragraphs(section.Text) {
+				fmt.Fprintf(md, "%s\n\n", escapeMarkdown(p))
+			}
+			for _, url := range section.Images {
+				if img := markdownImage(images, url); img != "" {
+					fmt.Fprintf(md, "![](%s)\n\n", img)
+				}
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	if err := writeZipFile(zw, fileName(book.Title)+".md", []byte(md.String())); err != nil {
+		return nil, err
+	}
+	for _, img := range images.images {
+		if err := writeZipFile(zw, img.name, img.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// markdownImage 下载成功时引用压缩包中的图片，否则引用原始url
+func markdownImage(images *imageSet, url string) string {
+	if url == "" {
+		return ""
+	}
+	if img := images.get(url); img != nil {
+		return img.name
+	}
+	return strings.ReplaceAll(url, " ", "%20")
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "#", `\#`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// JSONStreamScanner 从流式输出的json中提前取出已经完整的部分
+// 顶层对象的字段值完整后即可取出，数组字段的元素逐个取出
+type JSONStreamScanner struct {
+	buf strings.Builder
+}
+
+func (s *JSONStreamScanner) Write(delta string) {
+	s.buf.WriteString(delta)
+}
+
+func (s *JSONStreamScanner) Reset() {
+	s.buf.Reset()
+}
+
+func (s *JSONStreamScanner) String() string {
+	return s.buf.String()
+}
+
+// Scan 返回已完整的顶层字段值，以及数组字段中已完整的元素
+// 输出可能包含 ```json 等前缀，从第一个 { 开始解析
+func (s *JSONStreamScanner) Scan() (map[string]json.RawMessage, map[string][]json.RawMessage) {
+	fields := make(map[string]json.RawMessage)
+	items := make(map[string][]json.RawMessage)
+	data := s.buf.String()
+	start := strings.Index(data, "{")
+	if start == -1 {
+		return fields, items
+	}
+	var (
+		depth      int
+		inString   bool
+		escaped    bool
+		strStart   int
+		lastString string
+		key        string
+		valueStart = -1
+		itemStart  = -1
+	)
+	for i := start; i < len(data); i++ {
+		c := data[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				lastString = data[strStart:i]
+				if depth == 1 && valueStart == -1 {
+					// 顶层的字符串值完整
+					if key != "" && isValueString(data, strStart) {
+						fields[key] = json.RawMessage(data[strStart-1 : i+1])
+						key = ""
+					}
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+			strStart = i + 1
+		case ':':
+			if depth == 1 {
+				key = lastString
+			}
+		case '{', '[':
+			depth++
+			if depth == 2 && key != "" {
+				valueStart = i
+			}
+			if depth == 3 && valueStart != -1 && data[valueStart] == '[' {
+				itemStart = i
+			}
+		case '}', ']':
+			depth--
+			if depth == 2 && itemStart != -1 {
+				items[key] = append(items[key], json.RawMessage(data[itemStart:i+1]))
+				itemStart = -1
+			}
+			if depth == 1 && valueStart != -1 {
+				fields[key] = json.RawMessage(data[valueStart : i+1])
+				valueStart = -1
+				key = ""
+			}
+			if depth == 0 {
+				return fields, items
+			}
+		case ',':
+			if depth == 1 {
+				key = ""
+			}
+		}
+	}
+	return fields, items
+}
+
+// isValueString 判断 strStart 处的字符串是字段值而不是字段名（前面是冒号）
+func isValueString(data string, strStart int) bool {
+	for i := strStart - 2; i >= 0; i-- {
+		switch data[i] {
+		case ' ', '\n', '\r', '\t':
+			continue
+		case ':':
+			return true
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONStreamScanner(t *testing.T) {
+	full := "```json\n{\"summary\":{\"title\":\"第一章 {开始}\",\"content\":\"他说：\\\"走\\\"\"},\n" +
+		"\"name\": \"grapery\",\n" +
+		"\"scenes\":[{\"id\":\"1\",\"roles\":[{\"id\":\"r1\"}]},{\"id\":\"2\"}]}\n```"
+	s := &JSONStreamScanner{}
+	var (
+		fields map[string]json.RawMessage
+		items  map[string][]json.RawMessage
+	)
+	// 逐字节写入，字段完整之前不会被取出
+	for i := 0; i < len(full); i++ {
+		s.Write(full[i : i+1])
+		fields, items = s.Scan()
+		if i < 60 {
+			assert.Empty(t, fields)
+		}
+	}
+	assert.Len(t, fields, 3)
+	summary := make(map[string]string)
+	assert.NoError(t, json.Unmarshal(fields["summary"], &summary))
+	assert.Equal(t, "第一章 {开始}", summary["title"])
+	assert.Equal(t, "他说：\"走\"", summary["content"])
+	assert.Equal(t, `"grapery"`, string(fields["name"]))
+	assert.Len(t, items["scenes"], 2)
+	assert.JSONEq(t, `{"id":"1","roles":[{"id":"r1"}]}`, string(items["scenes"][0]))
+
+	// 第一个场景完整、第二个场景未完整时只取出一个
+	s.Reset()
+	s.Write(`{"summary":{"title":"t"},"scenes":[{"id":"1"},{"id":"2","content":"未完`)
+	fields, items = s.Scan()
+	assert.Contains(t, fields, "summary")
+	assert.NotContains(t, fields, "scenes")
+	assert.Len(t, items["scenes"], 1)
+}
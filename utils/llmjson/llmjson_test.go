@@ -0,0 +1,86 @@
+package llmjson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepair(t *testing.T) {
+	cases := map[string]string{
+		"```json\n{\"a\":\"1\",}\n```":    `{"a":"1"}`,
+		"好的，结果如下：{\"a\":[1,2,],} 希望对你有帮助": `{"a":[1,2]}`,
+		`{"a":[{"b":"x"},{"b":"被截`:        `{"a":[{"b":"x"},{"b":"被截"}]}`,
+		`{"a":"1","b`:                     `{"a":"1"}`,
+		`{"a":"1","b":`:                   `{"a":"1"}`,
+		"{\"a\":\"第一行\n第二行\"}":            `{"a":"第一行\n第二行"}`,
+	}
+	for raw, want := range cases {
+		got := Repair(raw)
+		assert.True(t, json.Valid([]byte(got)), "raw: %s, got: %s", raw, got)
+		assert.JSONEq(t, want, got, "raw: %s", raw)
+	}
+}
+
+type chapter struct {
+	Title  string `json:"title"`
+	Scenes []struct {
+		ID      string `json:"id"`
+		Content string `json:"content"`
+	} `json:"scenes"`
+}
+
+var chapterSchema = Object(map[string]*Schema{
+	"title": String(),
+	"scenes": Array(Object(map[string]*Schema{
+		"id":      String(),
+		"content": String(),
+	}, "content").WithAliases(map[string]string{"text": "content"}), 1),
+}, "title", "scenes").WithAliases(map[string]string{"name": "title"})
+
+func TestParse(t *testing.T) {
+	out := new(chapter)
+	err := Parse("```json\n{\"name\":\"开始\",\"scenes\":{\"id\":1,\"text\":\"内容\"},}\n```", chapterSchema, out)
+	assert.NoError(t, err)
+	assert.Equal(t, "开始", out.Title)
+	assert.Len(t, out.Scenes, 1)
+	assert.Equal(t, "1", out.Scenes[0].ID)
+	assert.Equal(t, "内容", out.Scenes[0].Content)
+
+	err = Parse(`{"title":"开始","scenes":[]}`, chapterSchema, out)
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Contains(t, err.Error(), "$.scenes 至少需要1项")
+}
+
+func TestParseWithRetry(t *testing.T) {
+	outputs := []string{`{"title":""}`, `{"title":"开始","scenes":[{"content":"内容"}]}`}
+	feedbacks := make([]string, 0)
+	out := new(chapter)
+	raw, err := ParseWithRetry(context.Background(), chapterSchema, out, 2, func(ctx context.Context, feedback string) (string, error) {
+		feedbacks = append(feedbacks, feedback)
+		return outputs[len(feedbacks)-1], nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, outputs[1], raw)
+	assert.Equal(t, "", feedbacks[0])
+	assert.Contains(t, feedbacks[1], "缺少字段 title")
+
+	calls := 0
+	_, err = ParseWithRetry(context.Background(), chapterSchema, out, 1, func(ctx context.Context, feedback string) (string, error) {
+		calls++
+		return "not json", nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestParseMap(t *testing.T) {
+	var out map[string]string
+	err := Parse("---\n{\"name\":\"小明\",\"age\":18,\"tags\":null,}\n---", Map(String()), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "小明", "age": "18", "tags": ""}, out)
+}
@@ -0,0 +1,193 @@
+package llmjson
+
+import (
+	"strings"
+)
+
+// Repair 修复大模型输出json的常见问题：
+// 代码块包裹、json前后的说明文字、多余的尾逗号、输出被截断导致的未闭合字符串和括号
+func Repair(raw string) string {
+	s := stripFence(strings.TrimSpace(raw))
+	start := strings.IndexAny(s, "{[")
+	if start == -1 {
+		return s
+	}
+	s = s[start:]
+
+	var (
+		out      strings.Builder
+		stack    []byte
+		inString bool
+		escaped  bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			out.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			case c == '\n':
+				// 字符串中的换行不合法，转义
+				str := out.String()
+				out.Reset()
+				out.WriteString(str[:len(str)-1])
+				out.WriteString(`\n`)
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			trimTrailingComma(&out)
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				// 括号不匹配，丢弃
+				continue
+			}
+			stack = stack[:len(stack)-1]
+		}
+		out.WriteByte(c)
+		if len(stack) == 0 && (c == '}' || c == ']') {
+			// 顶层结束，忽略后面的说明文字
+			return out.String()
+		}
+	}
+	// 输出被截断，补全未闭合的字符串和括号
+	if inString {
+		if escaped {
+			str := out.String()
+			out.Reset()
+			out.WriteString(str[:len(str)-1])
+		}
+		out.WriteByte('"')
+	}
+	trimDanglingKey(&out)
+	for i := len(stack) - 1; i >= 0; i-- {
+		trimTrailingComma(&out)
+		out.WriteByte(stack[i])
+	}
+	return out.String()
+}
+
+// stripFence 去掉 ```json ... ``` 代码块
+func stripFence(s string) string {
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "```")
+	if idx := strings.Index(s, "\n"); idx != -1 && !strings.ContainsAny(s[:idx], "{[") {
+		s = s[idx+1:]
+	}
+	if idx := strings.LastIndex(s, "```"); idx != -1 {
+		s = s[:idx]
+	}
+	return strings.TrimSpace(s)
+}
+
+func trimTrailingComma(out *strings.Builder) {
+	str := strings.TrimRight(out.String(), " \t\r\n")
+	if strings.HasSuffix(str, ",") {
+		out.Reset()
+		out.WriteString(str[:len(str)-1])
+	}
+}
+
+// trimDanglingKey 截断在字段名或冒号之后时，去掉没有值的字段
+func trimDanglingKey(out *strings.Builder) {
+	str := strings.TrimRight(out.String(), " \t\r\n")
+	if strings.HasSuffix(str, ":") {
+		str = strings.TrimRight(str[:len(str)-1], " \t\r\n")
+	} else if !strings.HasSuffix(str, `"`) {
+		return
+	} else if !isObjectKey(str) {
+		return
+	}
+	// 去掉字段名
+	if idx := lastStringStart(str); idx >= 0 {
+		str = strings.TrimRight(str[:idx], " \t\r\n")
+	}
+	out.Reset()
+	out.WriteString(str)
+}
+
+// isObjectKey 判断末尾的字符串是否为对象的字段名（前面是 { 或 ,，且处于对象中）
+func isObjectKey(str string) bool {
+	idx := lastStringStart(str)
+	if idx <= 0 {
+		return false
+	}
+	prev := strings.TrimRight(str[:idx], " \t\r\n")
+	if prev == "" {
+		return false
+	}
+	switch prev[len(prev)-1] {
+	case '{':
+		return true
+	case ',':
+		return innermostOpen(prev) == '{'
+	}
+	return false
+}
+
+// lastStringStart 末尾字符串的起始引号位置
+func lastStringStart(str string) int {
+	for i := len(str) - 2; i >= 0; i-- {
+		if str[i] != '"' {
+			continue
+		}
+		backslashes := 0
+		for j := i - 1; j >= 0 && str[j] == '\\'; j-- {
+			backslashes++
+		}
+		if backslashes%2 == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// innermostOpen 返回最内层未闭合的括号
+func innermostOpen(str string) byte {
+	var (
+		stack    []byte
+		inString bool
+		escaped  bool
+	)
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			stack = append(stack, c)
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if len(stack) == 0 {
+		return 0
+	}
+	return stack[len(stack)-1]
+}
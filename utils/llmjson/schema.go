@@ -0,0 +1,190 @@
+package llmjson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kind 字段类型
+type Kind int
+
+const (
+	KindAny Kind = iota
+	KindString
+	KindObject
+	KindArray
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindString:
+		return "string"
+	case KindObject:
+		return "object"
+	case KindArray:
+		return "array"
+	}
+	return "any"
+}
+
+// Schema 声明期望的json结构
+type Schema struct {
+	Kind     Kind
+	Fields   map[string]*Schema // 对象的字段
+	Required []string           // 必填字段
+	Aliases  map[string]string  // 别名 -> 字段名，模型经常使用近义的字段名
+	Values   *Schema            // 未在Fields中声明的字段，用于map
+	Items    *Schema            // 数组元素
+	MinItems int
+}
+
+func String() *Schema {
+	return &Schema{Kind: KindString}
+}
+
+func Object(fields map[string]*Schema, required ...string) *Schema {
+	return &Schema{Kind: KindObject, Fields: fields, Required: required}
+}
+
+// Map 任意字段名、字段值相同类型的对象
+func Map(values *Schema) *Schema {
+	return &Schema{Kind: KindObject, Values: values}
+}
+
+func Array(items *Schema, minItems int) *Schema {
+	return &Schema{Kind: KindArray, Items: items, MinItems: minItems}
+}
+
+// WithAliases 设置字段别名
+func (s *Schema) WithAliases(aliases map[string]string) *Schema {
+	s.Aliases = aliases
+	return s
+}
+
+// ValidationError 校验失败的字段和原因，Error() 的内容可以直接作为重新生成的提示
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "json校验失败: " + strings.Join(e.Problems, "; ")
+}
+
+// Parse 修复、规范化并校验 raw，通过后解析到 out
+func Parse(raw string, schema *Schema, out any) error {
+	var value any
+	if err := json.Unmarshal([]byte(Repair(raw)), &value); err != nil {
+		return &ValidationError{Problems: []string{"不是合法的json: " + err.Error()}}
+	}
+	if schema != nil {
+		var problems []string
+		value = normalize(value, schema, "$", &problems)
+		if len(problems) != 0 {
+			return &ValidationError{Problems: problems}
+		}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// normalize 按schema重命名别名字段、转换可以兼容的类型，并记录不符合的地方
+func normalize(value any, schema *Schema, path string, problems *[]string) any {
+	switch schema.Kind {
+	case KindString:
+		switch v := value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(v)
+		case nil:
+			return ""
+		}
+		*problems = append(*problems, fmt.Sprintf("%s 应为%s", path, schema.Kind))
+	case KindObject:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s 应为%s", path, schema.Kind))
+			return value
+		}
+		for alias, name := range schema.Aliases {
+			if v, ok := obj[alias]; ok {
+				if _, exists := obj[name]; !exists {
+					obj[name] = v
+				}
+				delete(obj, alias)
+			}
+		}
+		for _, name := range schema.Required {
+			if v, ok := obj[name]; !ok || v == nil || v == "" {
+				*problems = append(*problems, fmt.Sprintf("%s 缺少字段 %s", path, name))
+			}
+		}
+		for name, v := range obj {
+			fieldSchema, ok := schema.Fields[name]
+			if !ok {
+				fieldSchema = schema.Values
+			}
+			if fieldSchema != nil && v != nil {
+				obj[name] = normalize(v, fieldSchema, path+"."+name, problems)
+			}
+		}
+		return obj
+	case KindArray:
+		arr, ok := value.([]any)
+		if !ok {
+			// 只有一个元素时模型经常省略数组
+			if _, isObj := value.(map[string]any); isObj && schema.Items != nil && schema.Items.Kind == KindObject {
+				arr = []any{value}
+			} else {
+				*problems = append(*problems, fmt.Sprintf("%s 应为%s", path, schema.Kind))
+				return value
+			}
+		}
+		if len(arr) < schema.MinItems {
+			*problems = append(*problems, fmt.Sprintf("%s 至少需要%d项", path, schema.MinItems))
+		}
+		if schema.Items != nil {
+			for i := range arr {
+				arr[i] = normalize(arr[i], schema.Items, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+		return arr
+	}
+	return value
+}
+
+// GenerateFunc 调用模型生成json，feedback 为上一次输出的校验错误，第一次调用时为空
+type GenerateFunc func(ctx context.Context, feedback string) (string, error)
+
+// ParseWithRetry 生成并解析，校验失败时带上错误重新生成，最多重试 retries 次
+// 返回最后一次模型的原始输出
+func ParseWithRetry(ctx context.Context, schema *Schema, out any, retries int, generate GenerateFunc) (string, error) {
+	var feedback string
+	for attempt := 0; ; attempt++ {
+		raw, err := generate(ctx, feedback)
+		if err != nil {
+			return raw, err
+		}
+		err = Parse(raw, schema, out)
+		if err == nil {
+			return raw, nil
+		}
+		if _, ok := err.(*ValidationError); !ok || attempt >= retries {
+			return raw, err
+		}
+		feedback = Feedback(err)
+	}
+}
+
+// Feedback 生成重新生成时附加给模型的提示
+func Feedback(err error) string {
+	return "上一次的输出无法使用（" + err.Error() + "），请严格按照要求的json格式重新输出，不要包含其他内容。"
+}
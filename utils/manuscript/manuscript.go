@@ -0,0 +1,245 @@
+package manuscript
+
+// 将 Markdown 或纯文本稿件拆分为章节和场景
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type Format string
+
+const (
+	FormatMarkdown Format = "markdown"
+	FormatText     Format = "text"
+)
+
+// DefaultSceneSize 没有场景分隔时，按段落合并的场景最大字数
+const DefaultSceneSize = 800
+
+// Manuscript 拆分后的稿件
+type Manuscript struct {
+	Title    string
+	Intro    string // 第一个章节之前的内容
+	Chapters []*Chapter
+}
+
+// Chapter 对应一个故事板
+type Chapter struct {
+	Title  string
+	Scenes []string
+}
+
+// Content 章节全文
+func (c *Chapter) Content() string {
+	return strings.Join(c.Scenes, "\n\n")
+}
+
+var (
+	// 纯文本的章节标题，例如 "第一章 出发"、"第12回"、"Chapter 3"
+	textChapterRe = regexp.MustCompile(`^(第[0-9一二三四五六七八九十百千零〇两]+[章节回卷幕]|(?i:chapter)\s*[0-9]+|序章|楔子|尾声|后记)(\s|[:：、.．]|$)`)
+	// 场景分隔，例如 "***"、"---"、"* * *"、"◇◇◇"
+	sceneBreakRe = regexp.MustCompile(`^(\*\s*){3,}$|^(-\s*){3,}$|^(_\s*){3,}$|^([#＊◇◆○●※~～]\s*){3,}$`)
+	// markdown 标题
+	headingRe = regexp.MustCompile(`^(#{1,6})\s+(.+?)\s*#*\s*$`)
+)
+
+// Parse 拆分稿件，format 为空时根据内容判断
+// 章节：markdown 使用标题（存在二级标题时一级标题作为书名），纯文本使用 "第X章" 等标题行
+// 场景：三级标题或分隔线，没有分隔时按段落合并到 sceneSize 字左右
+func Parse(content string, format Format, sceneSize int) *Manuscript {
+	if sceneSize <= 0 {
+		sceneSize = DefaultSceneSize
+	}
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\ufeff")
+	}
+	if format == "" {
+		format = detectFormat(lines)
+	}
+	p := &parser{sceneSize: sceneSize, markdown: format == FormatMarkdown, ms: &Manuscript{}}
+	if format == FormatMarkdown {
+		p.parseMarkdown(lines)
+	} else {
+		p.parseText(lines)
+	}
+	return p.ms
+}
+
+func detectFormat(lines []string) Format {
+	for _, line := range lines {
+		if headingRe.MatchString(strings.TrimSpace(line)) {
+			return FormatMarkdown
+		}
+	}
+	return FormatText
+}
+
+type parser struct {
+	sceneSize  int
+	markdown   bool
+	ms         *Manuscript
+	chapter    *Chapter
+	paragraphs []string // 当前场景的段落
+	current    []string // 当前段落的行
+	intro      []string
+}
+
+func (p *parser) parseMarkdown(lines []string) {
+	// 有二级标题时，一级标题作为书名，二级标题作为章节；否则一级标题作为章节
+	chapterLevel, minLevel := 0, 7
+	levels := make(map[int]int)
+	for _, line := range lines {
+		if m := headingRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			levels[len(m[1])]++
+			if len(m[1]) < minLevel {
+				minLevel = len(m[1])
+			}
+		}
+	}
+	chapterLevel = minLevel
+	if minLevel == 1 && levels[1] == 1 && levels[2] > 0 {
+		chapterLevel = 2
+	}
+	for _, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if m := headingRe.FindStringSubmatch(line); m != nil {
+			level, title := len(m[1]), stripMarkdown(m[2])
+			switch {
+			case level < chapterLevel:
+				if p.ms.Title == "" {
+					p.ms.Title = title
+				}
+			case level == chapterLevel:
+				p.startChapter(title)
+			default:
+				// 更低级的标题作为场景分隔
+				p.endScene()
+			}
+			continue
+		}
+		p.addLine(line)
+	}
+	p.finish()
+}
+
+func (p *parser) parseText(lines []string) {
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if textChapterRe.MatchString(line) && utf8.RuneCountInString(line) <= 40 {
+			p.startChapter(line)
+			continue
+		}
+		// 第一行非空且后面是空行的短文本视为书名
+		if i == 0 && p.ms.Title == "" && line != "" && utf8.RuneCountInString(line) <= 40 &&
+			len(lines) > 1 && strings.TrimSpace(lines[1]) == "" {
+			p.ms.Title = line
+			continue
+		}
+		p.addLine(line)
+	}
+	p.finish()
+}
+
+func (p *parser) addLine(line string) {
+	switch {
+	case sceneBreakRe.MatchString(line):
+		p.endScene()
+	case line == "":
+		p.endParagraph()
+	case p.markdown:
+		if line = stripMarkdown(line); line != "" {
+			p.current = append(p.current, line)
+		}
+	default:
+		// 纯文本的稿件通常一行一段
+		p.current = append(p.current, line)
+		p.endParagraph()
+	}
+}
+
+func (p *parser) startChapter(title string) {
+	p.endScene()
+	p.chapter = &Chapter{Title: title}
+	p.ms.Chapters = append(p.ms.Chapters, p.chapter)
+}
+
+// endParagraph 结束段落，场景超过大小时拆分
+func (p *parser) endParagraph() {
+	if len(p.current) == 0 {
+		return
+	}
+	paragraph := strings.Join(p.current, "\n")
+	p.current = nil
+	if size := runeCount(p.paragraphs); size > 0 && size+utf8.RuneCountInString(paragraph) > p.sceneSize {
+		p.endScene()
+	}
+	p.paragraphs = append(p.paragraphs, paragraph)
+}
+
+func (p *parser) endScene() {
+	p.flushParagraph()
+	if len(p.paragraphs) == 0 {
+		return
+	}
+	scene := strings.Join(p.paragraphs, "\n\n")
+	p.paragraphs = nil
+	if p.chapter == nil {
+		p.intro = append(p.intro, scene)
+		return
+	}
+	p.chapter.Scenes = append(p.chapter.Scenes, scene)
+}
+
+// flushParagraph 结束段落但不检查场景大小
+func (p *parser) flushParagraph() {
+	if len(p.current) == 0 {
+		return
+	}
+	p.paragraphs = append(p.paragraphs, strings.Join(p.current, "\n"))
+	p.current = nil
+}
+
+func (p *parser) finish() {
+	p.endParagraph()
+	p.endScene()
+	p.ms.Intro = strings.Join(p.intro, "\n\n")
+	// 没有章节标题时整篇作为一个章节
+	if len(p.ms.Chapters) == 0 && len(p.intro) > 0 {
+		p.ms.Chapters = append(p.ms.Chapters, &Chapter{Title: p.ms.Title, Scenes: p.intro})
+		p.ms.Intro = ""
+	}
+	// 去掉没有内容的章节
+	chapters := p.ms.Chapters[:0]
+	for _, chapter := range p.ms.Chapters {
+		if len(chapter.Scenes) > 0 {
+			chapters = append(chapters, chapter)
+		}
+	}
+	p.ms.Chapters = chapters
+}
+
+func runeCount(paragraphs []string) int {
+	n := 0
+	for _, paragraph := range paragraphs {
+		n += utf8.RuneCountInString(paragraph)
+	}
+	return n
+}
+
+var (
+	markdownImageRe = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	markdownLinkRe  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownMarkRe  = regexp.MustCompile(`(\*\*|__|~~|\x60)`)
+)
+
+// stripMarkdown 去掉行内的markdown标记，保留文字
+func stripMarkdown(line string) string {
+	line = strings.TrimLeft(line, "> ")
+	line = markdownImageRe.ReplaceAllString(line, "")
+	line = markdownLinkRe.ReplaceAllString(line, "$1")
+	line = markdownMarkRe.ReplaceAllString(line, "")
+	return strings.TrimSpace(line)
+}
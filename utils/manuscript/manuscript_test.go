@@ -0,0 +1,35 @@
+package manuscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMarkdown(t *testing.T) {
+	content := "# 星河旅人\n\n很久以前。\n\n## 第一章 出发\n\n他**收拾**好行李。\n第二行。\n\n### 夜晚\n\n夜里下起了雨，见[地图](http://x)。\n\n***\n\n天亮了。\n\n## 第二章\n\n![图](a.png)\n到达。\n"
+	ms := Parse(content, "", 0)
+	assert.Equal(t, "星河旅人", ms.Title)
+	assert.Equal(t, "很久以前。", ms.Intro)
+	assert.Len(t, ms.Chapters, 2)
+	assert.Equal(t, "第一章 出发", ms.Chapters[0].Title)
+	assert.Equal(t, []string{"他收拾好行李。\n第二行。", "夜里下起了雨，见地图。", "天亮了。"}, ms.Chapters[0].Scenes)
+	assert.Equal(t, []string{"到达。"}, ms.Chapters[1].Scenes)
+}
+
+func TestParseText(t *testing.T) {
+	content := "星河旅人\n\n第一章 出发\n　　他收拾好行李。\n　　出门了。\n\n第二章：到达\n" + strings.Repeat("很长的一段。\n", 10)
+	ms := Parse(content, FormatText, 20)
+	assert.Equal(t, "星河旅人", ms.Title)
+	assert.Len(t, ms.Chapters, 2)
+	assert.Equal(t, []string{"他收拾好行李。\n\n出门了。"}, ms.Chapters[0].Scenes)
+	assert.Equal(t, "第二章：到达", ms.Chapters[1].Title)
+	// 每段6个字，每个场景不超过20字
+	assert.Len(t, ms.Chapters[1].Scenes, 4)
+
+	// 没有章节标题时整篇作为一个章节
+	ms = Parse("只有一段话。", "", 0)
+	assert.Len(t, ms.Chapters, 1)
+	assert.Equal(t, []string{"只有一段话。"}, ms.Chapters[0].Scenes)
+}
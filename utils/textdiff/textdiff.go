@@ -0,0 +1,142 @@
+package textdiff
+
+// 基于最长公共子序列的文本比较，用于比较故事分支的章节和场景
+
+import (
+	"strings"
+)
+
+type OpType string
+
+const (
+	OpEqual  OpType = "equal"
+	OpInsert OpType = "insert"
+	OpDelete OpType = "delete"
+)
+
+// Op 一段相同、新增或删除的内容
+type Op struct {
+	Type OpType `json:"type"`
+	Text string `json:"text"`
+}
+
+// maxCells 超过时不再计算公共子序列，直接返回整体删除和新增
+const maxCells = 4 << 20
+
+// Diff 比较两个序列，每个元素对应一个操作
+func Diff(a, b []string) []Op {
+	// 去掉相同的前缀和后缀，减少计算量
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := make([]Op, 0, len(a)+len(b))
+	for _, s := range a[:prefix] {
+		ops = append(ops, Op{Type: OpEqual, Text: s})
+	}
+	ops = append(ops, lcsDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, s := range a[len(a)-suffix:] {
+		ops = append(ops, Op{Type: OpEqual, Text: s})
+	}
+	return ops
+}
+
+func lcsDiff(a, b []string) []Op {
+	ops := make([]Op, 0)
+	if len(a)*len(b) > maxCells {
+		for _, s := range a {
+			ops = append(ops, Op{Type: OpDelete, Text: s})
+		}
+		for _, s := range b {
+			ops = append(ops, Op{Type: OpInsert, Text: s})
+		}
+		return ops
+	}
+	// lcs[i][j] 为 a[i:] 和 b[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, Op{Type: OpEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, Op{Type: OpDelete, Text: a[i]})
+			i++
+		default:
+			ops = append(ops, Op{Type: OpInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, Op{Type: OpDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, Op{Type: OpInsert, Text: b[j]})
+	}
+	return ops
+}
+
+// merge 合并相邻的同类操作
+func merge(ops []Op) []Op {
+	ret := make([]Op, 0, len(ops))
+	for _, op := range ops {
+		if n := len(ret); n > 0 && ret[n-1].Type == op.Type {
+			ret[n-1].Text += op.Text
+			continue
+		}
+		ret = append(ret, op)
+	}
+	return ret
+}
+
+// DiffText 按句子比较两段文本，相邻的同类操作会合并
+func DiffText(a, b string) []Op {
+	return merge(Diff(Sentences(a), Sentences(b)))
+}
+
+// Sentences 按句末标点和换行拆分，保留标点和换行，拼接后与原文相同
+func Sentences(text string) []string {
+	ret := make([]string, 0)
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		if !strings.ContainsRune(sentenceEnds, runes[i]) {
+			continue
+		}
+		// 连续的标点和后面的引号归入同一句
+		for i+1 < len(runes) && strings.ContainsRune(sentenceEnds+sentenceClosers, runes[i+1]) {
+			i++
+		}
+		ret = append(ret, string(runes[start:i+1]))
+		start = i + 1
+	}
+	if start < len(runes) {
+		ret = append(ret, string(runes[start:]))
+	}
+	return ret
+}
+
+const (
+	sentenceEnds    = "。！？!?；;\n"
+	sentenceClosers = "”’\"」』)）"
+)
@@ -0,0 +1,46 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	ops := Diff([]string{"a", "b", "c", "d"}, []string{"a", "x", "c", "d", "e"})
+	assert.Equal(t, []Op{
+		{Type: OpEqual, Text: "a"},
+		{Type: OpDelete, Text: "b"},
+		{Type: OpInsert, Text: "x"},
+		{Type: OpEqual, Text: "c"},
+		{Type: OpEqual, Text: "d"},
+		{Type: OpInsert, Text: "e"},
+	}, ops)
+	assert.Empty(t, Diff(nil, nil))
+}
+
+func TestDiffText(t *testing.T) {
+	a := "他出门了。天下着雨！“真冷啊？”他说。"
+	b := "他出门了。天晴了。“真冷啊？”他说。"
+	assert.Equal(t, []string{"他出门了。", "天下着雨！", "“真冷啊？”", "他说。"}, Sentences(a))
+	ops := DiffText(a, b)
+	assert.Equal(t, []Op{
+		{Type: OpEqual, Text: "他出门了。"},
+		{Type: OpDelete, Text: "天下着雨！"},
+		{Type: OpInsert, Text: "天晴了。"},
+		{Type: OpEqual, Text: "“真冷啊？”他说。"},
+	}, ops)
+	// 拼接后与原文相同
+	var old, cur strings.Builder
+	for _, op := range ops {
+		if op.Type != OpInsert {
+			old.WriteString(op.Text)
+		}
+		if op.Type != OpDelete {
+			cur.WriteString(op.Text)
+		}
+	}
+	assert.Equal(t, a, old.String())
+	assert.Equal(t, b, cur.String())
+}
@@ -0,0 +1,72 @@
+package vector
+
+// 向量的编码和相似度计算，向量数量不大时直接在内存中暴力检索
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// Encode 将向量编码为小端序的 float32 字节
+func Encode(v []float32) []byte {
+	data := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(f))
+	}
+	return data
+}
+
+// Decode 解码 Encode 的结果，长度不是4的倍数时忽略多余的字节
+func Decode(data []byte) []float32 {
+	v := make([]float32, len(data)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return v
+}
+
+// Cosine 余弦相似度，维度不同或存在零向量时返回0
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// Match 检索结果，Index 为候选向量的下标
+type Match struct {
+	Index int
+	Score float64
+}
+
+// TopK 返回与 query 最相似且相似度不低于 minScore 的 k 个候选，按相似度从高到低
+func TopK(query []float32, candidates [][]float32, k int, minScore float64) []Match {
+	matches := make([]Match, 0)
+	if k <= 0 {
+		return matches
+	}
+	for i, c := range candidates {
+		score := Cosine(query, c)
+		if score < minScore {
+			continue
+		}
+		matches = append(matches, Match{Index: i, Score: score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
@@ -0,0 +1,41 @@
+package vector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	v := []float32{0.5, -1.25, 3, 0}
+	assert.Equal(t, v, Decode(Encode(v)))
+	assert.Len(t, Encode(v), 16)
+	assert.Empty(t, Decode([]byte{1, 2, 3}))
+}
+
+func TestCosine(t *testing.T) {
+	assert.InDelta(t, 1, Cosine([]float32{1, 2}, []float32{2, 4}), 1e-9)
+	assert.InDelta(t, 0, Cosine([]float32{1, 0}, []float32{0, 1}), 1e-9)
+	assert.InDelta(t, -1, Cosine([]float32{1, 0}, []float32{-1, 0}), 1e-9)
+	assert.Equal(t, float64(0), Cosine([]float32{1, 0}, []float32{1, 0, 0}))
+	assert.Equal(t, float64(0), Cosine([]float32{0, 0}, []float32{1, 0}))
+}
+
+func TestTopK(t *testing.T) {
+	candidates := [][]float32{
+		{0, 1},
+		{1, 0},
+		{1, 1},
+		{-1, 0},
+	}
+	matches := TopK([]float32{1, 0.1}, candidates, 2, 0)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, 1, matches[0].Index)
+	assert.Equal(t, 2, matches[1].Index)
+
+	matches = TopK([]float32{1, 0}, candidates, 10, 0.8)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, 1, matches[0].Index)
+
+	assert.Empty(t, TopK([]float32{1, 0}, candidates, 0, 0))
+}